@@ -52,9 +52,17 @@ func main() {
 					}()
 					return nil
 				},
+				OnStop: server.Shutdown,
 			})
 		}),
 
+		// Kill executions that are still running when the server stops
+		fx.Invoke(func(lc fx.Lifecycle, sandboxExec sandbox.SandboxExecutor) {
+			if executor, ok := sandboxExec.(sandbox.ShutdownExecutor); ok {
+				lc.Append(fx.Hook{OnStop: executor.Shutdown})
+			}
+		}),
+
 		// Use the application logger for fx logs
 		fx.WithLogger(func(log *zap.Logger) fxevent.Logger {
 			return &fxevent.ZapLogger{Logger: log}
@@ -6,8 +6,13 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -17,8 +22,28 @@ import (
 const (
 	DefaultHTTPPort        = 8080
 	DefaultTimeoutSec      = 10
+	DefaultBuildTimeoutSec = 60
+	DefaultSetupTimeoutSec = 120
 	DefaultMemoryMB        = 512
 	DefaultMaxArtifactSize = 20
+	DefaultMaxStdinSizeKB  = 1024
+	DefaultMaxTestCases    = 50
+	DefaultMaxOutputSizeKB = 1024
+
+	DefaultSessionIdleTTLSec    = 900
+	DefaultMaxSessionsPerClient = 5
+
+	DefaultPoolSize                   = 2
+	DefaultPoolMaxContainers          = 8
+	DefaultPoolHealthCheckIntervalSec = 30
+
+	DefaultInstance = "codebox" // name of the containers of a server whose host name is unknown
+
+	DefaultPidsLimit     = 256       // processes and threads per container
+	DefaultFileSizeLimit = 100000000 // bytes per file written in a container
+	DefaultTmpfsSizeMB   = 128       // size of the tmpfs mounts at /tmp and $HOME of containers
+
+	bytesPerKB = 1024
 )
 
 // Configuration value constants.
@@ -26,7 +51,17 @@ const (
 	TransportHTTP      = "http"
 	TransportStdio     = "stdio"
 	BackendDocker      = "docker"
+	BackendDockerAPI   = "docker-api"
+	BackendPodman      = "podman"
+	BackendPodmanAPI   = "podman-api"
+	BackendNerdctl     = "nerdctl"
+	BackendNamespace   = "namespace"
+	BackendBwrap       = "bwrap"
+	BackendWasm        = "wasm"
 	BackendLocal       = "local"
+	RuntimeCheckInfo   = "info"
+	RuntimeCheckFlag   = "flag"
+	RuntimeCheckShim   = "shim"
 	LogModeProduction  = "production"
 	LogModeDevelopment = "development"
 	LogLevelInfo       = "info"
@@ -36,6 +71,7 @@ const (
 type Config struct {
 	Server    ServerConfig        `mapstructure:"server"`
 	Sandbox   SandboxConfig       `mapstructure:"sandbox"`
+	Sessions  SessionsConfig      `mapstructure:"sessions"`
 	Languages map[string]Language `mapstructure:"languages"`
 	Logging   LoggingConfig       `mapstructure:"logging"`
 }
@@ -48,23 +84,146 @@ type ServerConfig struct {
 
 // SandboxConfig holds sandbox configuration.
 type SandboxConfig struct {
-	Backend            string `mapstructure:"backend"`
-	TimeoutSec         int    `mapstructure:"timeout_sec"`
-	MemoryMB           int    `mapstructure:"memory_mb"`
-	MaxArtifactSizeMB  int    `mapstructure:"max_artifact_size_mb"`
-	NetworkEnabled     bool   `mapstructure:"network_enabled"`
-	EnableLocalBackend bool   `mapstructure:"enable_local_backend"`
+	Backend             string                   `mapstructure:"backend"`
+	Runtime             string                   `mapstructure:"runtime"` // OCI runtime of the containers, e.g. runsc
+	TimeoutSec          int                      `mapstructure:"timeout_sec"`
+	BuildTimeoutSec     int                      `mapstructure:"build_timeout_sec"`
+	MemoryMB            int                      `mapstructure:"memory_mb"`
+	MaxArtifactSizeMB   int                      `mapstructure:"max_artifact_size_mb"`
+	MaxStdinSizeKB      int                      `mapstructure:"max_stdin_size_kb"`
+	MaxTestCases        int                      `mapstructure:"max_test_cases"`
+	MaxStdoutSizeKB     int                      `mapstructure:"max_stdout_size_kb"`
+	MaxStderrSizeKB     int                      `mapstructure:"max_stderr_size_kb"`
+	KillOnOutputLimit   bool                     `mapstructure:"kill_on_output_limit"`
+	SpillOutput         bool                     `mapstructure:"spill_output"`
+	MaxTimeoutSec       int                      `mapstructure:"max_timeout_sec"`
+	MaxMemoryMB         int                      `mapstructure:"max_memory_mb"`
+	NetworkEnabled      bool                     `mapstructure:"network_enabled"`
+	AllowRequestNetwork bool                     `mapstructure:"allow_request_network"`
+	AllowSetup          bool                     `mapstructure:"allow_setup"`       // let requests run a setup command with network access
+	SetupTimeoutSec     int                      `mapstructure:"setup_timeout_sec"` // time limit of the setup phase
+	EnableLocalBackend  bool                     `mapstructure:"enable_local_backend"`
+	EngineHost          string                   `mapstructure:"engine_host"`
+	Instance            string                   `mapstructure:"instance"` // labels the containers of this server, default: host name
+	Podman              PodmanConfig             `mapstructure:"podman"`
+	Namespace           NamespaceConfig          `mapstructure:"namespace"`
+	Bwrap               BwrapConfig              `mapstructure:"bwrap"`
+	Wasm                WasmConfig               `mapstructure:"wasm"`
+	Security            SecurityConfig           `mapstructure:"security"`
+	Resources           ResourcesConfig          `mapstructure:"resources"`
+	Filesystem          FilesystemConfig         `mapstructure:"filesystem"`
+	Egress              EgressConfig             `mapstructure:"egress"`
+	Runtimes            map[string]RuntimeConfig `mapstructure:"runtimes"`
+	Pool                PoolConfig               `mapstructure:"pool"`
+}
+
+// RuntimeConfig describes a container CLI compatible with the docker command line. Entries named after
+// a built-in runtime (docker, podman, nerdctl) override its settings, any other entry adds a runtime
+// that sandbox.backend can name.
+type RuntimeConfig struct {
+	Binary     string   `mapstructure:"binary"`      // CLI executable, e.g. nerdctl or /usr/local/bin/docker
+	GlobalArgs []string `mapstructure:"global_args"` // arguments before every subcommand, e.g. --namespace codebox
+	RunArgs    []string `mapstructure:"run_args"`    // extra arguments of every container run
+	Network    string   `mapstructure:"network"`     // network of containers with network access, default: bridge
+	Pool       *bool    `mapstructure:"pool"`        // whether the CLI supports cp for the warm pool
+	// RuntimeCheck is how the OCI runtimes passed with --runtime are checked at startup: info lists them
+	// like docker, flag accepts one as global --runtime like podman, shim looks for its containerd shim
+	RuntimeCheck string `mapstructure:"runtime_check"`
+}
+
+// PodmanConfig holds options of the podman-api backend.
+type PodmanConfig struct {
+	Userns string `mapstructure:"userns"`
+}
+
+// NamespaceConfig holds options of the namespace backend.
+type NamespaceConfig struct {
+	Rootfs       string `mapstructure:"rootfs"`        // root filesystem directory of languages without their own
+	CgroupParent string `mapstructure:"cgroup_parent"` // cgroup v2 directory of the sandbox cgroups, default: the server's cgroup
+}
+
+// BwrapConfig holds options of the bwrap backend.
+type BwrapConfig struct {
+	Binary string   `mapstructure:"binary"` // bubblewrap executable, default: bwrap
+	Rootfs string   `mapstructure:"rootfs"` // root filesystem directory instead of the system directories of the host
+	Binds  []string `mapstructure:"binds"`  // host paths bound read-only for every language
+}
+
+// SecurityConfig holds the security profiles of the containers of the container backends. A language's
+// security overrides every field that it sets.
+type SecurityConfig struct {
+	Seccomp  string   `mapstructure:"seccomp"`  // seccomp JSON profile file, builtin or unconfined, default: the engine's profile
+	AppArmor string   `mapstructure:"apparmor"` // AppArmor profile loaded on the host, or unconfined, default: the engine's profile
+	SELinux  []string `mapstructure:"selinux"`  // SELinux label options like type:container_t or level:s0:c1,c2, or disable
+}
+
+// IsZero reports whether no security profile is configured.
+func (s *SecurityConfig) IsZero() bool {
+	return s.Seccomp == "" && s.AppArmor == "" && len(s.SELinux) == 0
+}
+
+// ResourcesConfig holds the resource limits of the containers of the container backends on top of memory_mb.
+// A language's resources override every field that it sets, and its ulimits those with the same name.
+type ResourcesConfig struct {
+	CPUs         float64          `mapstructure:"cpus"`           // CPU quota in cores, e.g. 1.5, 0 for no quota
+	PidsLimit    int64            `mapstructure:"pids_limit"`     // processes and threads, -1 for unlimited, default: 256
+	MemorySwapMB int              `mapstructure:"memory_swap_mb"` // swap on top of memory_mb, -1 for unlimited, default: none
+	ShmSizeMB    int              `mapstructure:"shm_size_mb"`    // size of /dev/shm, default: the engine's 64MB
+	Ulimits      map[string]int64 `mapstructure:"ulimits"`        // soft and hard limits by name, e.g. nofile: 1024
+}
+
+// FilesystemConfig holds the filesystem restrictions of the containers of the container backends.
+type FilesystemConfig struct {
+	WritableRootfs bool `mapstructure:"writable_rootfs"` // keep the root filesystem writable instead of read-only
+	TmpfsSizeMB    int  `mapstructure:"tmpfs_size_mb"`   // size of the tmpfs mounts at /tmp and $HOME, default: 128
+	WorkdirSizeMB  int  `mapstructure:"workdir_size_mb"` // size of the workdir, 0 for no quota
+}
+
+// ulimitNames are the resource limits that container engines accept as ulimits
+var ulimitNames = []string{
+	"as", "core", "cpu", "data", "fsize", "locks", "memlock", "msgqueue", "nice", "nofile", "nproc",
+	"rss", "rtprio", "rttime", "sigpending", "stack",
+}
+
+// WasmConfig holds options of the wasm backend.
+type WasmConfig struct {
+	MaxCalls int64  `mapstructure:"max_calls"` // function calls a module may make per phase, 0 for unlimited
+	CacheDir string `mapstructure:"cache_dir"` // directory that keeps compiled modules across restarts, default: in memory
+}
+
+// PoolConfig holds configuration of the warm container pool.
+type PoolConfig struct {
+	Enabled                bool     `mapstructure:"enabled"`
+	Size                   int      `mapstructure:"size"`
+	MaxContainers          int      `mapstructure:"max_containers"`
+	Languages              []string `mapstructure:"languages"`
+	HealthCheckIntervalSec int      `mapstructure:"health_check_interval_sec"`
+}
+
+// SessionsConfig holds configuration of persistent sandbox sessions.
+type SessionsConfig struct {
+	IdleTTLSec   int `mapstructure:"idle_ttl_sec"`
+	MaxPerClient int `mapstructure:"max_per_client"`
 }
 
 // Language holds language-specific configurations.
 type Language struct {
 	Image           string            `mapstructure:"image"`
+	SourceFile      string            `mapstructure:"source_file"`
+	FileExtension   string            `mapstructure:"file_extension"`
 	BuildCmd        string            `mapstructure:"build_cmd"`
 	RunCmd          string            `mapstructure:"run_cmd"`
 	PrefixCode      string            `mapstructure:"prefix_code"`
 	PostfixCode     string            `mapstructure:"postfix_code"`
 	Environment     map[string]string `mapstructure:"environment"`
 	ExcludePatterns []string          `mapstructure:"exclude_patterns"`
+	Runtime         string            `mapstructure:"runtime"`   // OCI runtime of the language, overriding sandbox.runtime
+	Rootfs          string            `mapstructure:"rootfs"`    // root filesystem of the namespace and bwrap backends
+	Binds           []string          `mapstructure:"binds"`     // host paths mounted read-only by the bwrap and wasm backends
+	Wasm            string            `mapstructure:"wasm"`      // WASI module of the wasm backend that runs run_cmd, e.g. a python.wasm build
+	Security        SecurityConfig    `mapstructure:"security"`  // security profiles of the language, overriding sandbox.security
+	Resources       ResourcesConfig   `mapstructure:"resources"` // resource limits of the language, overriding sandbox.resources
+	Egress          EgressPolicy      `mapstructure:"egress"`    // egress allowlist of the language, replacing sandbox.egress.allow
 }
 
 // LoggingConfig holds logging configuration.
@@ -121,10 +280,30 @@ func setDefaults(v *viper.Viper) {
 	// Sandbox defaults
 	v.SetDefault("sandbox.backend", BackendDocker)
 	v.SetDefault("sandbox.timeout_sec", DefaultTimeoutSec)
+	v.SetDefault("sandbox.build_timeout_sec", DefaultBuildTimeoutSec)
 	v.SetDefault("sandbox.memory_mb", DefaultMemoryMB)
 	v.SetDefault("sandbox.max_artifact_size_mb", DefaultMaxArtifactSize)
+	v.SetDefault("sandbox.max_stdin_size_kb", DefaultMaxStdinSizeKB)
+	v.SetDefault("sandbox.max_test_cases", DefaultMaxTestCases)
+	v.SetDefault("sandbox.max_stdout_size_kb", DefaultMaxOutputSizeKB)
+	v.SetDefault("sandbox.max_stderr_size_kb", DefaultMaxOutputSizeKB)
+	v.SetDefault("sandbox.kill_on_output_limit", false)
+	v.SetDefault("sandbox.spill_output", false)
 	v.SetDefault("sandbox.network_enabled", false)
+	v.SetDefault("sandbox.allow_request_network", false)
+	v.SetDefault("sandbox.allow_setup", false)
+	v.SetDefault("sandbox.setup_timeout_sec", DefaultSetupTimeoutSec)
 	v.SetDefault("sandbox.enable_local_backend", false)
+	v.SetDefault("sandbox.pool.enabled", false)
+	v.SetDefault("sandbox.pool.size", DefaultPoolSize)
+	v.SetDefault("sandbox.pool.max_containers", DefaultPoolMaxContainers)
+	v.SetDefault("sandbox.pool.health_check_interval_sec", DefaultPoolHealthCheckIntervalSec)
+	v.SetDefault("sandbox.egress.enabled", false)
+	v.SetDefault("sandbox.egress.network", DefaultEgressNetwork)
+
+	// Session defaults
+	v.SetDefault("sessions.idle_ttl_sec", DefaultSessionIdleTTLSec)
+	v.SetDefault("sessions.max_per_client", DefaultMaxSessionsPerClient)
 
 	// Logging defaults
 	v.SetDefault("logging.mode", LogModeProduction)
@@ -187,6 +366,10 @@ func (c *Config) validate() error {
 		return fmt.Errorf("sandbox.timeout_sec must be positive, got: %d", c.Sandbox.TimeoutSec)
 	}
 
+	if c.Sandbox.BuildTimeoutSec < 0 {
+		return fmt.Errorf("sandbox.build_timeout_sec must not be negative, got: %d", c.Sandbox.BuildTimeoutSec)
+	}
+
 	if c.Sandbox.MemoryMB <= 0 {
 		return fmt.Errorf("sandbox.memory_mb must be positive, got: %d", c.Sandbox.MemoryMB)
 	}
@@ -195,15 +378,81 @@ func (c *Config) validate() error {
 		return fmt.Errorf("sandbox.max_artifact_size_mb must be positive, got: %d", c.Sandbox.MaxArtifactSizeMB)
 	}
 
-	supportedBackends := map[string]bool{
-		BackendDocker: true,
-		"podman":      true,
-		BackendLocal:  c.Sandbox.EnableLocalBackend,
+	if c.Sandbox.MaxStdinSizeKB < 0 {
+		return fmt.Errorf("sandbox.max_stdin_size_kb must not be negative, got: %d", c.Sandbox.MaxStdinSizeKB)
+	}
+
+	if c.Sandbox.MaxTestCases < 0 {
+		return fmt.Errorf("sandbox.max_test_cases must not be negative, got: %d", c.Sandbox.MaxTestCases)
+	}
+
+	if c.Sandbox.MaxStdoutSizeKB < 0 {
+		return fmt.Errorf("sandbox.max_stdout_size_kb must not be negative, got: %d", c.Sandbox.MaxStdoutSizeKB)
 	}
-	if !supportedBackends[c.Sandbox.Backend] {
+
+	if c.Sandbox.MaxStderrSizeKB < 0 {
+		return fmt.Errorf("sandbox.max_stderr_size_kb must not be negative, got: %d", c.Sandbox.MaxStderrSizeKB)
+	}
+
+	if err := c.validateRequestLimits(); err != nil {
+		return err
+	}
+
+	if err := c.validateSessions(); err != nil {
+		return err
+	}
+
+	if err := c.validatePool(); err != nil {
+		return err
+	}
+
+	if err := c.validateRuntimes(); err != nil {
+		return err
+	}
+
+	if !c.IsCLIBackend() && !c.isAPIBackend() && c.Sandbox.Backend != BackendNamespace && c.Sandbox.Backend != BackendBwrap &&
+		c.Sandbox.Backend != BackendWasm && (c.Sandbox.Backend != BackendLocal || !c.Sandbox.EnableLocalBackend) {
 		return fmt.Errorf("unsupported sandbox.backend: %s", c.Sandbox.Backend)
 	}
 
+	if host := c.Sandbox.EngineHost; host != "" {
+		if !strings.HasPrefix(host, "unix://") && !strings.HasPrefix(host, "tcp://") {
+			return fmt.Errorf("invalid sandbox.engine_host: %s, must start with unix:// or tcp://", host)
+		}
+	}
+
+	if err := c.validatePodman(); err != nil {
+		return err
+	}
+
+	if err := c.validateNamespace(); err != nil {
+		return err
+	}
+
+	if err := c.validateBwrap(); err != nil {
+		return err
+	}
+
+	if err := c.validateWasm(); err != nil {
+		return err
+	}
+
+	if err := c.validateSecurity(); err != nil {
+		return err
+	}
+
+	if err := c.validateResources(); err != nil {
+		return err
+	}
+
+	if err := c.validateFilesystem(); err != nil {
+		return err
+	}
+
+	if err := c.validateEgress(); err != nil {
+		return err
+	}
+
 	if m := c.Logging.Mode; m != LogModeProduction && m != LogModeDevelopment {
 		return fmt.Errorf("invalid logging.mode: %s, must be 'production' or 'development'", m)
 	}
@@ -216,7 +465,558 @@ func (c *Config) validate() error {
 	return nil
 }
 
+// validateRequestLimits ensures the per-request ceilings are not below the defaults they bound.
+func (c *Config) validateRequestLimits() error {
+	if c.Sandbox.MaxTimeoutSec < 0 {
+		return fmt.Errorf("sandbox.max_timeout_sec must not be negative, got: %d", c.Sandbox.MaxTimeoutSec)
+	}
+	if c.Sandbox.MaxTimeoutSec > 0 && c.Sandbox.MaxTimeoutSec < c.Sandbox.TimeoutSec {
+		return fmt.Errorf("sandbox.max_timeout_sec (%d) must not be lower than sandbox.timeout_sec (%d)",
+			c.Sandbox.MaxTimeoutSec, c.Sandbox.TimeoutSec)
+	}
+
+	if c.Sandbox.MaxMemoryMB < 0 {
+		return fmt.Errorf("sandbox.max_memory_mb must not be negative, got: %d", c.Sandbox.MaxMemoryMB)
+	}
+	if c.Sandbox.MaxMemoryMB > 0 && c.Sandbox.MaxMemoryMB < c.Sandbox.MemoryMB {
+		return fmt.Errorf("sandbox.max_memory_mb (%d) must not be lower than sandbox.memory_mb (%d)",
+			c.Sandbox.MaxMemoryMB, c.Sandbox.MemoryMB)
+	}
+
+	if c.Sandbox.SetupTimeoutSec < 0 {
+		return fmt.Errorf("sandbox.setup_timeout_sec must not be negative, got: %d", c.Sandbox.SetupTimeoutSec)
+	}
+	if c.Sandbox.AllowSetup && c.Sandbox.Backend == BackendWasm {
+		return errors.New("sandbox.allow_setup is not supported by the wasm backend, which has no network access")
+	}
+
+	return nil
+}
+
+// validateSessions ensures the session settings are usable.
+func (c *Config) validateSessions() error {
+	if c.Sessions.IdleTTLSec < 0 {
+		return fmt.Errorf("sessions.idle_ttl_sec must not be negative, got: %d", c.Sessions.IdleTTLSec)
+	}
+	if c.Sessions.MaxPerClient < 0 {
+		return fmt.Errorf("sessions.max_per_client must not be negative, got: %d", c.Sessions.MaxPerClient)
+	}
+	return nil
+}
+
+// validatePool ensures the warm container pool settings are usable.
+func (c *Config) validatePool() error {
+	pool := c.Sandbox.Pool
+	if pool.Size < 0 {
+		return fmt.Errorf("sandbox.pool.size must not be negative, got: %d", pool.Size)
+	}
+	if pool.MaxContainers < 0 {
+		return fmt.Errorf("sandbox.pool.max_containers must not be negative, got: %d", pool.MaxContainers)
+	}
+	if pool.HealthCheckIntervalSec < 0 {
+		return fmt.Errorf("sandbox.pool.health_check_interval_sec must not be negative, got: %d", pool.HealthCheckIntervalSec)
+	}
+	if pool.Enabled && !c.IsCLIBackend() {
+		return fmt.Errorf("sandbox.pool is only supported by container CLI backends, got: %s", c.Sandbox.Backend)
+	}
+	return nil
+}
+
+// validateRuntimes ensures that added container CLIs name their binary and do not shadow other backends.
+func (c *Config) validateRuntimes() error {
+	for name, runtime := range c.Sandbox.Runtimes {
+		switch runtime.RuntimeCheck {
+		case "", RuntimeCheckInfo, RuntimeCheckFlag, RuntimeCheckShim:
+		default:
+			return fmt.Errorf("invalid sandbox.runtimes.%s.runtime_check: %s, must be 'info', 'flag' or 'shim'", name, runtime.RuntimeCheck)
+		}
+		switch name {
+		case BackendDockerAPI, BackendPodmanAPI, BackendLocal:
+			return fmt.Errorf("invalid sandbox.runtimes: %s is not a container CLI backend", name)
+		case BackendDocker, BackendPodman, BackendNerdctl:
+		default:
+			if runtime.Binary == "" {
+				return fmt.Errorf("sandbox.runtimes.%s.binary is required", name)
+			}
+		}
+	}
+	return c.validateOCIRuntimes()
+}
+
+// validateOCIRuntimes ensures OCI runtimes are only selected for backends that pass them to the container engine.
+func (c *Config) validateOCIRuntimes() error {
+	if c.IsCLIBackend() || c.isAPIBackend() {
+		return nil
+	}
+	if c.Sandbox.Runtime != "" {
+		return fmt.Errorf("sandbox.runtime is only supported by container backends, got: %s", c.Sandbox.Backend)
+	}
+	for name, lang := range c.Languages {
+		if lang.Runtime != "" {
+			return fmt.Errorf("languages.%s.runtime is only supported by container backends, got: %s", name, c.Sandbox.Backend)
+		}
+	}
+	return nil
+}
+
+// IsCLIBackend reports whether sandbox.backend names a container CLI, built in or added in sandbox.runtimes.
+func (c *Config) IsCLIBackend() bool {
+	switch c.Sandbox.Backend {
+	case BackendDocker, BackendPodman, BackendNerdctl:
+		return true
+	}
+	_, ok := c.Sandbox.Runtimes[c.Sandbox.Backend]
+	return ok
+}
+
+// isAPIBackend reports whether sandbox.backend talks to the REST API of a container engine.
+func (c *Config) isAPIBackend() bool {
+	return c.Sandbox.Backend == BackendDockerAPI || c.Sandbox.Backend == BackendPodmanAPI
+}
+
+// validatePodman ensures the user namespace mode is one podman supports.
+func (c *Config) validatePodman() error {
+	userns := c.Sandbox.Podman.Userns
+	if userns == "" {
+		return nil
+	}
+	mode, _, _ := strings.Cut(userns, ":")
+	switch mode {
+	case "auto", "host", "keep-id", "nomap", "private", "ns":
+	default:
+		return fmt.Errorf("invalid sandbox.podman.userns: %s, must be auto, host, keep-id, nomap, private or ns:<path>", userns)
+	}
+	if c.Sandbox.Backend != BackendPodmanAPI {
+		return fmt.Errorf("sandbox.podman is only supported by the podman-api backend, got: %s", c.Sandbox.Backend)
+	}
+	return nil
+}
+
+// validateNamespace ensures the namespace backend has a root filesystem to run every language in.
+func (c *Config) validateNamespace() error {
+	if c.Sandbox.Backend != BackendNamespace {
+		if c.Sandbox.Namespace != (NamespaceConfig{}) {
+			return fmt.Errorf("sandbox.namespace is only supported by the namespace backend, got: %s", c.Sandbox.Backend)
+		}
+		for name, lang := range c.Languages {
+			if lang.Rootfs != "" && c.Sandbox.Backend != BackendBwrap {
+				return fmt.Errorf("languages.%s.rootfs is only supported by the namespace and bwrap backends, got: %s", name, c.Sandbox.Backend)
+			}
+		}
+		return nil
+	}
+	if c.Sandbox.Namespace.Rootfs != "" {
+		return nil
+	}
+	for name, lang := range c.Languages {
+		if lang.Rootfs == "" {
+			return fmt.Errorf("languages.%s.rootfs or sandbox.namespace.rootfs is required by the namespace backend", name)
+		}
+	}
+	if len(c.Languages) == 0 {
+		return errors.New("sandbox.namespace.rootfs is required by the namespace backend")
+	}
+	return nil
+}
+
+// validateBwrap ensures the paths bound by the bwrap backend are absolute.
+func (c *Config) validateBwrap() error {
+	if c.Sandbox.Backend != BackendBwrap {
+		if c.Sandbox.Bwrap.Binary != "" || c.Sandbox.Bwrap.Rootfs != "" || len(c.Sandbox.Bwrap.Binds) > 0 {
+			return fmt.Errorf("sandbox.bwrap is only supported by the bwrap backend, got: %s", c.Sandbox.Backend)
+		}
+		for name, lang := range c.Languages {
+			if len(lang.Binds) > 0 && c.Sandbox.Backend != BackendWasm {
+				return fmt.Errorf("languages.%s.binds is only supported by the bwrap and wasm backends, got: %s", name, c.Sandbox.Backend)
+			}
+		}
+		return nil
+	}
+
+	if rootfs := c.Sandbox.Bwrap.Rootfs; rootfs != "" && !filepath.IsAbs(rootfs) {
+		return fmt.Errorf("invalid sandbox.bwrap.rootfs: %s is not an absolute path", rootfs)
+	}
+	for _, bind := range c.Sandbox.Bwrap.Binds {
+		if !filepath.IsAbs(bind) {
+			return fmt.Errorf("invalid sandbox.bwrap.binds: %s is not an absolute path", bind)
+		}
+	}
+	for name, lang := range c.Languages {
+		if lang.Rootfs != "" && !filepath.IsAbs(lang.Rootfs) {
+			return fmt.Errorf("invalid languages.%s.rootfs: %s is not an absolute path", name, lang.Rootfs)
+		}
+		for _, bind := range lang.Binds {
+			if !filepath.IsAbs(bind) {
+				return fmt.Errorf("invalid languages.%s.binds: %s is not an absolute path", name, bind)
+			}
+		}
+	}
+	return nil
+}
+
+// validateWasm ensures every language of the wasm backend has a module that runs it, and that its
+// read-only mounts are absolute paths.
+func (c *Config) validateWasm() error {
+	if c.Sandbox.Backend != BackendWasm {
+		if c.Sandbox.Wasm != (WasmConfig{}) {
+			return fmt.Errorf("sandbox.wasm is only supported by the wasm backend, got: %s", c.Sandbox.Backend)
+		}
+		for name, lang := range c.Languages {
+			if lang.Wasm != "" {
+				return fmt.Errorf("languages.%s.wasm is only supported by the wasm backend, got: %s", name, c.Sandbox.Backend)
+			}
+		}
+		return nil
+	}
+
+	if c.Sandbox.Wasm.MaxCalls < 0 {
+		return fmt.Errorf("sandbox.wasm.max_calls must not be negative, got: %d", c.Sandbox.Wasm.MaxCalls)
+	}
+	if len(c.Languages) == 0 {
+		return errors.New("languages with a wasm module are required by the wasm backend")
+	}
+	for name, lang := range c.Languages {
+		if lang.Wasm == "" {
+			return fmt.Errorf("languages.%s.wasm is required by the wasm backend", name)
+		}
+		for _, bind := range lang.Binds {
+			if !filepath.IsAbs(bind) {
+				return fmt.Errorf("invalid languages.%s.binds: %s is not an absolute path", name, bind)
+			}
+		}
+	}
+	return nil
+}
+
+// selinuxLabelOptions are the prefixes of the SELinux label options that container engines accept
+var selinuxLabelOptions = []string{"user:", "role:", "type:", "level:", "filetype:"}
+
+// validateSecurity ensures the security profiles are only set for container backends, that seccomp
+// profile files exist and parse, and that SELinux label options are well-formed.
+func (c *Config) validateSecurity() error {
+	profiles := map[string]SecurityConfig{"sandbox.security": c.Sandbox.Security}
+	for name, lang := range c.Languages {
+		profiles[fmt.Sprintf("languages.%s.security", name)] = lang.Security
+	}
+
+	for key, security := range profiles {
+		if security.IsZero() {
+			continue
+		}
+		if !c.IsCLIBackend() && !c.isAPIBackend() {
+			return fmt.Errorf("%s is only supported by container backends, got: %s", key, c.Sandbox.Backend)
+		}
+
+		switch security.Seccomp {
+		case "", SeccompBuiltin, SecurityUnconfined:
+		default:
+			data, err := os.ReadFile(security.Seccomp)
+			if err != nil {
+				return fmt.Errorf("invalid %s.seccomp: %w", key, err)
+			}
+			if err := validateSeccompProfile(data); err != nil {
+				return fmt.Errorf("invalid %s.seccomp: %s: %w", key, security.Seccomp, err)
+			}
+		}
+
+		if strings.ContainsAny(security.AppArmor, " \t\n=") {
+			return fmt.Errorf("invalid %s.apparmor: %q is not a profile name", key, security.AppArmor)
+		}
+
+		if len(security.SELinux) > 0 && c.Sandbox.Backend == BackendNerdctl {
+			return fmt.Errorf("%s.selinux is not supported by the nerdctl backend", key)
+		}
+		for _, option := range security.SELinux {
+			valid := option == "disable" || slices.ContainsFunc(selinuxLabelOptions, func(prefix string) bool {
+				return strings.HasPrefix(option, prefix) && len(option) > len(prefix)
+			})
+			if !valid {
+				return fmt.Errorf("invalid %s.selinux: %s, must be disable or start with user:, role:, type:, level: or filetype:", key, option)
+			}
+		}
+	}
+	return nil
+}
+
+// validateResources ensures the resource limits are only set for container backends and are consistent
+// with each other and with the memory limit and phase timeouts.
+func (c *Config) validateResources() error {
+	limits := map[string]ResourcesConfig{"sandbox.resources": c.Sandbox.Resources}
+	for name, lang := range c.Languages {
+		limits[fmt.Sprintf("languages.%s.resources", name)] = lang.Resources
+	}
+
+	for key, resources := range limits {
+		if resources.CPUs == 0 && resources.PidsLimit == 0 && resources.MemorySwapMB == 0 && resources.ShmSizeMB == 0 &&
+			len(resources.Ulimits) == 0 {
+			continue
+		}
+		if !c.IsCLIBackend() && !c.isAPIBackend() {
+			return fmt.Errorf("%s is only supported by container backends, got: %s", key, c.Sandbox.Backend)
+		}
+
+		if resources.CPUs < 0 {
+			return fmt.Errorf("%s.cpus must not be negative, got: %g", key, resources.CPUs)
+		}
+		if resources.PidsLimit < -1 {
+			return fmt.Errorf("%s.pids_limit must be positive or -1 for unlimited, got: %d", key, resources.PidsLimit)
+		}
+		if resources.MemorySwapMB < -1 {
+			return fmt.Errorf("%s.memory_swap_mb must be positive or -1 for unlimited, got: %d", key, resources.MemorySwapMB)
+		}
+		if resources.ShmSizeMB < 0 {
+			return fmt.Errorf("%s.shm_size_mb must not be negative, got: %d", key, resources.ShmSizeMB)
+		}
+		// /dev/shm is charged to the memory of the container, so a larger one could never be filled
+		if resources.ShmSizeMB > c.Sandbox.MemoryMB {
+			return fmt.Errorf("%s.shm_size_mb (%d) must not exceed sandbox.memory_mb (%d)", key, resources.ShmSizeMB, c.Sandbox.MemoryMB)
+		}
+
+		for name, value := range resources.Ulimits {
+			if !slices.Contains(ulimitNames, name) {
+				return fmt.Errorf("invalid %s.ulimits: unknown limit %s", key, name)
+			}
+			if value <= 0 {
+				return fmt.Errorf("invalid %s.ulimits: %s must be positive, got: %d", key, name, value)
+			}
+		}
+
+		// A CPU time limit below the phase timeouts kills programs before they time out
+		if cpu, ok := resources.Ulimits["cpu"]; ok {
+			longest := max(time.Duration(c.GetMaxTimeoutSec())*time.Second, c.GetBuildTimeout())
+			if c.Sandbox.AllowSetup {
+				longest = max(longest, c.GetSetupTimeout())
+			}
+			if time.Duration(cpu)*time.Second < longest {
+				return fmt.Errorf("%s.ulimits.cpu (%d) must not be lower than the longest phase timeout (%d seconds)",
+					key, cpu, int(longest.Seconds()))
+			}
+		}
+	}
+	return nil
+}
+
+// validateFilesystem ensures the tmpfs and workdir sizes are usable. The size-capped workdir is a tmpfs
+// volume that is copied through a container, which needs a container CLI with cp and rules out the warm pool.
+func (c *Config) validateFilesystem() error {
+	filesystem := c.Sandbox.Filesystem
+	if filesystem.TmpfsSizeMB < 0 {
+		return fmt.Errorf("sandbox.filesystem.tmpfs_size_mb must not be negative, got: %d", filesystem.TmpfsSizeMB)
+	}
+	if filesystem.WorkdirSizeMB < 0 {
+		return fmt.Errorf("sandbox.filesystem.workdir_size_mb must not be negative, got: %d", filesystem.WorkdirSizeMB)
+	}
+	if filesystem.WorkdirSizeMB == 0 {
+		return nil
+	}
+
+	if !c.IsCLIBackend() {
+		return fmt.Errorf("sandbox.filesystem.workdir_size_mb is only supported by container CLI backends, got: %s", c.Sandbox.Backend)
+	}
+	if c.Sandbox.Pool.Enabled {
+		return errors.New("sandbox.filesystem.workdir_size_mb cannot be combined with sandbox.pool")
+	}
+	// The workdir is held in memory and charged to the containers that write it
+	if filesystem.WorkdirSizeMB > c.Sandbox.MemoryMB {
+		return fmt.Errorf("sandbox.filesystem.workdir_size_mb (%d) must not exceed sandbox.memory_mb (%d)",
+			filesystem.WorkdirSizeMB, c.Sandbox.MemoryMB)
+	}
+	return nil
+}
+
 // GetTimeout returns the execution timeout as a duration.
 func (c *Config) GetTimeout() time.Duration {
 	return time.Duration(c.Sandbox.TimeoutSec) * time.Second
 }
+
+// GetBuildTimeout returns the build timeout as a duration, falling back to the execution timeout when unset.
+func (c *Config) GetBuildTimeout() time.Duration {
+	if c.Sandbox.BuildTimeoutSec <= 0 {
+		return c.GetTimeout()
+	}
+	return time.Duration(c.Sandbox.BuildTimeoutSec) * time.Second
+}
+
+// GetSetupTimeout returns the time limit of the setup phase, falling back to the default when unset.
+func (c *Config) GetSetupTimeout() time.Duration {
+	if c.Sandbox.SetupTimeoutSec <= 0 {
+		return DefaultSetupTimeoutSec * time.Second
+	}
+	return time.Duration(c.Sandbox.SetupTimeoutSec) * time.Second
+}
+
+// GetMaxStdinSize returns the stdin size limit in bytes, falling back to the default when unset.
+func (c *Config) GetMaxStdinSize() int {
+	if c.Sandbox.MaxStdinSizeKB <= 0 {
+		return DefaultMaxStdinSizeKB * bytesPerKB
+	}
+	return c.Sandbox.MaxStdinSizeKB * bytesPerKB
+}
+
+// GetMaxStdoutSize returns the captured stdout limit in bytes, falling back to the default when unset.
+func (c *Config) GetMaxStdoutSize() int {
+	if c.Sandbox.MaxStdoutSizeKB <= 0 {
+		return DefaultMaxOutputSizeKB * bytesPerKB
+	}
+	return c.Sandbox.MaxStdoutSizeKB * bytesPerKB
+}
+
+// GetMaxStderrSize returns the captured stderr limit in bytes, falling back to the default when unset.
+func (c *Config) GetMaxStderrSize() int {
+	if c.Sandbox.MaxStderrSizeKB <= 0 {
+		return DefaultMaxOutputSizeKB * bytesPerKB
+	}
+	return c.Sandbox.MaxStderrSizeKB * bytesPerKB
+}
+
+// GetMaxTestCases returns the maximum number of test cases per batch, falling back to the default when unset.
+func (c *Config) GetMaxTestCases() int {
+	if c.Sandbox.MaxTestCases <= 0 {
+		return DefaultMaxTestCases
+	}
+	return c.Sandbox.MaxTestCases
+}
+
+// GetMaxTimeoutSec returns the largest run timeout a request may ask for, falling back to the default timeout when unset.
+func (c *Config) GetMaxTimeoutSec() int {
+	if c.Sandbox.MaxTimeoutSec <= 0 {
+		return c.Sandbox.TimeoutSec
+	}
+	return c.Sandbox.MaxTimeoutSec
+}
+
+// GetMaxMemoryMB returns the largest memory limit a request may ask for, falling back to the default limit when unset.
+func (c *Config) GetMaxMemoryMB() int {
+	if c.Sandbox.MaxMemoryMB <= 0 {
+		return c.Sandbox.MemoryMB
+	}
+	return c.Sandbox.MaxMemoryMB
+}
+
+// GetRuntime returns the OCI runtime of a language, falling back to sandbox.runtime when the language sets none.
+// An empty runtime leaves the choice to the container engine.
+func (c *Config) GetRuntime(language string) string {
+	if lang, ok := c.Languages[language]; ok && lang.Runtime != "" {
+		return lang.Runtime
+	}
+	return c.Sandbox.Runtime
+}
+
+// GetSecurity returns the security profiles of a language's containers: sandbox.security with the
+// fields that the language sets overridden.
+func (c *Config) GetSecurity(language string) SecurityConfig {
+	security := c.Sandbox.Security
+	lang, ok := c.Languages[language]
+	if !ok {
+		return security
+	}
+	if lang.Security.Seccomp != "" {
+		security.Seccomp = lang.Security.Seccomp
+	}
+	if lang.Security.AppArmor != "" {
+		security.AppArmor = lang.Security.AppArmor
+	}
+	if len(lang.Security.SELinux) > 0 {
+		security.SELinux = lang.Security.SELinux
+	}
+	return security
+}
+
+// GetResources returns the resource limits of a language's containers: sandbox.resources with the fields
+// and ulimits that the language sets overridden, and the default PIDs and file size limits when neither sets them.
+func (c *Config) GetResources(language string) ResourcesConfig {
+	resources := c.Sandbox.Resources
+	resources.Ulimits = map[string]int64{"fsize": DefaultFileSizeLimit}
+	maps.Copy(resources.Ulimits, c.Sandbox.Resources.Ulimits)
+
+	if lang, ok := c.Languages[language]; ok {
+		if lang.Resources.CPUs != 0 {
+			resources.CPUs = lang.Resources.CPUs
+		}
+		if lang.Resources.PidsLimit != 0 {
+			resources.PidsLimit = lang.Resources.PidsLimit
+		}
+		if lang.Resources.MemorySwapMB != 0 {
+			resources.MemorySwapMB = lang.Resources.MemorySwapMB
+		}
+		if lang.Resources.ShmSizeMB != 0 {
+			resources.ShmSizeMB = lang.Resources.ShmSizeMB
+		}
+		maps.Copy(resources.Ulimits, lang.Resources.Ulimits)
+	}
+
+	if resources.PidsLimit == 0 {
+		resources.PidsLimit = DefaultPidsLimit
+	}
+	return resources
+}
+
+// GetRootfs returns the root filesystem directory of a language for the namespace and bwrap backends,
+// falling back to sandbox.namespace.rootfs or sandbox.bwrap.rootfs when the language sets none.
+func (c *Config) GetRootfs(language string) string {
+	if lang, ok := c.Languages[language]; ok && lang.Rootfs != "" {
+		return lang.Rootfs
+	}
+	if c.Sandbox.Backend == BackendBwrap {
+		return c.Sandbox.Bwrap.Rootfs
+	}
+	return c.Sandbox.Namespace.Rootfs
+}
+
+// GetRuntimes returns every OCI runtime selected by sandbox.runtime or a language, sorted and without duplicates.
+func (c *Config) GetRuntimes() []string {
+	runtimes := []string{}
+	if c.Sandbox.Runtime != "" {
+		runtimes = append(runtimes, c.Sandbox.Runtime)
+	}
+	for _, lang := range c.Languages {
+		if lang.Runtime != "" {
+			runtimes = append(runtimes, lang.Runtime)
+		}
+	}
+	slices.Sort(runtimes)
+	return slices.Compact(runtimes)
+}
+
+// GetSessionIdleTTL returns how long an unused session is kept, falling back to the default when unset.
+func (c *Config) GetSessionIdleTTL() time.Duration {
+	if c.Sessions.IdleTTLSec <= 0 {
+		return DefaultSessionIdleTTLSec * time.Second
+	}
+	return time.Duration(c.Sessions.IdleTTLSec) * time.Second
+}
+
+// GetMaxSessionsPerClient returns how many sessions a client may keep open, falling back to the default when unset.
+func (c *Config) GetMaxSessionsPerClient() int {
+	if c.Sessions.MaxPerClient <= 0 {
+		return DefaultMaxSessionsPerClient
+	}
+	return c.Sessions.MaxPerClient
+}
+
+// GetTmpfsSizeMB returns the size of the tmpfs mounts at /tmp and $HOME of containers, falling back to the default when unset.
+func (c *Config) GetTmpfsSizeMB() int {
+	if c.Sandbox.Filesystem.TmpfsSizeMB <= 0 {
+		return DefaultTmpfsSizeMB
+	}
+	return c.Sandbox.Filesystem.TmpfsSizeMB
+}
+
+// GetInstance returns the name that labels the containers of this server, falling back to the host name when unset.
+func (c *Config) GetInstance() string {
+	if c.Sandbox.Instance != "" {
+		return c.Sandbox.Instance
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		return hostname
+	}
+	return DefaultInstance
+}
+
+// GetPoolHealthCheckInterval returns how often idle pool containers are checked, falling back to the default when unset.
+func (c *Config) GetPoolHealthCheckInterval() time.Duration {
+	if c.Sandbox.Pool.HealthCheckIntervalSec <= 0 {
+		return DefaultPoolHealthCheckIntervalSec * time.Second
+	}
+	return time.Duration(c.Sandbox.Pool.HealthCheckIntervalSec) * time.Second
+}
@@ -0,0 +1,132 @@
+// Package config provides application configuration management.
+//
+// The config package handles loading and validation of the application's
+// configuration from YAML files. This file holds the configuration of the
+// egress proxy that network access of containers goes through and the
+// validation of its allowlists.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Defaults of the egress proxy.
+const (
+	DefaultEgressPort    = "3128"
+	DefaultEgressNetwork = "codebox-egress"
+)
+
+// EgressConfig holds the egress proxy of the container CLI backends. With the proxy, containers with network
+// access join an internal network that only reaches the proxy, which lets through the hosts of the allowlist.
+type EgressConfig struct {
+	Enabled  bool     `mapstructure:"enabled"`   // route network access through the proxy
+	Listen   string   `mapstructure:"listen"`    // address the proxy listens on, default: port 3128 on the gateway of the network
+	Network  string   `mapstructure:"network"`   // internal network of the containers, default: codebox-egress
+	ProxyURL string   `mapstructure:"proxy_url"` // URL containers reach the proxy at, default: the gateway of the network
+	Allow    []string `mapstructure:"allow"`     // hosts, *.domains, IP addresses and CIDRs that may be reached
+}
+
+// EgressPolicy holds the allowlist of a language, replacing sandbox.egress.allow.
+type EgressPolicy struct {
+	Allow []string `mapstructure:"allow"`
+}
+
+// dnsLabel matches a single label of a DNS name
+const dnsLabel = `[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?`
+
+// hostnamePattern matches DNS names, optionally with a leading *. for every subdomain
+var hostnamePattern = regexp.MustCompile(`^(\*\.)?(` + dnsLabel + `\.)*` + dnsLabel + `$`)
+
+// ValidateEgressRule ensures an allowlist entry is a hostname, a *. wildcard of the subdomains of a domain,
+// an IP address or a CIDR.
+func ValidateEgressRule(rule string) error {
+	if _, err := netip.ParsePrefix(rule); err == nil {
+		return nil
+	}
+	if _, err := netip.ParseAddr(rule); err == nil {
+		return nil
+	}
+	if !hostnamePattern.MatchString(rule) {
+		return fmt.Errorf("%s is not a hostname, *.domain, IP address or CIDR", rule)
+	}
+	return nil
+}
+
+// validateEgress ensures the egress proxy can be started and its allowlists parse. The proxy relies on
+// creating an internal network with the container CLI.
+func (c *Config) validateEgress() error {
+	egress := c.Sandbox.Egress
+	for name, lang := range c.Languages {
+		if lang.Egress.Allow != nil && !egress.Enabled {
+			return fmt.Errorf("languages.%s.egress requires sandbox.egress.enabled", name)
+		}
+	}
+	if !egress.Enabled {
+		return nil
+	}
+
+	if !c.IsCLIBackend() {
+		return fmt.Errorf("sandbox.egress is only supported by container CLI backends, got: %s", c.Sandbox.Backend)
+	}
+	if egress.Listen != "" {
+		if _, _, err := net.SplitHostPort(egress.Listen); err != nil {
+			return fmt.Errorf("invalid sandbox.egress.listen: %w", err)
+		}
+	}
+	if strings.ContainsAny(egress.Network, " \t\n/:") {
+		return fmt.Errorf("invalid sandbox.egress.network: %q is not a network name", egress.Network)
+	}
+	if egress.ProxyURL != "" {
+		proxyURL, err := url.Parse(egress.ProxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid sandbox.egress.proxy_url: %w", err)
+		}
+		if proxyURL.Scheme != "http" || proxyURL.Host == "" || proxyURL.User != nil {
+			return errors.New("invalid sandbox.egress.proxy_url: must be http://host:port without credentials")
+		}
+	}
+
+	allowlists := map[string][]string{"sandbox.egress.allow": egress.Allow}
+	for name, lang := range c.Languages {
+		allowlists[fmt.Sprintf("languages.%s.egress.allow", name)] = lang.Egress.Allow
+	}
+	for key, rules := range allowlists {
+		for _, rule := range rules {
+			if err := ValidateEgressRule(rule); err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetEgressListen returns the address of the egress proxy, falling back to the default port on the gateway
+// of the egress network when unset, so that the proxy is not reachable from other networks of the host.
+func (c *Config) GetEgressListen(gateway string) string {
+	if c.Sandbox.Egress.Listen == "" {
+		return net.JoinHostPort(gateway, DefaultEgressPort)
+	}
+	return c.Sandbox.Egress.Listen
+}
+
+// GetEgressNetwork returns the internal network of containers with network access, falling back to the default when unset.
+func (c *Config) GetEgressNetwork() string {
+	if c.Sandbox.Egress.Network == "" {
+		return DefaultEgressNetwork
+	}
+	return c.Sandbox.Egress.Network
+}
+
+// GetEgressAllow returns the allowlist of a language: its own, or sandbox.egress.allow when it has none.
+func (c *Config) GetEgressAllow(language string) []string {
+	if lang, ok := c.Languages[language]; ok && lang.Egress.Allow != nil {
+		return lang.Egress.Allow
+	}
+	return c.Sandbox.Egress.Allow
+}
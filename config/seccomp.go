@@ -0,0 +1,67 @@
+// Package config provides application configuration management.
+//
+// The config package handles loading and validation of the application's
+// configuration from YAML files. This file holds the seccomp profile that
+// ships with codebox and the validation of seccomp profiles.
+package config
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Values of the security profiles with a special meaning.
+const (
+	SeccompBuiltin     = "builtin"    // the seccomp profile shipped with codebox
+	SecurityUnconfined = "unconfined" // no seccomp filter or AppArmor profile
+)
+
+// BuiltinSeccompProfile is the strict seccomp profile that codebox ships: it allows every system call except
+// those that administer the system, change mounts and namespaces, inspect other processes or expose large parts
+// of the kernel, like mount, ptrace, keyctl, bpf and io_uring, and allows personality with the same arguments
+// as the default profile of docker only.
+//
+//go:embed seccomp.json
+var BuiltinSeccompProfile []byte
+
+// seccompActions are the actions that container runtimes accept in seccomp profiles
+var seccompActions = []string{
+	"SCMP_ACT_ALLOW", "SCMP_ACT_ERRNO", "SCMP_ACT_KILL", "SCMP_ACT_KILL_PROCESS", "SCMP_ACT_KILL_THREAD",
+	"SCMP_ACT_TRAP", "SCMP_ACT_TRACE", "SCMP_ACT_LOG", "SCMP_ACT_NOTIFY",
+}
+
+// seccompProfile holds the parts of a seccomp profile in the format of docker and podman that are validated
+type seccompProfile struct {
+	DefaultAction string `json:"defaultAction"`
+	Syscalls      []struct {
+		Name   string   `json:"name"` // single system call of older profiles
+		Names  []string `json:"names"`
+		Action string   `json:"action"`
+	} `json:"syscalls"`
+}
+
+// validateSeccompProfile ensures data is a seccomp profile that container runtimes can load
+func validateSeccompProfile(data []byte) error {
+	var profile seccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return err
+	}
+	if profile.DefaultAction == "" {
+		return errors.New("defaultAction is required")
+	}
+	if !slices.Contains(seccompActions, profile.DefaultAction) {
+		return fmt.Errorf("unknown defaultAction: %s", profile.DefaultAction)
+	}
+	for i, syscall := range profile.Syscalls {
+		if syscall.Name == "" && len(syscall.Names) == 0 {
+			return fmt.Errorf("syscalls[%d] names no system call", i)
+		}
+		if !slices.Contains(seccompActions, syscall.Action) {
+			return fmt.Errorf("unknown action of syscalls[%d]: %s", i, syscall.Action)
+		}
+	}
+	return nil
+}
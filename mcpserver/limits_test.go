@@ -0,0 +1,146 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+	"github.com/isdmx/codebox/sandbox"
+)
+
+func TestRequestLimits(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	newConfig := func() *config.Config {
+		return &config.Config{
+			Server: config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+			Sandbox: config.SandboxConfig{
+				TimeoutSec:        10,
+				MemoryMB:          256,
+				MaxArtifactSizeMB: 20,
+				MaxTimeoutSec:     120,
+				MaxMemoryMB:       2048,
+			},
+			Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+			Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+		}
+	}
+	enabled := true
+
+	tests := []struct {
+		name         string
+		allowNetwork bool
+		args         ExecuteRequest
+		want         sandbox.Limits
+	}{
+		{"Defaults", false, ExecuteRequest{}, sandbox.Limits{TimeoutSec: 10, MemoryMB: 256}},
+		{"WithinCeilings", false, ExecuteRequest{TimeoutSec: 60, MemoryMB: 1024}, sandbox.Limits{TimeoutSec: 60, MemoryMB: 1024}},
+		{"ClampedToCeilings", false, ExecuteRequest{TimeoutSec: 600, MemoryMB: 8192}, sandbox.Limits{TimeoutSec: 120, MemoryMB: 2048}},
+		{"NetworkNotAllowed", false, ExecuteRequest{Network: &enabled}, sandbox.Limits{TimeoutSec: 10, MemoryMB: 256}},
+		{"NetworkAllowed", true, ExecuteRequest{Network: &enabled}, sandbox.Limits{TimeoutSec: 10, MemoryMB: 256, Network: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := newConfig()
+			cfg.Sandbox.AllowRequestNetwork = tt.allowNetwork
+			mockExecutor := &RecordingSandboxExecutor{executeResult: sandbox.ExecuteResult{Limits: tt.want}}
+			server, err := New(cfg, logger, mockExecutor)
+			require.NoError(t, err)
+
+			args := tt.args
+			args.Code = "print(1)"
+			args.Language = sandbox.LanguagePython
+			resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, args)
+			require.NoError(t, err)
+			require.True(t, resp.Success)
+
+			assert.Equal(t, tt.want.TimeoutSec, mockExecutor.lastRequest.TimeoutSec)
+			assert.Equal(t, tt.want.MemoryMB, mockExecutor.lastRequest.MemoryMB)
+			assert.Equal(t, tt.want.Network, mockExecutor.lastRequest.Network)
+			require.NotNil(t, resp.Limits)
+			assert.Equal(t, LimitsResponse{TimeoutSec: tt.want.TimeoutSec, MemoryMB: tt.want.MemoryMB, Network: tt.want.Network}, *resp.Limits)
+		})
+	}
+
+	t.Run("RejectsNegativeValues", func(t *testing.T) {
+		mockExecutor := &RecordingSandboxExecutor{}
+		server, err := New(newConfig(), logger, mockExecutor)
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, ExecuteRequest{
+			Code:       "print(1)",
+			Language:   sandbox.LanguagePython,
+			TimeoutSec: -5,
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.Success)
+		assert.Contains(t, resp.Error, "timeout_sec must not be negative")
+		assert.Equal(t, 0, mockExecutor.calls)
+	})
+}
+
+func TestRequestSetup(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	newServer := func(t *testing.T, allowSetup bool, executor sandbox.SandboxExecutor) *MCPServer {
+		t.Helper()
+		cfg := &config.Config{
+			Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+			Sandbox:   config.SandboxConfig{TimeoutSec: 10, MemoryMB: 256, MaxArtifactSizeMB: 20, AllowSetup: allowSetup, SetupTimeoutSec: 300},
+			Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+			Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+		}
+		server, err := New(cfg, logger, executor)
+		require.NoError(t, err)
+		return server
+	}
+	args := ExecuteRequest{Code: "import requests", Language: sandbox.LanguagePython, Setup: "pip install --target . requests"}
+
+	t.Run("PassesSetupToExecutor", func(t *testing.T) {
+		mockExecutor := &RecordingSandboxExecutor{executeResult: sandbox.ExecuteResult{
+			Status: sandbox.StatusOK,
+			Setup:  &sandbox.PhaseResult{Stdout: "Successfully installed requests", Status: sandbox.StatusOK},
+			Run:    &sandbox.PhaseResult{Status: sandbox.StatusOK},
+		}}
+		server := newServer(t, true, mockExecutor)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		require.True(t, resp.Success)
+		assert.Equal(t, args.Setup, mockExecutor.lastRequest.Setup)
+		assert.Equal(t, 300, mockExecutor.lastRequest.SetupTimeoutSec)
+		assert.False(t, mockExecutor.lastRequest.Network, "the code itself stays offline")
+		require.NotNil(t, resp.Setup)
+		assert.Equal(t, "Successfully installed requests", resp.Setup.Stdout)
+		require.NotNil(t, resp.Run)
+	})
+
+	t.Run("SetupTimeoutOnlyLowers", func(t *testing.T) {
+		for requested, want := range map[int]int{60: 60, 900: 300} {
+			mockExecutor := &RecordingSandboxExecutor{}
+			server := newServer(t, true, mockExecutor)
+
+			withTimeout := args
+			withTimeout.SetupTimeoutSec = requested
+			resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, withTimeout)
+			require.NoError(t, err)
+			require.True(t, resp.Success)
+			assert.Equal(t, want, mockExecutor.lastRequest.SetupTimeoutSec)
+		}
+	})
+
+	t.Run("RejectedWhenNotAllowed", func(t *testing.T) {
+		mockExecutor := &RecordingSandboxExecutor{}
+		server := newServer(t, false, mockExecutor)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		assert.False(t, resp.Success)
+		assert.Contains(t, resp.Error, "setup is not enabled")
+		assert.Equal(t, 0, mockExecutor.calls)
+	})
+}
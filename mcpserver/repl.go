@@ -0,0 +1,213 @@
+// Package mcpserver provides the Model Context Protocol (MCP) server implementation.
+//
+// REPL sessions keep a Python or Node.js interpreter running next to the
+// session workdir. Code sent to execute_in_session is evaluated in that
+// interpreter, so variables and imports survive between calls. A running
+// snippet can be interrupted, and restarting the session drops the interpreter
+// state while keeping the files.
+package mcpserver
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/sandbox"
+)
+
+// errNoRepl is returned for REPL operations on sessions that only keep a workdir
+var errNoRepl = errors.New("session has no REPL, create it with the repl option")
+
+// SessionActionRequest represents the input parameters for interrupting or restarting a REPL session
+type SessionActionRequest struct {
+	SessionID string `json:"session_id" jsonschema_description:"Identifier returned by create_session" jsonschema:"required"`
+}
+
+// SessionActionResponse represents the structured response from interrupting or restarting a REPL session
+type SessionActionResponse struct {
+	Error   string `json:"error,omitempty" jsonschema_description:"Error message if the operation failed"`
+	Success bool   `json:"success" jsonschema_description:"Indicates if the operation succeeded"`
+}
+
+// registerReplTools registers the interrupt_session and restart_session tools when the backend supports REPLs
+func (s *MCPServer) registerReplTools() {
+	if _, ok := s.sandboxExec.(sandbox.ReplExecutor); !ok {
+		s.logger.Info("sandbox backend does not support REPL sessions, interrupt_session and restart_session are disabled")
+		return
+	}
+
+	interruptTool := mcp.NewTool("interrupt_session",
+		mcp.WithDescription("Interrupt the snippet running in a REPL session, keeping the interpreter state"),
+		mcp.WithInputSchema[SessionActionRequest](),
+		mcp.WithOutputSchema[SessionActionResponse](),
+	)
+	s.mcpServer.AddTool(interruptTool, mcp.NewStructuredToolHandler(s.handleInterruptSession))
+
+	restartTool := mcp.NewTool("restart_session",
+		mcp.WithDescription("Restart the interpreter of a REPL session, dropping its state but keeping the working directory"),
+		mcp.WithInputSchema[SessionActionRequest](),
+		mcp.WithOutputSchema[SessionActionResponse](),
+	)
+	s.mcpServer.AddTool(restartTool, mcp.NewStructuredToolHandler(s.handleRestartSession))
+}
+
+// startRepl starts the interpreter of a REPL session in its workdir with the memory limit and network access of limits
+func (s *MCPServer) startRepl(
+	ctx context.Context,
+	language string,
+	limits sandbox.Limits,
+	session *sandbox.Session,
+) (*sandbox.Repl, error) {
+	replExec, ok := s.sandboxExec.(sandbox.ReplExecutor)
+	if !ok {
+		return nil, errors.New("the sandbox backend does not support REPL sessions")
+	}
+	if _, ok := s.languages.Get(language); !ok {
+		return nil, fmt.Errorf("invalid language: %s", language)
+	}
+
+	repl, err := replExec.StartRepl(ctx, sandbox.ReplRequest{
+		Language: language,
+		Workdir:  session.Workdir(),
+		MemoryMB: limits.MemoryMB,
+		Network:  limits.Network,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start REPL: %w", err)
+	}
+	return repl, nil
+}
+
+// evalInRepl evaluates the code of an execute_in_session call in the interpreter of a REPL session
+func (s *MCPServer) evalInRepl(
+	ctx context.Context,
+	repl *sandbox.Repl,
+	session *sandbox.Session,
+	execReq *sandbox.ExecuteRequest,
+	includeArtifacts bool,
+) ExecuteResponse {
+	if execReq.Language != repl.Language() {
+		return ExecuteResponse{Error: fmt.Sprintf("the REPL of this session runs %s, not %s", repl.Language(), execReq.Language)}
+	}
+	if execReq.Stdin != nil {
+		return ExecuteResponse{Error: "stdin is not supported in REPL sessions"}
+	}
+
+	result, err := repl.Eval(ctx, execReq.Code, time.Duration(execReq.TimeoutSec)*time.Second)
+	if err != nil {
+		s.logger.Error("REPL evaluation failed", zap.String("session_id", session.ID), zap.Error(err))
+		return ExecuteResponse{
+			ExitCode: 1,
+			Status:   string(sandbox.StatusInternalError),
+			Error:    fmt.Sprintf("execution failed: %v; restart the session to start a new interpreter", err),
+		}
+	}
+
+	s.logger.Info("REPL evaluation completed",
+		zap.String("session_id", session.ID),
+		zap.String("status", string(result.Status)),
+		zap.Duration("duration", result.Duration))
+
+	response := ExecuteResponse{
+		Stdout:      result.Stdout,
+		Stderr:      result.Stderr,
+		OutputStats: newOutputStats(result.Output),
+		Status:      string(result.Status),
+		Result:      result.Result,
+		Exception:   result.Exception,
+		Limits:      &LimitsResponse{TimeoutSec: execReq.TimeoutSec},
+		Usage:       &UsageResponse{WallTimeMs: result.Duration.Milliseconds()},
+		Success:     true,
+	}
+	if result.Status != sandbox.StatusOK {
+		response.ExitCode = 1
+	}
+
+	if includeArtifacts {
+		artifactsTar, err := s.sessionArtifacts(session, repl.Language())
+		if err != nil {
+			response.Error = err.Error()
+			response.Success = false
+			return response
+		}
+		response.ArtifactsTar = base64.StdEncoding.EncodeToString(artifactsTar)
+	}
+
+	return response
+}
+
+// sessionArtifacts returns the session workdir as a tar, bounded by the artifact size limit
+func (s *MCPServer) sessionArtifacts(session *sandbox.Session, language string) ([]byte, error) {
+	artifactsTar, err := sandbox.CreateTarFromDirWithExcludes(session.Workdir(), s.config.Languages[language].ExcludePatterns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create artifacts tar: %w", err)
+	}
+
+	if limit := s.config.Sandbox.MaxArtifactSizeMB * sandbox.MaxArtifactSizeMul; len(artifactsTar) > limit {
+		return nil, fmt.Errorf("artifacts size exceeds limit: %d bytes > %d bytes", len(artifactsTar), limit)
+	}
+	return artifactsTar, nil
+}
+
+// handleInterruptSession handles the interrupt_session tool. It does not wait for the running snippet,
+// whose execute_in_session call returns with the interrupted status.
+func (s *MCPServer) handleInterruptSession(
+	_ context.Context,
+	_ mcp.CallToolRequest,
+	args SessionActionRequest,
+) (SessionActionResponse, error) {
+	session, err := s.sessions.Get(args.SessionID)
+	if err != nil {
+		return SessionActionResponse{Error: err.Error()}, nil
+	}
+
+	repl := session.Repl()
+	if repl == nil {
+		return SessionActionResponse{Error: errNoRepl.Error()}, nil
+	}
+	if err := repl.Interrupt(); err != nil {
+		return SessionActionResponse{Error: err.Error()}, nil
+	}
+
+	s.logger.Info("session interrupted", zap.String("session_id", args.SessionID))
+	return SessionActionResponse{Success: true}, nil
+}
+
+// handleRestartSession handles the restart_session tool, waiting for a running snippet to finish
+func (s *MCPServer) handleRestartSession(
+	ctx context.Context,
+	_ mcp.CallToolRequest,
+	args SessionActionRequest,
+) (SessionActionResponse, error) {
+	session, release, err := s.sessions.Acquire(args.SessionID)
+	if err != nil {
+		return SessionActionResponse{Error: err.Error()}, nil
+	}
+	defer release()
+
+	previous := session.Repl()
+	if previous == nil {
+		return SessionActionResponse{Error: errNoRepl.Error()}, nil
+	}
+
+	// The old interpreter is stopped first, so that both never hold memory at the same time.
+	// It stays in the session when the new one fails to start, so that the restart can be retried.
+	// The new one gets the limits the session was created with.
+	previous.Close()
+	started := previous.Request()
+	limits := sandbox.Limits{MemoryMB: started.MemoryMB, Network: started.Network}
+	repl, err := s.startRepl(ctx, started.Language, limits, session)
+	if err != nil {
+		s.logger.Warn("failed to restart REPL", zap.String("session_id", args.SessionID), zap.Error(err))
+		return SessionActionResponse{Error: err.Error()}, nil
+	}
+	session.SetRepl(repl)
+
+	s.logger.Info("session restarted", zap.String("session_id", args.SessionID))
+	return SessionActionResponse{Success: true}, nil
+}
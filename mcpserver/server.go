@@ -9,6 +9,8 @@ package mcpserver
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/mark3labs/mcp-go/mcp"
@@ -21,19 +23,115 @@ import (
 
 // ExecuteRequest represents the input parameters for code execution
 type ExecuteRequest struct {
-	Code       string `json:"code" jsonschema_description:"User-provided source code" jsonschema:"required"`
-	Language   string `json:"language" jsonschema:"enum=python,enum=nodejs,enum=go,enum=cpp,required"`
-	WorkdirTar string `json:"workdir_tar,omitempty" jsonschema_description:"Base64-encoded tar.gz of initial working directory (optional)"`
+	Code            string `json:"code" jsonschema_description:"User-provided source code" jsonschema:"required"`
+	Language        string `json:"language" jsonschema_description:"Programming language of the code" jsonschema:"required"`
+	WorkdirTar      string `json:"workdir_tar,omitempty" jsonschema_description:"Base64-encoded tar.gz of initial working directory (optional)"`
+	Stdin           string `json:"stdin,omitempty" jsonschema_description:"Data passed to the standard input of the program (optional)"`
+	TimeoutSec      int    `json:"timeout_sec,omitempty" jsonschema_description:"Run time limit in seconds, capped by the server maximum (optional)"`
+	MemoryMB        int    `json:"memory_mb,omitempty" jsonschema_description:"Memory limit in MB, capped by the server maximum (optional)"`
+	Network         *bool  `json:"network,omitempty" jsonschema_description:"Request network access, honoured only when the server allows it (optional)"`
+	Setup           string `json:"setup,omitempty" jsonschema_description:"Shell command run with network access before the code, e.g. pip install -r requirements.txt (optional)"` //nolint:lll // Struct tags cannot be split
+	SetupTimeoutSec int    `json:"setup_timeout_sec,omitempty" jsonschema_description:"Time limit of the setup command in seconds, capped by the server limit (optional)"`          //nolint:lll // Struct tags cannot be split
 }
 
 // ExecuteResponse represents the structured response from code execution
 type ExecuteResponse struct {
-	Stdout       string `json:"stdout" jsonschema_description:"Standard output from execution"`
-	Stderr       string `json:"stderr" jsonschema_description:"Standard error from execution"`
-	ExitCode     int    `json:"exit_code" jsonschema_description:"Exit code of the process"`
-	ArtifactsTar string `json:"artifacts_tar,omitempty" jsonschema_description:"Base64-encoded tar.gz of working directory after execution"`
-	Error        string `json:"error,omitempty" jsonschema_description:"Error message if execution failed"`
-	Success      bool   `json:"success" jsonschema_description:"Indicates if execution was successful"`
+	Stdout string `json:"stdout" jsonschema_description:"Standard output from execution"`
+	Stderr string `json:"stderr" jsonschema_description:"Standard error from execution"`
+	OutputStats
+	ExitCode     int             `json:"exit_code" jsonschema_description:"Exit code of the process, -1 when it was killed"`
+	Status       string          `json:"status,omitempty" jsonschema_description:"Termination status of the execution" jsonschema:"enum=ok,enum=nonzero_exit,enum=timeout,enum=oom_killed,enum=killed_by_signal,enum=compile_error,enum=setup_error,enum=output_limit_exceeded,enum=disk_quota_exceeded,enum=internal_error,enum=exception,enum=interrupted"` //nolint:lll // Struct tags cannot be split
+	Signal       string          `json:"signal,omitempty" jsonschema_description:"Name of the signal that killed the program, e.g. SIGSEGV"`
+	Result       string          `json:"result,omitempty" jsonschema_description:"Representation of the value of the last expression, for REPL sessions"`
+	Exception    string          `json:"exception,omitempty" jsonschema_description:"Traceback of the exception raised by the snippet, for REPL sessions"`
+	Setup        *PhaseResponse  `json:"setup,omitempty" jsonschema_description:"Result of the setup command, which ran with network access"`
+	Build        *PhaseResponse  `json:"build,omitempty" jsonschema_description:"Result of the build phase for compiled languages"`
+	Run          *PhaseResponse  `json:"run,omitempty" jsonschema_description:"Result of the run phase, absent when the setup or build failed"`
+	Limits       *LimitsResponse `json:"limits,omitempty" jsonschema_description:"Resource limits the execution ran under"`
+	Usage        *UsageResponse  `json:"usage,omitempty" jsonschema_description:"Resources used by all phases together"`
+	Runtime      string          `json:"runtime,omitempty" jsonschema_description:"OCI runtime the sandbox ran with, e.g. runsc"`
+	ArtifactsTar string          `json:"artifacts_tar,omitempty" jsonschema_description:"Base64-encoded tar.gz of working directory after execution"`
+	Error        string          `json:"error,omitempty" jsonschema_description:"Error message if execution failed"`
+	Success      bool            `json:"success" jsonschema_description:"Indicates if execution was successful"`
+}
+
+// PhaseResponse represents the result of a single execution phase
+type PhaseResponse struct {
+	Stdout string `json:"stdout" jsonschema_description:"Standard output of the phase"`
+	Stderr string `json:"stderr" jsonschema_description:"Standard error of the phase"`
+	OutputStats
+	ExitCode   int            `json:"exit_code" jsonschema_description:"Exit code of the phase"`
+	DurationMs int64          `json:"duration_ms" jsonschema_description:"Wall-clock duration of the phase in milliseconds"`
+	TimedOut   bool           `json:"timed_out" jsonschema_description:"Indicates if the phase hit its time limit"`
+	Status     string         `json:"status" jsonschema_description:"Termination status of the phase"`
+	Signal     string         `json:"signal,omitempty" jsonschema_description:"Name of the signal that killed the phase"`
+	Usage      *UsageResponse `json:"usage,omitempty" jsonschema_description:"Resources used by the phase"`
+}
+
+// UsageResponse represents the resources used by an execution
+type UsageResponse struct {
+	WallTimeMs      int64 `json:"wall_time_ms" jsonschema_description:"Wall-clock time in milliseconds"`
+	CPUUserMs       int64 `json:"cpu_user_ms" jsonschema_description:"CPU time spent in user mode in milliseconds"`
+	CPUSystemMs     int64 `json:"cpu_system_ms" jsonschema_description:"CPU time spent in kernel mode in milliseconds"`
+	PeakMemoryBytes int64 `json:"peak_memory_bytes" jsonschema_description:"Peak memory usage in bytes, 0 when unknown"`
+	OOMKilled       bool  `json:"oom_killed" jsonschema_description:"Indicates if the program was killed for exceeding its memory limit"`
+}
+
+// newUsageResponse converts sandbox resource usage, omitting it when nothing was measured
+func newUsageResponse(usage sandbox.ResourceUsage) *UsageResponse {
+	if usage == (sandbox.ResourceUsage{}) {
+		return nil
+	}
+	return &UsageResponse{
+		WallTimeMs:      usage.WallTime.Milliseconds(),
+		CPUUserMs:       usage.UserTime.Milliseconds(),
+		CPUSystemMs:     usage.SystemTime.Milliseconds(),
+		PeakMemoryBytes: usage.PeakMemoryBytes,
+		OOMKilled:       usage.OOMKilled,
+	}
+}
+
+// OutputStats describes the size of the output streams and whether they were truncated to the configured limits
+type OutputStats struct {
+	StdoutBytes     int64 `json:"stdout_bytes" jsonschema_description:"Total bytes written to stdout, including any truncated part"`
+	StderrBytes     int64 `json:"stderr_bytes" jsonschema_description:"Total bytes written to stderr, including any truncated part"`
+	StdoutTruncated bool  `json:"stdout_truncated" jsonschema_description:"Indicates if stdout was cut at the size limit"`
+	StderrTruncated bool  `json:"stderr_truncated" jsonschema_description:"Indicates if stderr was cut at the size limit"`
+}
+
+// newOutputStats converts the output statistics of a sandbox result
+func newOutputStats(stats sandbox.OutputStats) OutputStats {
+	return OutputStats{
+		StdoutBytes:     stats.StdoutBytes,
+		StderrBytes:     stats.StderrBytes,
+		StdoutTruncated: stats.StdoutTruncated,
+		StderrTruncated: stats.StderrTruncated,
+	}
+}
+
+// LimitsResponse represents the effective resource limits of an execution
+type LimitsResponse struct {
+	TimeoutSec int  `json:"timeout_sec" jsonschema_description:"Run time limit in seconds"`
+	MemoryMB   int  `json:"memory_mb" jsonschema_description:"Memory limit in MB, 0 when the backend does not limit memory"`
+	Network    bool `json:"network" jsonschema_description:"Indicates if the sandbox had network access"`
+}
+
+// newPhaseResponse converts a sandbox phase result, keeping nil for phases that did not run
+func newPhaseResponse(phase *sandbox.PhaseResult) *PhaseResponse {
+	if phase == nil {
+		return nil
+	}
+	return &PhaseResponse{
+		Stdout:      phase.Stdout,
+		Stderr:      phase.Stderr,
+		OutputStats: newOutputStats(phase.Output),
+		ExitCode:    phase.ExitCode,
+		DurationMs:  phase.Duration.Milliseconds(),
+		TimedOut:    phase.TimedOut,
+		Status:      string(phase.Status),
+		Signal:      phase.Signal,
+		Usage:       newUsageResponse(phase.Usage),
+	}
 }
 
 // MCPServer represents the MCP server
@@ -41,15 +139,24 @@ type MCPServer struct {
 	config      *config.Config
 	logger      *zap.Logger
 	sandboxExec sandbox.SandboxExecutor
+	languages   *sandbox.LanguageRegistry
+	sessions    *sandbox.SessionManager
 	mcpServer   *server.MCPServer
 }
 
 // New creates a new MCPServer
 func New(cfg *config.Config, logger *zap.Logger, sandboxExec sandbox.SandboxExecutor) (*MCPServer, error) {
+	languages, err := sandbox.NewLanguageRegistry(cfg.Languages)
+	if err != nil {
+		return nil, fmt.Errorf("invalid languages configuration: %w", err)
+	}
+
 	s := &MCPServer{
 		config:      cfg,
 		logger:      logger,
 		sandboxExec: sandboxExec,
+		languages:   languages,
+		sessions:    sandbox.NewSessionManager(logger, cfg.GetSessionIdleTTL(), cfg.GetMaxSessionsPerClient()),
 	}
 
 	// Log configuration parameters on startup
@@ -57,14 +164,44 @@ func New(cfg *config.Config, logger *zap.Logger, sandboxExec sandbox.SandboxExec
 		zap.String("server.transport", s.config.Server.Transport),
 		zap.Int("server.http_port", s.config.Server.HTTPPort),
 		zap.String("sandbox.backend", s.config.Sandbox.Backend),
+		zap.String("sandbox.runtime", s.config.Sandbox.Runtime),
+		zap.String("sandbox.engine_host", s.config.Sandbox.EngineHost),
+		zap.String("sandbox.podman.userns", s.config.Sandbox.Podman.Userns),
+		zap.String("sandbox.namespace.rootfs", s.config.Sandbox.Namespace.Rootfs),
+		zap.String("sandbox.bwrap.rootfs", s.config.Sandbox.Bwrap.Rootfs),
+		zap.Int64("sandbox.wasm.max_calls", s.config.Sandbox.Wasm.MaxCalls),
+		zap.String("sandbox.security.seccomp", s.config.Sandbox.Security.Seccomp),
+		zap.Float64("sandbox.resources.cpus", s.config.Sandbox.Resources.CPUs),
+		zap.Int64("sandbox.resources.pids_limit", s.config.Sandbox.Resources.PidsLimit),
+		zap.Bool("sandbox.filesystem.writable_rootfs", s.config.Sandbox.Filesystem.WritableRootfs),
+		zap.Int("sandbox.filesystem.workdir_size_mb", s.config.Sandbox.Filesystem.WorkdirSizeMB),
+		zap.Bool("sandbox.egress.enabled", s.config.Sandbox.Egress.Enabled),
+		zap.Strings("sandbox.egress.allow", s.config.Sandbox.Egress.Allow),
 		zap.Int("sandbox.timeout_sec", s.config.Sandbox.TimeoutSec),
+		zap.Int("sandbox.build_timeout_sec", s.config.Sandbox.BuildTimeoutSec),
 		zap.Int("sandbox.memory_mb", s.config.Sandbox.MemoryMB),
 		zap.Int("sandbox.max_artifact_size_mb", s.config.Sandbox.MaxArtifactSizeMB),
+		zap.Int("sandbox.max_stdin_size_kb", s.config.Sandbox.MaxStdinSizeKB),
+		zap.Int("sandbox.max_timeout_sec", s.config.GetMaxTimeoutSec()),
+		zap.Int("sandbox.max_memory_mb", s.config.GetMaxMemoryMB()),
 		zap.Bool("sandbox.network_enabled", s.config.Sandbox.NetworkEnabled),
+		zap.Bool("sandbox.allow_request_network", s.config.Sandbox.AllowRequestNetwork),
+		zap.Bool("sandbox.allow_setup", s.config.Sandbox.AllowSetup),
 		zap.Bool("sandbox.enable_local_backend", s.config.Sandbox.EnableLocalBackend),
+		zap.Bool("sandbox.pool.enabled", s.config.Sandbox.Pool.Enabled),
+		zap.Int("sandbox.pool.size", s.config.Sandbox.Pool.Size),
+		zap.Duration("sessions.idle_ttl", s.config.GetSessionIdleTTL()),
+		zap.Int("sessions.max_per_client", s.config.GetMaxSessionsPerClient()),
 	}
-	for lang, langCfg := range s.config.Languages {
-		fields = append(fields, zap.String(fmt.Sprintf("languages.%s.image", lang), langCfg.Image))
+	for _, name := range s.languages.Names() {
+		lang, _ := s.languages.Get(name)
+		fields = append(fields, zap.String(fmt.Sprintf("languages.%s.image", name), lang.Image))
+		if runtime := s.config.GetRuntime(name); runtime != "" {
+			fields = append(fields, zap.String(fmt.Sprintf("languages.%s.runtime", name), runtime))
+		}
+		if rootfs := s.config.GetRootfs(name); rootfs != "" {
+			fields = append(fields, zap.String(fmt.Sprintf("languages.%s.rootfs", name), rootfs))
+		}
 	}
 	logger.Info("configuration loaded", fields...)
 
@@ -74,6 +211,12 @@ func New(cfg *config.Config, logger *zap.Logger, sandboxExec sandbox.SandboxExec
 	// Register the execute_sandboxed_code tool
 	s.registerExecuteSandboxedCodeTool()
 
+	// Register the execute_test_cases tool when the backend supports batch execution
+	s.registerExecuteTestCasesTool()
+
+	// Register the create_session, execute_in_session and close_session tools
+	s.registerSessionTools()
+
 	return s, nil
 }
 
@@ -83,77 +226,153 @@ func (s *MCPServer) registerExecuteSandboxedCodeTool() {
 		mcp.WithDescription("Execute untrusted code in a sandboxed environment"),
 		mcp.WithInputSchema[ExecuteRequest](),
 		mcp.WithOutputSchema[ExecuteResponse](),
+		withLanguageEnum(s.languages.Names()),
 	)
 
 	s.mcpServer.AddTool(tool, mcp.NewStructuredToolHandler(s.handleExecuteSandboxedCodeStructured))
 }
 
+// withLanguageEnum restricts the language property of a generated input schema
+// to the languages from the registry. It must be applied after mcp.WithInputSchema.
+func withLanguageEnum(languages []string) mcp.ToolOption {
+	return func(t *mcp.Tool) {
+		if len(languages) == 0 || t.RawInputSchema == nil {
+			return
+		}
+
+		var schema map[string]any
+		if err := json.Unmarshal(t.RawInputSchema, &schema); err != nil {
+			return
+		}
+
+		properties, ok := schema["properties"].(map[string]any)
+		if !ok {
+			return
+		}
+		language, ok := properties["language"].(map[string]any)
+		if !ok {
+			return
+		}
+		language["enum"] = languages
+
+		if raw, err := json.Marshal(schema); err == nil {
+			t.RawInputSchema = raw
+		}
+	}
+}
+
 // handleExecuteSandboxedCodeStructured handles the execute_sandboxed_code tool with structured input/output
 func (s *MCPServer) handleExecuteSandboxedCodeStructured(
 	ctx context.Context,
-	_ mcp.CallToolRequest,
+	request mcp.CallToolRequest,
 	args ExecuteRequest,
 ) (ExecuteResponse, error) {
 	s.logger.Info("code execution requested")
 
-	// Validate language
-	if _, ok := s.config.Languages[args.Language]; !ok {
+	execReq, err := s.newExecuteRequest(ctx, &request, &args)
+	if err != nil {
 		return ExecuteResponse{
 			Success: false,
-			Error:   fmt.Sprintf("invalid language: %s", args.Language),
+			Error:   err.Error(),
 		}, nil
 	}
 
+	return s.execute(ctx, &execReq), nil
+}
+
+// newExecuteRequest validates the tool arguments and converts them into a sandbox execution request
+func (s *MCPServer) newExecuteRequest(
+	ctx context.Context,
+	request *mcp.CallToolRequest,
+	args *ExecuteRequest,
+) (sandbox.ExecuteRequest, error) {
+	// Validate language
+	if _, ok := s.languages.Get(args.Language); !ok {
+		return sandbox.ExecuteRequest{}, fmt.Errorf("invalid language: %s", args.Language)
+	}
+
 	// Get optional workdir_tar
-	var workdirTar []byte
-	if args.WorkdirTar != "" {
-		decodedWorkdirTar, decodeErr := base64.StdEncoding.DecodeString(args.WorkdirTar)
-		if decodeErr != nil {
-			return ExecuteResponse{
-				Success: false,
-				Error:   fmt.Sprintf("failed to decode workdir_tar: %v", decodeErr),
-			}, nil
-		}
-		workdirTar = decodedWorkdirTar
+	workdirTar, err := decodeWorkdirTar(args.WorkdirTar)
+	if err != nil {
+		return sandbox.ExecuteRequest{}, err
+	}
+
+	// Get optional stdin, bounded by the configured limit
+	stdin, err := s.stdinBytes(args.Stdin)
+	if err != nil {
+		return sandbox.ExecuteRequest{}, err
+	}
+
+	// Resolve the resource limits of this request against the configured maximums
+	limits, err := s.resolveLimits(args)
+	if err != nil {
+		return sandbox.ExecuteRequest{}, err
+	}
+
+	// Resolve the setup phase, which only runs when the server allows it
+	setupTimeoutSec, err := s.resolveSetup(args)
+	if err != nil {
+		return sandbox.ExecuteRequest{}, err
 	}
 
 	// Log execution
 	s.logger.Info("executing code in sandbox",
 		zap.String("language", args.Language),
-		zap.Bool("has_workdir", len(workdirTar) > 0))
+		zap.Bool("has_workdir", len(workdirTar) > 0),
+		zap.Int("stdin_len", len(stdin)),
+		zap.Int("timeout_sec", limits.TimeoutSec),
+		zap.Int("memory_mb", limits.MemoryMB),
+		zap.Bool("network", limits.Network),
+		zap.String("setup", args.Setup))
 
-	// Prepare the execution request
-	execReq := sandbox.ExecuteRequest{
+	return sandbox.ExecuteRequest{
 		Language:   args.Language,
 		Code:       args.Code,
 		WorkdirTar: workdirTar,
-		TimeoutSec: s.config.Sandbox.TimeoutSec,
-		MemoryMB:   s.config.Sandbox.MemoryMB,
-		Network:    s.config.Sandbox.NetworkEnabled,
-	}
+		Stdin:      stdin,
+		TimeoutSec: limits.TimeoutSec,
+		MemoryMB:   limits.MemoryMB,
+		Network:    limits.Network,
+		Stream:     s.outputStream(ctx, progressToken(request)),
 
+		Setup:           args.Setup,
+		SetupTimeoutSec: setupTimeoutSec,
+	}, nil
+}
+
+// execute runs a sandbox execution request and converts its result into a tool response
+func (s *MCPServer) execute(ctx context.Context, execReq *sandbox.ExecuteRequest) ExecuteResponse {
 	// Execute the code
-	result, err := s.sandboxExec.Execute(ctx, execReq)
+	result, err := s.sandboxExec.Execute(ctx, *execReq)
 	if err != nil {
 		s.logger.Error("sandbox execution failed",
 			zap.Error(err),
-			zap.String("language", args.Language),
-			zap.String("code", args.Code))
+			zap.String("language", execReq.Language),
+			zap.String("code", execReq.Code))
 		return ExecuteResponse{
 			Stdout:   "",
 			Stderr:   "",
 			ExitCode: 1,
+			Status:   string(sandbox.StatusInternalError),
 			Error:    fmt.Sprintf("execution failed: %v", err),
 			Success:  false,
-		}, nil
+		}
 	}
 
 	// Log execution result
 	s.logger.Info("code execution completed",
-		zap.String("language", args.Language),
+		zap.String("language", execReq.Language),
 		zap.Int("exit_code", result.ExitCode),
+		zap.String("status", string(result.Status)),
+		zap.String("runtime", result.Runtime),
+		zap.Duration("cpu_user", result.Usage.UserTime),
+		zap.Duration("cpu_system", result.Usage.SystemTime),
+		zap.Int64("peak_memory_bytes", result.Usage.PeakMemoryBytes),
+		zap.Bool("oom_killed", result.Usage.OOMKilled),
 		zap.Int("stdout_len", len(result.Stdout)),
-		zap.Int("stderr_len", len(result.Stderr)))
+		zap.Int("stderr_len", len(result.Stderr)),
+		zap.Bool("stdout_truncated", result.Output.StdoutTruncated),
+		zap.Bool("stderr_truncated", result.Output.StderrTruncated))
 
 	// Encode artifacts as base64
 	artifactsB64 := base64.StdEncoding.EncodeToString(result.ArtifactsTar)
@@ -161,10 +380,104 @@ func (s *MCPServer) handleExecuteSandboxedCodeStructured(
 	return ExecuteResponse{
 		Stdout:       result.Stdout,
 		Stderr:       result.Stderr,
+		OutputStats:  newOutputStats(result.Output),
 		ExitCode:     result.ExitCode,
+		Status:       string(result.Status),
+		Signal:       result.Signal,
+		Setup:        newPhaseResponse(result.Setup),
+		Build:        newPhaseResponse(result.Build),
+		Run:          newPhaseResponse(result.Run),
+		Limits:       newLimitsResponse(result.Limits),
+		Usage:        newUsageResponse(result.Usage),
+		Runtime:      result.Runtime,
 		ArtifactsTar: artifactsB64,
 		Success:      true,
-	}, nil
+	}
+}
+
+// decodeWorkdirTar decodes the optional base64-encoded workdir tar
+func decodeWorkdirTar(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+
+	workdirTar, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode workdir_tar: %w", err)
+	}
+	return workdirTar, nil
+}
+
+// stdinBytes converts optional stdin to bytes, enforcing the configured size limit
+func (s *MCPServer) stdinBytes(stdin string) ([]byte, error) {
+	if stdin == "" {
+		return nil, nil
+	}
+
+	if limit := s.config.GetMaxStdinSize(); len(stdin) > limit {
+		return nil, fmt.Errorf("stdin size exceeds limit: %d bytes > %d bytes", len(stdin), limit)
+	}
+	return []byte(stdin), nil
+}
+
+// resolveLimits returns the limits of a request, using the configured defaults for unset
+// values and capping requested values at the configured maximums
+func (s *MCPServer) resolveLimits(args *ExecuteRequest) (sandbox.Limits, error) {
+	if args.TimeoutSec < 0 {
+		return sandbox.Limits{}, fmt.Errorf("timeout_sec must not be negative, got: %d", args.TimeoutSec)
+	}
+	if args.MemoryMB < 0 {
+		return sandbox.Limits{}, fmt.Errorf("memory_mb must not be negative, got: %d", args.MemoryMB)
+	}
+
+	limits := sandbox.Limits{
+		TimeoutSec: s.config.Sandbox.TimeoutSec,
+		MemoryMB:   s.config.Sandbox.MemoryMB,
+		Network:    s.config.Sandbox.NetworkEnabled,
+	}
+	if args.TimeoutSec > 0 {
+		limits.TimeoutSec = min(args.TimeoutSec, s.config.GetMaxTimeoutSec())
+	}
+	if args.MemoryMB > 0 {
+		limits.MemoryMB = min(args.MemoryMB, s.config.GetMaxMemoryMB())
+	}
+	if args.Network != nil && *args.Network && s.config.Sandbox.AllowRequestNetwork {
+		limits.Network = true
+	}
+
+	return limits, nil
+}
+
+// resolveSetup checks the setup command of a request and returns the time limit of its setup phase.
+// A requested setup timeout can only lower the configured one.
+func (s *MCPServer) resolveSetup(args *ExecuteRequest) (int, error) {
+	if args.SetupTimeoutSec < 0 {
+		return 0, fmt.Errorf("setup_timeout_sec must not be negative, got: %d", args.SetupTimeoutSec)
+	}
+	if args.Setup == "" {
+		return 0, nil
+	}
+	if !s.config.Sandbox.AllowSetup {
+		return 0, errors.New("setup is not enabled on this server")
+	}
+
+	timeoutSec := int(s.config.GetSetupTimeout().Seconds())
+	if args.SetupTimeoutSec > 0 {
+		timeoutSec = min(args.SetupTimeoutSec, timeoutSec)
+	}
+	return timeoutSec, nil
+}
+
+// newLimitsResponse converts the limits reported by the executor, omitting them when the executor reported none
+func newLimitsResponse(limits sandbox.Limits) *LimitsResponse {
+	if limits == (sandbox.Limits{}) {
+		return nil
+	}
+	return &LimitsResponse{
+		TimeoutSec: limits.TimeoutSec,
+		MemoryMB:   limits.MemoryMB,
+		Network:    limits.Network,
+	}
 }
 
 // ServeStdio starts the server on stdio
@@ -182,6 +495,12 @@ func (s *MCPServer) ServeHTTP() error {
 	return httpServer.Start(fmt.Sprintf(":%d", port))
 }
 
+// Shutdown closes all sessions and removes their workdirs
+func (s *MCPServer) Shutdown(_ context.Context) error {
+	s.sessions.CloseAll()
+	return nil
+}
+
 // GetMCPServer returns the underlying MCP server for fx
 func (s *MCPServer) GetMCPServer() *server.MCPServer {
 	return s.mcpServer
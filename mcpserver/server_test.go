@@ -2,8 +2,11 @@ package mcpserver
 
 import (
 	"context"
+	"encoding/json"
+	"strings"
 	"testing"
 
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
@@ -22,6 +25,19 @@ func (m *MockSandboxExecutor) Execute(_ context.Context, _ sandbox.ExecuteReques
 	return m.executeResult, m.executeError
 }
 
+// RecordingSandboxExecutor implements sandbox.SandboxExecutor and records the last request
+type RecordingSandboxExecutor struct {
+	executeResult sandbox.ExecuteResult
+	lastRequest   sandbox.ExecuteRequest
+	calls         int
+}
+
+func (r *RecordingSandboxExecutor) Execute(_ context.Context, req sandbox.ExecuteRequest) (sandbox.ExecuteResult, error) { //nolint:gocritic // Mock implementation requires full parameter signature
+	r.lastRequest = req
+	r.calls++
+	return r.executeResult, nil
+}
+
 func TestNewMCPServer(t *testing.T) {
 	logger := zaptest.NewLogger(t)
 	cfg := &config.Config{
@@ -83,3 +99,171 @@ func TestServerCreation(t *testing.T) {
 	assert.Equal(t, mockExecutor, server.sandboxExec)
 	assert.NotNil(t, server.mcpServer)
 }
+
+func TestLanguageRegistryIntegration(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:  config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox: config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20},
+		Logging: config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{
+			sandbox.LanguagePython: {},
+			"ruby":                 {Image: "ruby:3.3-slim", FileExtension: "rb", RunCmd: "ruby main.rb"},
+		},
+	}
+
+	t.Run("SchemaEnumFromRegistry", func(t *testing.T) {
+		server, err := New(cfg, logger, &MockSandboxExecutor{})
+		require.NoError(t, err)
+
+		tool := server.GetMCPServer().GetTool("execute_sandboxed_code")
+		require.NotNil(t, tool)
+
+		var schema struct {
+			Properties map[string]struct {
+				Enum []string `json:"enum"`
+			} `json:"properties"`
+		}
+		require.NoError(t, json.Unmarshal(tool.Tool.RawInputSchema, &schema))
+		assert.Equal(t, []string{sandbox.LanguagePython, "ruby"}, schema.Properties["language"].Enum)
+	})
+
+	t.Run("RejectsUnregisteredLanguage", func(t *testing.T) {
+		server, err := New(cfg, logger, &MockSandboxExecutor{})
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, ExecuteRequest{
+			Code:     "print('hello')",
+			Language: sandbox.LanguageGo,
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.Success)
+		assert.Contains(t, resp.Error, "invalid language")
+	})
+
+	t.Run("AcceptsConfiguredLanguage", func(t *testing.T) {
+		mockExecutor := &MockSandboxExecutor{executeResult: sandbox.ExecuteResult{Stdout: "hello\n"}}
+		server, err := New(cfg, logger, mockExecutor)
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, ExecuteRequest{
+			Code:     "puts 'hello'",
+			Language: "ruby",
+		})
+		require.NoError(t, err)
+		assert.True(t, resp.Success)
+		assert.Equal(t, "hello\n", resp.Stdout)
+	})
+
+	t.Run("InvalidLanguageConfig", func(t *testing.T) {
+		badCfg := *cfg
+		badCfg.Languages = map[string]config.Language{"ruby": {Image: "ruby:3.3-slim"}}
+
+		_, err := New(&badCfg, logger, &MockSandboxExecutor{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid languages configuration")
+	})
+}
+
+func TestStdinHandling(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20, MaxStdinSizeKB: 1},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+	}
+
+	t.Run("PassesStdinToExecutor", func(t *testing.T) {
+		mockExecutor := &RecordingSandboxExecutor{}
+		server, err := New(cfg, logger, mockExecutor)
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, ExecuteRequest{
+			Code:     "print(input())",
+			Language: sandbox.LanguagePython,
+			Stdin:    "hello\n",
+		})
+		require.NoError(t, err)
+		assert.True(t, resp.Success)
+		assert.Equal(t, []byte("hello\n"), mockExecutor.lastRequest.Stdin)
+	})
+
+	t.Run("RejectsOversizedStdin", func(t *testing.T) {
+		mockExecutor := &RecordingSandboxExecutor{}
+		server, err := New(cfg, logger, mockExecutor)
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, ExecuteRequest{
+			Code:     "print(input())",
+			Language: sandbox.LanguagePython,
+			Stdin:    strings.Repeat("x", 1025),
+		})
+		require.NoError(t, err)
+		assert.False(t, resp.Success)
+		assert.Contains(t, resp.Error, "stdin size exceeds limit")
+		assert.Equal(t, 0, mockExecutor.calls)
+	})
+}
+
+func TestExecutionStatus(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+	}
+	args := ExecuteRequest{Code: "import os\nos.abort()", Language: sandbox.LanguagePython}
+
+	t.Run("KilledBySignal", func(t *testing.T) {
+		run := &sandbox.PhaseResult{ExitCode: 134, Status: sandbox.StatusKilledBySignal, Signal: "SIGABRT"}
+		server, err := New(cfg, logger, &MockSandboxExecutor{executeResult: sandbox.ExecuteResult{
+			ExitCode: 134,
+			Status:   sandbox.StatusKilledBySignal,
+			Signal:   "SIGABRT",
+			Run:      run,
+		}})
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		assert.True(t, resp.Success)
+		assert.Equal(t, "killed_by_signal", resp.Status)
+		assert.Equal(t, "SIGABRT", resp.Signal)
+		require.NotNil(t, resp.Run)
+		assert.Equal(t, "killed_by_signal", resp.Run.Status)
+		assert.Equal(t, "SIGABRT", resp.Run.Signal)
+	})
+
+	t.Run("InternalError", func(t *testing.T) {
+		server, err := New(cfg, logger, &MockSandboxExecutor{executeError: assert.AnError})
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		assert.False(t, resp.Success)
+		assert.Equal(t, "internal_error", resp.Status)
+	})
+}
+
+func TestExecutionRuntime(t *testing.T) {
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20, Runtime: "runsc"},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+	}
+	server, err := New(cfg, zaptest.NewLogger(t), &MockSandboxExecutor{executeResult: sandbox.ExecuteResult{
+		Status:  sandbox.StatusOK,
+		Run:     &sandbox.PhaseResult{Status: sandbox.StatusOK},
+		Runtime: "runsc",
+	}})
+	require.NoError(t, err)
+
+	resp, err := server.handleExecuteSandboxedCodeStructured(context.Background(), mcp.CallToolRequest{},
+		ExecuteRequest{Code: "print('ok')", Language: sandbox.LanguagePython})
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+	assert.Equal(t, "runsc", resp.Runtime)
+}
@@ -0,0 +1,170 @@
+// Package mcpserver provides the Model Context Protocol (MCP) server implementation.
+//
+// The session tools keep a workdir on the server across tool calls. A client
+// creates a session, runs code in it any number of times and closes it, so
+// files written by one step are available to the next without sending the
+// workdir back and forth. REPL sessions additionally keep an interpreter.
+package mcpserver
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"go.uber.org/zap"
+)
+
+// CreateSessionRequest represents the input parameters for creating a session
+type CreateSessionRequest struct {
+	WorkdirTar string `json:"workdir_tar,omitempty" jsonschema_description:"Base64-encoded tar.gz of the initial session working directory (optional)"`                                                                  //nolint:lll // Struct tags cannot be split
+	Repl       string `json:"repl,omitempty" jsonschema_description:"Keep an interpreter of this language running, so variables and imports survive between executions (optional)" jsonschema:"enum=python,enum=nodejs"` //nolint:lll // Struct tags cannot be split
+	MemoryMB   int    `json:"memory_mb,omitempty" jsonschema_description:"Memory limit in MB of the REPL interpreter, capped by the server maximum (optional)"`                                                          //nolint:lll // Struct tags cannot be split
+	Network    *bool  `json:"network,omitempty" jsonschema_description:"Request network access for the REPL interpreter, honoured only when the server allows it (optional)"`                                            //nolint:lll // Struct tags cannot be split
+}
+
+// CreateSessionResponse represents the structured response from creating a session
+type CreateSessionResponse struct {
+	SessionID  string `json:"session_id,omitempty" jsonschema_description:"Identifier of the new session"`
+	Repl       string `json:"repl,omitempty" jsonschema_description:"Language of the session interpreter, for REPL sessions"`
+	IdleTTLSec int    `json:"idle_ttl_sec,omitempty" jsonschema_description:"Seconds without executions after which the session is closed"`
+	Error      string `json:"error,omitempty" jsonschema_description:"Error message if the session could not be created"`
+	Success    bool   `json:"success" jsonschema_description:"Indicates if the session was created"`
+}
+
+// SessionExecuteRequest represents the input parameters for code execution in a session
+type SessionExecuteRequest struct {
+	SessionID string `json:"session_id" jsonschema_description:"Identifier returned by create_session" jsonschema:"required"`
+	ExecuteRequest
+	IncludeArtifacts bool `json:"include_artifacts,omitempty" jsonschema_description:"Return the session working directory as artifacts_tar (optional)"` //nolint:lll // Struct tags cannot be split
+}
+
+// CloseSessionRequest represents the input parameters for closing a session
+type CloseSessionRequest struct {
+	SessionID string `json:"session_id" jsonschema_description:"Identifier returned by create_session" jsonschema:"required"`
+}
+
+// CloseSessionResponse represents the structured response from closing a session
+type CloseSessionResponse struct {
+	Error   string `json:"error,omitempty" jsonschema_description:"Error message if the session could not be closed"`
+	Success bool   `json:"success" jsonschema_description:"Indicates if the session was closed"`
+}
+
+// registerSessionTools registers the create_session, execute_in_session and close_session tools,
+// and the tools of REPL sessions when the backend supports them
+func (s *MCPServer) registerSessionTools() {
+	createTool := mcp.NewTool("create_session",
+		mcp.WithDescription("Create a sandbox session whose working directory is kept between executions"),
+		mcp.WithInputSchema[CreateSessionRequest](),
+		mcp.WithOutputSchema[CreateSessionResponse](),
+	)
+	s.mcpServer.AddTool(createTool, mcp.NewStructuredToolHandler(s.handleCreateSession))
+
+	executeTool := mcp.NewTool("execute_in_session",
+		mcp.WithDescription("Execute untrusted code in the working directory of a sandbox session"),
+		mcp.WithInputSchema[SessionExecuteRequest](),
+		mcp.WithOutputSchema[ExecuteResponse](),
+		withLanguageEnum(s.languages.Names()),
+	)
+	s.mcpServer.AddTool(executeTool, mcp.NewStructuredToolHandler(s.handleExecuteInSession))
+
+	closeTool := mcp.NewTool("close_session",
+		mcp.WithDescription("Close a sandbox session and remove its working directory"),
+		mcp.WithInputSchema[CloseSessionRequest](),
+		mcp.WithOutputSchema[CloseSessionResponse](),
+	)
+	s.mcpServer.AddTool(closeTool, mcp.NewStructuredToolHandler(s.handleCloseSession))
+
+	s.registerReplTools()
+}
+
+// handleCreateSession handles the create_session tool
+func (s *MCPServer) handleCreateSession(
+	ctx context.Context,
+	_ mcp.CallToolRequest,
+	args CreateSessionRequest,
+) (CreateSessionResponse, error) {
+	workdirTar, err := decodeWorkdirTar(args.WorkdirTar)
+	if err != nil {
+		return CreateSessionResponse{Error: err.Error()}, nil
+	}
+
+	// The interpreter of a REPL session keeps the limits it was started with
+	limits, err := s.resolveLimits(&ExecuteRequest{MemoryMB: args.MemoryMB, Network: args.Network})
+	if err != nil {
+		return CreateSessionResponse{Error: err.Error()}, nil
+	}
+
+	session, err := s.sessions.Create(clientID(ctx), workdirTar)
+	if err != nil {
+		s.logger.Warn("failed to create session", zap.Error(err))
+		return CreateSessionResponse{Error: err.Error()}, nil
+	}
+
+	if args.Repl != "" {
+		repl, err := s.startRepl(ctx, args.Repl, limits, session)
+		if err != nil {
+			s.logger.Warn("failed to create REPL session", zap.String("language", args.Repl), zap.Error(err))
+			_ = s.sessions.Close(session.ID)
+			return CreateSessionResponse{Error: err.Error()}, nil
+		}
+		session.SetRepl(repl)
+	}
+
+	return CreateSessionResponse{
+		SessionID:  session.ID,
+		Repl:       args.Repl,
+		IdleTTLSec: int(s.sessions.IdleTTL().Seconds()),
+		Success:    true,
+	}, nil
+}
+
+// handleExecuteInSession handles the execute_in_session tool
+func (s *MCPServer) handleExecuteInSession(
+	ctx context.Context,
+	request mcp.CallToolRequest,
+	args SessionExecuteRequest,
+) (ExecuteResponse, error) {
+	s.logger.Info("session code execution requested", zap.String("session_id", args.SessionID))
+
+	execReq, err := s.newExecuteRequest(ctx, &request, &args.ExecuteRequest)
+	if err != nil {
+		return ExecuteResponse{Error: err.Error()}, nil
+	}
+
+	session, release, err := s.sessions.Acquire(args.SessionID)
+	if err != nil {
+		return ExecuteResponse{Error: err.Error()}, nil
+	}
+	defer release()
+
+	// REPL sessions evaluate the code in their interpreter
+	if repl := session.Repl(); repl != nil {
+		return s.evalInRepl(ctx, repl, session, &execReq, args.IncludeArtifacts), nil
+	}
+
+	// The files stay in the session, so they are only sent back on request
+	execReq.Workdir = session.Workdir()
+	execReq.SkipArtifacts = !args.IncludeArtifacts
+
+	return s.execute(ctx, &execReq), nil
+}
+
+// handleCloseSession handles the close_session tool
+func (s *MCPServer) handleCloseSession(
+	_ context.Context,
+	_ mcp.CallToolRequest,
+	args CloseSessionRequest,
+) (CloseSessionResponse, error) {
+	if err := s.sessions.Close(args.SessionID); err != nil {
+		return CloseSessionResponse{Error: err.Error()}, nil
+	}
+	return CloseSessionResponse{Success: true}, nil
+}
+
+// clientID identifies the MCP client of a tool call, whose sessions count against its session limit
+func clientID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return session.SessionID()
+	}
+	return ""
+}
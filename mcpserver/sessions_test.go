@@ -0,0 +1,256 @@
+package mcpserver
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+	"github.com/isdmx/codebox/sandbox"
+)
+
+func TestSessionTools(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20},
+		Sessions:  config.SessionsConfig{IdleTTLSec: 60, MaxPerClient: 1},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+	}
+	executor := &RecordingSandboxExecutor{executeResult: sandbox.ExecuteResult{Stdout: "ok\n", Status: sandbox.StatusOK}}
+	s, err := New(cfg, logger, executor)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s.Shutdown(context.Background())) }()
+
+	for _, name := range []string{"create_session", "execute_in_session", "close_session"} {
+		assert.NotNil(t, s.mcpServer.GetTool(name), name)
+	}
+
+	client := s.mcpServer.WithContext(context.Background(), &testClientSession{})
+	otherClient := s.mcpServer.WithContext(context.Background(), &otherClientSession{})
+
+	created, err := s.handleCreateSession(client, mcp.CallToolRequest{}, CreateSessionRequest{})
+	require.NoError(t, err)
+	require.True(t, created.Success, created.Error)
+	assert.NotEmpty(t, created.SessionID)
+	assert.Equal(t, 60, created.IdleTTLSec)
+
+	t.Run("PerClientLimit", func(t *testing.T) {
+		response, err := s.handleCreateSession(client, mcp.CallToolRequest{}, CreateSessionRequest{})
+		require.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Contains(t, response.Error, "session limit reached")
+	})
+
+	t.Run("ExecuteInSession", func(t *testing.T) {
+		args := SessionExecuteRequest{
+			SessionID:      created.SessionID,
+			ExecuteRequest: ExecuteRequest{Code: "print('ok')", Language: sandbox.LanguagePython},
+		}
+
+		response, err := s.handleExecuteInSession(client, mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		assert.True(t, response.Success)
+		assert.Equal(t, "ok\n", response.Stdout)
+		assert.NotEmpty(t, executor.lastRequest.Workdir)
+		assert.True(t, executor.lastRequest.SkipArtifacts)
+		workdir := executor.lastRequest.Workdir
+
+		args.IncludeArtifacts = true
+		_, err = s.handleExecuteInSession(client, mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		assert.Equal(t, workdir, executor.lastRequest.Workdir, "every execution runs in the same workdir")
+		assert.False(t, executor.lastRequest.SkipArtifacts)
+	})
+
+	t.Run("UsableAcrossConnections", func(t *testing.T) {
+		// The session ID is all a client needs, e.g. after reconnecting
+		response, err := s.handleExecuteInSession(otherClient, mcp.CallToolRequest{}, SessionExecuteRequest{
+			SessionID:      created.SessionID,
+			ExecuteRequest: ExecuteRequest{Code: "print('ok')", Language: sandbox.LanguagePython},
+		})
+		require.NoError(t, err)
+		assert.True(t, response.Success)
+
+		// The session only counts against the limit of the client that created it
+		other, err := s.handleCreateSession(otherClient, mcp.CallToolRequest{}, CreateSessionRequest{})
+		require.NoError(t, err)
+		assert.True(t, other.Success, other.Error)
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		closed, err := s.handleCloseSession(client, mcp.CallToolRequest{}, CloseSessionRequest{SessionID: created.SessionID})
+		require.NoError(t, err)
+		assert.True(t, closed.Success)
+		assert.NoDirExists(t, executor.lastRequest.Workdir)
+
+		response, err := s.handleExecuteInSession(client, mcp.CallToolRequest{}, SessionExecuteRequest{
+			SessionID:      created.SessionID,
+			ExecuteRequest: ExecuteRequest{Code: "print('ok')", Language: sandbox.LanguagePython},
+		})
+		require.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Contains(t, response.Error, "session not found")
+	})
+}
+
+func TestReplSessionTools(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20, AllowRequestNetwork: true},
+		Sessions:  config.SessionsConfig{IdleTTLSec: 60, MaxPerClient: 2},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}, sandbox.LanguageNodeJS: {}},
+	}
+	executor := &RecordingReplExecutor{
+		LocalExecutor: sandbox.NewLocalExecutor(logger, &sandbox.Config{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20}, cfg),
+	}
+	s, err := New(cfg, logger, executor)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, s.Shutdown(context.Background())) }()
+
+	for _, name := range []string{"interrupt_session", "restart_session"} {
+		assert.NotNil(t, s.mcpServer.GetTool(name), name)
+	}
+
+	client := s.mcpServer.WithContext(context.Background(), &testClientSession{})
+	network := true
+	created, err := s.handleCreateSession(client, mcp.CallToolRequest{}, CreateSessionRequest{
+		Repl:     sandbox.LanguagePython,
+		MemoryMB: 256,
+		Network:  &network,
+	})
+	require.NoError(t, err)
+	require.True(t, created.Success, created.Error)
+	assert.Equal(t, sandbox.LanguagePython, created.Repl)
+
+	execute := func(code string) ExecuteResponse {
+		response, err := s.handleExecuteInSession(client, mcp.CallToolRequest{}, SessionExecuteRequest{
+			SessionID:      created.SessionID,
+			ExecuteRequest: ExecuteRequest{Code: code, Language: sandbox.LanguagePython},
+		})
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("StateSurvives", func(t *testing.T) {
+		response := execute("x = 40\nopen('data.txt', 'w').write('hi')")
+		require.True(t, response.Success, response.Error)
+		assert.Equal(t, string(sandbox.StatusOK), response.Status)
+
+		response = execute("print('x is', x)\nx + 2")
+		assert.Equal(t, "x is 40\n", response.Stdout)
+		assert.Equal(t, "42", response.Result)
+	})
+
+	t.Run("Exception", func(t *testing.T) {
+		response := execute("undefined_name")
+		assert.True(t, response.Success)
+		assert.Equal(t, string(sandbox.StatusException), response.Status)
+		assert.Contains(t, response.Exception, "NameError")
+	})
+
+	t.Run("LanguageMismatch", func(t *testing.T) {
+		response, err := s.handleExecuteInSession(client, mcp.CallToolRequest{}, SessionExecuteRequest{
+			SessionID:      created.SessionID,
+			ExecuteRequest: ExecuteRequest{Code: "1", Language: sandbox.LanguageNodeJS},
+		})
+		require.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Contains(t, response.Error, "runs python")
+	})
+
+	t.Run("Interrupt", func(t *testing.T) {
+		go func() {
+			time.Sleep(200 * time.Millisecond)
+			response, err := s.handleInterruptSession(client, mcp.CallToolRequest{}, SessionActionRequest{SessionID: created.SessionID})
+			assert.NoError(t, err)
+			assert.True(t, response.Success, response.Error)
+		}()
+
+		response := execute("while True:\n    pass")
+		assert.Equal(t, string(sandbox.StatusInterrupted), response.Status)
+		assert.Equal(t, "40", execute("x").Result)
+	})
+
+	t.Run("Restart", func(t *testing.T) {
+		response, err := s.handleRestartSession(client, mcp.CallToolRequest{}, SessionActionRequest{SessionID: created.SessionID})
+		require.NoError(t, err)
+		require.True(t, response.Success, response.Error)
+
+		// The interpreter state is gone, the files are kept
+		assert.Contains(t, execute("x").Exception, "NameError")
+		assert.Equal(t, "'hi'", execute("open('data.txt').read()").Result)
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		// The interpreter gets the limits of the session, also when it is restarted
+		requests := executor.Requests()
+		require.Len(t, requests, 2)
+		for _, req := range requests {
+			assert.Equal(t, 256, req.MemoryMB)
+			assert.True(t, req.Network)
+		}
+	})
+
+	t.Run("WorkdirSession", func(t *testing.T) {
+		plain, err := s.handleCreateSession(client, mcp.CallToolRequest{}, CreateSessionRequest{})
+		require.NoError(t, err)
+		require.True(t, plain.Success, plain.Error)
+		defer func() {
+			_, _ = s.handleCloseSession(client, mcp.CallToolRequest{}, CloseSessionRequest{SessionID: plain.SessionID})
+		}()
+
+		response, err := s.handleInterruptSession(client, mcp.CallToolRequest{}, SessionActionRequest{SessionID: plain.SessionID})
+		require.NoError(t, err)
+		assert.Contains(t, response.Error, "session has no REPL")
+	})
+
+	t.Run("UnsupportedLanguage", func(t *testing.T) {
+		response, err := s.handleCreateSession(client, mcp.CallToolRequest{}, CreateSessionRequest{Repl: sandbox.LanguageGo})
+		require.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Equal(t, 1, s.sessions.Count(clientID(client)), "a session whose REPL failed to start is closed")
+	})
+}
+
+// RecordingReplExecutor is a local executor that records the requests of the REPLs it starts
+type RecordingReplExecutor struct {
+	*sandbox.LocalExecutor
+	mu       sync.Mutex
+	requests []sandbox.ReplRequest
+}
+
+func (r *RecordingReplExecutor) StartRepl(ctx context.Context, req sandbox.ReplRequest) (*sandbox.Repl, error) {
+	r.mu.Lock()
+	r.requests = append(r.requests, req)
+	r.mu.Unlock()
+	return r.LocalExecutor.StartRepl(ctx, req)
+}
+
+// Requests returns the recorded REPL requests
+func (r *RecordingReplExecutor) Requests() []sandbox.ReplRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]sandbox.ReplRequest(nil), r.requests...)
+}
+
+// otherClientSession is a client session with a different identity than testClientSession
+type otherClientSession struct {
+	testClientSession
+}
+
+func (s *otherClientSession) SessionID() string { return "other-session" }
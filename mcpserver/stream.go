@@ -0,0 +1,67 @@
+// Package mcpserver provides the Model Context Protocol (MCP) server implementation.
+//
+// Output of a running execution is streamed to the client as MCP progress
+// notifications when the tool call carries a progress token, so callers see
+// what a long-running program prints before it finishes.
+package mcpserver
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/sandbox"
+)
+
+// Progress notification fields describing a streamed output chunk
+const (
+	progressNotificationMethod = "notifications/progress"
+	streamPhaseMetaKey         = "codebox/phase"
+	streamNameMetaKey          = "codebox/stream"
+)
+
+// progressToken returns the progress token of a tool call, or nil when the client did not ask for progress
+func progressToken(request *mcp.CallToolRequest) mcp.ProgressToken {
+	if request.Params.Meta == nil {
+		return nil
+	}
+	return request.Params.Meta.ProgressToken
+}
+
+// outputStream returns a handler sending every output chunk to the client as a progress notification
+// for the given token. The chunk text is the notification message and the progress is the number of
+// bytes streamed so far. It returns nil when there is no token, which disables streaming.
+func (s *MCPServer) outputStream(ctx context.Context, token mcp.ProgressToken) sandbox.OutputHandler {
+	if token == nil {
+		return nil
+	}
+
+	// Chunks of stdout and stderr arrive concurrently, but progress must increase with every notification
+	var mu sync.Mutex
+	var streamed int64
+
+	return func(chunk sandbox.OutputChunk) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		streamed += int64(len(chunk.Data))
+		err := s.mcpServer.SendNotificationToClient(ctx, progressNotificationMethod, map[string]any{
+			"progressToken": token,
+			"progress":      streamed,
+			"message":       string(chunk.Data),
+			"_meta": map[string]any{
+				streamPhaseMetaKey: chunk.Phase,
+				streamNameMetaKey:  chunk.Stream,
+			},
+		})
+		if err != nil {
+			// Streaming is best effort, the complete output is still part of the final response
+			s.logger.Debug("failed to stream output chunk",
+				zap.Error(err),
+				zap.String("phase", chunk.Phase),
+				zap.String("stream", chunk.Stream))
+		}
+	}
+}
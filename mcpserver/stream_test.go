@@ -0,0 +1,98 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+	"github.com/isdmx/codebox/sandbox"
+)
+
+// StreamingSandboxExecutor implements sandbox.SandboxExecutor and streams fixed chunks before returning
+type StreamingSandboxExecutor struct {
+	chunks   []sandbox.OutputChunk
+	streamed bool
+}
+
+func (s *StreamingSandboxExecutor) Execute(_ context.Context, req sandbox.ExecuteRequest) (sandbox.ExecuteResult, error) { //nolint:gocritic // Mock implementation requires full parameter signature
+	s.streamed = req.Stream != nil
+	if req.Stream != nil {
+		for _, chunk := range s.chunks {
+			req.Stream(chunk)
+		}
+	}
+	return sandbox.ExecuteResult{Stdout: "hello\n", Stderr: "oops\n", Status: sandbox.StatusOK}, nil
+}
+
+// testClientSession is an initialized client session that collects notifications
+type testClientSession struct {
+	notifications chan mcp.JSONRPCNotification
+}
+
+func (s *testClientSession) SessionID() string { return "test-session" }
+
+func (s *testClientSession) NotificationChannel() chan<- mcp.JSONRPCNotification {
+	return s.notifications
+}
+
+func (s *testClientSession) Initialize() {}
+
+func (s *testClientSession) Initialized() bool { return true }
+
+func TestOutputStreaming(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+	}
+	executor := &StreamingSandboxExecutor{chunks: []sandbox.OutputChunk{
+		{Phase: sandbox.PhaseRun, Stream: sandbox.StreamStdout, Data: []byte("hello\n")},
+		{Phase: sandbox.PhaseRun, Stream: sandbox.StreamStderr, Data: []byte("oops\n")},
+	}}
+	s, err := New(cfg, logger, executor)
+	require.NoError(t, err)
+
+	session := &testClientSession{notifications: make(chan mcp.JSONRPCNotification, 10)}
+	ctx := s.mcpServer.WithContext(context.Background(), session)
+	args := ExecuteRequest{Code: "print('hello')", Language: sandbox.LanguagePython}
+
+	t.Run("WithProgressToken", func(t *testing.T) {
+		request := mcp.CallToolRequest{}
+		request.Params.Meta = &mcp.Meta{ProgressToken: "exec-1"}
+
+		response, err := s.handleExecuteSandboxedCodeStructured(ctx, request, args)
+		require.NoError(t, err)
+		assert.True(t, executor.streamed)
+		assert.Equal(t, "hello\n", response.Stdout, "the final response is unchanged")
+
+		require.Len(t, session.notifications, 2)
+		first := <-session.notifications
+		second := <-session.notifications
+
+		assert.Equal(t, "notifications/progress", first.Method)
+		params := first.Params.AdditionalFields
+		assert.Equal(t, "exec-1", params["progressToken"])
+		assert.Equal(t, int64(6), params["progress"])
+		assert.Equal(t, "hello\n", params["message"])
+		assert.Equal(t, map[string]any{"codebox/phase": "run", "codebox/stream": "stdout"}, params["_meta"])
+
+		params = second.Params.AdditionalFields
+		assert.Equal(t, int64(11), params["progress"], "progress counts the bytes streamed so far")
+		assert.Equal(t, "oops\n", params["message"])
+		assert.Equal(t, map[string]any{"codebox/phase": "run", "codebox/stream": "stderr"}, params["_meta"])
+	})
+
+	t.Run("WithoutProgressToken", func(t *testing.T) {
+		_, err := s.handleExecuteSandboxedCodeStructured(ctx, mcp.CallToolRequest{}, args)
+		require.NoError(t, err)
+		assert.False(t, executor.streamed)
+		assert.Empty(t, session.notifications)
+	})
+}
@@ -0,0 +1,176 @@
+// Package mcpserver provides the Model Context Protocol (MCP) server implementation.
+//
+// The execute_test_cases tool builds a program once and runs it against a
+// list of test cases, returning a verdict for every case. It is only exposed
+// when the sandbox backend supports batch execution.
+package mcpserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/sandbox"
+)
+
+// TestCasesRequest represents the input parameters for batch test case execution
+type TestCasesRequest struct {
+	Code           string          `json:"code" jsonschema_description:"User-provided source code" jsonschema:"required"`
+	Language       string          `json:"language" jsonschema_description:"Programming language of the code" jsonschema:"required"`
+	WorkdirTar     string          `json:"workdir_tar,omitempty" jsonschema_description:"Base64-encoded tar.gz of initial working directory (optional)"`
+	TestCases      []TestCaseInput `json:"test_cases" jsonschema_description:"Test cases to run the program against" jsonschema:"required"`
+	Comparison     string          `json:"comparison,omitempty" jsonschema_description:"How output is compared, defaults to exact" jsonschema:"enum=exact,enum=whitespace,enum=float"` //nolint:lll // Struct tags cannot be split
+	FloatTolerance float64         `json:"float_tolerance,omitempty" jsonschema_description:"Absolute or relative tolerance for float comparison (default 1e-6)"`
+}
+
+// TestCaseInput represents a single test case
+type TestCaseInput struct {
+	Stdin          string `json:"stdin,omitempty" jsonschema_description:"Data passed to the standard input of the program"`
+	ExpectedStdout string `json:"expected_stdout" jsonschema_description:"Expected standard output" jsonschema:"required"`
+	TimeoutSec     int    `json:"timeout_sec,omitempty" jsonschema_description:"Time limit of this case in seconds, capped by the server limit"`
+}
+
+// TestCasesResponse represents the structured response from batch test case execution
+type TestCasesResponse struct {
+	Build   *PhaseResponse     `json:"build,omitempty" jsonschema_description:"Result of the build phase for compiled languages"`
+	Cases   []TestCaseResponse `json:"cases" jsonschema_description:"Result of every test case in request order"`
+	Passed  int                `json:"passed" jsonschema_description:"Number of accepted test cases"`
+	Total   int                `json:"total" jsonschema_description:"Total number of test cases"`
+	Runtime string             `json:"runtime,omitempty" jsonschema_description:"OCI runtime the sandbox ran with, e.g. runsc"`
+	Error   string             `json:"error,omitempty" jsonschema_description:"Error message if execution failed"`
+	Success bool               `json:"success" jsonschema_description:"Indicates if execution was successful"`
+}
+
+// TestCaseResponse represents the result of a single test case
+type TestCaseResponse struct {
+	Verdict    string         `json:"verdict" jsonschema_description:"accepted, wrong_answer, time_limit_exceeded, runtime_error or compile_error"`
+	Stdout     string         `json:"stdout" jsonschema_description:"Standard output of the program"`
+	Stderr     string         `json:"stderr" jsonschema_description:"Standard error of the program"`
+	ExitCode   int            `json:"exit_code" jsonschema_description:"Exit code of the program"`
+	DurationMs int64          `json:"duration_ms" jsonschema_description:"Wall-clock duration of the case in milliseconds"`
+	Usage      *UsageResponse `json:"usage,omitempty" jsonschema_description:"Resources used by the case"`
+}
+
+// registerExecuteTestCasesTool registers the execute_test_cases tool if the executor supports batches
+func (s *MCPServer) registerExecuteTestCasesTool() {
+	if _, ok := s.sandboxExec.(sandbox.BatchExecutor); !ok {
+		s.logger.Info("sandbox backend does not support batch execution, execute_test_cases is disabled")
+		return
+	}
+
+	tool := mcp.NewTool("execute_test_cases",
+		mcp.WithDescription("Build code once and run it against many stdin test cases, returning a verdict per case"),
+		mcp.WithInputSchema[TestCasesRequest](),
+		mcp.WithOutputSchema[TestCasesResponse](),
+		withLanguageEnum(s.languages.Names()),
+	)
+
+	s.mcpServer.AddTool(tool, mcp.NewStructuredToolHandler(s.handleExecuteTestCasesStructured))
+}
+
+// handleExecuteTestCasesStructured handles the execute_test_cases tool with structured input/output
+func (s *MCPServer) handleExecuteTestCasesStructured(
+	ctx context.Context,
+	_ mcp.CallToolRequest,
+	args TestCasesRequest,
+) (TestCasesResponse, error) {
+	s.logger.Info("test case execution requested")
+
+	batchExec, ok := s.sandboxExec.(sandbox.BatchExecutor)
+	if !ok {
+		return TestCasesResponse{Error: "sandbox backend does not support batch execution"}, nil
+	}
+
+	batchReq, err := s.newBatchRequest(&args)
+	if err != nil {
+		return TestCasesResponse{Error: err.Error()}, nil
+	}
+
+	s.logger.Info("executing test cases in sandbox",
+		zap.String("language", args.Language),
+		zap.Int("cases", len(batchReq.Cases)),
+		zap.String("comparison", string(batchReq.Compare)))
+
+	result, err := batchExec.ExecuteBatch(ctx, batchReq)
+	if err != nil {
+		s.logger.Error("sandbox batch execution failed",
+			zap.Error(err),
+			zap.String("language", args.Language))
+		return TestCasesResponse{Error: fmt.Sprintf("execution failed: %v", err)}, nil
+	}
+
+	response := TestCasesResponse{
+		Build:   newPhaseResponse(result.Build),
+		Cases:   make([]TestCaseResponse, len(result.Cases)),
+		Passed:  result.Passed(),
+		Total:   len(result.Cases),
+		Runtime: result.Runtime,
+		Success: true,
+	}
+	for i := range result.Cases {
+		testCase := &result.Cases[i]
+		response.Cases[i] = TestCaseResponse{
+			Verdict:    string(testCase.Verdict),
+			Stdout:     testCase.Stdout,
+			Stderr:     testCase.Stderr,
+			ExitCode:   testCase.ExitCode,
+			DurationMs: testCase.Duration.Milliseconds(),
+			Usage:      newUsageResponse(testCase.Usage),
+		}
+	}
+
+	s.logger.Info("test case execution completed",
+		zap.String("language", args.Language),
+		zap.Int("passed", response.Passed),
+		zap.Int("total", response.Total))
+
+	return response, nil
+}
+
+// newBatchRequest validates the tool arguments and converts them into a sandbox batch request
+func (s *MCPServer) newBatchRequest(args *TestCasesRequest) (sandbox.BatchRequest, error) {
+	if _, ok := s.languages.Get(args.Language); !ok {
+		return sandbox.BatchRequest{}, fmt.Errorf("invalid language: %s", args.Language)
+	}
+
+	if len(args.TestCases) == 0 {
+		return sandbox.BatchRequest{}, fmt.Errorf("at least one test case is required")
+	}
+	if limit := s.config.GetMaxTestCases(); len(args.TestCases) > limit {
+		return sandbox.BatchRequest{}, fmt.Errorf("too many test cases: %d > %d", len(args.TestCases), limit)
+	}
+
+	compare := sandbox.CompareMode(args.Comparison)
+	if err := sandbox.ValidateCompareMode(compare); err != nil {
+		return sandbox.BatchRequest{}, err
+	}
+
+	workdirTar, err := decodeWorkdirTar(args.WorkdirTar)
+	if err != nil {
+		return sandbox.BatchRequest{}, err
+	}
+
+	cases := make([]sandbox.TestCase, len(args.TestCases))
+	for i, testCase := range args.TestCases {
+		stdin, err := s.stdinBytes(testCase.Stdin)
+		if err != nil {
+			return sandbox.BatchRequest{}, fmt.Errorf("test case %d: %w", i+1, err)
+		}
+		cases[i] = sandbox.TestCase{
+			Stdin:          stdin,
+			ExpectedStdout: testCase.ExpectedStdout,
+			TimeoutSec:     testCase.TimeoutSec,
+		}
+	}
+
+	return sandbox.BatchRequest{
+		Language:       args.Language,
+		Code:           args.Code,
+		WorkdirTar:     workdirTar,
+		Cases:          cases,
+		Compare:        compare,
+		FloatTolerance: args.FloatTolerance,
+	}, nil
+}
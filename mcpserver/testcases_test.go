@@ -0,0 +1,109 @@
+package mcpserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+	"github.com/isdmx/codebox/sandbox"
+)
+
+// BatchSandboxExecutor implements sandbox.SandboxExecutor and sandbox.BatchExecutor for testing
+type BatchSandboxExecutor struct {
+	MockSandboxExecutor
+	batchResult sandbox.BatchResult
+	lastRequest sandbox.BatchRequest
+	calls       int
+}
+
+func (b *BatchSandboxExecutor) ExecuteBatch(_ context.Context, req sandbox.BatchRequest) (sandbox.BatchResult, error) { //nolint:gocritic // Mock implementation requires full parameter signature
+	b.lastRequest = req
+	b.calls++
+	return b.batchResult, nil
+}
+
+func TestExecuteTestCasesTool(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Server:    config.ServerConfig{Transport: "stdio", HTTPPort: 8080},
+		Sandbox:   config.SandboxConfig{TimeoutSec: 30, MemoryMB: 512, MaxArtifactSizeMB: 20, MaxTestCases: 2},
+		Logging:   config.LoggingConfig{Mode: "production", Level: "info"},
+		Languages: map[string]config.Language{sandbox.LanguagePython: {}},
+	}
+
+	t.Run("RegisteredOnlyForBatchExecutors", func(t *testing.T) {
+		server, err := New(cfg, logger, &MockSandboxExecutor{})
+		require.NoError(t, err)
+		assert.Nil(t, server.GetMCPServer().GetTool("execute_test_cases"))
+
+		server, err = New(cfg, logger, &BatchSandboxExecutor{})
+		require.NoError(t, err)
+		assert.NotNil(t, server.GetMCPServer().GetTool("execute_test_cases"))
+	})
+
+	t.Run("RunsCases", func(t *testing.T) {
+		mockExecutor := &BatchSandboxExecutor{batchResult: sandbox.BatchResult{Cases: []sandbox.TestCaseResult{
+			{Verdict: sandbox.VerdictAccepted, Stdout: "3\n", Duration: 15 * time.Millisecond},
+			{Verdict: sandbox.VerdictWrongAnswer, Stdout: "4\n"},
+		}}}
+		server, err := New(cfg, logger, mockExecutor)
+		require.NoError(t, err)
+
+		resp, err := server.handleExecuteTestCasesStructured(context.Background(), mcp.CallToolRequest{}, TestCasesRequest{
+			Code:     "print(sum(map(int, input().split())))",
+			Language: sandbox.LanguagePython,
+			TestCases: []TestCaseInput{
+				{Stdin: "1 2\n", ExpectedStdout: "3\n"},
+				{Stdin: "2 2\n", ExpectedStdout: "5\n", TimeoutSec: 1},
+			},
+			Comparison: "whitespace",
+		})
+		require.NoError(t, err)
+		assert.True(t, resp.Success)
+		assert.Equal(t, 1, resp.Passed)
+		assert.Equal(t, 2, resp.Total)
+		require.Len(t, resp.Cases, 2)
+		assert.Equal(t, "accepted", resp.Cases[0].Verdict)
+		assert.Equal(t, int64(15), resp.Cases[0].DurationMs)
+		assert.Equal(t, "wrong_answer", resp.Cases[1].Verdict)
+
+		require.Len(t, mockExecutor.lastRequest.Cases, 2)
+		assert.Equal(t, []byte("2 2\n"), mockExecutor.lastRequest.Cases[1].Stdin)
+		assert.Equal(t, 1, mockExecutor.lastRequest.Cases[1].TimeoutSec)
+		assert.Equal(t, sandbox.CompareWhitespace, mockExecutor.lastRequest.Compare)
+	})
+
+	t.Run("RejectsInvalidRequests", func(t *testing.T) {
+		mockExecutor := &BatchSandboxExecutor{}
+		server, err := New(cfg, logger, mockExecutor)
+		require.NoError(t, err)
+
+		oneCase := []TestCaseInput{{ExpectedStdout: "1"}}
+		tests := []struct {
+			name string
+			args TestCasesRequest
+			want string
+		}{
+			{"InvalidLanguage", TestCasesRequest{Language: "cobol", TestCases: oneCase}, "invalid language"},
+			{"NoCases", TestCasesRequest{Language: sandbox.LanguagePython}, "at least one test case"},
+			{"TooManyCases", TestCasesRequest{Language: sandbox.LanguagePython, TestCases: make([]TestCaseInput, 3)}, "too many test cases"},
+			{"InvalidComparison", TestCasesRequest{Language: sandbox.LanguagePython, TestCases: oneCase, Comparison: "fuzzy"}, "unsupported comparison mode"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				resp, err := server.handleExecuteTestCasesStructured(context.Background(), mcp.CallToolRequest{}, tt.args)
+				require.NoError(t, err)
+				assert.False(t, resp.Success)
+				assert.Contains(t, resp.Error, tt.want)
+			})
+		}
+		assert.Equal(t, 0, mockExecutor.calls)
+	})
+}
@@ -0,0 +1,185 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Backends that cannot mount the workdir into a
+// container copy it in as a tar archive before the container starts and copy it
+// back out afterwards, keeping file modes so that built programs stay executable.
+package sandbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Permissions of archived workdir entries. Copied files belong to root in the container,
+// so they are opened up for the unprivileged user the program runs as.
+const (
+	archiveDirMode  = 0o777
+	archiveFileMode = 0o666
+	archiveExecMode = 0o111
+
+	// archiveOwnerDirMode keeps directories copied back from a container accessible to the server
+	archiveOwnerDirMode = 0o700
+)
+
+// workdirArchive returns a tar of the contents of the workdir to be extracted at WorkDirPath.
+// Spilled output is written on the host while the program runs and is left out.
+func workdirArchive(workdirPath string) ([]byte, error) {
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+
+	err := filepath.Walk(workdirPath, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(workdirPath, file)
+		if err != nil {
+			return err
+		}
+		if relPath == SpillDirName {
+			return filepath.SkipDir
+		}
+		if relPath == "." {
+			return nil // the workdir volume exists already
+		}
+
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+
+		switch {
+		case fi.IsDir():
+			header.Name += "/"
+			header.Mode = archiveDirMode
+		case fi.Mode().IsRegular():
+			header.Mode = archiveFileMode
+			if fi.Mode().Perm()&archiveExecMode != 0 {
+				header.Mode |= archiveExecMode
+			}
+		case link == "":
+			return nil // sockets, devices and pipes are not copied
+		}
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			return copyFileInto(tarWriter, file)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to archive workdir: %w", err)
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, fmt.Errorf("failed to archive workdir: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// copyFileInto writes the contents of a file to w
+func copyFileInto(w io.Writer, file string) error {
+	f, err := os.Open(file) //nolint:gosec // The file was found by walking the workdir
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// extractWorkdirArchive replaces the contents of the workdir with the archive of a container workdir,
+// whose entries are named after the base name of WorkDirPath. The spilled output on the host is kept.
+// Extraction goes through an os.Root, so that symlinks created by the program cannot lead it out of the workdir.
+func extractWorkdirArchive(r io.Reader, workdirPath string) error {
+	root, err := os.OpenRoot(workdirPath)
+	if err != nil {
+		return fmt.Errorf("failed to open workdir: %w", err)
+	}
+	defer root.Close()
+
+	entries, err := os.ReadDir(workdirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read workdir: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == SpillDirName {
+			continue
+		}
+		if err := root.RemoveAll(entry.Name()); err != nil {
+			return fmt.Errorf("failed to clear workdir: %w", err)
+		}
+	}
+
+	workdirName := path.Base(WorkDirPath)
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %w", err)
+		}
+
+		relPath, ok := strings.CutPrefix(path.Clean(header.Name), workdirName+"/")
+		if topLevel, _, _ := strings.Cut(relPath, "/"); !ok || topLevel == SpillDirName {
+			continue
+		}
+		if err := extractEntry(root, relPath, header, tarReader); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", relPath, err)
+		}
+	}
+}
+
+// extractEntry creates a single archive entry below root. Entry types other than directories,
+// regular files and symlinks are skipped.
+func extractEntry(root *os.Root, relPath string, header *tar.Header, r io.Reader) error {
+	mode := fs.FileMode(header.Mode).Perm() //nolint:gosec // Tar modes are 12 bit values
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := root.MkdirAll(relPath, DirPermission); err != nil {
+			return err
+		}
+		return root.Chmod(relPath, mode|archiveOwnerDirMode)
+	case tar.TypeReg:
+		if err := root.MkdirAll(path.Dir(relPath), DirPermission); err != nil {
+			return err
+		}
+		f, err := root.OpenFile(relPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode|FilePermission)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			_ = f.Close()
+			return err
+		}
+		return f.Close()
+	case tar.TypeSymlink:
+		if err := root.MkdirAll(path.Dir(relPath), DirPermission); err != nil {
+			return err
+		}
+		return root.Symlink(header.Linkname, relPath)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,86 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkdirArchiveRoundTrip(t *testing.T) {
+	workdir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(workdir, "pkg"), DirPermission))
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "pkg", "lib.py"), []byte("x = 1"), FilePermission))
+	require.NoError(t, os.WriteFile(filepath.Join(workdir, "app"), []byte("binary"), 0o700))
+	require.NoError(t, os.Symlink("pkg/lib.py", filepath.Join(workdir, "link.py")))
+	require.NoError(t, os.MkdirAll(filepath.Join(workdir, SpillDirName), DirPermission))
+
+	archive, err := workdirArchive(workdir)
+	require.NoError(t, err)
+
+	// The archive extracts into the workdir of the container, open to every user
+	modes := make(map[string]int64)
+	tarReader := tar.NewReader(bytes.NewReader(archive))
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		modes[header.Name] = header.Mode
+	}
+	assert.Equal(t, map[string]int64{
+		"app":        0o777,
+		"link.py":    modes["link.py"],
+		"pkg/":       archiveDirMode,
+		"pkg/lib.py": archiveFileMode,
+	}, modes)
+
+	// Extracting the workdir archive of a container replaces the workdir contents, keeping the spilled output on the host
+	target := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(target, "stale.txt"), []byte("old"), FilePermission))
+	require.NoError(t, os.MkdirAll(filepath.Join(target, SpillDirName), DirPermission))
+	require.NoError(t, extractWorkdirArchive(bytes.NewReader(containerWorkdirArchive(t, archive)), target))
+
+	assert.NoFileExists(t, filepath.Join(target, "stale.txt"))
+	assert.DirExists(t, filepath.Join(target, SpillDirName))
+	info, err := os.Stat(filepath.Join(target, "app"))
+	require.NoError(t, err)
+	assert.NotZero(t, info.Mode().Perm()&0o100, "executables stay executable")
+	content, err := os.ReadFile(filepath.Join(target, "link.py"))
+	require.NoError(t, err)
+	assert.Equal(t, "x = 1", string(content))
+}
+
+func TestExtractWorkdirArchiveStaysInWorkdir(t *testing.T) {
+	outside := t.TempDir()
+
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "workdir/escape", Typeflag: tar.TypeSymlink, Linkname: outside}))
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "workdir/escape/owned", Typeflag: tar.TypeReg, Mode: 0o644, Size: 2}))
+	_, err := tarWriter.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, tarWriter.Close())
+
+	err = extractWorkdirArchive(bytes.NewReader(buf.Bytes()), t.TempDir())
+	require.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(outside, "owned"))
+}
+
+// containerWorkdirArchive returns the archive that an engine returns for WorkDirPath after the workdir archive
+// was copied into it, with every entry below the base name of the workdir
+func containerWorkdirArchive(t *testing.T, archive []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	tarReader := tar.NewReader(bytes.NewReader(archive))
+	for header, err := tarReader.Next(); err == nil; header, err = tarReader.Next() {
+		header.Name = "workdir/" + header.Name
+		require.NoError(t, tarWriter.WriteHeader(header))
+		_, err = io.Copy(tarWriter, tarReader)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	return buf.Bytes()
+}
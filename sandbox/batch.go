@@ -0,0 +1,217 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Batch execution builds a program once and
+// runs it against many test cases in the same prepared workdir, judging the
+// output of every case against its expected output.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verdict is the judgement of a single test case
+type Verdict string
+
+// Test case verdicts
+const (
+	VerdictAccepted          Verdict = "accepted"
+	VerdictWrongAnswer       Verdict = "wrong_answer"
+	VerdictTimeLimitExceeded Verdict = "time_limit_exceeded"
+	VerdictRuntimeError      Verdict = "runtime_error"
+	VerdictCompileError      Verdict = "compile_error"
+)
+
+// CompareMode selects how actual output is compared to the expected output
+type CompareMode string
+
+// Output comparison modes
+const (
+	CompareExact      CompareMode = "exact"
+	CompareWhitespace CompareMode = "whitespace"
+	CompareFloat      CompareMode = "float"
+)
+
+// DefaultFloatTolerance is the absolute and relative tolerance used by CompareFloat when none is given
+const DefaultFloatTolerance = 1e-6
+
+// TestCase is a single input and expected output pair
+type TestCase struct {
+	Stdin          []byte
+	ExpectedStdout string
+	TimeoutSec     int // 0 uses the executor run timeout, larger values are capped by it
+}
+
+// BatchRequest represents the parameters for building code once and running many test cases
+type BatchRequest struct {
+	Language       string
+	Code           string
+	WorkdirTar     []byte // decoded base64
+	Cases          []TestCase
+	Compare        CompareMode
+	FloatTolerance float64
+}
+
+// TestCaseResult represents the outcome of a single test case
+type TestCaseResult struct {
+	Verdict  Verdict
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	Usage    ResourceUsage
+}
+
+// BatchResult represents the result of a batch execution
+type BatchResult struct {
+	Build   *PhaseResult // nil when the language has no build step
+	Cases   []TestCaseResult
+	Runtime string // OCI runtime the containers ran with, empty for the engine default
+}
+
+// Passed returns the number of accepted test cases
+func (r *BatchResult) Passed() int {
+	passed := 0
+	for i := range r.Cases {
+		if r.Cases[i].Verdict == VerdictAccepted {
+			passed++
+		}
+	}
+	return passed
+}
+
+// BatchExecutor is implemented by executors that can build once and run many test cases
+type BatchExecutor interface {
+	ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error)
+}
+
+// ValidateCompareMode checks the comparison mode, treating an empty mode as exact
+func ValidateCompareMode(mode CompareMode) error {
+	switch mode {
+	case "", CompareExact, CompareWhitespace, CompareFloat:
+		return nil
+	default:
+		return fmt.Errorf("unsupported comparison mode: %s", mode)
+	}
+}
+
+// runTestCases runs the build phase once and then every test case through the run phase.
+// When the build fails every case is reported as a compile error without being run.
+func runTestCases(
+	ctx context.Context,
+	lang Language,
+	buildTimeout, runTimeout time.Duration,
+	req *BatchRequest,
+	run phaseFunc,
+) (BatchResult, error) {
+	if err := ValidateCompareMode(req.Compare); err != nil {
+		return BatchResult{}, err
+	}
+
+	var result BatchResult
+
+	build, err := runBuildPhase(ctx, lang, buildTimeout, run)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	result.Build = build
+
+	result.Cases = make([]TestCaseResult, len(req.Cases))
+	for i := range req.Cases {
+		if build != nil && !build.Succeeded() {
+			result.Cases[i] = TestCaseResult{Verdict: VerdictCompileError}
+			continue
+		}
+
+		testCase := &req.Cases[i]
+		timeout := runTimeout
+		if caseTimeout := time.Duration(testCase.TimeoutSec) * time.Second; caseTimeout > 0 && caseTimeout < timeout {
+			timeout = caseTimeout
+		}
+
+		phase, err := runTimedPhase(ctx, timeout, PhaseRun, lang.RunCmd, testCase.Stdin, run)
+		if err != nil {
+			return BatchResult{}, fmt.Errorf("test case %d failed: %w", i+1, err)
+		}
+
+		result.Cases[i] = TestCaseResult{
+			Verdict:  judge(&phase, testCase.ExpectedStdout, req.Compare, req.FloatTolerance),
+			Stdout:   phase.Stdout,
+			Stderr:   phase.Stderr,
+			ExitCode: phase.ExitCode,
+			Duration: phase.Duration,
+			Usage:    phase.Usage,
+		}
+	}
+
+	return result, nil
+}
+
+// judge assigns a verdict to a finished run phase
+func judge(phase *PhaseResult, expected string, mode CompareMode, tolerance float64) Verdict {
+	switch {
+	case phase.TimedOut:
+		return VerdictTimeLimitExceeded
+	case phase.ExitCode != 0:
+		return VerdictRuntimeError
+	case CompareOutput(phase.Stdout, expected, mode, tolerance):
+		return VerdictAccepted
+	default:
+		return VerdictWrongAnswer
+	}
+}
+
+// CompareOutput reports whether the actual output matches the expected output under the given mode
+func CompareOutput(actual, expected string, mode CompareMode, tolerance float64) bool {
+	switch mode {
+	case CompareWhitespace:
+		return equalTokens(strings.Fields(actual), strings.Fields(expected), exactToken)
+	case CompareFloat:
+		if tolerance <= 0 {
+			tolerance = DefaultFloatTolerance
+		}
+		return equalTokens(strings.Fields(actual), strings.Fields(expected), func(a, b string) bool {
+			return floatToken(a, b, tolerance)
+		})
+	default:
+		return actual == expected
+	}
+}
+
+// equalTokens compares two token lists pairwise with the given token comparison
+func equalTokens(actual, expected []string, equal func(a, b string) bool) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+	for i := range actual {
+		if !equal(actual[i], expected[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func exactToken(a, b string) bool {
+	return a == b
+}
+
+// floatToken compares numeric tokens within an absolute or relative tolerance and other tokens exactly
+func floatToken(a, b string, tolerance float64) bool {
+	if a == b {
+		return true
+	}
+
+	x, errA := strconv.ParseFloat(a, 64)
+	y, errB := strconv.ParseFloat(b, 64)
+	if errA != nil || errB != nil || math.IsNaN(x) || math.IsNaN(y) {
+		return false
+	}
+
+	diff := math.Abs(x - y)
+	return diff <= tolerance || diff <= tolerance*math.Max(math.Abs(x), math.Abs(y))
+}
@@ -0,0 +1,161 @@
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestCompareOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		actual    string
+		expected  string
+		mode      CompareMode
+		tolerance float64
+		want      bool
+	}{
+		{"ExactMatch", "42\n", "42\n", CompareExact, 0, true},
+		{"ExactTrailingNewline", "42", "42\n", CompareExact, 0, false},
+		{"DefaultIsExact", "1 2", "1  2", "", 0, false},
+		{"WhitespaceIgnoresLayout", "1  2\n3\n\n", "1 2 3", CompareWhitespace, 0, true},
+		{"WhitespaceTokenMismatch", "1 2 4", "1 2 3", CompareWhitespace, 0, false},
+		{"WhitespaceExtraToken", "1 2 3 4", "1 2 3", CompareWhitespace, 0, false},
+		{"FloatDefaultTolerance", "0.3333333\n", "0.333333", CompareFloat, 0, true},
+		{"FloatOutsideTolerance", "0.34", "0.333", CompareFloat, 1e-3, false},
+		{"FloatRelativeTolerance", "1000001", "1000000", CompareFloat, 1e-6, true},
+		{"FloatNonNumericTokens", "answer 1.0", "answer 1", CompareFloat, 0, true},
+		{"FloatNonNumericMismatch", "yes", "no", CompareFloat, 0, false},
+		{"FloatNaN", "NaN", "nan", CompareFloat, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CompareOutput(tt.actual, tt.expected, tt.mode, tt.tolerance))
+		})
+	}
+}
+
+func TestValidateCompareMode(t *testing.T) {
+	for _, mode := range []CompareMode{"", CompareExact, CompareWhitespace, CompareFloat} {
+		require.NoError(t, ValidateCompareMode(mode))
+	}
+	require.Error(t, ValidateCompareMode("fuzzy"))
+}
+
+func TestRunTestCases(t *testing.T) {
+	interpreted := Language{Name: LanguagePython, RunCmd: "python main.py"}
+	compiled := Language{Name: LanguageGo, BuildCmd: "go build -o app main.go", RunCmd: "./app"}
+
+	t.Run("Verdicts", func(t *testing.T) {
+		req := &BatchRequest{Cases: []TestCase{
+			{Stdin: []byte("ok"), ExpectedStdout: "ok"},
+			{Stdin: []byte("wrong"), ExpectedStdout: "ok"},
+			{Stdin: []byte("crash"), ExpectedStdout: "ok"},
+			{Stdin: []byte("hang"), ExpectedStdout: "ok", TimeoutSec: 1},
+		}}
+
+		result, err := runTestCases(context.Background(), interpreted, time.Second, 50*time.Millisecond, req,
+			func(ctx context.Context, _, _ string, stdin []byte) (PhaseResult, error) {
+				switch string(stdin) {
+				case "crash":
+					return PhaseResult{Stderr: "Traceback", ExitCode: 1}, nil
+				case "hang":
+					<-ctx.Done()
+					return PhaseResult{}, ctx.Err()
+				default:
+					return PhaseResult{Stdout: string(stdin)}, nil
+				}
+			})
+		require.NoError(t, err)
+		assert.Nil(t, result.Build)
+		require.Len(t, result.Cases, 4)
+		assert.Equal(t, VerdictAccepted, result.Cases[0].Verdict)
+		assert.Equal(t, VerdictWrongAnswer, result.Cases[1].Verdict)
+		assert.Equal(t, VerdictRuntimeError, result.Cases[2].Verdict)
+		assert.Equal(t, "Traceback", result.Cases[2].Stderr)
+		assert.Equal(t, VerdictTimeLimitExceeded, result.Cases[3].Verdict)
+		// The per-case timeout of one second is capped by the run timeout
+		assert.Less(t, result.Cases[3].Duration, time.Second)
+		assert.Equal(t, 1, result.Passed())
+	})
+
+	t.Run("BuildsOnce", func(t *testing.T) {
+		var phases []string
+		req := &BatchRequest{Cases: []TestCase{{ExpectedStdout: "x"}, {ExpectedStdout: "x"}, {ExpectedStdout: "x"}}}
+
+		result, err := runTestCases(context.Background(), compiled, time.Second, time.Second, req,
+			func(_ context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				phases = append(phases, phase)
+				return PhaseResult{Stdout: "x"}, nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, []string{PhaseBuild, PhaseRun, PhaseRun, PhaseRun}, phases)
+		require.NotNil(t, result.Build)
+		assert.Equal(t, 3, result.Passed())
+	})
+
+	t.Run("CompileErrorSkipsCases", func(t *testing.T) {
+		req := &BatchRequest{Cases: []TestCase{{ExpectedStdout: "x"}, {ExpectedStdout: "y"}}}
+
+		result, err := runTestCases(context.Background(), compiled, time.Second, time.Second, req,
+			func(_ context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				if phase == PhaseRun {
+					t.Fatal("test cases must not run after a failed build")
+				}
+				return PhaseResult{Stderr: "syntax error", ExitCode: 1}, nil
+			})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.Equal(t, "syntax error", result.Build.Stderr)
+		require.Len(t, result.Cases, 2)
+		for _, testCase := range result.Cases {
+			assert.Equal(t, VerdictCompileError, testCase.Verdict)
+		}
+		assert.Equal(t, 0, result.Passed())
+	})
+
+	t.Run("InvalidCompareMode", func(t *testing.T) {
+		_, err := runTestCases(context.Background(), interpreted, time.Second, time.Second,
+			&BatchRequest{Compare: "fuzzy"}, func(context.Context, string, string, []byte) (PhaseResult, error) {
+				t.Fatal("nothing must run with an invalid comparison mode")
+				return PhaseResult{}, nil
+			})
+		require.Error(t, err)
+	})
+}
+
+func TestLocalExecutorBatch(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+	result, err := executor.ExecuteBatch(context.Background(), BatchRequest{
+		Language: LanguagePython,
+		Code:     "a, b = map(int, input().split())\nprint(a + b)\n",
+		Cases: []TestCase{
+			{Stdin: []byte("1 2\n"), ExpectedStdout: "3\n"},
+			{Stdin: []byte("2 2\n"), ExpectedStdout: "5"},
+			{Stdin: []byte("oops\n"), ExpectedStdout: "0"},
+		},
+		Compare: CompareWhitespace,
+	})
+	require.NoError(t, err)
+	require.Len(t, result.Cases, 3)
+	assert.Equal(t, VerdictAccepted, result.Cases[0].Verdict)
+	assert.Equal(t, VerdictWrongAnswer, result.Cases[1].Verdict)
+	assert.Equal(t, "4\n", result.Cases[1].Stdout)
+	assert.Equal(t, VerdictRuntimeError, result.Cases[2].Verdict)
+	assert.Contains(t, result.Cases[2].Stderr, "ValueError")
+}
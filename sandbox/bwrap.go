@@ -0,0 +1,315 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The BwrapExecutor runs every phase in
+// bubblewrap, a lighter alternative to containers: the toolchain comes from
+// read-only binds of the host's system directories or of a root filesystem
+// directory, and only the workdir is writable.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+const defaultBwrapBinary = "bwrap"
+
+const (
+	// bwrapStatusFD is the descriptor of the status file that bwrap writes JSON status events to,
+	// the first extra file of the command
+	bwrapStatusFD = "3"
+	// bwrapStatusLimit bounds how much of the status file is read
+	bwrapStatusLimit = 64 * 1024
+)
+
+// bwrapSystemPaths are bound read-only from the host when no root filesystem is configured. Only the
+// parts of /etc that programs need are bound, so that host configuration stays out of the sandbox.
+var bwrapSystemPaths = []string{
+	"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64",
+	"/etc/alternatives", "/etc/ld.so.cache", "/etc/ld.so.conf", "/etc/ld.so.conf.d", "/etc/ssl", "/etc/ca-certificates",
+	"/etc/localtime", "/etc/passwd", "/etc/group", "/etc/nsswitch.conf", "/etc/hosts", "/etc/resolv.conf",
+}
+
+// BwrapExecutor implements SandboxExecutor with bubblewrap
+type BwrapExecutor struct {
+	logger     *zap.Logger
+	config     *Config
+	cfg        *config.Config // Reference to the full configuration
+	cmdRunner  CommandRunner
+	fs         FileSystem
+	workdirs   hostWorkdirs
+	binary     string
+	systemArgs []string // arguments that bind the system paths of the host
+}
+
+// BwrapExecutorOption defines a functional option for BwrapExecutor
+type BwrapExecutorOption func(*BwrapExecutor)
+
+// WithBwrapCommandRunner sets the CommandRunner for BwrapExecutor
+func WithBwrapCommandRunner(cmdRunner CommandRunner) BwrapExecutorOption {
+	return func(b *BwrapExecutor) {
+		b.cmdRunner = cmdRunner
+	}
+}
+
+// WithBwrapFileSystem sets the FileSystem for BwrapExecutor
+func WithBwrapFileSystem(fs FileSystem) BwrapExecutorOption {
+	return func(b *BwrapExecutor) {
+		b.fs = fs
+	}
+}
+
+// NewBwrapExecutor creates a new BwrapExecutor. It fails when bubblewrap is not installed or when a root
+// filesystem of the configured languages is missing.
+func NewBwrapExecutor(logger *zap.Logger, executorConfig *Config, cfg *config.Config, opts ...BwrapExecutorOption) (*BwrapExecutor, error) {
+	executor := &BwrapExecutor{
+		logger:     logger,
+		config:     executorConfig,
+		cfg:        cfg,
+		cmdRunner:  &RealCommandRunner{}, // Default implementation
+		fs:         &RealFileSystem{},    // Default implementation
+		binary:     cfg.Sandbox.Bwrap.Binary,
+		systemArgs: bwrapSystemArgs(bwrapSystemPaths),
+	}
+
+	if executor.binary == "" {
+		executor.binary = defaultBwrapBinary
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(executor)
+	}
+	executor.workdirs = hostWorkdirs{logger: logger, config: executorConfig, cfg: cfg, fs: executor.fs}
+
+	binary, err := exec.LookPath(executor.binary)
+	if err != nil {
+		return nil, err
+	}
+	executor.binary = binary
+
+	// A read-only root filesystem needs the mount points of the sandbox and of the binds in place
+	for language := range cfg.Languages {
+		rootfs := cfg.GetRootfs(language)
+		if rootfs == "" {
+			continue
+		}
+		if err := prepareRootfs(rootfs); err != nil {
+			return nil, err
+		}
+		for _, bind := range executor.binds(language) {
+			if err := prepareBindTarget(rootfs, bind); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return executor, nil
+}
+
+// bwrapSystemArgs returns the bwrap arguments that recreate the system paths of the host that exist: the
+// symlinks of merged /usr systems like /bin -> usr/bin as symlinks, everything else as read-only binds
+func bwrapSystemArgs(paths []string) []string {
+	args := []string{}
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 && filepath.Dir(path) == "/" {
+			if target, err := os.Readlink(path); err == nil {
+				args = append(args, "--symlink", target, path)
+				continue
+			}
+		}
+		args = append(args, "--ro-bind", path, path)
+	}
+	return args
+}
+
+// prepareBindTarget creates the mount point of a bound host path in a root filesystem
+func prepareBindTarget(rootfs, bind string) error {
+	info, err := os.Stat(bind)
+	if err != nil {
+		return fmt.Errorf("invalid bind %s: %w", bind, err)
+	}
+
+	target := filepath.Join(rootfs, bind)
+	if info.IsDir() {
+		err = os.MkdirAll(target, DirPermission)
+	} else if err = os.MkdirAll(filepath.Dir(target), DirPermission); err == nil {
+		var file *os.File
+		file, err = os.OpenFile(target, os.O_CREATE|os.O_RDONLY, FilePermission)
+		if err == nil {
+			err = file.Close()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create mount point of %s in rootfs %s: %w", bind, rootfs, err)
+	}
+	return nil
+}
+
+// binds returns the host paths bound read-only for a language
+func (b *BwrapExecutor) binds(language string) []string {
+	binds := slices.Clone(b.cfg.Sandbox.Bwrap.Binds)
+	if langConfig, exists := b.cfg.Languages[language]; exists {
+		binds = append(binds, langConfig.Binds...)
+	}
+	return binds
+}
+
+// Execute runs the code in bubblewrap
+//
+//nolint:gocritic // Large request struct is passed by value to satisfy the SandboxExecutor interface
+func (b *BwrapExecutor) Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error) {
+	workdirPath, lang, cleanup, err := b.workdirs.prepare(req.Language, req.Code, req.Workdir, req.WorkdirTar)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	defer cleanup()
+
+	// Run the build phase (if any) and the run phase each in its own sandbox
+	limits := b.config.limits(req.TimeoutSec, req.MemoryMB, req.Network)
+	phase := b.sandboxPhase(req.Language, workdirPath, limits, b.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, b.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	// bubblewrap cannot limit memory, so only the time limit and network isolation are enforced
+	result.Limits = limits.unlimitedMemory()
+
+	// Only return artifacts when the run phase actually finished and the caller wants them
+	if err := b.workdirs.collectArtifacts(&result, &req, workdirPath); err != nil {
+		return ExecuteResult{}, err
+	}
+	return result, nil
+}
+
+// ExecuteBatch builds the code once and runs it against every test case in the same workdir
+//
+//nolint:gocritic // Large request struct is passed by value to mirror Execute
+func (b *BwrapExecutor) ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error) {
+	workdirPath, lang, cleanup, err := b.workdirs.prepare(req.Language, req.Code, "", req.WorkdirTar)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer cleanup()
+
+	limits := b.config.limits(0, 0, false)
+	phase := b.sandboxPhase(req.Language, workdirPath, limits, phaseCapture{})
+	return runTestCases(ctx, lang, b.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+}
+
+// sandboxPhase returns a phaseFunc that runs every phase in its own bubblewrap sandbox on the workdir.
+// capture decides where the output goes besides the phase result.
+func (b *BwrapExecutor) sandboxPhase(language, workdirPath string, limits Limits, capture phaseCapture) phaseFunc {
+	return func(ctx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return b.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			return b.runSandboxed(ctx, language, workdirPath, limits.forPhase(phase).Network, command, base)
+		})
+	}
+}
+
+// runSandboxed runs a single shell command in bubblewrap with the workdir bound to WorkDirPath.
+// base carries the stdin and output capture of the phase.
+func (b *BwrapExecutor) runSandboxed(
+	ctx context.Context,
+	language, workdirPath string,
+	network bool,
+	command string,
+	base Command,
+) (PhaseResult, error) {
+	// bwrap reports the pid of the command once it started it in the sandbox
+	status, err := os.CreateTemp("", "codebox-bwrap-status-*")
+	if err != nil {
+		return PhaseResult{}, fmt.Errorf("failed to create bubblewrap status file: %w", err)
+	}
+	defer func() {
+		_ = status.Close()
+		_ = os.Remove(status.Name())
+	}()
+
+	base.Args = b.bwrapArgs(language, workdirPath, network, command)
+	base.Env = sandboxEnvironment(b.cfg, language)
+	base.ExtraFiles = []*os.File{status}
+	output, err := b.cmdRunner.RunCommand(ctx, base)
+
+	// A timeout is reported by the caller, keep whatever output was produced
+	if ctx.Err() != nil {
+		return PhaseResult{Stdout: output.Stdout, Stderr: output.Stderr, Usage: output.Usage, Output: output.Output}, ctx.Err()
+	}
+
+	if err != nil {
+		return PhaseResult{}, fmt.Errorf("failed to start bubblewrap: %w", err)
+	}
+
+	// A command that never started failed with the sandbox, while whatever the command writes is its own output
+	if output.ExitCode != 0 && !bwrapChildStarted(status) {
+		return PhaseResult{}, fmt.Errorf("failed to set up sandbox: %s", strings.TrimSpace(output.Stderr))
+	}
+
+	return phaseOutput(&output), nil
+}
+
+// bwrapChildStarted reports whether the JSON status that bwrap wrote to its status file has the pid
+// of its child, which bwrap reports once it created the namespaces of the sandbox and started the child
+func bwrapChildStarted(status *os.File) bool {
+	if _, err := status.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	decoder := json.NewDecoder(io.LimitReader(status, bwrapStatusLimit))
+	for {
+		var event struct {
+			ChildPID *int `json:"child-pid"`
+		}
+		if err := decoder.Decode(&event); err != nil {
+			return false
+		}
+		if event.ChildPID != nil {
+			return true
+		}
+	}
+}
+
+// bwrapArgs returns the bwrap command line that runs command for a language: every namespace unshared
+// except the network when network access is enabled, without capabilities, in a new session and killed
+// together with the server, on a read-only root with only the workdir writable. bwrap writes its status
+// events to bwrapStatusFD.
+func (b *BwrapExecutor) bwrapArgs(language, workdirPath string, network bool, command string) []string {
+	args := []string{b.binary, "--json-status-fd", bwrapStatusFD, "--unshare-all"}
+	if network {
+		args = append(args, "--share-net")
+	}
+	args = append(args, "--die-with-parent", "--new-session", "--cap-drop", "ALL", "--hostname", "codebox")
+
+	if rootfs := b.cfg.GetRootfs(language); rootfs != "" {
+		args = append(args, "--ro-bind", rootfs, "/")
+	} else {
+		args = append(args, b.systemArgs...)
+	}
+	for _, bind := range b.binds(language) {
+		args = append(args, "--ro-bind", bind, bind)
+	}
+
+	args = append(args,
+		"--bind", workdirPath, WorkDirPath,
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--chdir", WorkDirPath,
+		namespaceShell, "-c", command,
+	)
+	return args
+}
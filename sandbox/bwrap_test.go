@@ -0,0 +1,185 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// newBwrapTestExecutor creates a BwrapExecutor with a fake bwrap binary
+func newBwrapTestExecutor(t *testing.T, cfg *config.Config, network bool, runner CommandRunner) *BwrapExecutor {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "bwrap")
+	require.NoError(t, os.WriteFile(binary, []byte("#!/bin/sh\n"), 0o755)) //nolint:gosec // Test executable
+	cfg.Sandbox.Backend = config.BackendBwrap
+	cfg.Sandbox.Bwrap.Binary = binary
+
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5, NetworkEnabled: network}
+	executor, err := NewBwrapExecutor(zaptest.NewLogger(t), executorConfig, cfg, WithBwrapCommandRunner(runner))
+	require.NoError(t, err)
+	return executor
+}
+
+// bwrapStarted writes the status event of bwrap that reports the started child of a sandbox
+func bwrapStarted(t *testing.T, cmd Command) {
+	t.Helper()
+	require.Len(t, cmd.ExtraFiles, 1)
+	_, err := cmd.ExtraFiles[0].WriteString(`{ "child-pid": 42, "cgroup-namespace": 4026531835 }` + "\n")
+	require.NoError(t, err)
+}
+
+// argPairs returns the values that follow every occurrence of flag in args
+func argPairs(args []string, flag string, values int) [][]string {
+	var pairs [][]string
+	for i, arg := range args {
+		if arg == flag && i+values < len(args) {
+			pairs = append(pairs, args[i+1:i+1+values])
+		}
+	}
+	return pairs
+}
+
+func TestBwrapExecutor(t *testing.T) {
+	t.Run("Execute", func(t *testing.T) {
+		toolchain := t.TempDir()
+		cfg := &config.Config{
+			Sandbox: config.SandboxConfig{Bwrap: config.BwrapConfig{Binds: []string{"/opt"}}},
+			Languages: map[string]config.Language{
+				LanguagePython: {Binds: []string{toolchain}, Environment: map[string]string{"PYTHONPATH": toolchain}},
+			},
+		}
+		runner := &FuncCommandRunner{run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			bwrapStarted(t, cmd)
+			workdir := argPairs(cmd.Args, "--bind", 2)[0][0]
+			require.NoError(t, os.WriteFile(filepath.Join(workdir, "out.txt"), []byte("result"), FilePermission))
+			return CommandResult{Stdout: "hello\n", ExitCode: 3}, nil
+		}}
+		executor := newBwrapTestExecutor(t, cfg, false, runner)
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print('hello')"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusNonzeroExit, result.Status)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "hello\n", result.Stdout)
+		assert.NotEmpty(t, result.ArtifactsTar)
+		assert.Equal(t, Limits{TimeoutSec: 5}, result.Limits, "memory is not limited")
+
+		cmd := runner.Commands()[0]
+		args := cmd.Args
+		assert.Equal(t, cfg.Sandbox.Bwrap.Binary, args[0])
+		assert.Equal(t, [][]string{{bwrapStatusFD}}, argPairs(args, "--json-status-fd", 1))
+		for _, flag := range []string{"--unshare-all", "--die-with-parent", "--new-session"} {
+			assert.Contains(t, args, flag)
+		}
+		assert.NotContains(t, args, "--share-net")
+		assert.Equal(t, [][]string{{"ALL"}}, argPairs(args, "--cap-drop", 1))
+		assert.Contains(t, argPairs(args, "--ro-bind", 2), []string{"/opt", "/opt"})
+		assert.Contains(t, argPairs(args, "--ro-bind", 2), []string{toolchain, toolchain})
+		binds := argPairs(args, "--bind", 2)
+		require.Len(t, binds, 1, "only the workdir is writable")
+		assert.Equal(t, WorkDirPath, binds[0][1])
+		assert.Equal(t, []string{"--chdir", WorkDirPath, "/bin/sh", "-c", "python3 main.py"}, args[len(args)-5:])
+		assert.Contains(t, cmd.Env, "PYTHONPATH="+toolchain)
+		assert.Contains(t, cmd.Env, "PATH="+sandboxPath)
+	})
+
+	t.Run("RootfsAndNetwork", func(t *testing.T) {
+		rootfs := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "bin"), DirPermission))
+		require.NoError(t, os.WriteFile(filepath.Join(rootfs, "bin", "sh"), nil, FilePermission))
+		toolchain := t.TempDir()
+		cfg := &config.Config{Languages: map[string]config.Language{
+			LanguagePython: {},
+			LanguageNodeJS: {Rootfs: rootfs, Binds: []string{toolchain}},
+		}}
+		runner := &FuncCommandRunner{}
+		executor := newBwrapTestExecutor(t, cfg, true, runner)
+		assert.DirExists(t, filepath.Join(rootfs, WorkDirPath), "mount points are prepared")
+		assert.DirExists(t, filepath.Join(rootfs, toolchain))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguageNodeJS, Code: "1"})
+		require.NoError(t, err)
+		assert.True(t, result.Limits.Network)
+		args := runner.Calls()[0]
+		assert.Contains(t, args, "--share-net")
+		roBinds := argPairs(args, "--ro-bind", 2)
+		assert.Equal(t, []string{rootfs, "/"}, roBinds[0])
+		assert.NotContains(t, roBinds, []string{"/usr", "/usr"}, "the host system is not bound")
+
+		_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "1"})
+		require.NoError(t, err)
+		args = runner.Calls()[1]
+		start := slices.Index(args, "--hostname") + 2
+		assert.Equal(t, executor.systemArgs, args[start:start+len(executor.systemArgs)], "the host system is bound without a rootfs")
+	})
+
+	t.Run("SetupFailed", func(t *testing.T) {
+		runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) {
+			return CommandResult{ExitCode: 1, Stderr: "bwrap: No permissions to create new namespace\n"}, nil
+		}}
+		executor := newBwrapTestExecutor(t, &config.Config{}, false, runner)
+
+		_, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to set up sandbox: bwrap: No permissions to create new namespace")
+	})
+
+	t.Run("ProgramWritesLikeBwrap", func(t *testing.T) {
+		// Once the child started, its exit code and stderr belong to the program, whatever they look like
+		runner := &FuncCommandRunner{run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			bwrapStarted(t, cmd)
+			return CommandResult{ExitCode: 1, Stderr: "bwrap: not really\n"}, nil
+		}}
+		executor := newBwrapTestExecutor(t, &config.Config{}, false, runner)
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusNonzeroExit, result.Status)
+		assert.Equal(t, "bwrap: not really\n", result.Stderr)
+	})
+
+	t.Run("MissingBinary", func(t *testing.T) {
+		cfg := &config.Config{Sandbox: config.SandboxConfig{Bwrap: config.BwrapConfig{Binary: filepath.Join(t.TempDir(), "bwrap")}}}
+		_, err := NewBwrapExecutor(zaptest.NewLogger(t), &Config{}, cfg)
+		require.Error(t, err)
+	})
+}
+
+func TestRealCommandRunnerExtraFiles(t *testing.T) {
+	status, err := os.CreateTemp(t.TempDir(), "status")
+	require.NoError(t, err)
+	defer status.Close()
+
+	_, err = RealCommandRunner{}.RunCommand(context.Background(), Command{
+		Args:       []string{"sh", "-c", `echo '{"child-pid": 7}' >&3`},
+		ExtraFiles: []*os.File{status},
+	})
+	require.NoError(t, err)
+	assert.True(t, bwrapChildStarted(status))
+
+	empty, err := os.CreateTemp(t.TempDir(), "status")
+	require.NoError(t, err)
+	defer empty.Close()
+	assert.False(t, bwrapChildStarted(empty))
+}
+
+func TestBwrapSystemArgs(t *testing.T) {
+	dir := t.TempDir()
+	args := bwrapSystemArgs([]string{dir, filepath.Join(dir, "missing")})
+	assert.Equal(t, []string{"--ro-bind", dir, dir}, args)
+
+	// Merged /usr systems link /bin to usr/bin
+	if target, err := os.Readlink("/bin"); err == nil {
+		args = bwrapSystemArgs([]string{"/bin"})
+		assert.Equal(t, []string{"--symlink", target, "/bin"}, args)
+		assert.True(t, slices.Contains(bwrapSystemPaths, "/usr"))
+	}
+}
@@ -0,0 +1,186 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The namespace backend limits every sandbox
+// with its own cgroup v2 leaf below a parent cgroup that has the memory, cpu
+// and pids controllers delegated to the server, and reads the resource usage
+// of the sandbox from it.
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Limits of every namespace sandbox besides its memory
+const (
+	cgroupPidsLimit = 256             // processes and threads
+	cgroupCPUMax    = "100000 100000" // one CPU: 100ms of CPU time per 100ms period
+)
+
+const (
+	cgroupRoot          = "/sys/fs/cgroup" // usual mount point of the cgroup v2 hierarchy
+	cgroupServerDir     = "codebox-server" // leaf the server moves into when it owns the parent cgroup
+	cgroupControllers   = "cpu memory pids"
+	cgroupRemoveTimeout = 2 * time.Second // how long removal waits for killed processes to exit
+)
+
+// cgroupParent is the cgroup v2 directory that the sandbox cgroups are created in
+type cgroupParent struct {
+	path string
+}
+
+// newCgroupParent prepares path, or the cgroup of the server when path is empty, to hold sandbox cgroups.
+// The memory, cpu and pids controllers have to be available in it. A cgroup with processes cannot enable
+// controllers for its children, so the server moves itself into a leaf of its own cgroup first.
+func newCgroupParent(path string) (*cgroupParent, error) {
+	own, err := ownCgroup()
+	if err != nil && path == "" {
+		return nil, err
+	}
+	if path == "" {
+		path = own
+	}
+
+	controllers, err := os.ReadFile(filepath.Join(path, "cgroup.controllers"))
+	if err != nil {
+		return nil, fmt.Errorf("cgroup v2 is not available at %s: %w", path, err)
+	}
+	available := strings.Fields(string(controllers))
+	for _, controller := range strings.Fields(cgroupControllers) {
+		if !slices.Contains(available, controller) {
+			return nil, fmt.Errorf("the %s controller is not delegated to cgroup %s", controller, path)
+		}
+	}
+
+	if path == own {
+		server := filepath.Join(path, cgroupServerDir)
+		if err := os.Mkdir(server, DirPermission); err != nil && !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to create the server cgroup: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(server, "cgroup.procs"), []byte(strconv.Itoa(os.Getpid())), FilePermission); err != nil {
+			return nil, fmt.Errorf("failed to move the server into its own cgroup: %w", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(path, "cgroup.subtree_control"), []byte("+cpu +memory +pids"), FilePermission); err != nil {
+		return nil, fmt.Errorf("failed to enable the cgroup controllers of %s: %w", path, err)
+	}
+	return &cgroupParent{path: path}, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 that the server runs in
+func ownCgroup() (string, error) {
+	membership, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the cgroup of the server: %w", err)
+	}
+	var relative string
+	for line := range strings.SplitSeq(string(membership), "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			relative = path
+		}
+	}
+	if relative == "" {
+		return "", errors.New("the server does not run in a cgroup v2 hierarchy")
+	}
+
+	mountInfo, err := os.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return "", fmt.Errorf("failed to read the mount table: %w", err)
+	}
+	return filepath.Join(cgroup2Mount(mountInfo), relative), nil
+}
+
+// cgroup2Mount returns the mount point of the cgroup v2 hierarchy in a mount table,
+// which is /sys/fs/cgroup/unified on hosts that still mount the v1 controllers
+func cgroup2Mount(mountInfo []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(mountInfo))
+	for scanner.Scan() {
+		// Fields: id parent major:minor root mount-point options [optional...] - type source super-options
+		fields := strings.Fields(scanner.Text())
+		separator := slices.Index(fields, "-")
+		if separator > 4 && separator+1 < len(fields) && fields[separator+1] == "cgroup2" {
+			return fields[4]
+		}
+	}
+	return cgroupRoot
+}
+
+// cgroupLeaf is the cgroup of a single sandbox
+type cgroupLeaf struct {
+	path string
+	dir  *os.File // open directory, used to start the sandbox right inside the cgroup
+}
+
+// create makes a cgroup named name with the memory limit of limits
+func (p *cgroupParent) create(name string, limits Limits) (*cgroupLeaf, error) {
+	path := filepath.Join(p.path, name)
+	if err := os.Mkdir(path, DirPermission); err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	leaf := &cgroupLeaf{path: path}
+
+	settings := []struct{ file, value string }{
+		{"memory.max", strconv.Itoa(limits.MemoryMB * BytesPerKB * BytesPerKB)},
+		{"pids.max", strconv.Itoa(cgroupPidsLimit)},
+		{"cpu.max", cgroupCPUMax},
+	}
+	for _, setting := range settings {
+		if err := os.WriteFile(filepath.Join(path, setting.file), []byte(setting.value), FilePermission); err != nil {
+			_ = leaf.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", setting.file, err)
+		}
+	}
+	// Sandboxes get no swap on top of their memory limit, if the kernel accounts swap at all
+	_ = os.WriteFile(filepath.Join(path, "memory.swap.max"), []byte("0"), FilePermission)
+
+	dir, err := os.Open(path)
+	if err != nil {
+		_ = leaf.remove()
+		return nil, fmt.Errorf("failed to open cgroup: %w", err)
+	}
+	leaf.dir = dir
+	return leaf, nil
+}
+
+// usage returns the CPU time, memory peak and OOM kills accounted to the cgroup
+func (l *cgroupLeaf) usage() ResourceUsage {
+	// memory.peak needs Linux 5.19, older kernels only report the CPU time
+	cpuStat, _ := os.ReadFile(filepath.Join(l.path, cpuStatFile))
+	memoryPeak, _ := os.ReadFile(filepath.Join(l.path, memoryPeakFile))
+	usage := parseCgroupUsage(cpuStat, memoryPeak)
+
+	events, _ := os.ReadFile(filepath.Join(l.path, memoryEventsFile))
+	usage.OOMKilled = parseOOMKills(events) > 0
+	return usage
+}
+
+// remove kills whatever still runs in the cgroup and removes it
+func (l *cgroupLeaf) remove() error {
+	if l.dir != nil {
+		_ = l.dir.Close()
+	}
+	// cgroup.kill needs Linux 5.14, before that the sandbox init takes its processes down with it
+	_ = os.WriteFile(filepath.Join(l.path, "cgroup.kill"), []byte("1"), FilePermission)
+
+	deadline := time.Now().Add(cgroupRemoveTimeout)
+	for {
+		err := os.Remove(l.path)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !errors.Is(err, syscall.EBUSY) || time.Now().After(deadline) {
+			return fmt.Errorf("failed to remove cgroup %s: %w", l.path, err)
+		}
+		time.Sleep(10 * time.Millisecond) //nolint:mnd // Killed processes exit within milliseconds
+	}
+}
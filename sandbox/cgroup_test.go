@@ -0,0 +1,95 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeCgroupParent returns a directory that looks like a cgroup with the given controllers
+func newFakeCgroupParent(t *testing.T, controllers string) string {
+	t.Helper()
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "cgroup.controllers"), []byte(controllers+"\n"), FilePermission))
+	return dir
+}
+
+func TestCgroupParent(t *testing.T) {
+	t.Run("EnablesControllers", func(t *testing.T) {
+		dir := newFakeCgroupParent(t, "cpuset cpu io memory pids")
+		parent, err := newCgroupParent(dir)
+		require.NoError(t, err)
+		assert.Equal(t, dir, parent.path)
+
+		subtree, err := os.ReadFile(filepath.Join(dir, "cgroup.subtree_control"))
+		require.NoError(t, err)
+		assert.Equal(t, "+cpu +memory +pids", string(subtree))
+	})
+
+	t.Run("MissingController", func(t *testing.T) {
+		_, err := newCgroupParent(newFakeCgroupParent(t, "cpu pids"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "the memory controller is not delegated to cgroup")
+	})
+
+	t.Run("NoCgroup", func(t *testing.T) {
+		_, err := newCgroupParent(t.TempDir())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cgroup v2 is not available")
+	})
+}
+
+func TestCgroupLeaf(t *testing.T) {
+	parent := &cgroupParent{path: newFakeCgroupParent(t, cgroupControllers)}
+	leaf, err := parent.create("codebox-exec-1", Limits{MemoryMB: 256})
+	require.NoError(t, err)
+	require.NotNil(t, leaf.dir)
+
+	for file, value := range map[string]string{
+		"memory.max":      "268435456",
+		"memory.swap.max": "0",
+		"pids.max":        "256",
+		"cpu.max":         "100000 100000",
+	} {
+		content, err := os.ReadFile(filepath.Join(leaf.path, file))
+		require.NoError(t, err)
+		assert.Equal(t, value, string(content), file)
+	}
+
+	files := map[string]string{
+		cpuStatFile:     "usage_usec 900\nuser_usec 600\nsystem_usec 300\n",
+		memoryPeakFile:  "4096\n",
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+	}
+	for file, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(leaf.path, file), []byte(content), FilePermission))
+	}
+	usage := leaf.usage()
+	assert.Equal(t, 600*time.Microsecond, usage.UserTime)
+	assert.Equal(t, 300*time.Microsecond, usage.SystemTime)
+	assert.Equal(t, int64(4096), usage.PeakMemoryBytes)
+	assert.True(t, usage.OOMKilled)
+
+	// A real cgroup can be removed with its interface files, a plain directory cannot
+	err = leaf.remove()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to remove cgroup")
+	killed, err := os.ReadFile(filepath.Join(leaf.path, "cgroup.kill"))
+	require.NoError(t, err)
+	assert.Equal(t, "1", string(killed), "the processes of the cgroup are killed first")
+}
+
+func TestCgroup2Mount(t *testing.T) {
+	hybrid := []byte(
+		"25 30 0:22 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw\n" +
+			"26 25 0:23 / /sys/fs/cgroup ro,nosuid,nodev,noexec shared:8 - tmpfs tmpfs ro,mode=755\n" +
+			"27 26 0:24 / /sys/fs/cgroup/unified rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw\n" +
+			"28 26 0:25 / /sys/fs/cgroup/memory rw,nosuid,nodev,noexec,relatime shared:10 - cgroup cgroup rw,memory\n")
+	assert.Equal(t, "/sys/fs/cgroup/unified", cgroup2Mount(hybrid))
+
+	assert.Equal(t, cgroupRoot, cgroup2Mount([]byte("22 1 0:21 / /proc rw,nosuid - proc proc rw\n")))
+}
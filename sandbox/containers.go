@@ -0,0 +1,170 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Container backends keep a registry of the
+// containers they are running, so that a container is killed and removed
+// however its execution ends: normally, on timeout, when the caller cancels
+// or when the server shuts down. Containers are labelled with the instance of
+// the server, so that the ones left behind by a crash are removed at startup.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// containerCleanupTimeout bounds killing and removing a container, which happens even after the execution was cancelled
+const containerCleanupTimeout = 30 * time.Second
+
+// containerInstanceLabel labels the containers and volumes of a server with its sandbox.instance
+const containerInstanceLabel = "codebox.instance"
+
+// instanceLabel returns the label of the containers and volumes of an instance
+func instanceLabel(instance string) string {
+	return containerInstanceLabel + "=" + instance
+}
+
+// ShutdownExecutor is implemented by executors that have to clean up running executions when the server stops
+type ShutdownExecutor interface {
+	// Shutdown kills every running execution and waits until its resources are removed
+	Shutdown(ctx context.Context) error
+}
+
+// containerRemover force-removes a container, killing it first when kill is set
+type containerRemover func(ctx context.Context, name string, kill bool) error
+
+// containerRegistry tracks the running containers of an executor by name
+type containerRegistry struct {
+	logger  *zap.Logger
+	remove  containerRemover
+	mu      sync.Mutex
+	running map[string]struct{}
+}
+
+// newContainerRegistry creates an empty registry for containers that are removed with remove
+func newContainerRegistry(logger *zap.Logger, remove containerRemover) *containerRegistry {
+	return &containerRegistry{
+		logger:  logger,
+		remove:  remove,
+		running: make(map[string]struct{}),
+	}
+}
+
+// track registers a container that is about to be started
+func (r *containerRegistry) track(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running[name] = struct{}{}
+}
+
+// untrack removes a container from the registry, reporting whether it was still registered
+func (r *containerRegistry) untrack(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.running[name]; !ok {
+		return false
+	}
+	delete(r.running, name)
+	return true
+}
+
+// names returns the names of the registered containers
+func (r *containerRegistry) names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.running))
+	for name := range r.running {
+		names = append(names, name)
+	}
+	return names
+}
+
+// release removes a finished or abandoned container. When kill is set, the container is killed first
+// because its execution was cancelled or timed out. Cleanup outlives the cancellation of ctx.
+// Containers that were already released, e.g. by shutdown, are skipped.
+func (r *containerRegistry) release(ctx context.Context, name string, kill bool) {
+	if !r.untrack(name) {
+		return
+	}
+
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerCleanupTimeout)
+	defer cancel()
+
+	if err := r.remove(cleanupCtx, name, kill); err != nil {
+		r.logger.Warn("failed to remove container", zap.String("container", name), zap.Error(err))
+	}
+}
+
+// shutdown kills and removes every registered container
+func (r *containerRegistry) shutdown(ctx context.Context) error {
+	var errs []error
+	for _, name := range r.names() {
+		if !r.untrack(name) {
+			continue
+		}
+		r.logger.Info("killing container on shutdown", zap.String("container", name))
+		if err := r.remove(ctx, name, true); err != nil {
+			errs = append(errs, fmt.Errorf("container %s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// cliContainerRemover removes containers with cli, a container CLI like docker or podman and its global arguments.
+// Force-removing a container waits until it is gone, and its anonymous volumes are removed with it.
+func cliContainerRemover(logger *zap.Logger, runner CommandRunner, cli []string) containerRemover {
+	return func(ctx context.Context, name string, kill bool) error {
+		if kill {
+			// The container may already have exited, in which case removing it is all that is left to do
+			if _, err := runner.RunCommand(ctx, Command{Args: append(slices.Clone(cli), "kill", name)}); err != nil {
+				logger.Debug("failed to kill container", zap.String("container", name), zap.Error(err))
+			}
+		}
+
+		if _, err := runner.RunCommand(ctx, Command{Args: append(slices.Clone(cli), "rm", "-f", "-v", name)}); err != nil {
+			return fmt.Errorf("failed to remove container: %w", err)
+		}
+		return nil
+	}
+}
+
+// removeStaleContainers force-removes the containers and volumes of an instance that a previous run of the
+// server left behind, e.g. when it crashed. It runs before the executor starts containers of its own.
+func removeStaleContainers(ctx context.Context, logger *zap.Logger, runner CommandRunner, cli []string, instance string) {
+	filter := "label=" + instanceLabel(instance)
+	stale := []struct {
+		kind     string
+		list, rm []string
+	}{
+		{kind: "container", list: []string{"ps", "-a", "-q", "--filter", filter}, rm: []string{"rm", "-f", "-v"}},
+		{kind: "volume", list: []string{"volume", "ls", "-q", "--filter", filter}, rm: []string{"volume", "rm", "-f"}},
+	}
+
+	// Containers go first, since they keep their volumes in use
+	for _, resource := range stale {
+		output, err := runner.RunCommand(ctx, Command{Args: append(slices.Clone(cli), resource.list...)})
+		if err != nil || output.ExitCode != 0 {
+			logger.Warn("failed to list stale "+resource.kind+"s",
+				zap.String("instance", instance), zap.String("stderr", output.Stderr), zap.Error(err))
+			continue
+		}
+
+		ids := strings.Fields(output.Stdout)
+		if len(ids) == 0 {
+			continue
+		}
+		logger.Info("removing stale "+resource.kind+"s", zap.String("instance", instance), zap.Strings("ids", ids))
+		args := append(append(slices.Clone(cli), resource.rm...), ids...)
+		if output, err := runner.RunCommand(ctx, Command{Args: args}); err != nil || output.ExitCode != 0 {
+			logger.Warn("failed to remove stale "+resource.kind+"s",
+				zap.String("instance", instance), zap.String("stderr", output.Stderr), zap.Error(err))
+		}
+	}
+}
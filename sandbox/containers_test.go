@@ -0,0 +1,240 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// fakeContainerEngine emulates the container lifecycle of a container CLI. A container started with
+// run keeps running until it is killed, and the program of a phase run with exec until it is killed
+// or finishes, which happens when finish is closed. Stopping the CLI client does not stop the
+// container, just like with the real CLIs.
+type fakeContainerEngine struct {
+	mu      sync.Mutex
+	live    map[string]chan struct{} // closed when the container stops
+	killed  []string
+	started chan string
+	finish  chan struct{}
+}
+
+func newFakeContainerEngine() *fakeContainerEngine {
+	return &fakeContainerEngine{
+		live:    make(map[string]chan struct{}),
+		started: make(chan string, 10),
+		finish:  make(chan struct{}),
+	}
+}
+
+func (e *fakeContainerEngine) RunCommand(ctx context.Context, cmd Command) (CommandResult, error) {
+	switch cmd.Args[1] {
+	case "run":
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.live[cmd.Args[3]] = make(chan struct{})
+		return CommandResult{}, nil
+	case "exec":
+		if !isPhaseCommand(cmd.Args) {
+			return CommandResult{}, nil
+		}
+		name := cmd.Args[len(cmd.Args)-len(phaseCommand(""))-1]
+		e.mu.Lock()
+		stopped := e.live[name]
+		e.mu.Unlock()
+		e.started <- name
+
+		select {
+		case <-e.finish:
+			return CommandResult{Stdout: "done\n"}, nil
+		case <-stopped:
+			return CommandResult{ExitCode: 137, Signal: "SIGKILL"}, nil
+		case <-ctx.Done():
+			// Only the client is gone, the program keeps running
+			return CommandResult{ExitCode: -1}, nil
+		}
+	case "kill":
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.killed = append(e.killed, cmd.Args[2])
+		if stopped, ok := e.live[cmd.Args[2]]; ok {
+			close(stopped)
+			e.live[cmd.Args[2]] = nil
+		}
+		return CommandResult{}, nil
+	case "rm":
+		name := cmd.Args[len(cmd.Args)-1]
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if stopped := e.live[name]; stopped != nil {
+			close(stopped)
+		}
+		delete(e.live, name)
+		return CommandResult{}, nil
+	default:
+		return CommandResult{}, nil
+	}
+}
+
+// leftovers returns the containers that were never removed
+func (e *fakeContainerEngine) leftovers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for name := range e.live {
+		names = append(names, name)
+	}
+	return names
+}
+
+// killedContainers returns the containers that received a kill
+func (e *fakeContainerEngine) killedContainers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]string(nil), e.killed...)
+}
+
+// containerExecutor is the part of the container executors exercised by the cancellation tests
+type containerExecutor interface {
+	SandboxExecutor
+	ShutdownExecutor
+}
+
+func TestContainerCleanup(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}}}
+	req := ExecuteRequest{Language: LanguagePython, Code: "while True: pass"}
+
+	backends := map[string]func(engine *fakeContainerEngine, executorConfig *Config) containerExecutor{
+		"docker": func(engine *fakeContainerEngine, executorConfig *Config) containerExecutor {
+			return NewDockerExecutor(logger, executorConfig, cfg, WithDockerCommandRunner(engine))
+		},
+		"podman": func(engine *fakeContainerEngine, executorConfig *Config) containerExecutor {
+			return NewPodmanExecutor(logger, executorConfig, cfg, WithPodmanCommandRunner(engine))
+		},
+	}
+
+	for backend, newExecutor := range backends {
+		t.Run(backend, func(t *testing.T) {
+			t.Run("Finished", func(t *testing.T) {
+				engine := newFakeContainerEngine()
+				close(engine.finish)
+				executor := newExecutor(engine, &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+				result, err := executor.Execute(context.Background(), req)
+				require.NoError(t, err)
+				assert.Equal(t, "done\n", result.Stdout)
+				assert.Empty(t, engine.killedContainers(), "finished containers are only removed")
+				assert.Empty(t, engine.leftovers())
+			})
+
+			t.Run("Cancelled", func(t *testing.T) {
+				engine := newFakeContainerEngine()
+				executor := newExecutor(engine, &Config{TimeoutSec: 60, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+				ctx, cancel := context.WithCancel(context.Background())
+				go func() {
+					<-engine.started
+					cancel()
+				}()
+
+				_, err := executor.Execute(ctx, req)
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, context.Canceled))
+				assert.Len(t, engine.killedContainers(), 1)
+				assert.Empty(t, engine.leftovers())
+			})
+
+			t.Run("TimedOut", func(t *testing.T) {
+				engine := newFakeContainerEngine()
+				executor := newExecutor(engine, &Config{TimeoutSec: 1, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+				result, err := executor.Execute(context.Background(), req)
+				require.NoError(t, err)
+				assert.Equal(t, StatusTimeout, result.Status)
+				assert.Len(t, engine.killedContainers(), 1)
+				assert.Empty(t, engine.leftovers())
+			})
+
+			t.Run("Shutdown", func(t *testing.T) {
+				engine := newFakeContainerEngine()
+				executor := newExecutor(engine, &Config{TimeoutSec: 60, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					_, _ = executor.Execute(context.Background(), req)
+				}()
+
+				name := <-engine.started
+				require.NoError(t, executor.Shutdown(context.Background()))
+				assert.Equal(t, []string{name}, engine.killedContainers())
+				assert.Empty(t, engine.leftovers())
+
+				select {
+				case <-done:
+				case <-time.After(5 * time.Second):
+					t.Fatal("the execution did not end after shutdown")
+				}
+				assert.Len(t, engine.killedContainers(), 1, "containers released on shutdown are not killed again")
+			})
+		})
+	}
+}
+
+func TestRemoveStaleContainers(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	cfg := &config.Config{
+		Sandbox:   config.SandboxConfig{Instance: "worker-1"},
+		Languages: map[string]config.Language{LanguagePython: {}},
+	}
+
+	t.Run("Labelled", func(t *testing.T) {
+		runner := &FuncCommandRunner{}
+		executor := NewOCIExecutor(logger, &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}, cfg, dockerRuntime,
+			WithOCICommandRunner(runner))
+
+		_, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "1"})
+		require.NoError(t, err)
+		run := runner.RunCalls()[0]
+		labelAt := slices.Index(run, "--label")
+		require.Positive(t, labelAt)
+		assert.Equal(t, "codebox.instance=worker-1", run[labelAt+1])
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		runner := &FuncCommandRunner{run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			switch cmd.Args[1] {
+			case "ps":
+				return CommandResult{Stdout: "abc123\ndef456\n"}, nil
+			case "volume":
+				if cmd.Args[2] == "ls" {
+					return CommandResult{Stdout: "codebox-workdir-1\n"}, nil
+				}
+			}
+			return CommandResult{}, nil
+		}}
+
+		removeStaleContainers(context.Background(), logger, runner, []string{"docker"}, cfg.GetInstance())
+		assert.Equal(t, [][]string{
+			{"docker", "ps", "-a", "-q", "--filter", "label=codebox.instance=worker-1"},
+			{"docker", "rm", "-f", "-v", "abc123", "def456"},
+			{"docker", "volume", "ls", "-q", "--filter", "label=codebox.instance=worker-1"},
+			{"docker", "volume", "rm", "-f", "codebox-workdir-1"},
+		}, runner.Calls())
+	})
+
+	t.Run("NothingLeft", func(t *testing.T) {
+		runner := &FuncCommandRunner{}
+		removeStaleContainers(context.Background(), logger, runner, []string{"podman"}, cfg.GetInstance())
+		assert.Len(t, runner.Calls(), 2, "only the listings run")
+	})
+}
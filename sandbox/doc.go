@@ -2,7 +2,8 @@
 //
 // The sandbox package implements the execution engine for running untrusted
 // code in isolated environments. It supports multiple backends including
-// Docker, Podman, and local execution (for development).
+// Docker, Podman, Linux namespaces, bubblewrap, in-process WebAssembly, and local
+// execution (for development).
 //
 // The package defines the SandboxExecutor interface and provides concrete
 // implementations for different execution backends. Each executor handles
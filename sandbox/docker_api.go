@@ -0,0 +1,209 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The Docker Engine API backend runs containers
+// through the REST API of the Docker daemon instead of the docker CLI.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// DefaultDockerHost is the Docker Engine socket used when neither sandbox.engine_host nor DOCKER_HOST is set
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// NewDockerAPIExecutor creates an executor for the Docker Engine listening on host,
+// e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375
+func NewDockerAPIExecutor(
+	logger *zap.Logger,
+	executorConfig *Config,
+	cfg *config.Config,
+	host string,
+	opts ...EngineAPIExecutorOption,
+) (*EngineAPIExecutor, error) {
+	client, err := newEngineClient(host)
+	if err != nil {
+		return nil, err
+	}
+	return newEngineAPIExecutor(logger, executorConfig, cfg, client, &dockerEngine{logger: logger, client: client}, opts...), nil
+}
+
+// dockerContainerConfig is the body of a container create request
+type dockerContainerConfig struct {
+	Image      string
+	Cmd        []string
+	Env        []string
+	WorkingDir string
+	User       string
+	Labels     map[string]string
+	HostConfig dockerHostConfig
+}
+
+// dockerHostConfig holds the resource limits and security restrictions of a container
+type dockerHostConfig struct {
+	Memory         int64
+	MemorySwap     int64 // memory and swap together, -1 for unlimited swap
+	NanoCpus       int64 // CPU quota in billionths of a core, 0 for no quota
+	PidsLimit      int64 // -1 for unlimited
+	ShmSize        int64 // size of /dev/shm in bytes, 0 for the engine default
+	NetworkMode    string
+	SecurityOpt    []string
+	CapDrop        []string
+	Ulimits        []dockerUlimit
+	ReadonlyRootfs bool
+	Tmpfs          map[string]string // mount options by mount point
+	Mounts         []dockerMount
+	Runtime        string // OCI runtime, empty for the engine default
+}
+
+// dockerMount is a volume mounted into a container. Volumes without a name are anonymous.
+type dockerMount struct {
+	Type          string
+	Target        string
+	VolumeOptions dockerVolumeOptions
+}
+
+// dockerVolumeOptions selects the driver that creates an anonymous volume and its options
+type dockerVolumeOptions struct {
+	DriverConfig struct {
+		Name    string
+		Options map[string]string
+	}
+}
+
+// dockerUlimit is a resource limit of the processes in a container
+type dockerUlimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// dockerEngine implements the endpoints of the Docker Engine API that differ from other engines
+type dockerEngine struct {
+	logger *zap.Logger
+	client *engineClient
+}
+
+func (d *dockerEngine) containerPath(name, endpoint string) string {
+	if endpoint == "" {
+		return "/containers/" + name
+	}
+	return "/containers/" + name + "/" + endpoint
+}
+
+func (d *dockerEngine) execPath(id, endpoint string) string {
+	return "/exec/" + id + "/" + endpoint
+}
+
+func (d *dockerEngine) listPath() string {
+	return "/containers/json"
+}
+
+// containerConfig translates a container into a create request
+func (d *dockerEngine) containerConfig(spec *containerSpec) dockerContainerConfig {
+	networkMode := "none" // Disable network by default
+	if spec.Network {
+		networkMode = "bridge"
+	}
+
+	env := make([]string, 0, len(spec.Env))
+	for key, value := range spec.Env {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+	slices.Sort(env)
+
+	ulimits := make([]dockerUlimit, 0, len(spec.Resources.Ulimits))
+	for _, ulimit := range spec.Resources.Ulimits {
+		ulimits = append(ulimits, dockerUlimit{Name: ulimit.Name, Soft: ulimit.Value, Hard: ulimit.Value})
+	}
+
+	// New volumes of the local driver belong to root, so the volumes are tmpfs mounts that everybody can write to
+	mounts := make([]dockerMount, 0, len(engineVolumes))
+	for _, target := range engineVolumes {
+		mount := dockerMount{Type: "volume", Target: target}
+		mount.VolumeOptions.DriverConfig.Name = "local"
+		mount.VolumeOptions.DriverConfig.Options = map[string]string{"type": "tmpfs", "device": "tmpfs", "o": "mode=1777"}
+		mounts = append(mounts, mount)
+	}
+
+	return dockerContainerConfig{
+		Image:      spec.Image,
+		Cmd:        spec.Cmd,
+		Env:        env,
+		WorkingDir: WorkDirPath,
+		User:       spec.User,
+		Labels:     spec.Labels,
+		HostConfig: dockerHostConfig{
+			Memory:         spec.MemoryBytes,
+			MemorySwap:     spec.Resources.memorySwapBytes(spec.MemoryBytes),
+			NanoCpus:       int64(spec.Resources.CPUs * nanoCPUsPerCore),
+			PidsLimit:      spec.Resources.PidsLimit,
+			ShmSize:        int64(spec.Resources.ShmSizeMB) * BytesPerKB * BytesPerKB,
+			NetworkMode:    networkMode,
+			SecurityOpt:    append([]string{"no-new-privileges:true"}, spec.Security.securityOpts(true)...),
+			CapDrop:        []string{"ALL"}, // Drop all capabilities
+			Ulimits:        ulimits,
+			ReadonlyRootfs: spec.Filesystem.ReadOnly,
+			Tmpfs:          spec.Filesystem.tmpfsMounts(),
+			Mounts:         mounts,
+			Runtime:        spec.Runtime,
+		},
+	}
+}
+
+// checkRuntime looks the runtime up in the runtimes registered with the daemon
+func (d *dockerEngine) checkRuntime(ctx context.Context, name string) error {
+	var info struct {
+		Runtimes map[string]json.RawMessage
+	}
+	if err := d.client.call(ctx, http.MethodGet, "/info", nil, nil, &info); err != nil {
+		return err
+	}
+	if _, ok := info.Runtimes[name]; !ok {
+		registered := slices.Sorted(maps.Keys(info.Runtimes))
+		return fmt.Errorf("not registered with the docker engine, registered runtimes: %s", strings.Join(registered, ", "))
+	}
+	return nil
+}
+
+func (d *dockerEngine) createContainer(ctx context.Context, name string, spec *containerSpec) error {
+	body := d.containerConfig(spec)
+	query := url.Values{"name": {name}}
+	err := d.client.call(ctx, http.MethodPost, "/containers/create", query, body, nil)
+	if isEngineNotFound(err) {
+		if err := d.pullImage(ctx, spec.Image); err != nil {
+			return err
+		}
+		err = d.client.call(ctx, http.MethodPost, "/containers/create", query, body, nil)
+	}
+	return err
+}
+
+// pullImage pulls an image, following the progress stream until the pull finished or failed
+func (d *dockerEngine) pullImage(ctx context.Context, image string) error {
+	d.logger.Info("pulling image", zap.String("image", image))
+
+	// Without a tag the engine would pull every tag of the repository
+	query := url.Values{"fromImage": {image}}
+	if !strings.Contains(image, "@") && !strings.Contains(image[strings.LastIndex(image, "/")+1:], ":") {
+		query.Set("tag", "latest")
+	}
+
+	resp, err := d.client.send(ctx, http.MethodPost, "/images/create", query, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+	return readPullProgress(resp.Body, image)
+}
@@ -0,0 +1,795 @@
+package sandbox
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// fakeFile is a file in the filesystem of a fake container
+type fakeFile struct {
+	content string
+	mode    int64
+	dir     bool
+}
+
+// fakeContainer is a container of the fake engine. Its program runs in an exec of the container user.
+type fakeContainer struct {
+	name       string
+	cmd        []string
+	config     dockerContainerConfig // create request of the Docker Engine API
+	spec       libpodSpec            // create request of the libpod API
+	files      map[string]fakeFile   // by path relative to the container root
+	execCmd    []string              // command of the last exec of the container user
+	stdin      []byte
+	cpuStat    string // cgroup accounting that a root exec of containerUsageCommand prints
+	memoryPeak string
+	oomKills   int
+	started    chan struct{}
+	killed     chan struct{}
+	killOnce   sync.Once
+}
+
+// command returns the shell command the exec of the container user runs
+func (c *fakeContainer) command() string {
+	return c.execCmd[len(c.execCmd)-1]
+}
+
+// fakeExec is an exec instance of the fake engine
+type fakeExec struct {
+	container *fakeContainer
+	config    engineExecConfig
+	exitCode  int
+}
+
+// fakeProgram emulates the program of a container, returning its output and exit code.
+// A program that should run until it is killed waits for c.killed.
+type fakeProgram func(c *fakeContainer) (stdout, stderr string, exitCode int)
+
+// fakeEngine serves the engine API endpoints used by the EngineAPIExecutor on a unix socket
+type fakeEngine struct {
+	host    string
+	program fakeProgram
+
+	mu         sync.Mutex
+	images     map[string]bool
+	pulls      []string
+	containers map[string]*fakeContainer
+	execs      map[string]*fakeExec
+	created    []*fakeContainer
+	killed     []string
+	removed    []string
+}
+
+// newFakeEngine serves the container endpoints that the Docker Engine API and the libpod API share below prefix.
+// The returned mux takes the engine specific endpoints.
+func newFakeEngine(t *testing.T, program fakeProgram, prefix string) (*fakeEngine, *http.ServeMux) {
+	t.Helper()
+
+	// Unix socket paths are limited to about 100 bytes, which test temp dirs can exceed
+	dir, err := os.MkdirTemp("", "codebox-engine-")
+	require.NoError(t, err)
+	socket := filepath.Join(dir, "engine.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	e := &fakeEngine{
+		host:       "unix://" + socket,
+		program:    program,
+		images:     map[string]bool{"python:3.11-slim": true, "golang:1.23-alpine": true},
+		containers: make(map[string]*fakeContainer),
+		execs:      make(map[string]*fakeExec),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT "+prefix+"/containers/{name}/archive", e.putArchive)
+	mux.HandleFunc("GET "+prefix+"/containers/{name}/archive", e.getArchive)
+	mux.HandleFunc("POST "+prefix+"/containers/{name}/start", e.start)
+	mux.HandleFunc("POST "+prefix+"/containers/{name}/exec", e.createExec)
+	mux.HandleFunc("POST "+prefix+"/exec/{id}/start", e.startExec)
+	mux.HandleFunc("GET "+prefix+"/exec/{id}/json", e.inspectExec)
+	mux.HandleFunc("POST "+prefix+"/containers/{name}/kill", e.kill)
+	mux.HandleFunc("DELETE "+prefix+"/containers/{name}", e.remove)
+	mux.HandleFunc("GET "+prefix+"/containers/json", e.list)
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: time.Second}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = os.RemoveAll(dir)
+	})
+	return e, mux
+}
+
+// newFakeDockerEngine serves the Docker Engine API
+func newFakeDockerEngine(t *testing.T, program fakeProgram) *fakeEngine {
+	t.Helper()
+	e, mux := newFakeEngine(t, program, "")
+	mux.HandleFunc("POST /containers/create", e.create)
+	mux.HandleFunc("POST /images/create", e.pull)
+	mux.HandleFunc("GET /info", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `{"Runtimes":{"runc":{"path":"runc"},"runsc":{"path":"/usr/local/bin/runsc"}}}`)
+	})
+	return e
+}
+
+func writeEngineError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+// container looks up the container of a request, answering 404 when it does not exist
+func (e *fakeEngine) container(w http.ResponseWriter, r *http.Request) *fakeContainer {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	c, ok := e.containers[r.PathValue("name")]
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such container: "+r.PathValue("name"))
+	}
+	return c
+}
+
+func (e *fakeEngine) create(w http.ResponseWriter, r *http.Request) {
+	var spec dockerContainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeEngineError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.images[spec.Image] {
+		writeEngineError(w, http.StatusNotFound, "No such image: "+spec.Image)
+		return
+	}
+	c := e.addContainer(r.URL.Query().Get("name"), spec.Cmd)
+	c.config = spec
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"Id":%q}`, c.name)
+}
+
+// addContainer registers a created container, the caller holds e.mu
+func (e *fakeEngine) addContainer(name string, cmd []string) *fakeContainer {
+	c := &fakeContainer{
+		name:    name,
+		cmd:     cmd,
+		files:   make(map[string]fakeFile),
+		started: make(chan struct{}),
+		killed:  make(chan struct{}),
+	}
+	// The workdir is a volume that everybody can write to
+	for _, volume := range engineVolumes {
+		c.files[strings.TrimPrefix(volume, "/")] = fakeFile{mode: 0o1777, dir: true}
+	}
+	e.containers[c.name] = c
+	e.created = append(e.created, c)
+	return c
+}
+
+func (e *fakeEngine) pull(w http.ResponseWriter, r *http.Request) {
+	repository := r.URL.Query().Get("fromImage")
+	image := repository
+	if tag := r.URL.Query().Get("tag"); tag != "" {
+		image += ":" + tag
+	}
+	e.mu.Lock()
+	e.pulls = append(e.pulls, image)
+	e.images[image] = true
+	e.images[repository] = true // an image without a tag refers to latest
+	e.mu.Unlock()
+	_, _ = io.WriteString(w, "{\"status\":\"Pulling from library\"}\n{\"status\":\"Downloaded newer image\"}\n")
+}
+
+func (e *fakeEngine) putArchive(w http.ResponseWriter, r *http.Request) {
+	c := e.container(w, r)
+	if c == nil {
+		return
+	}
+	dir := strings.TrimPrefix(r.URL.Query().Get("path"), "/")
+	tarReader := tar.NewReader(r.Body)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writeEngineError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content, _ := io.ReadAll(tarReader)
+		e.mu.Lock()
+		c.files[path.Join(dir, header.Name)] = fakeFile{
+			content: string(content),
+			mode:    header.Mode,
+			dir:     header.Typeflag == tar.TypeDir,
+		}
+		e.mu.Unlock()
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (e *fakeEngine) getArchive(w http.ResponseWriter, r *http.Request) {
+	c := e.container(w, r)
+	if c == nil {
+		return
+	}
+	dir := strings.TrimPrefix(r.URL.Query().Get("path"), "/")
+
+	e.mu.Lock()
+	var names []string
+	for name := range c.files {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	tarWriter := tar.NewWriter(&buf)
+	for _, name := range names {
+		file := c.files[name]
+		header := &tar.Header{Name: name, Mode: file.mode, Size: int64(len(file.content)), Typeflag: tar.TypeReg}
+		if file.dir {
+			header.Name += "/"
+			header.Typeflag = tar.TypeDir
+			header.Size = 0
+		}
+		_ = tarWriter.WriteHeader(header)
+		_, _ = io.WriteString(tarWriter, file.content)
+	}
+	_ = tarWriter.Close()
+	e.mu.Unlock()
+
+	if len(names) == 0 {
+		writeEngineError(w, http.StatusNotFound, "Could not find the file "+dir)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-tar")
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (e *fakeEngine) createExec(w http.ResponseWriter, r *http.Request) {
+	c := e.container(w, r)
+	if c == nil {
+		return
+	}
+	var config engineExecConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeEngineError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	id := fmt.Sprintf("exec-%d", len(e.execs))
+	e.execs[id] = &fakeExec{container: c, config: config}
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"Id":%q}`, id)
+}
+
+// startExec streams the output of an exec: the cgroup accounting for a root exec of the usage command,
+// and otherwise the output of the program
+func (e *fakeEngine) startExec(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	x, ok := e.execs[r.PathValue("id")]
+	e.mu.Unlock()
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such exec instance: "+r.PathValue("id"))
+		return
+	}
+	c := x.container
+	var start engineExecStart
+	if err := json.NewDecoder(r.Body).Decode(&start); err != nil || start.Detach {
+		writeEngineError(w, http.StatusBadRequest, "exec start must attach")
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	_, _ = rw.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\n" +
+		"Connection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	_ = rw.Flush()
+
+	var stdout, stderr string
+	exitCode := 0
+	if x.config.User == containerRootUser && slices.Equal(x.config.Cmd, containerUsageCommand) {
+		e.mu.Lock()
+		stdout = fmt.Sprintf("== %s\n%s== %s\n%s== %s\noom_kill %d\n== df\n",
+			cpuStatFile, c.cpuStat, memoryPeakFile, c.memoryPeak, memoryEventsFile, c.oomKills)
+		e.mu.Unlock()
+	} else {
+		e.mu.Lock()
+		c.execCmd = x.config.Cmd
+		e.mu.Unlock()
+		if x.config.AttachStdin {
+			c.stdin, _ = io.ReadAll(rw) // until the client closes its side
+		}
+		stdout, stderr, exitCode = e.program(c)
+	}
+	for streamType, data := range map[byte]string{streamTypeStdout: stdout, streamTypeStderr: stderr} {
+		if data != "" {
+			_ = writeFrame(rw, streamType, []byte(data))
+		}
+	}
+	_ = rw.Flush()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	select {
+	case <-c.killed:
+		x.exitCode = 137
+	default:
+		x.exitCode = exitCode
+	}
+}
+
+func (e *fakeEngine) inspectExec(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	x, ok := e.execs[r.PathValue("id")]
+	if !ok {
+		writeEngineError(w, http.StatusNotFound, "No such exec instance: "+r.PathValue("id"))
+		return
+	}
+	_, _ = fmt.Fprintf(w, `{"Running":false,"ExitCode":%d}`, x.exitCode)
+}
+
+// writeFrame writes data as a single frame of a multiplexed exec stream
+func writeFrame(w io.Writer, streamType byte, data []byte) error {
+	header := make([]byte, streamHeaderSize)
+	header[0] = streamType
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data))) //nolint:gosec // Test frames are small
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func (e *fakeEngine) start(w http.ResponseWriter, r *http.Request) {
+	if c := e.container(w, r); c != nil {
+		close(c.started)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (e *fakeEngine) kill(w http.ResponseWriter, r *http.Request) {
+	c := e.container(w, r)
+	if c == nil {
+		return
+	}
+	e.mu.Lock()
+	e.killed = append(e.killed, c.name)
+	e.mu.Unlock()
+	c.killOnce.Do(func() { close(c.killed) })
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) remove(w http.ResponseWriter, r *http.Request) {
+	c := e.container(w, r)
+	if c == nil {
+		return
+	}
+	if r.URL.Query().Get("force") != "1" {
+		writeEngineError(w, http.StatusConflict, "container is running")
+		return
+	}
+	c.killOnce.Do(func() { close(c.killed) })
+	e.mu.Lock()
+	delete(e.containers, c.name)
+	e.removed = append(e.removed, c.name)
+	e.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// list answers the containers that carry every label of the label filter. Without all, the engine would
+// leave out the stopped containers, so the fake answers no container at all.
+func (e *fakeEngine) list(w http.ResponseWriter, r *http.Request) {
+	var filters map[string][]string
+	if err := json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters); err != nil {
+		writeEngineError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	containers := []map[string]string{}
+	for name, c := range e.containers {
+		labels := c.config.Labels
+		if labels == nil {
+			labels = c.spec.Labels
+		}
+		matches := r.URL.Query().Get("all") == "true"
+		for _, label := range filters["label"] {
+			key, value, _ := strings.Cut(label, "=")
+			matches = matches && labels[key] == value
+		}
+		if matches {
+			containers = append(containers, map[string]string{"Id": name})
+		}
+	}
+	_ = json.NewEncoder(w).Encode(containers)
+}
+
+// state returns copies of the recorded pulls, killed and removed containers and the containers left behind
+func (e *fakeEngine) state() (pulls, killed, removed, leftovers []string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name := range e.containers {
+		leftovers = append(leftovers, name)
+	}
+	return slices.Clone(e.pulls), slices.Clone(e.killed), slices.Clone(e.removed), leftovers
+}
+
+// createdContainers returns the containers created so far
+func (e *fakeEngine) createdContainers() []*fakeContainer {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Clone(e.created)
+}
+
+// recordUsage emulates the cgroup accounting of a program, the caller holds no lock
+func recordUsage(c *fakeContainer) {
+	c.cpuStat = "usage_usec 2000\nuser_usec 1500\nsystem_usec 500\n"
+	c.memoryPeak = "1048576\n"
+}
+
+// runUntilKilled is a program that never finishes on its own
+func runUntilKilled(c *fakeContainer) (string, string, int) {
+	<-c.killed
+	return "partial\n", "", 137
+}
+
+func newDockerAPIExecutor(t *testing.T, engine *fakeEngine, executorConfig *Config) *EngineAPIExecutor {
+	t.Helper()
+	cfg := &config.Config{Languages: map[string]config.Language{
+		LanguagePython: {Environment: map[string]string{"PYTHONUNBUFFERED": "1", "LANG": "C.UTF-8"}},
+		LanguageGo:     {},
+	}}
+	executor, err := NewDockerAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host)
+	require.NoError(t, err)
+	return executor
+}
+
+func TestDockerAPIExecutor(t *testing.T) {
+	defaultConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+
+	t.Run("Execute", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(c *fakeContainer) (string, string, int) {
+			c.files["workdir/out.txt"] = fakeFile{content: "result", mode: 0o644}
+			recordUsage(c)
+			return c.files["workdir/main.py"].content + string(c.stdin), "warning\n", 0
+		})
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguagePython,
+			Code:     "print(input())\n",
+			Stdin:    []byte("hello\n"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOK, result.Status)
+		assert.Equal(t, "print(input())\nhello\n", result.Stdout)
+		assert.Equal(t, "warning\n", result.Stderr)
+		assert.Zero(t, result.ExitCode)
+		assert.Equal(t, 1500*time.Microsecond, result.Usage.UserTime)
+		assert.Equal(t, int64(1048576), result.Usage.PeakMemoryBytes)
+		assert.Positive(t, result.Usage.WallTime)
+
+		// The workdir written by the program is returned as the artifacts
+		artifactsDir := t.TempDir()
+		require.NoError(t, ExtractTarToDir(&RealFileSystem{}, result.ArtifactsTar, artifactsDir))
+		out, err := os.ReadFile(filepath.Join(artifactsDir, "out.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "result", string(out))
+
+		// The container gets the restrictions of the CLI backends and the copied-in workdir
+		created := engine.createdContainers()
+		require.Len(t, created, 1)
+		spec := created[0].config
+		assert.Equal(t, "python:3.11-slim", spec.Image)
+		assert.Equal(t, containerIdleCommand, spec.Cmd)
+		assert.Equal(t, phaseCommand("python3 main.py"), created[0].execCmd, "the phase runs with exec")
+		assert.Equal(t, []string{"HOME=/home/codebox", "LANG=C.UTF-8", "PYTHONUNBUFFERED=1"}, spec.Env)
+		assert.Equal(t, WorkDirPath, spec.WorkingDir)
+		assert.Equal(t, "nobody", spec.User)
+		assert.Equal(t, int64(128*1024*1024), spec.HostConfig.Memory)
+		assert.Equal(t, "none", spec.HostConfig.NetworkMode)
+		assert.Equal(t, []string{"ALL"}, spec.HostConfig.CapDrop)
+		assert.Equal(t, []string{"no-new-privileges:true"}, spec.HostConfig.SecurityOpt)
+		assert.Contains(t, spec.HostConfig.Ulimits, dockerUlimit{Name: "fsize", Soft: 100000000, Hard: 100000000})
+		assert.Equal(t, map[string]string{containerInstanceLabel: executor.instance}, spec.Labels)
+		assert.Equal(t, int64(0o666), created[0].files["workdir/main.py"].mode)
+
+		_, killed, removed, leftovers := engine.state()
+		assert.Empty(t, killed, "idle containers are force-removed")
+		assert.Equal(t, []string{created[0].name}, removed)
+		assert.Empty(t, leftovers)
+	})
+
+	t.Run("RemovesStaleContainers", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, nil)
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+		engine.mu.Lock()
+		engine.addContainer("codebox-stale", containerIdleCommand).config.Labels = map[string]string{
+			containerInstanceLabel: executor.instance,
+		}
+		engine.addContainer("codebox-other", containerIdleCommand).config.Labels = map[string]string{
+			containerInstanceLabel: "other-instance",
+		}
+		engine.addContainer("unrelated", nil)
+		engine.mu.Unlock()
+
+		executor.removeStaleContainers(context.Background())
+
+		_, _, removed, leftovers := engine.state()
+		assert.Equal(t, []string{"codebox-stale"}, removed, "only the containers of this instance are removed")
+		assert.ElementsMatch(t, []string{"codebox-other", "unrelated"}, leftovers)
+	})
+
+	t.Run("Runtime", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) { return "ok\n", "", 0 })
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+		executor.cfg.Sandbox.Runtime = "runsc"
+		require.NoError(t, executor.checkRuntimes(context.Background()))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print('ok')"})
+		require.NoError(t, err)
+		assert.Equal(t, "runsc", result.Runtime)
+		assert.Equal(t, "runsc", engine.createdContainers()[0].config.HostConfig.Runtime)
+
+		// The server refuses to start with a runtime that the daemon does not list
+		executor.cfg.Languages[LanguageGo] = config.Language{Runtime: "kata"}
+		err = executor.checkRuntimes(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "OCI runtime kata is not available")
+		assert.Contains(t, err.Error(), "registered runtimes: runc, runsc")
+	})
+
+	t.Run("PullsMissingImage", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) { return "ok\n", "", 0 })
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+		executor.cfg.Languages[LanguagePython] = config.Language{Image: "python"}
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print('ok')"})
+		require.NoError(t, err)
+		assert.Equal(t, "ok\n", result.Stdout)
+		assert.Empty(t, engine.createdContainers()[0].stdin, "stdin stays closed without input")
+
+		pulls, _, _, _ := engine.state()
+		assert.Equal(t, []string{"python:latest"}, pulls)
+	})
+
+	t.Run("BuildOutputStaysExecutable", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(c *fakeContainer) (string, string, int) {
+			if strings.HasPrefix(c.command(), "go build") {
+				c.files["workdir/app"] = fakeFile{content: "binary", mode: 0o755}
+				return "", "", 0
+			}
+			if c.files["workdir/app"].mode&0o111 == 0 {
+				return "", "permission denied\n", 126
+			}
+			return "ran\n", "", 0
+		})
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguageGo, Code: "package main"})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.Equal(t, StatusOK, result.Build.Status)
+		assert.Equal(t, StatusOK, result.Status, result.Stderr)
+		assert.Equal(t, "ran\n", result.Stdout)
+	})
+
+	t.Run("OOMKilled", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(c *fakeContainer) (string, string, int) {
+			c.oomKills = 1
+			return "", "", 137
+		})
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "x = [1] * 10**10"})
+		require.NoError(t, err)
+		assert.Equal(t, 137, result.ExitCode)
+		assert.Equal(t, StatusOOMKilled, result.Status)
+		assert.True(t, result.Usage.OOMKilled)
+	})
+
+	t.Run("NetworkRequested", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+		executor := newDockerAPIExecutor(t, engine, defaultConfig)
+
+		_, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass", Network: true})
+		require.NoError(t, err)
+		assert.Equal(t, "bridge", engine.createdContainers()[0].config.HostConfig.NetworkMode)
+	})
+
+	t.Run("TimedOut", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, runUntilKilled)
+		executor := newDockerAPIExecutor(t, engine, &Config{TimeoutSec: 1, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "while True: pass"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusTimeout, result.Status)
+
+		_, killed, _, leftovers := engine.state()
+		assert.Len(t, killed, 1)
+		assert.Empty(t, leftovers)
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, runUntilKilled)
+		executor := newDockerAPIExecutor(t, engine, &Config{TimeoutSec: 60, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+		_, err := executor.Execute(ctx, ExecuteRequest{Language: LanguagePython, Code: "while True: pass"})
+		require.ErrorIs(t, err, context.Canceled)
+
+		_, killed, _, leftovers := engine.state()
+		assert.Len(t, killed, 1)
+		assert.Empty(t, leftovers)
+	})
+
+	t.Run("OutputLimitKillsContainer", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) {
+			return strings.Repeat("x", 100), "", 0
+		})
+		executor := newDockerAPIExecutor(t, engine, &Config{
+			TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5, MaxStdoutBytes: 10, KillOnOutputLimit: true,
+		})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print('x' * 100)"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOutputLimitExceeded, result.Status)
+		assert.Equal(t, strings.Repeat("x", 10), result.Stdout)
+		assert.Equal(t, int64(100), result.Output.StdoutBytes)
+
+		_, killed, _, leftovers := engine.state()
+		assert.NotEmpty(t, killed)
+		assert.Empty(t, leftovers)
+	})
+
+	t.Run("Shutdown", func(t *testing.T) {
+		engine := newFakeDockerEngine(t, runUntilKilled)
+		executor := newDockerAPIExecutor(t, engine, &Config{TimeoutSec: 60, MemoryMB: 128, MaxArtifactSizeMB: 5})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "while True: pass"})
+		}()
+		require.Eventually(t, func() bool {
+			created := engine.createdContainers()
+			if len(created) == 0 {
+				return false
+			}
+			select {
+			case <-created[0].started:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 10*time.Millisecond)
+
+		require.NoError(t, executor.Shutdown(context.Background()))
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the execution did not end after shutdown")
+		}
+		_, killed, _, leftovers := engine.state()
+		assert.Len(t, killed, 1)
+		assert.Empty(t, leftovers)
+	})
+}
+
+func TestDockerAPIExecutorSecurity(t *testing.T) {
+	engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+	cfg := &config.Config{Sandbox: config.SandboxConfig{
+		Security: config.SecurityConfig{Seccomp: config.SeccompBuiltin, AppArmor: "codebox", SELinux: []string{"type:container_t"}},
+	}}
+	security, err := NewSecurityProfiles(cfg)
+	require.NoError(t, err)
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor, err := NewDockerAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host, WithEngineAPISecurity(security))
+	require.NoError(t, err)
+
+	_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+	require.NoError(t, err)
+
+	// The engine API takes the seccomp profile itself instead of its file
+	opts := engine.createdContainers()[0].config.HostConfig.SecurityOpt
+	require.Len(t, opts, 4)
+	assert.Equal(t, "no-new-privileges:true", opts[0])
+	profile, ok := strings.CutPrefix(opts[1], "seccomp=")
+	require.True(t, ok)
+	assert.JSONEq(t, string(config.BuiltinSeccompProfile), profile)
+	assert.NotContains(t, profile, "\n")
+	assert.Equal(t, []string{"apparmor=codebox", "label=type:container_t"}, opts[2:])
+}
+
+func TestDockerAPIExecutorEngineErrors(t *testing.T) {
+	_, err := NewDockerAPIExecutor(zaptest.NewLogger(t), &Config{}, &config.Config{}, "ssh://docker-host")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported engine host")
+
+	// Nothing listens on the socket
+	executor, err := NewDockerAPIExecutor(zaptest.NewLogger(t), &Config{TimeoutSec: 5}, &config.Config{},
+		"unix://"+filepath.Join(t.TempDir(), "missing.sock"))
+	require.NoError(t, err)
+	_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to create container")
+}
+
+func TestDockerAPIExecutorResources(t *testing.T) {
+	engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+	cfg := &config.Config{Sandbox: config.SandboxConfig{Resources: config.ResourcesConfig{
+		CPUs: 1.5, PidsLimit: 64, MemorySwapMB: -1, ShmSizeMB: 32, Ulimits: map[string]int64{"nofile": 1024},
+	}}}
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor, err := NewDockerAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host)
+	require.NoError(t, err)
+
+	_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+	require.NoError(t, err)
+
+	hostConfig := engine.createdContainers()[0].config.HostConfig
+	assert.Equal(t, int64(1500000000), hostConfig.NanoCpus)
+	assert.Equal(t, int64(64), hostConfig.PidsLimit)
+	assert.Equal(t, int64(-1), hostConfig.MemorySwap)
+	assert.Equal(t, int64(32*1024*1024), hostConfig.ShmSize)
+	assert.Equal(t, []dockerUlimit{
+		{Name: "fsize", Soft: 100000000, Hard: 100000000},
+		{Name: "nofile", Soft: 1024, Hard: 1024},
+	}, hostConfig.Ulimits)
+}
+
+func TestDockerAPIExecutorFilesystem(t *testing.T) {
+	for _, writable := range []bool{false, true} {
+		engine := newFakeDockerEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+		cfg := &config.Config{Sandbox: config.SandboxConfig{Filesystem: config.FilesystemConfig{WritableRootfs: writable}}}
+		executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+		executor, err := NewDockerAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host)
+		require.NoError(t, err)
+
+		_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+		require.NoError(t, err)
+
+		hostConfig := engine.createdContainers()[0].config.HostConfig
+		assert.Equal(t, !writable, hostConfig.ReadonlyRootfs)
+		options := "rw,exec,nosuid,nodev,size=128m,mode=1777"
+		assert.Equal(t, map[string]string{"/tmp": options, containerHomeDir: options}, hostConfig.Tmpfs)
+
+		// The workdir is copied through a volume, which works on a read-only root filesystem
+		require.Len(t, hostConfig.Mounts, 1)
+		assert.Equal(t, WorkDirPath, hostConfig.Mounts[0].Target)
+		assert.Equal(t, "volume", hostConfig.Mounts[0].Type)
+		assert.Equal(t, "mode=1777", hostConfig.Mounts[0].VolumeOptions.DriverConfig.Options["o"])
+	}
+}
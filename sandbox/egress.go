@@ -0,0 +1,346 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The egress proxy is an HTTP forward proxy
+// that tunnels HTTPS with CONNECT. It is the only way out of the internal
+// network of containers with network access, and it lets a container reach
+// the hosts of the allowlist of its language and nothing else. Containers
+// authenticate with credentials of their own, so every request is logged
+// with the container and language it came from.
+package sandbox
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+const (
+	// egressProxyUser is the user name of the proxy credentials of containers, whose password identifies the container
+	egressProxyUser = "codebox"
+	// egressDialTimeout bounds resolving and connecting to the target of a request
+	egressDialTimeout = 10 * time.Second
+	// egressReadHeaderTimeout bounds reading the request headers of a client
+	egressReadHeaderTimeout = 30 * time.Second
+)
+
+// errEgressBlocked is returned for targets outside the allowlist
+var errEgressBlocked = errors.New("target is not in the egress allowlist")
+
+// egressAllowlist holds the hosts, domains and networks that a language may reach
+type egressAllowlist struct {
+	hosts    map[string]bool // exact hostnames
+	domains  []string        // suffixes of *.domain rules, with the leading dot
+	prefixes []netip.Prefix  // IP addresses and CIDRs
+}
+
+// newEgressAllowlist parses allowlist rules that config.ValidateEgressRule accepted, skipping invalid ones
+func newEgressAllowlist(rules []string) egressAllowlist {
+	allow := egressAllowlist{hosts: make(map[string]bool)}
+	for _, rule := range rules {
+		if prefix, err := netip.ParsePrefix(rule); err == nil {
+			allow.prefixes = append(allow.prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(rule); err == nil {
+			allow.prefixes = append(allow.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+		} else if domain, ok := strings.CutPrefix(rule, "*"); ok {
+			allow.domains = append(allow.domains, strings.ToLower(domain))
+		} else {
+			allow.hosts[strings.ToLower(rule)] = true
+		}
+	}
+	return allow
+}
+
+// allowsHost reports whether a hostname is allowed by name
+func (a egressAllowlist) allowsHost(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if a.hosts[host] {
+		return true
+	}
+	for _, domain := range a.domains {
+		if strings.HasSuffix(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsAddr reports whether an IP address is in an allowed network
+func (a egressAllowlist) allowsAddr(addr netip.Addr) bool {
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// egressClient is a container that is allowed to use the proxy
+type egressClient struct {
+	container string
+	language  string
+	allow     egressAllowlist
+}
+
+// egressTargetKey is the context key of the checked address that a plain HTTP request is sent to
+type egressTargetKey struct{}
+
+// EgressProxy is the forward proxy of containers with network access
+type EgressProxy struct {
+	logger   *zap.Logger
+	cfg      *config.Config
+	resolver *net.Resolver
+	dialer   *net.Dialer
+	forward  *httputil.ReverseProxy // sends plain HTTP requests to their checked target
+
+	mu      sync.Mutex
+	clients map[string]egressClient // by token
+	tokens  map[string]string       // by container name
+
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewEgressProxy creates a proxy that checks the requests of every container against the allowlist of its language
+func NewEgressProxy(logger *zap.Logger, cfg *config.Config) *EgressProxy {
+	p := &EgressProxy{
+		logger:   logger,
+		cfg:      cfg,
+		resolver: net.DefaultResolver,
+		dialer:   &net.Dialer{Timeout: egressDialTimeout},
+		clients:  make(map[string]egressClient),
+		tokens:   make(map[string]string),
+	}
+	p.forward = &httputil.ReverseProxy{
+		// The outgoing request keeps the absolute URL of the proxy request, without the hop-by-hop headers
+		Rewrite: func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				target, _ := ctx.Value(egressTargetKey{}).(string)
+				return p.dialer.DialContext(ctx, network, target)
+			},
+			// Connections are not shared, since every request is checked against the allowlist of its container
+			DisableKeepAlives: true,
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			p.logger.Debug("egress request failed", zap.String("host", req.URL.Host), zap.Error(err))
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+	return p
+}
+
+// Start listens on addr, an address like :3128, and serves the proxy in the background
+func (p *EgressProxy) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	p.listener = listener
+	p.server = &http.Server{Handler: p, ReadHeaderTimeout: egressReadHeaderTimeout}
+	go func() {
+		if err := p.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			p.logger.Error("egress proxy stopped", zap.Error(err))
+		}
+	}()
+	p.logger.Info("egress proxy started", zap.String("address", listener.Addr().String()))
+	return nil
+}
+
+// Addr returns the address the proxy listens on, nil before Start
+func (p *EgressProxy) Addr() net.Addr {
+	if p.listener == nil {
+		return nil
+	}
+	return p.listener.Addr()
+}
+
+// Shutdown stops accepting requests and waits for the plain HTTP requests in flight. Tunnels end with their containers.
+func (p *EgressProxy) Shutdown(ctx context.Context) error {
+	if p.server == nil {
+		return nil
+	}
+	return p.server.Shutdown(ctx)
+}
+
+// register returns the proxy password of a container, which is valid until the container is unregistered
+func (p *EgressProxy) register(container, language string) string {
+	token := rand.Text()
+	client := egressClient{container: container, language: language, allow: newEgressAllowlist(p.cfg.GetEgressAllow(language))}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if previous, ok := p.tokens[container]; ok {
+		delete(p.clients, previous)
+	}
+	p.clients[token] = client
+	p.tokens[container] = token
+	return token
+}
+
+// unregister revokes the proxy password of a removed container
+func (p *EgressProxy) unregister(container string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if token, ok := p.tokens[container]; ok {
+		delete(p.clients, token)
+		delete(p.tokens, container)
+	}
+}
+
+// authenticate returns the container whose credentials the request carries
+func (p *EgressProxy) authenticate(req *http.Request) (egressClient, bool) {
+	// Proxy-Authorization has the format of Authorization, whose parser is only exposed on requests
+	auth := &http.Request{Header: http.Header{"Authorization": req.Header.Values("Proxy-Authorization")}}
+	user, token, ok := auth.BasicAuth()
+	if !ok || user != egressProxyUser {
+		return egressClient{}, false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	client, ok := p.clients[token]
+	return client, ok
+}
+
+// ServeHTTP tunnels CONNECT requests and forwards plain HTTP requests to the targets in the allowlist of the container
+func (p *EgressProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	client, ok := p.authenticate(req)
+	if !ok {
+		p.logger.Warn("egress request without valid proxy credentials",
+			zap.String("remote", req.RemoteAddr), zap.String("method", req.Method), zap.String("host", req.Host))
+		w.Header().Set("Proxy-Authenticate", `Basic realm="codebox"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	hostport := req.Host
+	if req.Method != http.MethodConnect {
+		if req.URL.Scheme != "http" || req.URL.Host == "" {
+			http.Error(w, "only absolute http URLs and CONNECT are proxied", http.StatusBadRequest)
+			return
+		}
+		hostport = req.URL.Host
+	}
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), "80")
+	}
+
+	logger := p.logger.With(
+		zap.String("container", client.container),
+		zap.String("language", client.language),
+		zap.String("method", req.Method),
+		zap.String("host", hostport),
+	)
+
+	ctx, cancel := context.WithTimeout(req.Context(), egressDialTimeout)
+	target, err := p.resolve(ctx, client.allow, hostport)
+	cancel()
+	if errors.Is(err, errEgressBlocked) {
+		logger.Warn("egress request blocked")
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logger.Warn("egress request failed", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	logger.Info("egress request allowed", zap.String("target", target))
+
+	if req.Method == http.MethodConnect {
+		p.tunnel(w, req, target, logger)
+		return
+	}
+	p.forward.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), egressTargetKey{}, target)))
+}
+
+// resolve returns the address to connect to for hostport. Hosts in the allowlist by name are connected to by name,
+// other hosts only when they resolve to an address in an allowed network.
+func (p *EgressProxy) resolve(ctx context.Context, allow egressAllowlist, hostport string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !allow.allowsAddr(addr) {
+			return "", errEgressBlocked
+		}
+		return hostport, nil
+	}
+	if allow.allowsHost(host) {
+		return hostport, nil
+	}
+	if len(allow.prefixes) == 0 {
+		return "", errEgressBlocked
+	}
+
+	addrs, err := p.resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if allow.allowsAddr(addr) {
+			return net.JoinHostPort(addr.Unmap().String(), port), nil
+		}
+	}
+	return "", errEgressBlocked
+}
+
+// tunnel connects to target and pipes the hijacked client connection to it until either side closes
+func (p *EgressProxy) tunnel(w http.ResponseWriter, req *http.Request, target string, logger *zap.Logger) {
+	upstream, err := p.dialer.DialContext(req.Context(), "tcp", target)
+	if err != nil {
+		logger.Warn("egress connection failed", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn, buffered, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		logger.Error("failed to hijack egress connection", zap.Error(err))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// The client may have sent data right after the CONNECT request, which is in the read buffer
+		_, _ = io.Copy(upstream, buffered)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(conn, upstream)
+		closeWrite(conn)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// closeWrite half-closes a TCP connection, so that the other side sees the end of the stream
+func closeWrite(conn net.Conn) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		_ = tcp.CloseWrite()
+	}
+}
@@ -0,0 +1,149 @@
+package sandbox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestEgressAllowlist(t *testing.T) {
+	allow := newEgressAllowlist([]string{"pypi.org", "*.pythonhosted.org", "10.0.0.0/8", "192.168.1.10"})
+
+	assert.True(t, allow.allowsHost("pypi.org"))
+	assert.True(t, allow.allowsHost("PyPI.org."))
+	assert.True(t, allow.allowsHost("files.pythonhosted.org"))
+	assert.False(t, allow.allowsHost("pythonhosted.org"), "*.domain only covers subdomains")
+	assert.False(t, allow.allowsHost("evilpypi.org"))
+	assert.False(t, allow.allowsHost("pypi.org.evil.com"))
+
+	assert.True(t, allow.allowsAddr(netip.MustParseAddr("10.1.2.3")))
+	assert.True(t, allow.allowsAddr(netip.MustParseAddr("::ffff:10.1.2.3")))
+	assert.True(t, allow.allowsAddr(netip.MustParseAddr("192.168.1.10")))
+	assert.False(t, allow.allowsAddr(netip.MustParseAddr("192.168.1.11")))
+}
+
+// newTestEgressProxy starts a proxy with the allowlists of cfg and returns it with its log
+func newTestEgressProxy(t *testing.T, cfg *config.Config) (*EgressProxy, *observer.ObservedLogs) {
+	t.Helper()
+	core, logs := observer.New(zapcore.DebugLevel)
+	proxy := NewEgressProxy(zap.New(core), cfg)
+	require.NoError(t, proxy.Start("127.0.0.1:0"))
+	t.Cleanup(func() { _ = proxy.Shutdown(context.Background()) })
+	return proxy, logs
+}
+
+// proxyClient returns a client that uses the proxy with the credentials of a container
+func proxyClient(proxy *EgressProxy, token string, base *http.Client) *http.Client {
+	proxyURL := &url.URL{Scheme: "http", Host: proxy.Addr().String(), User: url.UserPassword(egressProxyUser, token)}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if base != nil {
+		transport = base.Transport.(*http.Transport).Clone()
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Transport: transport}
+}
+
+func TestEgressProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		assert.Empty(t, req.Header.Get("Proxy-Authorization"), "the proxy credentials are not forwarded")
+		_, _ = io.WriteString(w, "mirror")
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "secure mirror")
+	}))
+	defer tlsUpstream.Close()
+
+	cfg := &config.Config{
+		Sandbox: config.SandboxConfig{Egress: config.EgressConfig{Enabled: true, Allow: []string{"127.0.0.1"}}},
+		Languages: map[string]config.Language{
+			LanguagePython: {},
+			LanguageGo:     {Egress: config.EgressPolicy{Allow: []string{"proxy.golang.org"}}},
+		},
+	}
+	proxy, logs := newTestEgressProxy(t, cfg)
+	get := func(client *http.Client, target string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(target)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		client := proxyClient(proxy, proxy.register("codebox-exec-1", LanguagePython), nil)
+		status, body := get(client, upstream.URL)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "mirror", body)
+
+		entries := logs.FilterMessage("egress request allowed").FilterField(zap.String("container", "codebox-exec-1")).All()
+		require.Len(t, entries, 1)
+		assert.Equal(t, LanguagePython, entries[0].ContextMap()["language"])
+		assert.Equal(t, http.MethodGet, entries[0].ContextMap()["method"])
+	})
+
+	t.Run("AllowedConnect", func(t *testing.T) {
+		client := proxyClient(proxy, proxy.register("codebox-exec-2", LanguagePython), tlsUpstream.Client())
+		status, body := get(client, tlsUpstream.URL)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, "secure mirror", body)
+		assert.Equal(t, 1, logs.FilterMessage("egress request allowed").FilterField(zap.String("method", http.MethodConnect)).Len())
+	})
+
+	t.Run("Blocked", func(t *testing.T) {
+		client := proxyClient(proxy, proxy.register("codebox-exec-3", LanguageGo), nil)
+		status, _ := get(client, upstream.URL)
+		assert.Equal(t, http.StatusForbidden, status)
+
+		tlsClient := proxyClient(proxy, proxy.register("codebox-exec-4", LanguageGo), tlsUpstream.Client())
+		_, err := tlsClient.Get(tlsUpstream.URL)
+		require.Error(t, err, "the tunnel is refused")
+
+		assert.Equal(t, 2, logs.FilterMessage("egress request blocked").FilterField(zap.String("language", LanguageGo)).Len())
+	})
+
+	t.Run("UnknownCredentials", func(t *testing.T) {
+		token := proxy.register("codebox-exec-5", LanguagePython)
+		proxy.unregister("codebox-exec-5")
+
+		status, _ := get(proxyClient(proxy, token, nil), upstream.URL)
+		assert.Equal(t, http.StatusProxyAuthRequired, status, "the credentials of removed containers are revoked")
+		status, _ = get(proxyClient(proxy, "guessed", nil), upstream.URL)
+		assert.Equal(t, http.StatusProxyAuthRequired, status)
+	})
+}
+
+func TestEgressProxyResolve(t *testing.T) {
+	proxy := NewEgressProxy(zap.NewNop(), &config.Config{})
+	ctx := context.Background()
+
+	// Hosts allowed by name are connected to by name, others only when they resolve into an allowed network
+	target, err := proxy.resolve(ctx, newEgressAllowlist([]string{"localhost"}), "localhost:443")
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:443", target)
+
+	target, err = proxy.resolve(ctx, newEgressAllowlist([]string{"127.0.0.0/8", "::1"}), "localhost:8080")
+	require.NoError(t, err)
+	assert.Contains(t, []string{"127.0.0.1:8080", "[::1]:8080"}, target)
+
+	_, err = proxy.resolve(ctx, newEgressAllowlist([]string{"10.0.0.0/8"}), "localhost:8080")
+	require.ErrorIs(t, err, errEgressBlocked)
+	_, err = proxy.resolve(ctx, newEgressAllowlist([]string{"pypi.org"}), "files.pythonhosted.org:443")
+	require.ErrorIs(t, err, errEgressBlocked)
+	_, err = proxy.resolve(ctx, newEgressAllowlist([]string{"pypi.org"}), "[::1]:443")
+	require.ErrorIs(t, err, errEgressBlocked)
+}
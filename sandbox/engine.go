@@ -0,0 +1,233 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The engine client talks to the REST API of a
+// container engine over its unix socket or TCP, so that API backends do not
+// depend on an installed CLI and get exit codes and container state as JSON.
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// engineHostName is the host name of API requests, the connection is made by the dialer of the client
+const engineHostName = "engine"
+
+// engineErrorBodyLimit bounds how much of an error response is read for its message
+const engineErrorBodyLimit = 64 * 1024
+
+// engineError is an error response of the engine API
+type engineError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *engineError) Error() string {
+	return fmt.Sprintf("engine API error %d: %s", e.StatusCode, e.Message)
+}
+
+// isEngineNotFound reports whether err is an engine response for a missing container or image
+func isEngineNotFound(err error) bool {
+	var apiErr *engineError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// engineClient is a minimal client of the Docker Engine API and the APIs of compatible engines
+type engineClient struct {
+	http *http.Client
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+// newEngineClient creates a client for an engine listening on host, e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375
+func newEngineClient(host string) (*engineClient, error) {
+	var network, address string
+	switch {
+	case strings.HasPrefix(host, "unix://"):
+		network, address = "unix", strings.TrimPrefix(host, "unix://")
+	case strings.HasPrefix(host, "tcp://"):
+		network, address = "tcp", strings.TrimPrefix(host, "tcp://")
+	default:
+		return nil, fmt.Errorf("unsupported engine host: %s", host)
+	}
+
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx)
+		},
+	}
+	return &engineClient{http: &http.Client{Transport: transport}, dial: dial}, nil
+}
+
+// newEngineRequest builds an API request for path, which may carry a query
+func newEngineRequest(ctx context.Context, method, path string, query url.Values, body io.Reader) (*http.Request, error) {
+	target := url.URL{Scheme: "http", Host: engineHostName, Path: path, RawQuery: query.Encode()}
+	return http.NewRequestWithContext(ctx, method, target.String(), body)
+}
+
+// send performs a request and returns the response of a successful one, whose body the caller must close
+func (c *engineClient) send(
+	ctx context.Context,
+	method, path string,
+	query url.Values,
+	body io.Reader,
+	contentType string,
+) (*http.Response, error) {
+	req, err := newEngineRequest(ctx, method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		return nil, readEngineError(resp)
+	}
+	return resp, nil
+}
+
+// call sends in as JSON, when not nil, and decodes the JSON response into out, when not nil
+func (c *engineClient) call(ctx context.Context, method, path string, query url.Values, in, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(data)
+		contentType = "application/json"
+	}
+
+	resp, err := c.send(ctx, method, path, query, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// hijack sends a request, with in as its JSON body, that the engine upgrades to a raw stream, as used to
+// attach to an exec. The returned reader holds the stream data the engine sent along with its response.
+func (c *engineClient) hijack(ctx context.Context, path string, query url.Values, in any) (net.Conn, io.Reader, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	req, err := newEngineRequest(ctx, http.MethodPost, path, query, bytes.NewReader(data))
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to send %s: %w", path, err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to read response of %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		err := readEngineError(resp)
+		_ = resp.Body.Close()
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	_ = resp.Body.Close() // upgrade responses have no body, the stream follows on the connection
+	return conn, reader, nil
+}
+
+// readEngineError turns an error response into an engineError, using the message field of JSON bodies
+func readEngineError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, engineErrorBodyLimit))
+	var message struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &message) == nil && message.Message != "" {
+		return &engineError{StatusCode: resp.StatusCode, Message: message.Message}
+	}
+	return &engineError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+}
+
+// Stream types of the multiplexed exec stream
+const (
+	streamTypeStdout = 1
+	streamTypeStderr = 2
+)
+
+// streamHeaderSize is the size of the header in front of every frame of a multiplexed stream
+const streamHeaderSize = 8
+
+// demuxStream copies a multiplexed exec stream into stdout and stderr until it ends.
+// Every frame starts with a header holding the stream type and the big-endian size of the frame.
+func demuxStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, streamHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		var w io.Writer
+		switch header[0] {
+		case streamTypeStdout:
+			w = stdout
+		case streamTypeStderr:
+			w = stderr
+		default:
+			w = io.Discard // stdin echo is never requested
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,526 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The EngineAPIExecutor runs code in containers
+// with the same restrictions as the CLI backends, but talks to the REST API of
+// the container engine instead of running its CLI. The workdir is copied into
+// every container and back out with the archive endpoints, so the engine does
+// not need access to the files of the server, and every phase runs with the
+// exec endpoints in a container that idles until the phase is done.
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// containerSpec describes a container of a phase independently of the engine API that creates it.
+// Every container also gets the restrictions of the CLI backends: no new privileges and no capabilities.
+type containerSpec struct {
+	Image       string
+	Cmd         []string
+	Env         map[string]string
+	User        string
+	MemoryBytes int64
+	Network     bool
+	Resources   containerResources  // CPU, PIDs, swap, shared memory and ulimit limits
+	Security    containerSecurity   // seccomp, AppArmor and SELinux profiles on top of the restrictions
+	Filesystem  containerFilesystem // read-only root filesystem and tmpfs mounts
+	Labels      map[string]string   // labels of the container, e.g. the instance of the server
+	Runtime     string              // OCI runtime of the container, empty for the engine default
+}
+
+// engineVolumes are the directories that the workdir is copied to and from. They are anonymous
+// volumes, because the archive endpoints cannot write to a read-only root filesystem.
+var engineVolumes = []string{WorkDirPath}
+
+// containerEngine covers the parts of an engine API that differ between engines.
+// Every other endpoint is shared by the Docker Engine API and the Podman libpod API.
+type containerEngine interface {
+	// containerPath returns the API path of a container endpoint, or of the container itself when endpoint is empty
+	containerPath(name, endpoint string) string
+	// execPath returns the API path of an endpoint of an exec instance
+	execPath(id, endpoint string) string
+	// listPath returns the API path of the container list
+	listPath() string
+	// checkRuntime makes sure that the engine knows an OCI runtime
+	checkRuntime(ctx context.Context, name string) error
+	// createContainer creates a container, pulling its image first when the engine does not have it
+	createContainer(ctx context.Context, name string, spec *containerSpec) error
+}
+
+// engineExecConfig is the body of an exec create request, which the Docker Engine and libpod APIs share
+type engineExecConfig struct {
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	Cmd          []string
+	User         string `json:",omitempty"` // the user of the container when empty
+}
+
+// engineExecStart is the body of an exec start request that attaches to the command
+type engineExecStart struct {
+	Detach bool
+	Tty    bool
+}
+
+// engineExecState is the part of an exec inspect response that tells whether and how the command exited
+type engineExecState struct {
+	Running  bool
+	ExitCode int
+}
+
+// execPollInterval is how often an exec whose output ended is inspected until the engine reports its exit
+const execPollInterval = 10 * time.Millisecond
+
+// EngineAPIExecutor implements SandboxExecutor using the REST API of a container engine
+type EngineAPIExecutor struct {
+	logger     *zap.Logger
+	config     *Config
+	cfg        *config.Config // Reference to the full configuration
+	client     *engineClient
+	engine     containerEngine
+	fs         FileSystem
+	workdirs   hostWorkdirs
+	containers *containerRegistry // containers of the running executions
+	security   *SecurityProfiles  // seccomp, AppArmor and SELinux profiles, nil for the engine defaults
+	instance   string             // instance of the server that labels its containers
+}
+
+// EngineAPIExecutorOption defines a functional option for EngineAPIExecutor
+type EngineAPIExecutorOption func(*EngineAPIExecutor)
+
+// WithEngineAPISecurity applies the security profiles of every language to its containers
+func WithEngineAPISecurity(security *SecurityProfiles) EngineAPIExecutorOption {
+	return func(e *EngineAPIExecutor) {
+		e.security = security
+	}
+}
+
+// WithEngineAPIFileSystem sets the FileSystem for EngineAPIExecutor
+func WithEngineAPIFileSystem(fs FileSystem) EngineAPIExecutorOption {
+	return func(e *EngineAPIExecutor) {
+		e.fs = fs
+	}
+}
+
+// newEngineAPIExecutor creates an executor for an engine whose API is reached through client
+func newEngineAPIExecutor(
+	logger *zap.Logger,
+	executorConfig *Config,
+	cfg *config.Config,
+	client *engineClient,
+	engine containerEngine,
+	opts ...EngineAPIExecutorOption,
+) *EngineAPIExecutor {
+	executor := &EngineAPIExecutor{
+		logger:   logger,
+		config:   executorConfig,
+		cfg:      cfg,
+		client:   client,
+		engine:   engine,
+		fs:       &RealFileSystem{}, // Default implementation
+		instance: cfg.GetInstance(),
+	}
+	executor.containers = newContainerRegistry(logger, executor.removeContainer)
+
+	// Apply options
+	for _, opt := range opts {
+		opt(executor)
+	}
+	executor.workdirs = hostWorkdirs{logger: logger, config: executorConfig, cfg: cfg, fs: executor.fs}
+
+	return executor
+}
+
+// Execute runs the code in a container of the engine
+//
+//nolint:gocritic // Large request struct is passed by value to satisfy the SandboxExecutor interface
+func (e *EngineAPIExecutor) Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error) {
+	workdirPath, lang, cleanup, err := e.workdirs.prepare(req.Language, req.Code, req.Workdir, req.WorkdirTar)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	defer cleanup()
+
+	// Run the build phase (if any) and the run phase, each in its own container
+	limits := e.config.limits(req.TimeoutSec, req.MemoryMB, req.Network)
+	phase := e.containerPhase(ctx, req.Language, lang, workdirPath, limits, e.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, e.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	result.Limits = limits
+	result.Runtime = e.cfg.GetRuntime(req.Language)
+
+	// Only return artifacts when the run phase actually finished and the caller wants them
+	if err := e.workdirs.collectArtifacts(&result, &req, workdirPath); err != nil {
+		return ExecuteResult{}, err
+	}
+	return result, nil
+}
+
+// ExecuteBatch builds the code once and runs it against every test case in the same workdir
+//
+//nolint:gocritic // Large request struct is passed by value to mirror Execute
+func (e *EngineAPIExecutor) ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error) {
+	workdirPath, lang, cleanup, err := e.workdirs.prepare(req.Language, req.Code, "", req.WorkdirTar)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer cleanup()
+
+	limits := e.config.limits(0, 0, false)
+	phase := e.containerPhase(ctx, req.Language, lang, workdirPath, limits, phaseCapture{})
+	result, err := runTestCases(ctx, lang, e.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	result.Runtime = e.cfg.GetRuntime(req.Language)
+	return result, nil
+}
+
+// containerPhase returns a phaseFunc that runs every phase in its own container on a copy of the workdir.
+// capture decides where the output goes besides the phase result.
+func (e *EngineAPIExecutor) containerPhase(
+	ctx context.Context,
+	language string,
+	lang Language,
+	workdirPath string,
+	limits Limits,
+	capture phaseCapture,
+) phaseFunc {
+	return func(phaseCtx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return e.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			spec := e.containerSpec(language, lang.Image, limits.forPhase(phase))
+			return e.runContainer(phaseCtx, ctx, spec, workdirPath, command, base)
+		})
+	}
+}
+
+// containerSpec returns the idle container that the command of a phase runs in, with the memory limit and network
+// access of limits and the language environment, resource limits, security profiles and filesystem restrictions.
+func (e *EngineAPIExecutor) containerSpec(language, image string, limits Limits) containerSpec {
+	var env map[string]string
+	if langConfig, exists := e.cfg.Languages[language]; exists {
+		env = langConfig.Environment
+	}
+	e.logger.Debug("applying environment variables", zap.String("language", language), zap.Any("env", env))
+
+	return containerSpec{
+		Image:       image,
+		Cmd:         containerIdleCommand,
+		Env:         withHome(env),
+		User:        "nobody", // Run as non-privileged user
+		MemoryBytes: int64(limits.MemoryMB) * BytesPerKB * BytesPerKB,
+		Network:     limits.Network,
+		Resources:   newContainerResources(e.cfg, language),
+		Security:    e.security.forLanguage(language),
+		Filesystem:  newContainerFilesystem(e.cfg),
+		Labels:      map[string]string{containerInstanceLabel: e.instance},
+		Runtime:     e.cfg.GetRuntime(language),
+	}
+}
+
+// runContainer runs a shell command in a single container on a copy of the workdir and copies the workdir back
+// once the command exited. phaseCtx bounds the phase, while ctx is used for cleanup after the phase timed out.
+// base carries the stdin and output capture of the phase; a non-nil stdin is written to the command.
+// The container is kept until the resource usage and workdir have been collected.
+func (e *EngineAPIExecutor) runContainer(
+	phaseCtx, ctx context.Context,
+	spec containerSpec,
+	workdirPath, command string,
+	base Command,
+) (PhaseResult, error) {
+	containerName := fmt.Sprintf("codebox-exec-%d", time.Now().UnixNano())
+
+	// Remove the container once it is done. A container whose phase timed out, was cancelled
+	// or exceeded its output limit is killed first.
+	var limitExceeded atomic.Bool
+	e.containers.track(containerName)
+	defer func() {
+		e.containers.release(ctx, containerName, phaseCtx.Err() != nil || limitExceeded.Load())
+	}()
+
+	// Kill the container as soon as its output exceeds the limits, if configured
+	onExceed := func() {
+		if base.Output.KillOnExceed && !limitExceeded.Swap(true) {
+			e.killContainer(ctx, containerName)
+		}
+	}
+	stdout := newCappedBuffer(base.Output.StdoutBytes, base.StdoutSink, onExceed)
+	stderr := newCappedBuffer(base.Output.StderrBytes, base.StderrSink, onExceed)
+
+	exitCode, wallTime, err := e.startAndExec(phaseCtx, containerName, &spec, workdirPath, command, base.Stdin, stdout, stderr)
+	output := CommandResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+		Output: OutputStats{
+			StdoutBytes:     stdout.total,
+			StderrBytes:     stderr.total,
+			StdoutTruncated: stdout.truncated(),
+			StderrTruncated: stderr.truncated(),
+		},
+		OutputLimitExceeded: limitExceeded.Load(),
+	}
+
+	// If the phase timed out, the deferred release makes sure the container does not keep running.
+	// Whatever it wrote to the workdir is discarded.
+	if errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+		return PhaseResult{Stdout: output.Stdout, Stderr: output.Stderr, Output: output.Output}, nil
+	}
+
+	// The caller cancelled the execution or the server is shutting down
+	if ctx.Err() != nil {
+		return PhaseResult{}, fmt.Errorf("execution cancelled: %w", ctx.Err())
+	}
+
+	if err != nil && !output.OutputLimitExceeded {
+		return PhaseResult{}, fmt.Errorf("failed to execute container: %w", err)
+	}
+
+	// The workdir is copied back whenever the program ran, like a mounted workdir would keep its changes
+	if err := e.copyFromContainer(ctx, containerName, workdirPath); err != nil {
+		return PhaseResult{}, err
+	}
+
+	result := phaseOutput(&output)
+	if !output.OutputLimitExceeded {
+		result.Usage = e.containerUsage(ctx, containerName, exitCode, wallTime)
+	}
+	return result, nil
+}
+
+// startAndExec creates and starts a container with the workdir copied into it and runs command in it.
+// It returns the exit code of the command and how long it ran.
+func (e *EngineAPIExecutor) startAndExec(
+	ctx context.Context,
+	containerName string,
+	spec *containerSpec,
+	workdirPath, command string,
+	stdin []byte,
+	stdout, stderr *cappedBuffer,
+) (int, time.Duration, error) {
+	if err := e.engine.createContainer(ctx, containerName, spec); err != nil {
+		return 0, 0, fmt.Errorf("failed to create container: %w", err)
+	}
+	if err := e.copyToContainer(ctx, containerName, workdirPath); err != nil {
+		return 0, 0, err
+	}
+	if err := e.client.call(ctx, http.MethodPost, e.engine.containerPath(containerName, "start"), nil, nil, nil); err != nil {
+		return 0, 0, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	start := time.Now()
+	exitCode, err := e.exec(ctx, containerName, "", phaseCommand(command), stdin, stdout, stderr)
+	return exitCode, time.Since(start), err
+}
+
+// exec runs cmd in a running container as user, or as the user of the container when user is empty.
+// It copies the output of cmd into stdout and stderr, writes a non-nil stdin to its input
+// and returns its exit code.
+func (e *EngineAPIExecutor) exec(
+	ctx context.Context,
+	containerName, user string,
+	cmd []string,
+	stdin []byte,
+	stdout, stderr io.Writer,
+) (int, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	execConfig := engineExecConfig{AttachStdin: stdin != nil, AttachStdout: true, AttachStderr: true, Cmd: cmd, User: user}
+	if err := e.client.call(ctx, http.MethodPost, e.engine.containerPath(containerName, "exec"), nil, execConfig, &created); err != nil {
+		return 0, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	conn, stream, err := e.client.hijack(ctx, e.engine.execPath(created.ID, "start"), nil, engineExecStart{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to start exec: %w", err)
+	}
+	defer conn.Close()
+	stopClose := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stopClose()
+
+	if stdin != nil {
+		go writeStdin(conn, stdin)
+	}
+
+	// The engine ends the stream once the command exited and all output was sent
+	if err := demuxStream(stream, stdout, stderr); err != nil {
+		return 0, fmt.Errorf("failed to read exec output: %w", err)
+	}
+	return e.waitExec(ctx, created.ID)
+}
+
+// waitExec inspects an exec whose output ended until the engine reports that its command exited
+func (e *EngineAPIExecutor) waitExec(ctx context.Context, id string) (int, error) {
+	for {
+		var state engineExecState
+		if err := e.client.call(ctx, http.MethodGet, e.engine.execPath(id, "json"), nil, nil, &state); err != nil {
+			return 0, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		if !state.Running {
+			return state.ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(execPollInterval):
+		}
+	}
+}
+
+// writeStdin writes the input of a program to an exec connection and closes its write side,
+// so that the program sees the end of its input. Writing stops when the connection is closed.
+func writeStdin(conn net.Conn, stdin []byte) {
+	if _, err := conn.Write(stdin); err != nil {
+		return
+	}
+	if closer, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = closer.CloseWrite()
+	}
+}
+
+// readPullProgress follows the progress stream of an image pull until it ends.
+// Pull failures are reported in the stream of a successful response.
+func readPullProgress(r io.Reader, image string) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var progress struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &progress) == nil && progress.Error != "" {
+			return fmt.Errorf("failed to pull image %s: %s", image, progress.Error)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	return nil
+}
+
+// copyToContainer copies the workdir into the workdir volume of a created container
+func (e *EngineAPIExecutor) copyToContainer(ctx context.Context, containerName, workdirPath string) error {
+	archive, err := workdirArchive(workdirPath)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"path": {WorkDirPath}}
+	resp, err := e.client.send(ctx, http.MethodPut, e.engine.containerPath(containerName, "archive"), query,
+		bytes.NewReader(archive), "application/x-tar")
+	if err != nil {
+		return fmt.Errorf("failed to copy workdir into container: %w", err)
+	}
+	return resp.Body.Close()
+}
+
+// copyFromContainer replaces the workdir with the workdir of a finished container
+func (e *EngineAPIExecutor) copyFromContainer(ctx context.Context, containerName, workdirPath string) error {
+	query := url.Values{"path": {WorkDirPath}}
+	resp, err := e.client.send(ctx, http.MethodGet, e.engine.containerPath(containerName, "archive"), query, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to copy workdir from container: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := extractWorkdirArchive(resp.Body, workdirPath); err != nil {
+		return fmt.Errorf("failed to copy workdir from container: %w", err)
+	}
+	return nil
+}
+
+// containerUsage reads the cgroup counters of a fresh container as root after its phase ended with exitCode
+// and returns the usage of the phase. Usage is informational, so failures are only logged.
+func (e *EngineAPIExecutor) containerUsage(ctx context.Context, containerName string, exitCode int, wallTime time.Duration) ResourceUsage {
+	output := newCappedBuffer(containerUsageOutputLimit, nil, nil)
+	status, err := e.exec(ctx, containerName, containerRootUser, containerUsageCommand, nil, output, io.Discard)
+	if err == nil && status != 0 {
+		err = fmt.Errorf("usage command exited with %d", status)
+	}
+	if err != nil {
+		e.logger.Warn("failed to collect container resource usage", zap.String("container", containerName), zap.Error(err))
+		return ResourceUsage{WallTime: wallTime}
+	}
+
+	// The counters of the fresh container started at zero, and its workdir has no quota
+	return parseContainerCounters(output.String()).phaseUsage(containerCounters{}, exitCode, wallTime, false)
+}
+
+// killContainer kills a running container, which may already have exited
+func (e *EngineAPIExecutor) killContainer(ctx context.Context, containerName string) {
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerCleanupTimeout)
+	defer cancel()
+	if err := e.client.call(cleanupCtx, http.MethodPost, e.engine.containerPath(containerName, "kill"), nil, nil, nil); err != nil {
+		e.logger.Debug("failed to kill container", zap.String("container", containerName), zap.Error(err))
+	}
+}
+
+// removeContainer force-removes a container and its volumes, killing it first when kill is set
+func (e *EngineAPIExecutor) removeContainer(ctx context.Context, containerName string, kill bool) error {
+	if kill {
+		e.killContainer(ctx, containerName)
+	}
+
+	query := url.Values{"force": {"1"}, "v": {"1"}}
+	err := e.client.call(ctx, http.MethodDelete, e.engine.containerPath(containerName, ""), query, nil, nil)
+	if err != nil && !isEngineNotFound(err) {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+	return nil
+}
+
+// removeStaleContainers force-removes the containers, with their anonymous volumes, that a previous run of this
+// instance left behind, e.g. after a crash. Failures are logged, since they must not keep the server from starting.
+func (e *EngineAPIExecutor) removeStaleContainers(ctx context.Context) {
+	filters, err := json.Marshal(map[string][]string{"label": {instanceLabel(e.instance)}})
+	if err != nil {
+		e.logger.Warn("failed to encode the stale container filter", zap.Error(err))
+		return
+	}
+	var containers []struct{ ID string }
+	query := url.Values{"all": {"true"}, "filters": {string(filters)}}
+	if err := e.client.call(ctx, http.MethodGet, e.engine.listPath(), query, nil, &containers); err != nil {
+		e.logger.Warn("failed to list stale containers", zap.String("instance", e.instance), zap.Error(err))
+		return
+	}
+
+	for _, container := range containers {
+		e.logger.Info("removing stale container", zap.String("instance", e.instance), zap.String("id", container.ID))
+		if err := e.removeContainer(ctx, container.ID, false); err != nil {
+			e.logger.Warn("failed to remove stale container", zap.String("id", container.ID), zap.Error(err))
+		}
+	}
+}
+
+// checkRuntimes makes sure that every OCI runtime selected by the configuration is known to the engine
+func (e *EngineAPIExecutor) checkRuntimes(ctx context.Context) error {
+	for _, name := range e.cfg.GetRuntimes() {
+		if err := e.engine.checkRuntime(ctx, name); err != nil {
+			return fmt.Errorf("OCI runtime %s is not available: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Shutdown kills and removes every container that is still running
+func (e *EngineAPIExecutor) Shutdown(ctx context.Context) error {
+	return e.containers.shutdown(ctx)
+}
@@ -0,0 +1,52 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDemuxStream(t *testing.T) {
+	var stream bytes.Buffer
+	require.NoError(t, writeFrame(&stream, streamTypeStdout, []byte("out 1\n")))
+	require.NoError(t, writeFrame(&stream, streamTypeStderr, []byte("err\n")))
+	require.NoError(t, writeFrame(&stream, 0, []byte("stdin echo")))
+	require.NoError(t, writeFrame(&stream, streamTypeStdout, []byte("out 2\n")))
+
+	var stdout, stderr bytes.Buffer
+	require.NoError(t, demuxStream(&stream, &stdout, &stderr))
+	assert.Equal(t, "out 1\nout 2\n", stdout.String())
+	assert.Equal(t, "err\n", stderr.String())
+
+	// A stream cut off in the middle of a frame is an error
+	stream.Reset()
+	require.NoError(t, writeFrame(&stream, streamTypeStdout, []byte("cut off")))
+	require.ErrorIs(t, demuxStream(io.LimitReader(&stream, 10), io.Discard, io.Discard), io.ErrUnexpectedEOF)
+}
+
+func TestEngineClientErrors(t *testing.T) {
+	_, err := newEngineClient("npipe:////./pipe/docker_engine")
+	require.Error(t, err)
+
+	engine := newFakeDockerEngine(t, nil)
+	client, err := newEngineClient(engine.host)
+	require.NoError(t, err)
+
+	err = client.call(context.Background(), http.MethodPost, "/containers/missing/start", nil, nil, nil)
+	require.Error(t, err)
+	assert.True(t, isEngineNotFound(err))
+	assert.Equal(t, "engine API error 404: No such container: missing", err.Error())
+
+	_, _, err = client.hijack(context.Background(), "/exec/missing/start", nil, engineExecStart{})
+	assert.True(t, isEngineNotFound(err))
+
+	// Error bodies that are not JSON are reported as they are
+	err = client.call(context.Background(), http.MethodGet, "/unknown", nil, nil, nil)
+	require.Error(t, err)
+	assert.Equal(t, "engine API error 404: 404 page not found", err.Error())
+}
@@ -28,17 +28,21 @@ type MockCommandRunner struct {
 	}
 }
 
-func (m *MockCommandRunner) RunCommand(_ context.Context, args []string) (stdout, stderr string, exitCode int, err error) {
+func (m *MockCommandRunner) RunCommand(_ context.Context, cmd Command) (CommandResult, error) {
 	cmdKey := ""
-	for _, arg := range args {
+	for _, arg := range cmd.Args {
 		cmdKey += arg + " "
 	}
 
 	if result, exists := m.commandResults[cmdKey]; exists {
-		return result.stdout, result.stderr, result.exitCode, result.err
+		return CommandResult{Stdout: result.stdout, Stderr: result.stderr, ExitCode: result.exitCode}, result.err
 	}
 
-	return m.defaultResult.stdout, m.defaultResult.stderr, m.defaultResult.exitCode, m.defaultResult.err
+	return CommandResult{
+		Stdout:   m.defaultResult.stdout,
+		Stderr:   m.defaultResult.stderr,
+		ExitCode: m.defaultResult.exitCode,
+	}, m.defaultResult.err
 }
 
 // MockFileSystem implements FileSystem for testing
@@ -67,6 +71,10 @@ func (m *MockFileSystem) MkdirAll(path string, _ os.FileMode) error {
 	return nil
 }
 
+func (*MockFileSystem) Chmod(_ string, _ os.FileMode) error {
+	return nil
+}
+
 func (m *MockFileSystem) WriteFile(filename string, data []byte, _ os.FileMode) error {
 	if err, exists := m.writeFileErrors[filename]; exists {
 		return err
@@ -86,6 +94,10 @@ func (m *MockFileSystem) ReadFile(filename string) ([]byte, error) {
 	return []byte{}, nil
 }
 
+func (*MockFileSystem) ReadDir(_ string) ([]os.DirEntry, error) {
+	return nil, nil
+}
+
 func (m *MockFileSystem) RemoveAll(path string) error {
 	if err, exists := m.removeAllErrors[path]; exists {
 		return err
@@ -6,7 +6,9 @@
 package sandbox
 
 import (
+	"context"
 	"fmt"
+	"os"
 
 	"go.uber.org/zap"
 
@@ -15,21 +17,195 @@ import (
 
 // NewExecutor creates an appropriate sandbox executor based on the configuration
 func NewExecutor(logger *zap.Logger, cfg *config.Config) (SandboxExecutor, error) {
+	// Fail fast on language definitions that could never be executed
+	languages, err := NewLanguageRegistry(cfg.Languages)
+	if err != nil {
+		return nil, fmt.Errorf("invalid languages configuration: %w", err)
+	}
+
 	executorConfig := Config{
 		TimeoutSec:        cfg.Sandbox.TimeoutSec,
+		BuildTimeoutSec:   cfg.Sandbox.BuildTimeoutSec,
 		MemoryMB:          cfg.Sandbox.MemoryMB,
 		NetworkEnabled:    cfg.Sandbox.NetworkEnabled,
 		MaxArtifactSizeMB: cfg.Sandbox.MaxArtifactSizeMB,
+		MaxStdoutBytes:    cfg.GetMaxStdoutSize(),
+		MaxStderrBytes:    cfg.GetMaxStderrSize(),
+		KillOnOutputLimit: cfg.Sandbox.KillOnOutputLimit,
+		SpillOutput:       cfg.Sandbox.SpillOutput,
+	}
+
+	// Container backends apply the seccomp, AppArmor and SELinux profiles of every language
+	security, err := NewSecurityProfiles(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid security profiles: %w", err)
 	}
 
 	switch backend := cfg.Sandbox.Backend; backend {
-	case "docker":
-		return NewDockerExecutor(logger, &executorConfig, cfg), nil
-	case "podman":
-		return NewPodmanExecutor(logger, &executorConfig, cfg), nil
-	case "local":
+	case config.BackendDockerAPI:
+		executor, err := NewDockerAPIExecutor(
+			logger, &executorConfig, cfg, engineHost(cfg, "DOCKER_HOST", DefaultDockerHost), WithEngineAPISecurity(security),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid docker engine host: %w", err)
+		}
+		return startEngineAPIExecutor(executor)
+	case config.BackendPodmanAPI:
+		executor, err := NewPodmanAPIExecutor(
+			logger, &executorConfig, cfg, engineHost(cfg, "CONTAINER_HOST", DefaultPodmanHost()), WithEngineAPISecurity(security),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("invalid podman service host: %w", err)
+		}
+		return startEngineAPIExecutor(executor)
+	case config.BackendNamespace:
+		executor, err := NewNamespaceExecutor(logger, &executorConfig, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("namespace backend is not available: %w", err)
+		}
+		return executor, nil
+	case config.BackendBwrap:
+		executor, err := NewBwrapExecutor(logger, &executorConfig, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("bwrap backend is not available: %w", err)
+		}
+		return executor, nil
+	case config.BackendWasm:
+		executor, err := NewWasmExecutor(logger, &executorConfig, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("wasm backend is not available: %w", err)
+		}
+		return executor, nil
+	case config.BackendLocal:
 		return NewLocalExecutor(logger, &executorConfig, cfg), nil
 	default:
-		return nil, fmt.Errorf("unsupported backend: %s", backend)
+		runtime, ok := ociRuntime(cfg)
+		if !ok {
+			return nil, fmt.Errorf("unsupported backend: %s", backend)
+		}
+
+		// A workdir quota is copied in and out with cp, which the runtimes without the warm pool lack
+		if cfg.Sandbox.Filesystem.WorkdirSizeMB > 0 && !runtime.Pool {
+			return nil, fmt.Errorf("sandbox.filesystem.workdir_size_mb is not supported by the %s runtime", runtime.Name)
+		}
+
+		opts := []OCIExecutorOption{WithOCISecurity(security)}
+		if cfg.Sandbox.Pool.Enabled {
+			if !runtime.Pool {
+				return nil, fmt.Errorf("sandbox.pool is not supported by the %s runtime", runtime.Name)
+			}
+			poolConfig, err := newPoolConfig(cfg, languages)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, WithOCIPool(poolConfig))
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), runtimeCheckTimeout)
+		defer cancel()
+
+		// Remove what a crashed run of this instance left behind before the warm pool starts containers
+		cli := append([]string{runtime.Binary}, runtime.GlobalArgs...)
+		removeStaleContainers(ctx, logger, &RealCommandRunner{}, cli, cfg.GetInstance())
+
+		// Route network access through the egress proxy before the warm pool starts containers
+		if cfg.Sandbox.Egress.Enabled {
+			egress, err := StartOCIEgress(ctx, logger, cfg, runtime, &RealCommandRunner{})
+			if err != nil {
+				return nil, fmt.Errorf("egress proxy is not available: %w", err)
+			}
+			opts = append(opts, WithOCIEgress(egress))
+		}
+		executor := NewOCIExecutor(logger, &executorConfig, cfg, runtime, opts...)
+
+		// Refuse to start with an OCI runtime that the engine cannot run containers with
+		if err := executor.checkRuntimes(ctx); err != nil {
+			_ = executor.Shutdown(ctx)
+			return nil, err
+		}
+		return executor, nil
+	}
+}
+
+// ociRuntime returns the container CLI named by sandbox.backend: a built-in runtime with the overrides
+// of its sandbox.runtimes entry, or a runtime added in sandbox.runtimes
+func ociRuntime(cfg *config.Config) (OCIRuntime, bool) {
+	name := cfg.Sandbox.Backend
+	builtin := map[string]OCIRuntime{
+		config.BackendDocker:  dockerRuntime,
+		config.BackendPodman:  podmanRuntime,
+		config.BackendNerdctl: nerdctlRuntime,
+	}
+	runtime, isBuiltin := builtin[name]
+	override, isConfigured := cfg.Sandbox.Runtimes[name]
+	if !isBuiltin && !isConfigured {
+		return OCIRuntime{}, false
+	}
+	if !isBuiltin {
+		runtime = OCIRuntime{Name: name, Network: "bridge", Pool: true, RuntimeCheck: config.RuntimeCheckInfo}
+	}
+
+	if override.Binary != "" {
+		runtime.Binary = override.Binary
+	}
+	if override.GlobalArgs != nil {
+		runtime.GlobalArgs = override.GlobalArgs
+	}
+	if override.RunArgs != nil {
+		runtime.RunArgs = override.RunArgs
+	}
+	if override.Network != "" {
+		runtime.Network = override.Network
+	}
+	if override.Pool != nil {
+		runtime.Pool = *override.Pool
+	}
+	if override.RuntimeCheck != "" {
+		runtime.RuntimeCheck = override.RuntimeCheck
+	}
+	return runtime, true
+}
+
+// newPoolConfig returns the warm pool configuration, pooling every language unless the languages are listed
+func newPoolConfig(cfg *config.Config, languages *LanguageRegistry) (PoolConfig, error) {
+	pooled := cfg.Sandbox.Pool.Languages
+	if len(pooled) == 0 {
+		pooled = languages.Names()
+	}
+	for _, language := range pooled {
+		if _, ok := languages.Get(language); !ok {
+			return PoolConfig{}, fmt.Errorf("invalid sandbox.pool.languages: unknown language %s", language)
+		}
+	}
+
+	return PoolConfig{
+		Size:                cfg.Sandbox.Pool.Size,
+		MaxContainers:       cfg.Sandbox.Pool.MaxContainers,
+		Languages:           pooled,
+		HealthCheckInterval: cfg.GetPoolHealthCheckInterval(),
+	}, nil
+}
+
+// engineHost returns the configured engine address, falling back to the environment variable
+// the engine CLI reads and then to the default socket of the engine
+func engineHost(cfg *config.Config, envVar, defaultHost string) string {
+	if cfg.Sandbox.EngineHost != "" {
+		return cfg.Sandbox.EngineHost
+	}
+	if host := os.Getenv(envVar); host != "" {
+		return host
+	}
+	return defaultHost
+}
+
+// startEngineAPIExecutor removes what a crashed run of this instance left behind through the engine API
+// and refuses to start with an OCI runtime that the engine cannot run containers with
+func startEngineAPIExecutor(executor *EngineAPIExecutor) (*EngineAPIExecutor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), runtimeCheckTimeout)
+	defer cancel()
+	executor.removeStaleContainers(ctx)
+	if err := executor.checkRuntimes(ctx); err != nil {
+		return nil, err
 	}
+	return executor, nil
 }
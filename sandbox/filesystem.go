@@ -0,0 +1,64 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Containers run on a read-only root filesystem
+// with size-capped tmpfs mounts for the scratch directories that programs and
+// toolchains expect to write to, like /tmp and the home directory.
+package sandbox
+
+import (
+	"fmt"
+	"maps"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// containerHomeDir is the home directory of the unprivileged user in containers
+const containerHomeDir = "/home/codebox"
+
+// containerFilesystem holds the filesystem restrictions of containers
+type containerFilesystem struct {
+	ReadOnly    bool // mount the root filesystem read-only
+	TmpfsSizeMB int  // size of the tmpfs mounts at /tmp and the home directory
+}
+
+// newContainerFilesystem returns the filesystem restrictions of the sandbox configuration
+func newContainerFilesystem(cfg *config.Config) containerFilesystem {
+	return containerFilesystem{
+		ReadOnly:    !cfg.Sandbox.Filesystem.WritableRootfs,
+		TmpfsSizeMB: cfg.GetTmpfsSizeMB(),
+	}
+}
+
+// tmpfsMounts returns the mount options of the tmpfs mounts by mount point. Toolchains run programs
+// they built in /tmp, so the mounts allow executables.
+func (f containerFilesystem) tmpfsMounts() map[string]string {
+	options := fmt.Sprintf("rw,exec,nosuid,nodev,size=%dm,mode=1777", f.TmpfsSizeMB)
+	return map[string]string{"/tmp": options, containerHomeDir: options}
+}
+
+// runArgs returns the flags of a container run that apply the restrictions
+func (f containerFilesystem) runArgs() []string {
+	var args []string
+	if f.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	for _, mountPoint := range []string{"/tmp", containerHomeDir} {
+		args = append(args, "--tmpfs", mountPoint+":"+f.tmpfsMounts()[mountPoint])
+	}
+	return args
+}
+
+// withHome returns the environment of a language with HOME pointing at the home directory tmpfs,
+// unless the language sets HOME itself
+func withHome(env map[string]string) map[string]string {
+	if _, ok := env["HOME"]; ok {
+		return env
+	}
+	env = maps.Clone(env)
+	if env == nil {
+		env = make(map[string]string, 1)
+	}
+	env["HOME"] = containerHomeDir
+	return env
+}
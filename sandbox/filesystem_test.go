@@ -0,0 +1,45 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestOCIExecutorFilesystem(t *testing.T) {
+	t.Run("ReadOnly", func(t *testing.T) {
+		cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {Environment: map[string]string{"LANG": "C.UTF-8"}}}}
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime)
+
+		args, err := executor.runArgs("codebox-exec-1", LanguagePython, Limits{MemoryMB: 128})
+		require.NoError(t, err)
+		assert.Contains(t, args, "--read-only")
+		assert.Subset(t, args, []string{
+			"/tmp:rw,exec,nosuid,nodev,size=128m,mode=1777",
+			containerHomeDir + ":rw,exec,nosuid,nodev,size=128m,mode=1777",
+			"HOME=" + containerHomeDir,
+			"LANG=C.UTF-8",
+		})
+	})
+
+	t.Run("Writable", func(t *testing.T) {
+		cfg := &config.Config{
+			Sandbox: config.SandboxConfig{Filesystem: config.FilesystemConfig{WritableRootfs: true, TmpfsSizeMB: 512}},
+			Languages: map[string]config.Language{
+				LanguageGo: {Environment: map[string]string{"HOME": "/tmp/home"}},
+			},
+		}
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime)
+
+		args, err := executor.runArgs("codebox-exec-1", LanguageGo, Limits{MemoryMB: 128})
+		require.NoError(t, err)
+		assert.NotContains(t, args, "--read-only")
+		assert.Contains(t, args, "/tmp:rw,exec,nosuid,nodev,size=512m,mode=1777")
+		assert.Contains(t, args, "HOME=/tmp/home", "the language keeps its own home directory")
+		assert.NotContains(t, args, "HOME="+containerHomeDir)
+	})
+}
@@ -17,24 +17,67 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
 // ExecuteRequest represents the parameters for code execution
 type ExecuteRequest struct {
 	Language   string
 	Code       string
-	WorkdirTar []byte // decoded base64
-	TimeoutSec int
-	MemoryMB   int
-	Network    bool
+	WorkdirTar []byte        // decoded base64
+	Stdin      []byte        // fed to the run phase, nil for no input
+	TimeoutSec int           // run phase time limit, 0 uses the executor default
+	MemoryMB   int           // memory limit, 0 uses the executor default
+	Network    bool          // enables network access for this request
+	Stream     OutputHandler // receives the output while the code runs, nil for none
+
+	// Setup is a shell command, e.g. pip install -r requirements.txt, that runs in the workdir with network
+	// access before the code, which runs offline unless network access is enabled. Empty for no setup phase.
+	Setup string
+	// SetupTimeoutSec is the time limit of the setup phase, 0 uses the build phase time limit
+	SetupTimeoutSec int
+
+	// Workdir is the persistent workdir of a session to run in instead of a temporary one.
+	// It is kept after the execution and the code file in it is overwritten.
+	Workdir string
+	// SkipArtifacts leaves ArtifactsTar empty, e.g. because the files stay in a session workdir
+	SkipArtifacts bool
 }
 
-// ExecuteResult represents the result of code execution
+// ExecuteResult represents the result of code execution.
+// Stdout, Stderr, ExitCode, Status and Signal mirror the last phase that ran,
+// except that a failed setup is reported as StatusSetupError and a failed build as StatusCompileError.
 type ExecuteResult struct {
 	Stdout       string
 	Stderr       string
 	ExitCode     int
-	ArtifactsTar []byte // raw tar.gz
+	Status       Status
+	Signal       string        // name of the signal that killed the program, if any
+	ArtifactsTar []byte        // raw tar.gz
+	Setup        *PhaseResult  // nil when the request has no setup command
+	Build        *PhaseResult  // nil when the language has no build step
+	Run          *PhaseResult  // nil when the build phase failed
+	Output       OutputStats   // output stream sizes of the last phase that ran
+	Limits       Limits        // limits the execution actually ran under
+	Usage        ResourceUsage // resources used by all phases together
+	Runtime      string        // OCI runtime the containers ran with, empty for the engine default
+}
+
+// setOutput copies the output of a phase into the top-level result fields
+func (r *ExecuteResult) setOutput(phase *PhaseResult) {
+	r.Stdout = phase.Stdout
+	r.Stderr = phase.Stderr
+	r.ExitCode = phase.ExitCode
+	r.Status = phase.Status
+	r.Signal = phase.Signal
+	r.Output = phase.Output
+}
+
+// hasArtifacts reports whether the run phase finished, so the workdir is worth returning
+func (r *ExecuteResult) hasArtifacts() bool {
+	return r.Run != nil && !r.Run.TimedOut
 }
 
 // SandboxExecutor defines the interface for sandbox execution
@@ -42,46 +85,112 @@ type SandboxExecutor interface {
 	Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error)
 }
 
+// Command describes a single process invocation for a CommandRunner
+type Command struct {
+	Args       []string
+	Dir        string       // working directory, empty for the current one
+	Env        []string     // full environment, nil to inherit the server environment
+	Stdin      []byte       // data fed to standard input, nil for none
+	Output     OutputLimits // bounds on the captured output, zero for unlimited
+	StdoutSink io.Writer    // receives the complete stdout as it is produced, nil for none
+	StderrSink io.Writer    // receives the complete stderr as it is produced, nil for none
+	ExtraFiles []*os.File   // open files passed to the command as descriptors 3 and up, nil for none
+
+	// SysProcAttr holds process attributes like the namespaces to start the command in, nil for none.
+	// The command still gets its own process group.
+	SysProcAttr *syscall.SysProcAttr
+}
+
+// CommandResult holds the captured output of a finished command
+type CommandResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Signal   string        // name of the signal that killed the process, if any
+	Usage    ResourceUsage // resources used by the process and its children
+	Output   OutputStats   // sizes of the output streams and whether they were truncated
+
+	// OutputLimitExceeded is set when the command was killed for exceeding its output limits
+	OutputLimitExceeded bool
+}
+
 // CommandRunner defines an interface for executing system commands
 type CommandRunner interface {
-	RunCommand(ctx context.Context, args []string) (stdout, stderr string, exitCode int, err error)
+	RunCommand(ctx context.Context, cmd Command) (CommandResult, error)
 }
 
 // RealCommandRunner implements CommandRunner using actual exec commands
 type RealCommandRunner struct{}
 
 // RunCommand executes the given command with arguments
-func (RealCommandRunner) RunCommand(ctx context.Context, args []string) (stdout, stderr string, exitCode int, err error) {
-	if len(args) < 1 {
-		return "", "", 0, fmt.Errorf("no command provided")
+func (RealCommandRunner) RunCommand(ctx context.Context, command Command) (CommandResult, error) {
+	if len(command.Args) < 1 {
+		return CommandResult{}, fmt.Errorf("no command provided")
+	}
+
+	// The command is also stopped when its output exceeds the limits and it should be killed for it
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	var limitExceeded atomic.Bool
+	onExceed := func() {
+		if command.Output.KillOnExceed {
+			limitExceeded.Store(true)
+			stop()
+		}
 	}
 
-	cmd := exec.CommandContext(ctx, args[0], args[1:]...) //nolint:gosec // Safe as this is controlled input
+	cmd := exec.CommandContext(runCtx, command.Args[0], command.Args[1:]...) //nolint:gosec // Safe as this is controlled input
+	if command.SysProcAttr != nil {
+		attr := *command.SysProcAttr
+		cmd.SysProcAttr = &attr
+	}
+	configureProcessGroup(cmd)
+	cmd.Dir = command.Dir
+	cmd.Env = command.Env
+	cmd.ExtraFiles = command.ExtraFiles
+	if command.Stdin != nil {
+		cmd.Stdin = bytes.NewReader(command.Stdin)
+	}
 
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
+	stdoutBuf := newCappedBuffer(command.Output.StdoutBytes, command.StdoutSink, onExceed)
+	stderrBuf := newCappedBuffer(command.Output.StderrBytes, command.StderrSink, onExceed)
+	cmd.Stdout = stdoutBuf
+	cmd.Stderr = stderrBuf
 
-	err = cmd.Run()
+	start := time.Now()
+	err := cmd.Run()
+	usage := processUsage(cmd.ProcessState, time.Since(start))
+	signal := exitSignal(cmd.ProcessState)
 
-	exitCode = 0
+	exitCode := 0
 	if err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			exitCode = exitError.ExitCode()
-		} else {
-			return "", "", 0, err
+		} else if ctx.Err() == nil && !limitExceeded.Load() {
+			return CommandResult{}, err
 		}
 	}
 
-	return stdoutBuf.String(), stderrBuf.String(), exitCode, nil
+	output := OutputStats{
+		StdoutBytes:     stdoutBuf.total,
+		StderrBytes:     stderrBuf.total,
+		StdoutTruncated: stdoutBuf.truncated(),
+		StderrTruncated: stderrBuf.truncated(),
+	}
+
+	return CommandResult{Stdout: stdoutBuf.String(), Stderr: stderrBuf.String(), ExitCode: exitCode, Signal: signal, Usage: usage,
+		Output: output, OutputLimitExceeded: limitExceeded.Load(),
+	}, nil
 }
 
 // FileSystem defines an interface for file system operations
 type FileSystem interface {
 	MkdirTemp(dir, pattern string) (string, error)
 	MkdirAll(path string, perm os.FileMode) error
+	Chmod(name string, perm os.FileMode) error
 	WriteFile(filename string, data []byte, perm os.FileMode) error
 	ReadFile(filename string) ([]byte, error)
+	ReadDir(name string) ([]os.DirEntry, error)
 	RemoveAll(path string) error
 	FileExists(path string) (bool, error)
 }
@@ -97,6 +206,10 @@ func (RealFileSystem) MkdirAll(path string, perm os.FileMode) error {
 	return os.MkdirAll(path, perm)
 }
 
+func (RealFileSystem) Chmod(name string, perm os.FileMode) error {
+	return os.Chmod(name, perm)
+}
+
 func (RealFileSystem) WriteFile(filename string, data []byte, perm os.FileMode) error {
 	return os.WriteFile(filename, data, perm)
 }
@@ -105,6 +218,10 @@ func (RealFileSystem) ReadFile(filename string) ([]byte, error) {
 	return os.ReadFile(filename)
 }
 
+func (RealFileSystem) ReadDir(name string) ([]os.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
 func (RealFileSystem) RemoveAll(path string) error {
 	return os.RemoveAll(path)
 }
@@ -148,36 +265,22 @@ const (
 	DirNodeModules = "node_modules"
 )
 
-// GetCodeFileName returns the appropriate filename based on the language
+// GetCodeFileName returns the built-in source filename for the language
 func GetCodeFileName(language string) (string, error) {
-	switch language {
-	case LanguagePython:
-		return FilenamePython, nil
-	case LanguageNodeJS:
-		return FilenameNodeJS, nil
-	case LanguageGo:
-		return FilenameGo, nil
-	case LanguageCPP:
-		return FilenameCPP, nil
-	default:
-		return "", fmt.Errorf("unsupported language: %s", language)
+	lang, err := ResolveLanguage(nil, language)
+	if err != nil {
+		return "", err
 	}
+	return lang.SourceFile, nil
 }
 
-// GetRunCommand returns the appropriate run command based on the language
+// GetRunCommand returns the built-in shell command that builds and runs the language
 func GetRunCommand(language string) (string, error) {
-	switch language {
-	case LanguagePython:
-		return fmt.Sprintf("python %s", FilenamePython), nil
-	case LanguageNodeJS:
-		return fmt.Sprintf("node %s", FilenameNodeJS), nil
-	case LanguageGo:
-		return fmt.Sprintf("go build -o app %s && ./app", FilenameGo), nil
-	case LanguageCPP:
-		return fmt.Sprintf("g++ -std=c++17 -O2 -o app %s && ./app", FilenameCPP), nil
-	default:
-		return "", fmt.Errorf("unsupported language: %s", language)
+	lang, err := ResolveLanguage(nil, language)
+	if err != nil {
+		return "", err
 	}
+	return lang.Command(), nil
 }
 
 // ExtractTarToDir extracts tar.gz data to the destination directory safely
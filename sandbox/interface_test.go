@@ -39,7 +39,7 @@ func TestGetRunCommand(t *testing.T) {
 		expected string
 		hasError bool
 	}{
-		{LanguagePython, "python main.py", false},
+		{LanguagePython, "python3 main.py", false},
 		{LanguageNodeJS, "node index.js", false},
 		{LanguageGo, "go build -o app main.go && ./app", false},
 		{LanguageCPP, "g++ -std=c++17 -O2 -o app main.cpp && ./app", false},
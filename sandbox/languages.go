@@ -0,0 +1,171 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The language registry describes how source
+// code for each configured language is written, built and run, so that adding
+// a language is a configuration change rather than a code change.
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// DefaultImage is the container image used when a language does not configure one
+const DefaultImage = "alpine:latest"
+
+// WorkdirPlaceholder stands for the workdir in build_cmd and run_cmd. It is replaced with WorkDirPath
+// in a sandbox and with the host directory of the workdir on the local backend.
+const WorkdirPlaceholder = "{workdir}"
+
+// Language describes how code in a single language is written, built and run
+type Language struct {
+	Name       string
+	Image      string
+	SourceFile string
+	Extension  string
+	BuildCmd   string
+	RunCmd     string
+}
+
+// Compiled reports whether the language has a separate build step
+func (l Language) Compiled() bool {
+	return l.BuildCmd != ""
+}
+
+// Command returns the full shell command that builds (if needed) and runs the code
+func (l Language) Command() string {
+	if !l.Compiled() {
+		return l.RunCmd
+	}
+	return fmt.Sprintf("%s && %s", l.BuildCmd, l.RunCmd)
+}
+
+// builtinLanguages holds the defaults for languages that work without any configuration
+var builtinLanguages = map[string]Language{
+	LanguagePython: {
+		Image:      "python:3.11-slim",
+		SourceFile: FilenamePython,
+		RunCmd:     "python3 " + FilenamePython,
+	},
+	LanguageNodeJS: {
+		Image:      "node:20-alpine",
+		SourceFile: FilenameNodeJS,
+		RunCmd:     "node " + FilenameNodeJS,
+	},
+	LanguageGo: {
+		Image:      "golang:1.23-alpine",
+		SourceFile: FilenameGo,
+		BuildCmd:   "go build -o app " + FilenameGo,
+		RunCmd:     "./app",
+	},
+	LanguageCPP: {
+		Image:      "gcc:13",
+		SourceFile: FilenameCPP,
+		BuildCmd:   "g++ -std=c++17 -O2 -o app " + FilenameCPP,
+		RunCmd:     "./app",
+	},
+}
+
+// ResolveLanguage merges the configuration of a language with its built-in defaults.
+// Configured values always win; languages without built-in defaults must at least
+// configure run_cmd and either source_file or file_extension.
+func ResolveLanguage(languages map[string]config.Language, name string) (Language, error) {
+	return resolveLanguageAt(languages, name, WorkDirPath)
+}
+
+// resolveLanguageAt resolves a language whose commands run in the workdir at workdirPath
+func resolveLanguageAt(languages map[string]config.Language, name, workdirPath string) (Language, error) {
+	langConfig, configured := languages[name]
+	lang, builtin := builtinLanguages[name]
+	if !configured && !builtin {
+		return Language{}, fmt.Errorf("unsupported language: %s", name)
+	}
+
+	lang.Name = name
+	if langConfig.Image != "" {
+		lang.Image = langConfig.Image
+	}
+	if lang.Image == "" {
+		lang.Image = DefaultImage
+	}
+	if langConfig.BuildCmd != "" {
+		lang.BuildCmd = langConfig.BuildCmd
+	}
+	if langConfig.RunCmd != "" {
+		lang.RunCmd = langConfig.RunCmd
+	}
+
+	switch {
+	case langConfig.SourceFile != "":
+		lang.SourceFile = langConfig.SourceFile
+	case langConfig.FileExtension != "":
+		lang.SourceFile = "main" + normalizeExtension(langConfig.FileExtension)
+	}
+	lang.Extension = filepath.Ext(lang.SourceFile)
+	if langConfig.FileExtension != "" {
+		lang.Extension = normalizeExtension(langConfig.FileExtension)
+	}
+
+	if lang.RunCmd == "" {
+		return Language{}, fmt.Errorf("language %s: run_cmd is required", name)
+	}
+	if lang.SourceFile == "" {
+		return Language{}, fmt.Errorf("language %s: source_file or file_extension is required", name)
+	}
+	if lang.SourceFile != filepath.Base(lang.SourceFile) || lang.SourceFile == "." || lang.SourceFile == ".." {
+		return Language{}, fmt.Errorf("language %s: source_file must be a plain file name, got: %s", name, lang.SourceFile)
+	}
+
+	lang.BuildCmd = strings.ReplaceAll(lang.BuildCmd, WorkdirPlaceholder, workdirPath)
+	lang.RunCmd = strings.ReplaceAll(lang.RunCmd, WorkdirPlaceholder, workdirPath)
+	return lang, nil
+}
+
+// normalizeExtension makes sure a file extension starts with a dot
+func normalizeExtension(ext string) string {
+	if strings.HasPrefix(ext, ".") {
+		return ext
+	}
+	return "." + ext
+}
+
+// LanguageRegistry holds the resolved set of languages from the configuration
+type LanguageRegistry struct {
+	languages map[string]Language
+}
+
+// NewLanguageRegistry resolves and validates every language in the languages section
+func NewLanguageRegistry(languages map[string]config.Language) (*LanguageRegistry, error) {
+	registry := &LanguageRegistry{languages: make(map[string]Language, len(languages))}
+
+	for name := range languages {
+		lang, err := ResolveLanguage(languages, name)
+		if err != nil {
+			return nil, err
+		}
+		registry.languages[name] = lang
+	}
+
+	return registry, nil
+}
+
+// Get returns the language with the given name
+func (r *LanguageRegistry) Get(name string) (Language, bool) {
+	lang, ok := r.languages[name]
+	return lang, ok
+}
+
+// Names returns the sorted names of all registered languages
+func (r *LanguageRegistry) Names() []string {
+	names := make([]string, 0, len(r.languages))
+	for name := range r.languages {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
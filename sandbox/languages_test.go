@@ -0,0 +1,145 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestResolveLanguage(t *testing.T) {
+	t.Run("BuiltinWithoutConfig", func(t *testing.T) {
+		lang, err := ResolveLanguage(nil, LanguageGo)
+		require.NoError(t, err)
+		assert.Equal(t, LanguageGo, lang.Name)
+		assert.Equal(t, "golang:1.23-alpine", lang.Image)
+		assert.Equal(t, FilenameGo, lang.SourceFile)
+		assert.Equal(t, ".go", lang.Extension)
+		assert.True(t, lang.Compiled())
+	})
+
+	t.Run("ConfigOverridesBuiltin", func(t *testing.T) {
+		languages := map[string]config.Language{
+			LanguageGo: {
+				Image:    "golang:1.25",
+				BuildCmd: "go build -o /workdir/app /workdir/main.go",
+				RunCmd:   "/workdir/app",
+			},
+		}
+
+		lang, err := ResolveLanguage(languages, LanguageGo)
+		require.NoError(t, err)
+		assert.Equal(t, "golang:1.25", lang.Image)
+		assert.Equal(t, FilenameGo, lang.SourceFile)
+		assert.Equal(t, "go build -o /workdir/app /workdir/main.go && /workdir/app", lang.Command())
+	})
+
+	t.Run("WorkdirPlaceholder", func(t *testing.T) {
+		languages := map[string]config.Language{
+			LanguageGo: {BuildCmd: "go build -o {workdir}/app {workdir}/main.go", RunCmd: "{workdir}/app -dir /workdir"},
+		}
+
+		lang, err := ResolveLanguage(languages, LanguageGo)
+		require.NoError(t, err)
+		assert.Equal(t, "go build -o /workdir/app /workdir/main.go && /workdir/app -dir /workdir", lang.Command())
+
+		lang, err = resolveLanguageAt(languages, LanguageGo, "/tmp/codebox-exec-1/workdir")
+		require.NoError(t, err)
+		assert.Equal(t, "go build -o /tmp/codebox-exec-1/workdir/app /tmp/codebox-exec-1/workdir/main.go", lang.BuildCmd)
+		assert.Equal(t, "/tmp/codebox-exec-1/workdir/app -dir /workdir", lang.RunCmd, "only the placeholder is replaced")
+	})
+
+	t.Run("CustomLanguageFromExtension", func(t *testing.T) {
+		languages := map[string]config.Language{
+			"ruby": {Image: "ruby:3.3-slim", FileExtension: "rb", RunCmd: "ruby main.rb"},
+		}
+
+		lang, err := ResolveLanguage(languages, "ruby")
+		require.NoError(t, err)
+		assert.Equal(t, "main.rb", lang.SourceFile)
+		assert.Equal(t, ".rb", lang.Extension)
+		assert.False(t, lang.Compiled())
+		assert.Equal(t, "ruby main.rb", lang.Command())
+	})
+
+	t.Run("CustomLanguageWithSourceFile", func(t *testing.T) {
+		languages := map[string]config.Language{
+			"rust": {
+				Image:      "rust:1-slim",
+				SourceFile: "main.rs",
+				BuildCmd:   "rustc -O -o app main.rs",
+				RunCmd:     "./app",
+			},
+		}
+
+		lang, err := ResolveLanguage(languages, "rust")
+		require.NoError(t, err)
+		assert.Equal(t, ".rs", lang.Extension)
+		assert.Equal(t, "rustc -O -o app main.rs && ./app", lang.Command())
+	})
+
+	t.Run("CustomLanguageDefaultImage", func(t *testing.T) {
+		languages := map[string]config.Language{
+			"shell": {FileExtension: ".sh", RunCmd: "sh main.sh"},
+		}
+
+		lang, err := ResolveLanguage(languages, "shell")
+		require.NoError(t, err)
+		assert.Equal(t, DefaultImage, lang.Image)
+	})
+
+	t.Run("MissingRunCmd", func(t *testing.T) {
+		languages := map[string]config.Language{"ruby": {FileExtension: "rb"}}
+
+		_, err := ResolveLanguage(languages, "ruby")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "run_cmd is required")
+	})
+
+	t.Run("MissingSourceFile", func(t *testing.T) {
+		languages := map[string]config.Language{"ruby": {RunCmd: "ruby main.rb"}}
+
+		_, err := ResolveLanguage(languages, "ruby")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "source_file or file_extension is required")
+	})
+
+	t.Run("SourceFileWithPath", func(t *testing.T) {
+		languages := map[string]config.Language{"ruby": {SourceFile: "../main.rb", RunCmd: "ruby main.rb"}}
+
+		_, err := ResolveLanguage(languages, "ruby")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "plain file name")
+	})
+
+	t.Run("UnknownLanguage", func(t *testing.T) {
+		_, err := ResolveLanguage(nil, "cobol")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unsupported language")
+	})
+}
+
+func TestLanguageRegistry(t *testing.T) {
+	t.Run("OnlyConfiguredLanguages", func(t *testing.T) {
+		registry, err := NewLanguageRegistry(map[string]config.Language{
+			LanguagePython: {},
+			"ruby":         {FileExtension: "rb", RunCmd: "ruby main.rb"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{LanguagePython, "ruby"}, registry.Names())
+
+		lang, ok := registry.Get("ruby")
+		require.True(t, ok)
+		assert.Equal(t, "main.rb", lang.SourceFile)
+
+		_, ok = registry.Get(LanguageGo)
+		assert.False(t, ok)
+	})
+
+	t.Run("InvalidLanguage", func(t *testing.T) {
+		_, err := NewLanguageRegistry(map[string]config.Language{"ruby": {}})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,52 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Every execution runs under resource limits
+// that default to the executor configuration and can be overridden per request.
+package sandbox
+
+import "time"
+
+// Limits are the resource limits applied to a single execution
+type Limits struct {
+	TimeoutSec int  // time limit of the run phase
+	MemoryMB   int  // memory limit of each phase
+	Network    bool // whether the sandbox has network access
+}
+
+// RunTimeout returns the run phase time limit as a duration
+func (l Limits) RunTimeout() time.Duration {
+	return time.Duration(l.TimeoutSec) * time.Second
+}
+
+// limits resolves the limits of an execution, using the executor defaults for unset values.
+// A request can enable network access but cannot disable it when the executor enables it.
+func (c *Config) limits(timeoutSec, memoryMB int, network bool) Limits {
+	limits := Limits{
+		TimeoutSec: c.TimeoutSec,
+		MemoryMB:   c.MemoryMB,
+		Network:    c.NetworkEnabled || network,
+	}
+	if timeoutSec > 0 {
+		limits.TimeoutSec = timeoutSec
+	}
+	if memoryMB > 0 {
+		limits.MemoryMB = memoryMB
+	}
+	return limits
+}
+
+// unlimitedMemory returns the limits reported by backends that do not limit memory, with MemoryMB 0
+func (l Limits) unlimitedMemory() Limits {
+	l.MemoryMB = 0
+	return l
+}
+
+// forPhase returns the limits of a single phase. The setup phase always has network access,
+// so that it can install dependencies for the phases after it, which keep the limits of the execution.
+func (l Limits) forPhase(phase string) Limits {
+	if phase == PhaseSetup {
+		l.Network = true
+	}
+	return l
+}
@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestConfigLimits(t *testing.T) {
+	executorConfig := &Config{TimeoutSec: 10, MemoryMB: 256}
+
+	t.Run("Defaults", func(t *testing.T) {
+		limits := executorConfig.limits(0, 0, false)
+		assert.Equal(t, Limits{TimeoutSec: 10, MemoryMB: 256}, limits)
+		assert.Equal(t, 10*time.Second, limits.RunTimeout())
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		assert.Equal(t, Limits{TimeoutSec: 60, MemoryMB: 1024, Network: true}, executorConfig.limits(60, 1024, true))
+	})
+
+	t.Run("NetworkEnabledByExecutor", func(t *testing.T) {
+		networked := &Config{TimeoutSec: 10, MemoryMB: 256, NetworkEnabled: true}
+		assert.True(t, networked.limits(0, 0, false).Network)
+	})
+}
+
+func TestDockerExecutorRequestLimits(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}}}
+
+	runner := &FuncCommandRunner{}
+	executor := NewDockerExecutor(logger, executorConfig, cfg, WithDockerCommandRunner(runner))
+
+	t.Run("ExecutorDefaults", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print(1)"})
+		require.NoError(t, err)
+		assert.Equal(t, Limits{TimeoutSec: 5, MemoryMB: 128}, result.Limits)
+
+		args := runner.RunCalls()[0]
+		assert.Contains(t, args, "128m")
+		assert.NotContains(t, args, "bridge")
+	})
+
+	t.Run("RequestOverrides", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language:   LanguagePython,
+			Code:       "print(1)",
+			TimeoutSec: 30,
+			MemoryMB:   1024,
+			Network:    true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, Limits{TimeoutSec: 30, MemoryMB: 1024, Network: true}, result.Limits)
+
+		calls := runner.RunCalls()
+		args := calls[len(calls)-1]
+		memory := slices.Index(args, "--memory")
+		require.NotEqual(t, -1, memory)
+		assert.Equal(t, "1024m", args[memory+1])
+		assert.Contains(t, args, "bridge")
+	})
+}
+
+func TestLocalExecutorReportsEnforcedLimits(t *testing.T) {
+	runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) { return CommandResult{}, nil }}
+	executor := NewLocalExecutor(zaptest.NewLogger(t), &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5},
+		&config.Config{}, WithLocalCommandRunner(runner))
+
+	result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print(1)", MemoryMB: 512})
+	require.NoError(t, err)
+	assert.Equal(t, Limits{TimeoutSec: 5, Network: true}, result.Limits, "processes share the memory and network of the host")
+}
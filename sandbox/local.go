@@ -7,13 +7,10 @@
 package sandbox
 
 import (
-	"bytes"
 	"context"
 	"fmt"
 	"os"
 	"os/exec"
-	"path/filepath"
-	"time"
 
 	"go.uber.org/zap"
 
@@ -27,6 +24,7 @@ type LocalExecutor struct {
 	cfg       *config.Config // Reference to the full configuration
 	cmdRunner CommandRunner
 	fs        FileSystem
+	workdirs  hostWorkdirs
 }
 
 // LocalExecutorOption defines a functional option for LocalExecutor
@@ -60,171 +58,140 @@ func NewLocalExecutor(logger *zap.Logger, executorConfig *Config, cfg *config.Co
 	for _, opt := range opts {
 		opt(executor)
 	}
+	executor.workdirs = hostWorkdirs{logger: logger, config: executorConfig, cfg: cfg, fs: executor.fs}
 
 	return executor
 }
 
 // Execute runs the code locally (WARNING: This is not secure and should only be used for development)
 //
-//nolint:gocyclo,funlen,gocritic // Complex function intentionally handles multiple languages with large request struct
+//nolint:gocritic // Large request struct is passed by value to satisfy the SandboxExecutor interface
 func (l *LocalExecutor) Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error) {
-	// Create a temporary directory for this execution
-	tempDir, err := os.MkdirTemp("", "codebox-exec-*")
+	workdirPath, lang, cleanup, err := l.prepareWorkdir(req.Language, req.Code, req.Workdir, req.WorkdirTar)
 	if err != nil {
-		return ExecuteResult{}, fmt.Errorf("failed to create temp dir: %w", err)
+		return ExecuteResult{}, err
 	}
-	defer os.RemoveAll(tempDir)
+	defer cleanup()
 
-	// Prepare the working directory
-	workdirPath := filepath.Join(tempDir, "workdir")
-	if mkdirErr := os.MkdirAll(workdirPath, DirPermission); mkdirErr != nil {
-		return ExecuteResult{}, fmt.Errorf("failed to create workdir: %w", mkdirErr)
+	// Run the build phase (if any) and the run phase as separate processes
+	limits := l.config.limits(req.TimeoutSec, req.MemoryMB, req.Network)
+	phase := l.processPhase(req.Language, workdirPath, l.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, l.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
 	}
+	// Processes share the memory and network of the host, so only the time limit is enforced
+	result.Limits = Limits{TimeoutSec: limits.TimeoutSec, Network: true}
 
-	// If workdir_tar is provided, extract it
-	if len(req.WorkdirTar) > 0 {
-		if extractErr := l.extractTarToDir(req.WorkdirTar, workdirPath); extractErr != nil {
-			return ExecuteResult{}, fmt.Errorf("failed to extract workdir_tar: %w", extractErr)
-		}
+	// Only return artifacts when the run phase actually finished and the caller wants them
+	if err := l.workdirs.collectArtifacts(&result, &req, workdirPath); err != nil {
+		return ExecuteResult{}, err
 	}
+	return result, nil
+}
 
-	// Write the user code to the appropriate file based on language
-	codeFileName, getErr := l.getCodeFileName(req.Language)
-	if getErr != nil {
-		return ExecuteResult{}, fmt.Errorf("invalid language: %w", getErr)
-	}
-
-	// Apply hooks for interpreted languages using config
-	finalCode := l.applyHooksFromConfig(req.Language, req.Code)
-
-	codeFilePath := filepath.Join(workdirPath, codeFileName)
-	if writeErr := l.fs.WriteFile(codeFilePath, []byte(finalCode), FilePermission); writeErr != nil {
-		return ExecuteResult{}, fmt.Errorf("failed to write user code: %w", writeErr)
-	}
-
-	// Execute with timeout
-	ctxWithTimeout, cancel := context.WithTimeout(ctx, time.Duration(l.config.TimeoutSec)*time.Second)
-	defer cancel()
-
-	// Build the command based on language
-	var cmd *exec.Cmd
-	switch req.Language {
-	case LanguagePython:
-		cmd = exec.CommandContext(ctxWithTimeout, "python3", codeFilePath)
-	case LanguageNodeJS:
-		cmd = exec.CommandContext(ctxWithTimeout, "node", codeFilePath)
-	case LanguageGo:
-		// Build and run Go code
-		//nolint:gosec // Building code is intended functionality
-		buildCmd := exec.CommandContext(ctxWithTimeout, "go", "build", "-o", filepath.Join(workdirPath, "app"), codeFilePath)
-		buildCmd.Dir = workdirPath
-		if buildErr := buildCmd.Run(); buildErr != nil {
-			return ExecuteResult{
-				Stdout:       "",
-				Stderr:       fmt.Sprintf("Build error: %v", buildErr),
-				ExitCode:     1,
-				ArtifactsTar: []byte{},
-			}, nil
-		}
-		//nolint:gosec // Running built app is intended functionality
-		cmd = exec.CommandContext(ctxWithTimeout, filepath.Join(workdirPath, "app"))
-	case LanguageCPP:
-		// Compile and run C++ code
-		binaryPath := filepath.Join(workdirPath, "app")
-		compileCmd := exec.CommandContext(ctxWithTimeout, "g++", "-std=c++17", "-O2", "-o", binaryPath, codeFilePath)
-		compileCmd.Dir = workdirPath
-		if compileErr := compileCmd.Run(); compileErr != nil {
-			return ExecuteResult{
-				Stdout:       "",
-				Stderr:       fmt.Sprintf("Compile error: %v", compileErr),
-				ExitCode:     1,
-				ArtifactsTar: []byte{},
-			}, nil
-		}
-		cmd = exec.CommandContext(ctxWithTimeout, binaryPath)
-	default:
-		return ExecuteResult{}, fmt.Errorf("unsupported language: %s", req.Language)
+// ExecuteBatch builds the code once and runs it against every test case in the same workdir
+//
+//nolint:gocritic // Large request struct is passed by value to mirror Execute
+func (l *LocalExecutor) ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error) {
+	workdirPath, lang, cleanup, err := l.prepareWorkdir(req.Language, req.Code, "", req.WorkdirTar)
+	if err != nil {
+		return BatchResult{}, err
 	}
+	defer cleanup()
 
-	// Set working directory
-	cmd.Dir = workdirPath
+	phase := l.processPhase(req.Language, workdirPath, phaseCapture{})
+	return runTestCases(ctx, lang, l.workdirs.buildTimeout(), l.config.limits(0, 0, false).RunTimeout(), &req, phase)
+}
 
+// prepareWorkdir prepares the workdir of an execution and points the commands of the language at it,
+// since local processes see the workdir at its host path
+func (l *LocalExecutor) prepareWorkdir(language, code, sessionWorkdir string, workdirTar []byte) (string, Language, func(), error) {
+	workdirPath, _, cleanup, err := l.workdirs.prepare(language, code, sessionWorkdir, workdirTar)
+	if err != nil {
+		return "", Language{}, nil, err
+	}
+
+	lang, err := resolveLanguageAt(l.cfg.Languages, language, workdirPath)
+	if err != nil {
+		cleanup()
+		return "", Language{}, nil, fmt.Errorf("invalid language: %w", err)
+	}
+	return workdirPath, lang, cleanup, nil
+}
+
+// processPhase returns a phaseFunc that runs every phase as a local process in the workdir.
+// capture decides where the output goes besides the phase result.
+func (l *LocalExecutor) processPhase(language, workdirPath string, capture phaseCapture) phaseFunc {
+	return func(ctx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return l.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			return l.runProcess(ctx, language, workdirPath, command, base)
+		})
+	}
+}
+
+// runProcess runs a single shell command as a local process inside the workdir.
+// base carries the stdin and output capture of the phase.
+func (l *LocalExecutor) runProcess(ctx context.Context, language, workdirPath, command string, base Command) (PhaseResult, error) {
 	// Set environment variables based on language
-	envVars := l.getEnvironmentVariables(req.Language)
+	envVars := l.getEnvironmentVariables(language)
 
 	// Start with existing environment
-	cmd.Env = os.Environ()
+	env := os.Environ()
 
 	// Add custom environment variables
 	for key, value := range envVars {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, value))
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 
-	// Capture stdout and stderr
-	var stdoutBuf, stderrBuf bytes.Buffer
-	cmd.Stdout = &stdoutBuf
-	cmd.Stderr = &stderrBuf
-
-	// Start the command
-	err = cmd.Run()
+	base.Args = []string{"sh", "-c", command}
+	base.Dir = workdirPath
+	base.Env = env
+	output, err := l.cmdRunner.RunCommand(ctx, base)
 
-	// If the context timed out, handle it explicitly
-	if ctxWithTimeout.Err() == context.DeadlineExceeded {
-		return ExecuteResult{
-			Stdout:       stdoutBuf.String(),
-			Stderr:       stderrBuf.String() + "\nExecution timed out",
-			ExitCode:     1,
-			ArtifactsTar: []byte{}, // Empty artifacts on timeout
-		}, nil
+	// A timeout is reported by the caller, keep whatever output was produced
+	if ctx.Err() != nil {
+		return PhaseResult{Stdout: output.Stdout, Stderr: output.Stderr, Usage: output.Usage, Output: output.Output}, ctx.Err()
 	}
 
-	// Get the exit code
-	exitCode := 0
 	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode = exitError.ExitCode()
-		} else {
-			return ExecuteResult{}, fmt.Errorf("failed to execute command: %w", err)
-		}
+		return PhaseResult{}, fmt.Errorf("failed to execute command: %w", err)
 	}
 
-	// Determine exclude patterns based on language from config
-	var excludePatterns []string
-	if langConfig, exists := l.cfg.Languages[req.Language]; exists {
-		excludePatterns = langConfig.ExcludePatterns
-	}
+	return phaseOutput(&output), nil
+}
 
-	// Create artifacts tar from the workdir with exclude patterns
-	artifactsTar, err := l.createTarFromDirWithExcludes(workdirPath, excludePatterns)
+// StartRepl starts a REPL interpreter as a local process inside the session workdir
+func (l *LocalExecutor) StartRepl(ctx context.Context, req ReplRequest) (*Repl, error) {
+	if _, err := l.resolveLanguage(req.Language); err != nil {
+		return nil, err
+	}
+	command, err := replCommand(req.Language)
 	if err != nil {
-		return ExecuteResult{}, fmt.Errorf("failed to create artifacts tar: %w", err)
+		return nil, err
 	}
 
-	// Check artifact size
-	if len(artifactsTar) > l.config.MaxArtifactSizeMB*1024*1024 {
-		return ExecuteResult{}, fmt.Errorf("artifacts size exceeds limit: %d bytes > %d bytes",
-			len(artifactsTar), l.config.MaxArtifactSizeMB*MaxArtifactSizeMul)
+	env := os.Environ()
+	for key, value := range l.getEnvironmentVariables(req.Language) {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
 	}
 
-	return ExecuteResult{
-		Stdout:       stdoutBuf.String(),
-		Stderr:       stderrBuf.String(),
-		ExitCode:     exitCode,
-		ArtifactsTar: artifactsTar,
-	}, nil
-}
-
-// Helper functions (same as other executors)
-func (*LocalExecutor) getCodeFileName(language string) (string, error) {
-	return GetCodeFileName(language)
+	cmd := exec.Command(command[0], command[1:]...) //nolint:gosec // The interpreter and driver are fixed
+	cmd.Dir = req.Workdir
+	cmd.Env = env
+	interrupt := func() error {
+		if cmd.Process == nil {
+			return ErrReplExited
+		}
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	return startRepl(ctx, req, cmd, l.config.MaxStdoutBytes, interrupt, func() {})
 }
 
-func (l *LocalExecutor) extractTarToDir(tarData []byte, destDir string) error {
-	return ExtractTarToDir(l.fs, tarData, destDir)
-}
+// Helper functions
 
-func (*LocalExecutor) createTarFromDirWithExcludes(srcDir string, excludePatterns []string) ([]byte, error) {
-	return CreateTarFromDirWithExcludes(srcDir, excludePatterns)
+func (l *LocalExecutor) resolveLanguage(language string) (Language, error) {
+	return ResolveLanguage(l.cfg.Languages, language)
 }
 
 func (l *LocalExecutor) getEnvironmentVariables(language string) map[string]string {
@@ -233,15 +200,3 @@ func (l *LocalExecutor) getEnvironmentVariables(language string) map[string]stri
 	}
 	return make(map[string]string)
 }
-
-// applyHooksFromConfig applies hooks for code execution based on language from config
-func (l *LocalExecutor) applyHooksFromConfig(language, code string) string {
-	var prefixCode, postfixCode string
-
-	if langConfig, exists := l.cfg.Languages[language]; exists {
-		prefixCode = langConfig.PrefixCode
-		postfixCode = langConfig.PostfixCode
-	}
-
-	return prefixCode + code + postfixCode
-}
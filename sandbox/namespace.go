@@ -0,0 +1,346 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The NamespaceExecutor isolates code with
+// Linux primitives directly, without a container daemon: every phase runs in
+// new user, mount, pid, ipc, uts, cgroup and network namespaces with a cgroup
+// v2 leaf for its limits, inside a prepared root filesystem directory that it
+// cannot write to, with no capabilities and a seccomp filter. Because the
+// sandbox runs in a user namespace, the server does not need to be root.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// The init of a namespace sandbox is the server binary itself, re-executed with its spec in namespaceInitEnv
+const (
+	namespaceInitEnv    = "_CODEBOX_NAMESPACE_INIT"
+	namespaceInitPath   = "/proc/self/exe"
+	namespaceStatusFile = "exit_status" // written by the init into the usage dir once the command finished
+	namespaceShell      = "/bin/sh"
+
+	// nobodyID is the host user and group that root in the sandbox maps to when the server runs as root
+	nobodyID = 65534
+)
+
+// namespaceMountPoints are the directories the init mounts over in the root filesystem
+var namespaceMountPoints = []string{WorkDirPath, "/proc", "/dev", "/tmp"}
+
+// namespaceSpec describes the sandbox that the init sets up before it runs the command
+type namespaceSpec struct {
+	Rootfs     string   `json:"rootfs"`
+	Workdir    string   `json:"workdir"` // host directory mounted at WorkDirPath
+	Args       []string `json:"args"`
+	Env        []string `json:"env"`
+	Network    bool     `json:"network"`     // the sandbox shares the network of the host instead of a private loopback
+	StatusFile string   `json:"status_file"` // host file that receives the exit status of the command
+}
+
+// NamespaceExecutor implements SandboxExecutor with Linux namespaces, cgroups and seccomp
+type NamespaceExecutor struct {
+	logger    *zap.Logger
+	config    *Config
+	cfg       *config.Config // Reference to the full configuration
+	cmdRunner CommandRunner
+	fs        FileSystem
+	workdirs  hostWorkdirs
+	cgroups   *cgroupParent
+	hostID    int // host user and group id that root in the sandbox maps to
+}
+
+// NamespaceExecutorOption defines a functional option for NamespaceExecutor
+type NamespaceExecutorOption func(*NamespaceExecutor)
+
+// WithNamespaceCommandRunner sets the CommandRunner for NamespaceExecutor
+func WithNamespaceCommandRunner(cmdRunner CommandRunner) NamespaceExecutorOption {
+	return func(n *NamespaceExecutor) {
+		n.cmdRunner = cmdRunner
+	}
+}
+
+// WithNamespaceFileSystem sets the FileSystem for NamespaceExecutor
+func WithNamespaceFileSystem(fs FileSystem) NamespaceExecutorOption {
+	return func(n *NamespaceExecutor) {
+		n.fs = fs
+	}
+}
+
+// NewNamespaceExecutor creates a new NamespaceExecutor. It fails when the kernel does not allow
+// user namespaces, when the cgroup controllers are not delegated to the server or when a root
+// filesystem of the configured languages is missing.
+func NewNamespaceExecutor(
+	logger *zap.Logger,
+	executorConfig *Config,
+	cfg *config.Config,
+	opts ...NamespaceExecutorOption,
+) (*NamespaceExecutor, error) {
+	executor := &NamespaceExecutor{
+		logger:    logger,
+		config:    executorConfig,
+		cfg:       cfg,
+		cmdRunner: &RealCommandRunner{}, // Default implementation
+		fs:        &RealFileSystem{},    // Default implementation
+		hostID:    os.Geteuid(),
+	}
+
+	// Root in the sandbox must not be root on the host
+	if executor.hostID == 0 {
+		executor.hostID = nobodyID
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(executor)
+	}
+	executor.workdirs = hostWorkdirs{logger: logger, config: executorConfig, cfg: cfg, fs: executor.fs}
+
+	if err := namespacesSupported(); err != nil {
+		return nil, err
+	}
+
+	cgroups, err := newCgroupParent(cfg.Sandbox.Namespace.CgroupParent)
+	if err != nil {
+		return nil, err
+	}
+	executor.cgroups = cgroups
+
+	for _, rootfs := range executor.rootfsDirs() {
+		if err := prepareRootfs(rootfs); err != nil {
+			return nil, err
+		}
+	}
+
+	return executor, nil
+}
+
+// rootfsDirs returns the root filesystems of the configured languages
+func (n *NamespaceExecutor) rootfsDirs() []string {
+	dirs := []string{}
+	if rootfs := n.cfg.Sandbox.Namespace.Rootfs; rootfs != "" {
+		dirs = append(dirs, rootfs)
+	}
+	for _, lang := range n.cfg.Languages {
+		if lang.Rootfs != "" {
+			dirs = append(dirs, lang.Rootfs)
+		}
+	}
+	return dirs
+}
+
+// prepareRootfs checks that a root filesystem has a shell and creates its mount points,
+// which cannot be created once the sandbox mounted it read-only
+func prepareRootfs(rootfs string) error {
+	if _, err := os.Lstat(filepath.Join(rootfs, namespaceShell)); err != nil {
+		return fmt.Errorf("invalid rootfs %s: %w", rootfs, err)
+	}
+	for _, mountPoint := range namespaceMountPoints {
+		if err := os.MkdirAll(filepath.Join(rootfs, mountPoint), DirPermission); err != nil {
+			return fmt.Errorf("failed to create mount point in rootfs %s: %w", rootfs, err)
+		}
+	}
+	return nil
+}
+
+// Execute runs the code in a namespace sandbox
+//
+//nolint:gocritic // Large request struct is passed by value to satisfy the SandboxExecutor interface
+func (n *NamespaceExecutor) Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error) {
+	workdirPath, lang, cleanup, err := n.prepareWorkdir(req.Language, req.Code, req.Workdir, req.WorkdirTar)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	defer cleanup()
+
+	// Run the build phase (if any) and the run phase each in its own sandbox
+	limits := n.config.limits(req.TimeoutSec, req.MemoryMB, req.Network)
+	phase := n.sandboxPhase(req.Language, workdirPath, limits, n.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, n.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	result.Limits = limits
+
+	// Only return artifacts when the run phase actually finished and the caller wants them
+	if err := n.workdirs.collectArtifacts(&result, &req, workdirPath); err != nil {
+		return ExecuteResult{}, err
+	}
+	return result, nil
+}
+
+// ExecuteBatch builds the code once and runs it against every test case in the same workdir
+//
+//nolint:gocritic // Large request struct is passed by value to mirror Execute
+func (n *NamespaceExecutor) ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error) {
+	workdirPath, lang, cleanup, err := n.prepareWorkdir(req.Language, req.Code, "", req.WorkdirTar)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer cleanup()
+
+	limits := n.config.limits(0, 0, false)
+	phase := n.sandboxPhase(req.Language, workdirPath, limits, phaseCapture{})
+	return runTestCases(ctx, lang, n.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+}
+
+// prepareWorkdir prepares the workdir of an execution, with the usage dir next to it,
+// and hands it over to the sandbox user
+func (n *NamespaceExecutor) prepareWorkdir(language, code, sessionWorkdir string, workdirTar []byte) (string, Language, func(), error) {
+	workdirPath, lang, cleanup, err := n.workdirs.prepare(language, code, sessionWorkdir, workdirTar)
+	if err != nil {
+		return "", Language{}, nil, err
+	}
+
+	// The init records the exit status of the command next to the workdir
+	if usageErr := prepareUsageDir(n.fs, workdirPath); usageErr != nil {
+		cleanup()
+		return "", Language{}, nil, usageErr
+	}
+
+	if shareErr := n.shareWorkdir(workdirPath); shareErr != nil {
+		cleanup()
+		return "", Language{}, nil, shareErr
+	}
+
+	return workdirPath, lang, cleanup, nil
+}
+
+// shareWorkdir hands the workdir over to the host user that root in the sandbox maps to,
+// when that is not the user of the server
+func (n *NamespaceExecutor) shareWorkdir(workdirPath string) error {
+	if n.hostID == os.Geteuid() {
+		return nil
+	}
+	if err := os.Lchown(filepath.Dir(workdirPath), n.hostID, n.hostID); err != nil {
+		return fmt.Errorf("failed to share workdir with the sandbox user: %w", err)
+	}
+	err := filepath.WalkDir(workdirPath, func(path string, _ fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, n.hostID, n.hostID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to share workdir with the sandbox user: %w", err)
+	}
+	return nil
+}
+
+// sandboxPhase returns a phaseFunc that runs every phase in its own namespace sandbox on the workdir.
+// capture decides where the output goes besides the phase result.
+func (n *NamespaceExecutor) sandboxPhase(language, workdirPath string, limits Limits, capture phaseCapture) phaseFunc {
+	return func(ctx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return n.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			return n.runSandboxed(ctx, language, workdirPath, limits.forPhase(phase), command, base)
+		})
+	}
+}
+
+// runSandboxed runs a single shell command in a fresh sandbox that mounts the workdir.
+// base carries the stdin and output capture of the phase.
+// The sandbox gets the memory limit and network access of limits.
+func (n *NamespaceExecutor) runSandboxed(
+	ctx context.Context,
+	language, workdirPath string,
+	limits Limits,
+	command string,
+	base Command,
+) (PhaseResult, error) {
+	rootfs := n.cfg.GetRootfs(language)
+	if rootfs == "" {
+		return PhaseResult{}, fmt.Errorf("no rootfs configured for language %s", language)
+	}
+
+	// The cgroup limits the sandbox from its first instruction on and takes down whatever is left of it
+	leaf, err := n.cgroups.create(fmt.Sprintf("codebox-exec-%d", time.Now().UnixNano()), limits)
+	if err != nil {
+		return PhaseResult{}, err
+	}
+	defer func() {
+		if rmErr := leaf.remove(); rmErr != nil {
+			n.logger.Warn("failed to remove sandbox cgroup", zap.Error(rmErr))
+		}
+	}()
+
+	statusFile := filepath.Join(usageDirFor(workdirPath), namespaceStatusFile)
+	_ = n.fs.RemoveAll(statusFile)
+	spec, err := json.Marshal(namespaceSpec{
+		Rootfs:     rootfs,
+		Workdir:    workdirPath,
+		Args:       []string{namespaceShell, "-c", command},
+		Env:        sandboxEnvironment(n.cfg, language),
+		Network:    limits.Network,
+		StatusFile: statusFile,
+	})
+	if err != nil {
+		return PhaseResult{}, fmt.Errorf("failed to encode sandbox spec: %w", err)
+	}
+
+	base.Args = []string{namespaceInitPath}
+	base.Env = []string{namespaceInitEnv + "=" + string(spec)}
+	base.SysProcAttr = namespaceSysProcAttr(limits.Network, n.hostID, leaf.dir)
+	output, err := n.cmdRunner.RunCommand(ctx, base)
+
+	// A timeout is reported by the caller, keep whatever output was produced
+	if ctx.Err() != nil {
+		return PhaseResult{Stdout: output.Stdout, Stderr: output.Stderr, Usage: output.Usage, Output: output.Output}, ctx.Err()
+	}
+
+	if err != nil {
+		return PhaseResult{}, fmt.Errorf("failed to start sandbox: %w", err)
+	}
+
+	if !output.OutputLimitExceeded {
+		// Without a status the init failed before the command could start
+		status, readErr := n.fs.ReadFile(statusFile)
+		if readErr != nil {
+			return PhaseResult{}, fmt.Errorf("failed to set up sandbox: %s", strings.TrimSpace(output.Stderr))
+		}
+		output.ExitCode, output.Signal = parseNamespaceStatus(string(status), output.ExitCode)
+	}
+
+	result := phaseOutput(&output)
+	result.Usage = leaf.usage()
+	result.Usage.WallTime = output.Usage.WallTime
+	return result, nil
+}
+
+// parseNamespaceStatus parses the exit status that the init recorded: "exit <code>" or "signal <name>".
+// A command killed by a signal has exit code -1, like a local process.
+func parseNamespaceStatus(status string, exitCode int) (int, string) {
+	kind, value, _ := strings.Cut(strings.TrimSpace(status), " ")
+	if kind == "signal" {
+		return -1, value
+	}
+	return exitCode, ""
+}
+
+// sandboxPath is the PATH of commands that do not inherit the environment of the server
+const sandboxPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// sandboxEnvironment returns the environment of a command in a sandbox that does not inherit the environment
+// of the server: a default PATH and HOME and the variables of the language, which can override them
+func sandboxEnvironment(cfg *config.Config, language string) []string {
+	env := []string{"PATH=" + sandboxPath, "HOME=/tmp"}
+	if langConfig, exists := cfg.Languages[language]; exists {
+		for key, value := range langConfig.Environment {
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return env
+}
+
+// errNamespacesUnsupported is returned on platforms and kernels without user namespaces
+var errNamespacesUnsupported = errors.New("the namespace backend needs Linux with user namespaces enabled")
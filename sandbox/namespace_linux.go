@@ -0,0 +1,280 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// namespaceSetupFailed is the exit code of an init that could not set up the sandbox
+const namespaceSetupFailed = 125
+
+// namespaceDevices are bind mounted from the host into the /dev of the sandbox
+var namespaceDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// namespaceDevLinks are the symlinks that programs expect in /dev
+var namespaceDevLinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+}
+
+// When the server binary is started as the init of a namespace sandbox it never gets to main:
+// it sets up the sandbox from its spec, runs the command and exits with its exit code.
+func init() {
+	encoded, ok := os.LookupEnv(namespaceInitEnv)
+	if !ok {
+		return
+	}
+
+	// Namespace setup, capabilities and the forked command all belong to this thread
+	runtime.LockOSThread()
+
+	var spec namespaceSpec
+	if err := json.Unmarshal([]byte(encoded), &spec); err != nil {
+		fmt.Fprintf(os.Stderr, "codebox-init: invalid spec: %v\n", err)
+		os.Exit(namespaceSetupFailed)
+	}
+	os.Exit(runNamespaceInit(&spec))
+}
+
+// namespacesSupported checks that the kernel lets the server create user namespaces
+func namespacesSupported() error {
+	// Debian and Ubuntu kernels can turn unprivileged user namespaces off
+	if value, err := os.ReadFile("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && string(value) == "0\n" && os.Geteuid() != 0 {
+		return fmt.Errorf("%w: kernel.unprivileged_userns_clone is 0", errNamespacesUnsupported)
+	}
+	if value, err := os.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil && string(value) == "0\n" {
+		return fmt.Errorf("%w: user.max_user_namespaces is 0", errNamespacesUnsupported)
+	}
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		return fmt.Errorf("%w: %w", errNamespacesUnsupported, err)
+	}
+	return nil
+}
+
+// namespaceSysProcAttr starts the init in new namespaces with root mapped to hostID and,
+// when cgroup is not nil, right inside that cgroup
+func namespaceSysProcAttr(network bool, hostID int, cgroup *os.File) *syscall.SysProcAttr {
+	flags := uintptr(unix.CLONE_NEWUSER | unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS | unix.CLONE_NEWCGROUP)
+	if !network {
+		flags |= unix.CLONE_NEWNET
+	}
+
+	attr := &syscall.SysProcAttr{
+		Cloneflags:                 flags,
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostID, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: hostID, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		// Switches to root of the namespace, a server running as root would otherwise stay unmapped host root
+		Credential: &syscall.Credential{Uid: 0, Gid: 0, NoSetGroups: true},
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	if cgroup != nil {
+		attr.UseCgroupFD = true
+		attr.CgroupFD = int(cgroup.Fd())
+	}
+	return attr
+}
+
+// runNamespaceInit sets up the sandbox described by spec, runs its command and records how it ended.
+// It returns the exit code of the init.
+func runNamespaceInit(spec *namespaceSpec) int {
+	status, err := os.OpenFile(spec.StatusFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, FilePermission)
+	if err == nil {
+		defer status.Close()
+		err = setupNamespace(spec)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "codebox-init: %v\n", err)
+		return namespaceSetupFailed
+	}
+
+	cmd := exec.Command(spec.Args[0], spec.Args[1:]...) //nolint:gosec // Running the untrusted command is the point of the sandbox
+	cmd.Dir = WorkDirPath
+	cmd.Env = spec.Env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "codebox-init: %v\n", err)
+		return namespaceSetupFailed
+	}
+
+	// The exit status of a command killed by a signal is 128 plus the signal, like in a shell
+	_ = cmd.Wait()
+	waitStatus, _ := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if waitStatus.Signaled() {
+		_, _ = fmt.Fprintf(status, "signal %s\n", unix.SignalName(waitStatus.Signal()))
+		return 128 + int(waitStatus.Signal()) //nolint:mnd // Shell convention for signal exits
+	}
+	_, _ = fmt.Fprintf(status, "exit %d\n", waitStatus.ExitStatus())
+	return waitStatus.ExitStatus()
+}
+
+// setupNamespace turns the fresh namespaces of the init into the sandbox: the read-only root filesystem
+// with the workdir, proc, dev and tmp mounted into it, a private hostname and loopback network, the
+// file and CPU limits of containers and no capabilities, with the seccomp filter installed.
+func setupNamespace(spec *namespaceSpec) error {
+	steps := []struct {
+		name string
+		run  func(*namespaceSpec) error
+	}{
+		{"mount the root filesystem", mountRootfs},
+		{"pivot into the root filesystem", pivotRoot},
+		{"set the hostname", func(*namespaceSpec) error { return unix.Sethostname([]byte("codebox")) }},
+		{"bring up the loopback interface", func(spec *namespaceSpec) error {
+			if spec.Network {
+				return nil
+			}
+			return loopbackUp()
+		}},
+		{"set resource limits", func(*namespaceSpec) error { return setNamespaceRlimits() }},
+		{"drop capabilities", func(*namespaceSpec) error { return dropCapabilities() }},
+		{"install the seccomp filter", func(*namespaceSpec) error { return installSeccompFilter() }},
+	}
+	for _, step := range steps {
+		if err := step.run(spec); err != nil {
+			return fmt.Errorf("failed to %s: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
+// mountRootfs mounts the root filesystem onto itself with the workdir, proc, dev and tmp, and makes it read-only
+func mountRootfs(spec *namespaceSpec) error {
+	// Nothing mounted in the sandbox propagates back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("/: %w", err)
+	}
+	rootfs := spec.Rootfs
+	if err := unix.Mount(rootfs, rootfs, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("%s: %w", rootfs, err)
+	}
+
+	mounts := []struct {
+		source, target, fstype string
+		flags                  uintptr
+		data                   string
+	}{
+		{spec.Workdir, WorkDirPath, "", unix.MS_BIND | unix.MS_REC, ""},
+		{"proc", "/proc", "proc", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC, ""},
+		{"tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, "mode=1777"},
+		{"tmpfs", "/dev", "tmpfs", unix.MS_NOSUID | unix.MS_NOEXEC, "mode=755"},
+	}
+	for _, m := range mounts {
+		if err := unix.Mount(m.source, filepath.Join(rootfs, m.target), m.fstype, m.flags, m.data); err != nil {
+			return fmt.Errorf("%s: %w", m.target, err)
+		}
+	}
+	if err := populateDev(filepath.Join(rootfs, "dev")); err != nil {
+		return err
+	}
+
+	// A bind mount keeps the locked flags of its source when it is remounted read-only
+	var stat unix.Statfs_t
+	if err := unix.Statfs(rootfs, &stat); err != nil {
+		return err
+	}
+	locked := uintptr(stat.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME)
+	return unix.Mount("", rootfs, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|locked, "")
+}
+
+// populateDev fills the tmpfs at dev with the host devices that programs need and a /dev/shm
+func populateDev(dev string) error {
+	for _, device := range namespaceDevices {
+		target := filepath.Join(dev, device)
+		if err := os.WriteFile(target, nil, FilePermission); err != nil {
+			return err
+		}
+		if err := unix.Mount(filepath.Join("/dev", device), target, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("/dev/%s: %w", device, err)
+		}
+	}
+	for name, target := range namespaceDevLinks {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, DirPermission); err != nil {
+		return err
+	}
+	return unix.Mount("tmpfs", shm, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777")
+}
+
+// pivotRoot makes the root filesystem the root of the mount namespace and detaches the host filesystem
+func pivotRoot(spec *namespaceSpec) error {
+	if err := unix.Chdir(spec.Rootfs); err != nil {
+		return err
+	}
+	// Stacks the old root on top of the new one, so the old root can be detached from "."
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return err
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return err
+	}
+	return unix.Chdir("/")
+}
+
+// loopbackUp brings up the loopback interface of the new network namespace
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return err
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// setNamespaceRlimits applies the default file size limit of the container backends. CPU time is bounded
+// by the phase timeout and the CPU quota of the cgroup.
+func setNamespaceRlimits() error {
+	return unix.Setrlimit(unix.RLIMIT_FSIZE, &unix.Rlimit{Cur: config.DefaultFileSizeLimit, Max: config.DefaultFileSizeLimit})
+}
+
+// dropCapabilities empties every capability set, so that root in the sandbox is an ordinary user
+// that cannot gain capabilities again, not even through executing a file
+func dropCapabilities() error {
+	for capability := 0; ; capability++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break // past the last capability of the kernel
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return err
+	}
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return err
+	}
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
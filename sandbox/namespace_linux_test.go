@@ -0,0 +1,313 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+	"golang.org/x/sys/unix"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// fakeNamespaceInit behaves like the init of a namespace sandbox: it records the exit status
+// of the command into the status file of the spec, and the resource usage into the cgroup
+type fakeNamespaceInit func(spec namespaceSpec, cgroup string) (CommandResult, string)
+
+func newNamespaceTestExecutor(t *testing.T, network bool, init fakeNamespaceInit) (*NamespaceExecutor, *FuncCommandRunner) {
+	t.Helper()
+	pythonRootfs, nodeRootfs := t.TempDir(), t.TempDir()
+	for _, rootfs := range []string{pythonRootfs, nodeRootfs} {
+		require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "bin"), DirPermission))
+		require.NoError(t, os.WriteFile(filepath.Join(rootfs, namespaceShell), nil, FilePermission))
+	}
+	cgroupParent := newFakeCgroupParent(t, cgroupControllers)
+
+	cfg := &config.Config{
+		Sandbox: config.SandboxConfig{
+			Backend:   config.BackendNamespace,
+			Namespace: config.NamespaceConfig{Rootfs: pythonRootfs, CgroupParent: cgroupParent},
+		},
+		Languages: map[string]config.Language{
+			LanguagePython: {Environment: map[string]string{"PYTHONUNBUFFERED": "1"}},
+			LanguageNodeJS: {Rootfs: nodeRootfs},
+		},
+	}
+	runner := &FuncCommandRunner{
+		run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			encoded, _ := strings.CutPrefix(cmd.Env[0], namespaceInitEnv+"=")
+			var spec namespaceSpec
+			require.NoError(t, json.Unmarshal([]byte(encoded), &spec))
+			cgroups, err := filepath.Glob(filepath.Join(cgroupParent, "codebox-exec-*"))
+			require.NoError(t, err)
+			require.NotEmpty(t, cgroups)
+
+			result, status := init(spec, cgroups[len(cgroups)-1])
+			if status != "" {
+				require.NoError(t, os.WriteFile(spec.StatusFile, []byte(status), FilePermission))
+			}
+			return result, nil
+		},
+	}
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5, NetworkEnabled: network}
+	executor, err := NewNamespaceExecutor(zaptest.NewLogger(t), executorConfig, cfg, WithNamespaceCommandRunner(runner))
+	require.NoError(t, err)
+
+	for _, rootfs := range []string{pythonRootfs, nodeRootfs} {
+		for _, mountPoint := range namespaceMountPoints {
+			assert.DirExists(t, filepath.Join(rootfs, mountPoint), "mount points are prepared")
+		}
+	}
+	return executor, runner
+}
+
+func TestNamespaceExecutor(t *testing.T) {
+	t.Run("Execute", func(t *testing.T) {
+		executor, runner := newNamespaceTestExecutor(t, false, func(spec namespaceSpec, cgroup string) (CommandResult, string) {
+			require.NoError(t, os.WriteFile(filepath.Join(spec.Workdir, "out.txt"), []byte("result"), FilePermission))
+			require.NoError(t, os.WriteFile(filepath.Join(cgroup, memoryPeakFile), []byte("2048\n"), FilePermission))
+			return CommandResult{Stdout: "hello\n", ExitCode: 3}, "exit 3\n"
+		})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguagePython,
+			Code:     "import sys; print('hello'); sys.exit(3)",
+			Stdin:    []byte("input\n"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, StatusNonzeroExit, result.Status)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "hello\n", result.Stdout)
+		assert.Equal(t, int64(2048), result.Usage.PeakMemoryBytes)
+		assert.NotEmpty(t, result.ArtifactsTar)
+
+		commands := runner.Commands()
+		require.Len(t, commands, 1)
+		cmd := commands[0]
+		assert.Equal(t, []string{namespaceInitPath}, cmd.Args)
+		assert.Equal(t, []byte("input\n"), cmd.Stdin)
+		require.NotNil(t, cmd.SysProcAttr)
+		assert.NotZero(t, cmd.SysProcAttr.Cloneflags&unix.CLONE_NEWUSER)
+		assert.NotZero(t, cmd.SysProcAttr.Cloneflags&unix.CLONE_NEWNET, "the sandbox gets its own network")
+		assert.Equal(t, []syscall.SysProcIDMap{{ContainerID: 0, HostID: executor.hostID, Size: 1}}, cmd.SysProcAttr.UidMappings)
+		assert.True(t, cmd.SysProcAttr.UseCgroupFD)
+
+		var spec namespaceSpec
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(cmd.Env[0], namespaceInitEnv+"=")), &spec))
+		assert.Equal(t, executor.cfg.Sandbox.Namespace.Rootfs, spec.Rootfs)
+		assert.Equal(t, []string{namespaceShell, "-c", "python3 main.py"}, spec.Args)
+		assert.Contains(t, spec.Env, "PYTHONUNBUFFERED=1")
+		assert.False(t, spec.Network)
+	})
+
+	t.Run("LanguageRootfsAndNetwork", func(t *testing.T) {
+		executor, runner := newNamespaceTestExecutor(t, true, func(namespaceSpec, string) (CommandResult, string) {
+			return CommandResult{}, "exit 0\n"
+		})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguageNodeJS, Code: "1"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOK, result.Status)
+
+		cmd := runner.Commands()[0]
+		assert.Zero(t, cmd.SysProcAttr.Cloneflags&unix.CLONE_NEWNET, "the sandbox shares the network of the host")
+		var spec namespaceSpec
+		require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(cmd.Env[0], namespaceInitEnv+"=")), &spec))
+		assert.Equal(t, executor.cfg.Languages[LanguageNodeJS].Rootfs, spec.Rootfs)
+		assert.True(t, spec.Network)
+	})
+
+	t.Run("OOMKilled", func(t *testing.T) {
+		executor, _ := newNamespaceTestExecutor(t, false, func(_ namespaceSpec, cgroup string) (CommandResult, string) {
+			require.NoError(t, os.WriteFile(filepath.Join(cgroup, "memory.events"), []byte("oom_kill 1\n"), FilePermission))
+			return CommandResult{ExitCode: 137}, "signal SIGKILL\n"
+		})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "x = ' ' * 2**40"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOOMKilled, result.Status)
+		assert.Equal(t, -1, result.ExitCode)
+	})
+
+	t.Run("SetupFailed", func(t *testing.T) {
+		executor, _ := newNamespaceTestExecutor(t, false, func(namespaceSpec, string) (CommandResult, string) {
+			return CommandResult{ExitCode: namespaceSetupFailed, Stderr: "codebox-init: failed to mount the root filesystem: EPERM\n"}, ""
+		})
+
+		_, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to set up sandbox: codebox-init: failed to mount the root filesystem")
+	})
+
+	t.Run("MissingShell", func(t *testing.T) {
+		cfg := &config.Config{Sandbox: config.SandboxConfig{
+			Namespace: config.NamespaceConfig{Rootfs: t.TempDir(), CgroupParent: newFakeCgroupParent(t, cgroupControllers)},
+		}}
+		_, err := NewNamespaceExecutor(zaptest.NewLogger(t), &Config{}, cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid rootfs")
+	})
+}
+
+func TestParseNamespaceStatus(t *testing.T) {
+	exitCode, signal := parseNamespaceStatus("exit 2\n", 2)
+	assert.Equal(t, 2, exitCode)
+	assert.Empty(t, signal)
+
+	exitCode, signal = parseNamespaceStatus("signal SIGSEGV\n", 139)
+	assert.Equal(t, -1, exitCode)
+	assert.Equal(t, "SIGSEGV", signal)
+}
+
+func TestSeccompFilter(t *testing.T) {
+	filter, err := seccompFilter()
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// Every jump must land inside the program and the program must end in a return
+	for i, instruction := range filter {
+		if instruction.Code&unix.BPF_JMP != 0 && instruction.Code&0x07 == unix.BPF_JMP {
+			assert.Less(t, i+1+int(max(instruction.Jt, instruction.Jf)), len(filter), "jump at %d", i)
+		}
+	}
+	last := filter[len(filter)-1]
+	assert.Equal(t, uint16(unix.BPF_RET|unix.BPF_K), last.Code)
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), last.K)
+
+	// Every denied syscall is followed by a return of EPERM
+	for _, nr := range []uintptr{unix.SYS_MOUNT, unix.SYS_PTRACE, unix.SYS_UNSHARE, unix.SYS_BPF} {
+		found := false
+		for i, instruction := range filter[:len(filter)-1] {
+			if instruction.Code == unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K && instruction.K == uint32(nr) {
+				assert.Equal(t, uint32(unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)), filter[i+1].K)
+				found = true
+			}
+		}
+		assert.True(t, found, "syscall %d is denied", nr)
+	}
+
+	arch, err := seccompAuditArch()
+	require.NoError(t, err)
+	eperm := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	for _, nr := range []uintptr{unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER, unix.SYS_KCMP} {
+		assert.Equal(t, eperm, runSeccompFilter(filter, arch, uint32(nr), 0), "syscall %d is denied", nr)
+	}
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(filter, arch, unix.SYS_READ, 0))
+
+	// personality only sets the default personalities and queries the current one
+	for _, persona := range []uint32{0x0, 0x8, 0x20000, 0xffffffff} {
+		assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(filter, arch, unix.SYS_PERSONALITY, persona), "personality %#x", persona)
+	}
+	for _, persona := range []uint32{0x40000, 0x400000, 0x1} { // ADDR_NO_RANDOMIZE, READ_IMPLIES_EXEC, PER_SVR4
+		assert.Equal(t, eperm, runSeccompFilter(filter, arch, unix.SYS_PERSONALITY, persona), "personality %#x", persona)
+	}
+
+	// clone is allowed unless it creates namespaces
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), runSeccompFilter(filter, arch, unix.SYS_CLONE, unix.CLONE_VM))
+	assert.Equal(t, eperm, runSeccompFilter(filter, arch, unix.SYS_CLONE, unix.CLONE_NEWUSER))
+}
+
+// runSeccompFilter interprets the instructions of a seccomp filter that seccompFilter uses for a syscall
+// with the low half of its first argument and returns the action of the filter
+func runSeccompFilter(filter []unix.SockFilter, arch, nr, arg0 uint32) uint32 {
+	data := map[uint32]uint32{seccompNrOffset: nr, seccompArchOffset: arch, seccompArg0Offset: arg0}
+	var accumulator uint32
+	for pc := 0; pc < len(filter); pc++ {
+		instruction := filter[pc]
+		switch instruction.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			accumulator = data[instruction.K]
+		case unix.BPF_RET | unix.BPF_K:
+			return instruction.K
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K:
+			var matched bool
+			switch instruction.Code &^ (unix.BPF_JMP | unix.BPF_K) {
+			case unix.BPF_JEQ:
+				matched = accumulator == instruction.K
+			case unix.BPF_JGE:
+				matched = accumulator >= instruction.K
+			default:
+				matched = accumulator&instruction.K != 0
+			}
+			if matched {
+				pc += int(instruction.Jt)
+			} else {
+				pc += int(instruction.Jf)
+			}
+		default:
+			panic(fmt.Sprintf("unsupported instruction %#x", instruction.Code))
+		}
+	}
+	panic("the filter does not return")
+}
+
+// TestNamespaceInit runs the init of the sandbox for real, without a cgroup. It needs a root filesystem
+// directory with a shell and coreutils, e.g. an extracted container image, in CODEBOX_TEST_ROOTFS.
+func TestNamespaceInit(t *testing.T) {
+	rootfs := os.Getenv("CODEBOX_TEST_ROOTFS")
+	if rootfs == "" {
+		t.Skip("CODEBOX_TEST_ROOTFS is not set")
+	}
+	require.NoError(t, namespacesSupported())
+	require.NoError(t, prepareRootfs(rootfs))
+
+	hostID := os.Geteuid()
+	if hostID == 0 {
+		hostID = nobodyID
+	}
+	executor := &NamespaceExecutor{fs: &RealFileSystem{}, hostID: hostID}
+	// The sandbox user has to reach the workdir, which it cannot below the private test directory
+	tempDir, err := os.MkdirTemp("", "codebox-exec-*")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(tempDir) })
+	workdir := filepath.Join(tempDir, "workdir")
+	require.NoError(t, os.MkdirAll(workdir, DirPermission))
+	require.NoError(t, prepareUsageDir(executor.fs, workdir))
+	require.NoError(t, executor.shareWorkdir(workdir))
+
+	script := strings.Join([]string{
+		"echo result > out.txt",
+		"hostname",
+		"id -u",
+		"touch /etc/codebox 2>/dev/null || echo read-only",
+		"grep CapEff /proc/self/status",
+		"grep -c : /proc/net/dev",
+		"unshare -r true 2>/dev/null || echo no-namespaces",
+		"exit 3",
+	}, "; ")
+	spec, err := json.Marshal(namespaceSpec{
+		Rootfs:     rootfs,
+		Workdir:    workdir,
+		Args:       []string{namespaceShell, "-c", script},
+		Env:        []string{"PATH=" + sandboxPath},
+		StatusFile: filepath.Join(usageDirFor(workdir), namespaceStatusFile),
+	})
+	require.NoError(t, err)
+
+	output, err := (&RealCommandRunner{}).RunCommand(context.Background(), Command{
+		Args:        []string{namespaceInitPath},
+		Env:         []string{namespaceInitEnv + "=" + string(spec)},
+		SysProcAttr: namespaceSysProcAttr(false, hostID, nil),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, output.ExitCode, output.Stderr)
+	assert.Equal(t, "codebox\n0\nread-only\nCapEff:\t0000000000000000\n1\nno-namespaces\n", output.Stdout)
+
+	status, err := os.ReadFile(filepath.Join(usageDirFor(workdir), namespaceStatusFile))
+	require.NoError(t, err)
+	assert.Equal(t, "exit 3\n", string(status))
+	content, err := os.ReadFile(filepath.Join(workdir, "out.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "result\n", string(content))
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// namespacesSupported reports that namespaces only exist on Linux
+func namespacesSupported() error {
+	return errNamespacesUnsupported
+}
+
+// namespaceSysProcAttr is never used outside of Linux, where NewNamespaceExecutor fails
+func namespaceSysProcAttr(bool, int, *os.File) *syscall.SysProcAttr {
+	return nil
+}
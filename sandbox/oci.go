@@ -0,0 +1,497 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The OCIExecutor runs code in containers with
+// security constraints including resource limits, network isolation or
+// egress through the allowlist proxy, and a read-only root filesystem with
+// size-capped tmpfs mounts for /tmp and the home directory, optionally with
+// a size-capped workdir. It drives any container CLI that follows the docker
+// command line, like docker, podman and nerdctl for containerd, and knows
+// which of their features differ.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// OCIRuntime describes a container CLI that follows the docker command line
+type OCIRuntime struct {
+	Name       string   // backend name, e.g. docker
+	Binary     string   // CLI executable
+	GlobalArgs []string // arguments before every subcommand, e.g. --namespace for nerdctl
+	RunArgs    []string // extra arguments of every container run
+	Network    string   // network of containers with network access
+	Pool       bool     // cp works like docker's, so executions can use the warm pool
+	// RuntimeCheck is how OCI runtimes passed with --runtime are checked, e.g. config.RuntimeCheckInfo
+	RuntimeCheck string
+}
+
+// Built-in container CLIs. nerdctl's cp needs a containerd snapshotter that supports it, so it does not pool containers.
+// Only docker lists its OCI runtimes; podman fails to start with an unknown one and containerd
+// runs a runtime through a shim binary on the PATH.
+var (
+	dockerRuntime = OCIRuntime{
+		Name: "docker", Binary: "docker", Network: "bridge", Pool: true, RuntimeCheck: config.RuntimeCheckInfo,
+	}
+	podmanRuntime = OCIRuntime{
+		Name: "podman", Binary: "podman", Network: "bridge", Pool: true, RuntimeCheck: config.RuntimeCheckFlag,
+	}
+	nerdctlRuntime = OCIRuntime{Name: "nerdctl", Binary: "nerdctl", Network: "bridge", RuntimeCheck: config.RuntimeCheckShim}
+)
+
+// OCIExecutor implements SandboxExecutor using a container CLI
+type OCIExecutor struct {
+	logger     *zap.Logger
+	config     *Config
+	cfg        *config.Config // Reference to the full configuration
+	runtime    OCIRuntime
+	cmdRunner  CommandRunner
+	fs         FileSystem
+	workdirs   hostWorkdirs
+	containers *containerRegistry // containers of the running executions
+	poolConfig PoolConfig
+	pool       *containerPool    // idle containers, nil when the pool is disabled
+	security   *SecurityProfiles // seccomp, AppArmor and SELinux profiles, nil for the engine defaults
+	egress     *OCIEgress        // egress proxy of containers with network access, nil for the unrestricted network
+	instance   string            // instance label of the containers and volumes
+}
+
+// Config holds configuration for the executors
+type Config struct {
+	TimeoutSec        int
+	BuildTimeoutSec   int
+	MemoryMB          int
+	NetworkEnabled    bool
+	MaxArtifactSizeMB int
+	MaxStdoutBytes    int  // 0 captures stdout without limit
+	MaxStderrBytes    int  // 0 captures stderr without limit
+	KillOnOutputLimit bool // kill programs whose output exceeds the limits
+	SpillOutput       bool // keep the complete output of truncated streams in the artifacts
+}
+
+// OCIExecutorOption defines a functional option for OCIExecutor
+type OCIExecutorOption func(*OCIExecutor)
+
+// WithOCICommandRunner sets the CommandRunner for OCIExecutor
+func WithOCICommandRunner(cmdRunner CommandRunner) OCIExecutorOption {
+	return func(o *OCIExecutor) {
+		o.cmdRunner = cmdRunner
+	}
+}
+
+// WithOCIFileSystem sets the FileSystem for OCIExecutor
+func WithOCIFileSystem(fs FileSystem) OCIExecutorOption {
+	return func(o *OCIExecutor) {
+		o.fs = fs
+	}
+}
+
+// WithOCISecurity applies the security profiles of every language to its containers
+func WithOCISecurity(security *SecurityProfiles) OCIExecutorOption {
+	return func(o *OCIExecutor) {
+		o.security = security
+	}
+}
+
+// NewOCIExecutor creates a new OCIExecutor for a container CLI with default implementations and optional interfaces
+func NewOCIExecutor(
+	logger *zap.Logger,
+	executorConfig *Config,
+	cfg *config.Config,
+	runtime OCIRuntime,
+	opts ...OCIExecutorOption,
+) *OCIExecutor {
+	executor := &OCIExecutor{
+		logger:    logger,
+		config:    executorConfig,
+		cfg:       cfg,
+		runtime:   runtime,
+		instance:  cfg.GetInstance(),
+		cmdRunner: &RealCommandRunner{}, // Default implementation
+		fs:        &RealFileSystem{},    // Default implementation
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(executor)
+	}
+	executor.workdirs = hostWorkdirs{logger: logger, config: executorConfig, cfg: cfg, fs: executor.fs}
+	remove := cliContainerRemover(logger, executor.cmdRunner, executor.command())
+	if executor.egress != nil {
+		remove = executor.egress.releasing(remove)
+	}
+	executor.containers = newContainerRegistry(logger, remove)
+
+	// Start filling the warm pool right away
+	if executor.runtime.Pool && executor.poolConfig.Size > 0 && len(executor.poolConfig.Languages) > 0 {
+		executor.pool = newContainerPool(
+			logger, executor.containers, executor.poolConfig, executor.startPoolContainer, executor.poolContainerRunning,
+		)
+	}
+
+	return executor
+}
+
+// Execute runs the code in a container
+//
+//nolint:gocritic // Large request struct is passed by value to satisfy the SandboxExecutor interface
+func (o *OCIExecutor) Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error) {
+	workdirPath, lang, cleanup, err := o.workdirs.prepare(req.Language, req.Code, req.Workdir, req.WorkdirTar)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	defer cleanup()
+
+	// Run the build phase (if any) and the run phase in an idle pool container,
+	// or otherwise each in its own container on a size-capped or the mounted workdir
+	limits := o.config.limits(req.TimeoutSec, req.MemoryMB, req.Network)
+	var result ExecuteResult
+	if containerName, ok := o.pooledContainer(&req, limits); ok {
+		result, err = o.runPooled(ctx, containerName, &req, lang, workdirPath, limits)
+	} else if o.cfg.Sandbox.Filesystem.WorkdirSizeMB > 0 {
+		result, err = o.runWithQuota(ctx, &req, lang, workdirPath, limits)
+	} else {
+		phase := o.containerPhase(ctx, req.Language, lang, workdirPath, limits, o.workdirs.outputCapture(workdirPath, req.Stream))
+		result, err = runPhases(ctx, lang, o.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	}
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	result.Limits = limits
+	result.Runtime = o.cfg.GetRuntime(req.Language)
+
+	// Only return artifacts when the run phase actually finished and the caller wants them
+	if err := o.workdirs.collectArtifacts(&result, &req, workdirPath); err != nil {
+		return ExecuteResult{}, err
+	}
+	return result, nil
+}
+
+// ExecuteBatch builds the code once and runs it against every test case in the same workdir
+//
+//nolint:gocritic // Large request struct is passed by value to mirror Execute
+func (o *OCIExecutor) ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error) {
+	workdirPath, lang, cleanup, err := o.workdirs.prepare(req.Language, req.Code, "", req.WorkdirTar)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer cleanup()
+
+	// Test cases do not return artifacts, so a size-capped workdir is never copied back
+	workdirSource := workdirPath
+	if o.cfg.Sandbox.Filesystem.WorkdirSizeMB > 0 {
+		quota, err := o.startWorkdirQuota(ctx, req.Language, lang, workdirPath)
+		if err != nil {
+			return BatchResult{}, err
+		}
+		defer o.releaseWorkdirQuota(ctx, quota)
+		workdirSource = quota.volume
+	}
+
+	limits := o.config.limits(0, 0, false)
+	phase := o.containerPhase(ctx, req.Language, lang, workdirSource, limits, phaseCapture{})
+	result, err := runTestCases(ctx, lang, o.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	result.Runtime = o.cfg.GetRuntime(req.Language)
+	return result, nil
+}
+
+// containerPhase returns a phaseFunc that runs every phase in its own container on the workdir,
+// so that only the setup phase is attached to the network.
+// workdirSource is mounted as the workdir: the workdir itself or the volume of a workdir quota.
+// capture decides where the output goes besides the phase result.
+func (o *OCIExecutor) containerPhase(
+	ctx context.Context,
+	language string,
+	lang Language,
+	workdirSource string,
+	limits Limits,
+	capture phaseCapture,
+) phaseFunc {
+	return func(phaseCtx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return o.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			return o.runContainer(phaseCtx, ctx, language, lang, workdirSource, limits.forPhase(phase), command, base)
+		})
+	}
+}
+
+// runContainer runs a single shell command in a fresh container that mounts workdirSource as the workdir.
+// phaseCtx bounds the phase, while ctx is used for cleanup after the phase timed out.
+// base carries the stdin and output capture of the phase.
+// The container gets the memory limit and network access of limits. It idles while the command runs
+// with exec, so that its cgroup accounting can be read once the command is done.
+func (o *OCIExecutor) runContainer(
+	phaseCtx, ctx context.Context,
+	language string,
+	lang Language,
+	workdirSource string,
+	limits Limits,
+	command string,
+	base Command,
+) (PhaseResult, error) {
+	containerName := fmt.Sprintf("codebox-exec-%d", time.Now().UnixNano())
+	runArgs, err := o.runArgs(containerName, language, limits)
+	if err != nil {
+		return PhaseResult{}, err
+	}
+	cmdArgs := append(runArgs,
+		"--detach",
+		"-v", fmt.Sprintf("%s:%s", workdirSource, WorkDirPath),
+		lang.Image,
+	)
+	cmdArgs = append(cmdArgs, containerIdleCommand...)
+
+	// Force-removing the container kills it, whether its phase finished, timed out or was cancelled
+	o.containers.track(containerName)
+	defer o.containers.release(ctx, containerName, false)
+
+	if err := o.cli(phaseCtx, cmdArgs...); err != nil {
+		// The phase timed out before the program started
+		if errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+			return PhaseResult{}, nil
+		}
+		if ctx.Err() != nil {
+			return PhaseResult{}, fmt.Errorf("execution cancelled: %w", ctx.Err())
+		}
+		return PhaseResult{}, fmt.Errorf("failed to start container: %w", err)
+	}
+
+	// The counters of the fresh container start at zero
+	var counters containerCounters
+	return o.execInContainer(phaseCtx, ctx, containerName, command, base, &counters)
+}
+
+// execInContainer runs a single shell command in a running container that only serves this execution.
+// phaseCtx bounds the phase, while ctx is used for cleanup after the phase timed out.
+// counters holds the cgroup counters of the container before the phase and is advanced past it.
+func (o *OCIExecutor) execInContainer(
+	phaseCtx, ctx context.Context,
+	containerName, command string,
+	base Command,
+	counters *containerCounters,
+) (PhaseResult, error) {
+	cmdArgs := o.command("exec")
+	if base.Stdin != nil {
+		cmdArgs = append(cmdArgs, "-i")
+	}
+	base.Args = append(append(cmdArgs, containerName), phaseCommand(command)...)
+
+	start := time.Now()
+	output, err := o.cmdRunner.RunCommand(phaseCtx, base)
+	wallTime := time.Since(start)
+
+	// Stopping the exec client does not stop the command, so the container is killed.
+	// It only serves this execution, so nothing else is lost.
+	if phaseCtx.Err() != nil || output.OutputLimitExceeded {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerCleanupTimeout)
+		defer cancel()
+		if err := o.cli(cleanupCtx, "kill", containerName); err != nil {
+			o.logger.Debug("failed to kill container", zap.String("container", containerName), zap.Error(err))
+		}
+	}
+
+	if errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+		return PhaseResult{Stdout: output.Stdout, Stderr: output.Stderr, Output: output.Output}, nil
+	}
+
+	// The caller cancelled the execution or the server is shutting down
+	if ctx.Err() != nil {
+		return PhaseResult{}, fmt.Errorf("execution cancelled: %w", ctx.Err())
+	}
+
+	// The program was killed for its output, its usage is not collected
+	if output.OutputLimitExceeded {
+		return phaseOutput(&output), nil
+	}
+
+	if err != nil {
+		return PhaseResult{}, fmt.Errorf("failed to execute in container: %w", err)
+	}
+
+	result := phaseOutput(&output)
+	result.Usage = o.containerUsage(ctx, containerName, counters, output.ExitCode, wallTime)
+	return result, nil
+}
+
+// containerUsage reads the cgroup counters of a container as root after a phase that ended with exitCode,
+// returns the usage of the phase and advances counters. Without the counters only the wall time is reported.
+func (o *OCIExecutor) containerUsage(
+	ctx context.Context,
+	containerName string,
+	counters *containerCounters,
+	exitCode int,
+	wallTime time.Duration,
+) ResourceUsage {
+	after, err := o.readCounters(ctx, containerName)
+	if err != nil {
+		o.logger.Warn("failed to collect container resource usage", zap.String("container", containerName), zap.Error(err))
+		return ResourceUsage{WallTime: wallTime}
+	}
+	// With a quota, every container that reads usage runs on a quota volume, since the pool is ruled out
+	usage := after.phaseUsage(*counters, exitCode, wallTime, o.cfg.Sandbox.Filesystem.WorkdirSizeMB > 0)
+	*counters = after
+	return usage
+}
+
+// readCounters runs containerUsageCommand as root in a container
+func (o *OCIExecutor) readCounters(ctx context.Context, containerName string) (containerCounters, error) {
+	args := append(o.command("exec", "--user", containerRootUser, containerName), containerUsageCommand...)
+	output, err := o.cmdRunner.RunCommand(ctx, Command{Args: args, Output: OutputLimits{StdoutBytes: containerUsageOutputLimit}})
+	if err != nil {
+		return containerCounters{}, err
+	}
+	if output.ExitCode != 0 {
+		return containerCounters{}, fmt.Errorf("%s exec: %s", o.runtime.Binary, strings.TrimSpace(output.Stderr))
+	}
+	return parseContainerCounters(output.Stdout), nil
+}
+
+// runArgs returns the run subcommand with the arguments shared by every container: the memory limit and
+// network access of limits, the resource limits, security restrictions and profiles, the filesystem
+// restrictions, the OCI runtime and environment of the language and the run arguments of the runtime.
+// With the egress proxy, containers with network access join its internal network and get proxy credentials
+// in an env file.
+func (o *OCIExecutor) runArgs(containerName, language string, limits Limits) ([]string, error) {
+	// Disable network by default
+	network, proxyEnvFile := "none", ""
+	if limits.Network && o.egress != nil {
+		var err error
+		if network, proxyEnvFile, err = o.egress.network(containerName, language); err != nil {
+			return nil, err
+		}
+	} else if limits.Network {
+		network = o.runtime.Network
+	}
+
+	// Prepare the run command with security restrictions
+	cmdArgs := []string{
+		"run",
+		"--name", containerName,
+		"--label", instanceLabel(o.instance),
+		"--workdir", WorkDirPath,
+		"--memory", fmt.Sprintf("%dm", limits.MemoryMB),
+		"--network", network,
+		"--security-opt", "no-new-privileges:true",
+		"--user", "nobody", // Run as non-privileged user
+		"--cap-drop", "ALL", // Drop all capabilities
+	}
+
+	// Apply the CPU, PIDs, swap, shared memory and ulimit limits of the language
+	cmdArgs = append(cmdArgs, newContainerResources(o.cfg, language).runArgs(limits.MemoryMB)...)
+
+	// Apply the seccomp, AppArmor and SELinux profiles of the language
+	security := o.security.forLanguage(language)
+	for _, opt := range security.securityOpts(false) {
+		cmdArgs = append(cmdArgs, "--security-opt", opt)
+	}
+
+	// Mount the root filesystem read-only with tmpfs mounts at /tmp and the home directory
+	cmdArgs = append(cmdArgs, newContainerFilesystem(o.cfg).runArgs()...)
+
+	// Run the container with the OCI runtime of the language, e.g. gVisor's runsc
+	if ociRuntime := o.cfg.GetRuntime(language); ociRuntime != "" {
+		cmdArgs = append(cmdArgs, "--runtime", ociRuntime)
+	}
+
+	// Add environment variables based on language from config
+	envVars := o.getEnvironmentVariables(language)
+
+	// Log environment variables for debugging (at info level to ensure visibility)
+	if len(envVars) > 0 {
+		o.logger.Info("applying environment variables", zap.Any("env_vars", envVars))
+		for key, value := range envVars {
+			o.logger.Info("env var details", zap.String("key", key), zap.String("value", value))
+		}
+	} else {
+		o.logger.Info("no environment variables found for language", zap.String("language", language))
+	}
+
+	for key, value := range withHome(envVars) {
+		cmdArgs = append(cmdArgs, "-e", fmt.Sprintf("%s=%s", key, value))
+	}
+	if proxyEnvFile != "" {
+		cmdArgs = append(cmdArgs, "--env-file", proxyEnvFile)
+	}
+
+	return append(cmdArgs, o.runtime.RunArgs...), nil
+}
+
+// command returns the CLI invocation of a subcommand, with the global arguments of the runtime in front
+func (o *OCIExecutor) command(args ...string) []string {
+	cmd := append([]string{o.runtime.Binary}, o.runtime.GlobalArgs...)
+	return append(cmd, args...)
+}
+
+// StartRepl starts a REPL interpreter in a container that mounts the session workdir and lives until the REPL is closed.
+// Its CPU time is bounded by the timeout of every snippet instead of a CPU time limit on the whole session.
+func (o *OCIExecutor) StartRepl(ctx context.Context, req ReplRequest) (*Repl, error) {
+	lang, err := o.resolveLanguage(req.Language)
+	if err != nil {
+		return nil, err
+	}
+	command, err := replCommand(req.Language)
+	if err != nil {
+		return nil, err
+	}
+
+	containerName := fmt.Sprintf("codebox-repl-%d", time.Now().UnixNano())
+	limits := o.config.limits(0, req.MemoryMB, req.Network)
+	runArgs, err := o.runArgs(containerName, req.Language, limits)
+	if err != nil {
+		return nil, err
+	}
+	cmdArgs := append(o.command(runArgs...),
+		"-v", fmt.Sprintf("%s:%s", req.Workdir, WorkDirPath),
+		"-i", lang.Image,
+	)
+	cmdArgs = append(cmdArgs, command...)
+
+	o.containers.track(containerName)
+	interrupt := func() error {
+		_, err := o.cmdRunner.RunCommand(context.Background(), Command{Args: o.command("kill", "--signal", "INT", containerName)})
+		return err
+	}
+	cleanup := func() {
+		o.containers.release(context.Background(), containerName, true)
+	}
+
+	o.logger.Info("starting REPL container", zap.String("container", containerName), zap.String("language", req.Language))
+	cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...) //nolint:gosec // Arguments are built from configuration
+	return startRepl(ctx, req, cmd, o.config.MaxStdoutBytes, interrupt, cleanup)
+}
+
+// Shutdown stops the warm pool, kills and removes every container that is still running and stops the egress proxy
+func (o *OCIExecutor) Shutdown(ctx context.Context) error {
+	if o.pool != nil {
+		o.pool.stop(ctx)
+	}
+	err := o.containers.shutdown(ctx)
+	if o.egress != nil {
+		err = errors.Join(err, o.egress.shutdown(ctx))
+	}
+	return err
+}
+
+// Helper functions
+
+func (o *OCIExecutor) resolveLanguage(language string) (Language, error) {
+	return ResolveLanguage(o.cfg.Languages, language)
+}
+
+func (o *OCIExecutor) getEnvironmentVariables(language string) map[string]string {
+	if langConfig, exists := o.cfg.Languages[language]; exists && langConfig.Environment != nil {
+		return langConfig.Environment
+	}
+	return make(map[string]string)
+}
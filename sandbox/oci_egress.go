@@ -0,0 +1,197 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. With the egress proxy, the OCIExecutor
+// attaches containers with network access to an internal network instead of
+// the default bridge. The internal network has no route out, so the proxy,
+// which listens on the host, is the only thing that containers can reach,
+// and they are pointed at it with the usual proxy environment variables,
+// passed in env files so that their credentials stay out of the command line.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// egressNoProxy is the NO_PROXY of containers, which only reach the proxy anyway
+const egressNoProxy = "localhost,127.0.0.1"
+
+// OCIEgress routes the network access of containers through the egress proxy
+type OCIEgress struct {
+	Proxy    *EgressProxy // proxy that checks requests against the allowlists
+	Network  string       // internal network of containers with network access
+	ProxyURL *url.URL     // URL of the proxy from within the network, without credentials
+	envDir   string       // private directory of the env files with the proxy credentials of containers
+}
+
+// WithOCIEgress attaches containers with network access to the internal network of the egress proxy
+func WithOCIEgress(egress *OCIEgress) OCIExecutorOption {
+	return func(o *OCIExecutor) {
+		o.egress = egress
+	}
+}
+
+// StartOCIEgress creates the internal network of the egress proxy unless it exists and starts the proxy.
+// Without sandbox.egress.listen, the proxy only listens on the gateway of the network, and without
+// sandbox.egress.proxy_url, containers reach it there.
+func StartOCIEgress(
+	ctx context.Context,
+	logger *zap.Logger,
+	cfg *config.Config,
+	runtime OCIRuntime,
+	runner CommandRunner,
+) (*OCIEgress, error) {
+	cli := append([]string{runtime.Binary}, runtime.GlobalArgs...)
+	network := cfg.GetEgressNetwork()
+	gateway, err := ensureEgressNetwork(ctx, runner, cli, network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up egress network %s: %w", network, err)
+	}
+
+	if gateway == "" && (cfg.Sandbox.Egress.Listen == "" || cfg.Sandbox.Egress.ProxyURL == "") {
+		return nil, fmt.Errorf("egress network %s has no gateway, set sandbox.egress.listen and sandbox.egress.proxy_url", network)
+	}
+	listen := cfg.GetEgressListen(gateway)
+
+	proxyURL := cfg.Sandbox.Egress.ProxyURL
+	if proxyURL == "" {
+		_, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sandbox.egress.listen: %w", err)
+		}
+		proxyURL = "http://" + net.JoinHostPort(gateway, port)
+	}
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid egress proxy URL: %w", err)
+	}
+
+	envDir, err := os.MkdirTemp("", "codebox-egress-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create egress env directory: %w", err)
+	}
+	proxy := NewEgressProxy(logger, cfg)
+	if err := proxy.Start(listen); err != nil {
+		_ = os.RemoveAll(envDir)
+		return nil, err
+	}
+	logger.Info("routing container network access through the egress proxy",
+		zap.String("network", network), zap.String("proxy_url", parsed.String()))
+	return &OCIEgress{Proxy: proxy, Network: network, ProxyURL: parsed, envDir: envDir}, nil
+}
+
+// egressNetworkInspect holds the fields of network inspect that docker and podman have in common,
+// which differ in case only: docker reports IPAM.Config and podman subnets
+type egressNetworkInspect struct {
+	Internal bool
+	IPAM     struct {
+		Config []struct{ Gateway string }
+	}
+	Subnets []struct{ Gateway string }
+}
+
+// ensureEgressNetwork creates an internal network unless it exists and returns its gateway. An existing
+// network that is not internal is refused, since its containers could bypass the proxy.
+func ensureEgressNetwork(ctx context.Context, runner CommandRunner, cli []string, network string) (string, error) {
+	inspect := func() (CommandResult, error) {
+		return runner.RunCommand(ctx, Command{Args: append(slices.Clone(cli), "network", "inspect", network)})
+	}
+	output, err := inspect()
+	if err != nil {
+		return "", err
+	}
+	if output.ExitCode != 0 {
+		create, err := runner.RunCommand(ctx, Command{Args: append(slices.Clone(cli), "network", "create", "--internal", network)})
+		if err != nil {
+			return "", err
+		}
+		if create.ExitCode != 0 {
+			return "", fmt.Errorf("%s network create: %s", cli[0], strings.TrimSpace(create.Stderr))
+		}
+		if output, err = inspect(); err != nil {
+			return "", err
+		}
+		if output.ExitCode != 0 {
+			return "", fmt.Errorf("%s network inspect: %s", cli[0], strings.TrimSpace(output.Stderr))
+		}
+	}
+
+	var networks []egressNetworkInspect
+	if err := json.Unmarshal([]byte(output.Stdout), &networks); err != nil {
+		return "", fmt.Errorf("failed to parse the network of %s: %w", cli[0], err)
+	}
+	if len(networks) != 1 {
+		return "", fmt.Errorf("%s network inspect returned %d networks", cli[0], len(networks))
+	}
+	if !networks[0].Internal {
+		return "", fmt.Errorf("network %s is not internal, so containers could bypass the proxy", network)
+	}
+	for _, subnet := range networks[0].IPAM.Config {
+		if subnet.Gateway != "" {
+			return subnet.Gateway, nil
+		}
+	}
+	for _, subnet := range networks[0].Subnets {
+		if subnet.Gateway != "" {
+			return subnet.Gateway, nil
+		}
+	}
+	return "", nil
+}
+
+// network registers a container with the proxy and returns its network and the env file with the proxy
+// environment of the container. The env file is only readable by the server, since the proxy URLs carry
+// the credentials of the container, which processes on the host must not see in the command line.
+func (e *OCIEgress) network(containerName, language string) (string, string, error) {
+	proxyURL := *e.ProxyURL
+	proxyURL.User = url.UserPassword(egressProxyUser, e.Proxy.register(containerName, language))
+	proxy := proxyURL.String()
+
+	var env strings.Builder
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		fmt.Fprintf(&env, "%s=%s\n", key, proxy)
+	}
+	for _, key := range []string{"NO_PROXY", "no_proxy"} {
+		fmt.Fprintf(&env, "%s=%s\n", key, egressNoProxy)
+	}
+	envFile := e.envFile(containerName)
+	if err := os.WriteFile(envFile, []byte(env.String()), 0o600); err != nil {
+		e.Proxy.unregister(containerName)
+		return "", "", fmt.Errorf("failed to write the proxy env file of %s: %w", containerName, err)
+	}
+	return e.Network, envFile, nil
+}
+
+// envFile returns the path of the env file of a container
+func (e *OCIEgress) envFile(containerName string) string {
+	return filepath.Join(e.envDir, containerName+".env")
+}
+
+// releasing revokes the proxy credentials of containers and removes their env files once they are removed
+func (e *OCIEgress) releasing(remove containerRemover) containerRemover {
+	return func(ctx context.Context, name string, kill bool) error {
+		defer func() {
+			e.Proxy.unregister(name)
+			_ = os.Remove(e.envFile(name))
+		}()
+		return remove(ctx, name, kill)
+	}
+}
+
+// shutdown stops the proxy and removes the env files of containers that were never released
+func (e *OCIEgress) shutdown(ctx context.Context) error {
+	return errors.Join(e.Proxy.Shutdown(ctx), os.RemoveAll(e.envDir))
+}
@@ -0,0 +1,172 @@
+package sandbox
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// networkArgs returns the values of the --network flags of a command line
+func networkArgs(args []string) []string {
+	var networks []string
+	for i, arg := range args {
+		if arg == "--network" && i+1 < len(args) {
+			networks = append(networks, args[i+1])
+		}
+	}
+	return networks
+}
+
+func TestOCIExecutorNetwork(t *testing.T) {
+	cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}}}
+
+	t.Run("Isolated", func(t *testing.T) {
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime)
+		args, err := executor.runArgs("codebox-exec-1", LanguagePython, Limits{MemoryMB: 128})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"none"}, networkArgs(args))
+	})
+
+	t.Run("Enabled", func(t *testing.T) {
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime)
+		args, err := executor.runArgs("codebox-exec-1", LanguagePython, Limits{MemoryMB: 128, Network: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bridge"}, networkArgs(args), "the container joins a single network")
+	})
+
+	t.Run("Egress", func(t *testing.T) {
+		proxy := NewEgressProxy(zaptest.NewLogger(t), cfg)
+		egress := &OCIEgress{
+			Proxy: proxy, Network: "codebox-egress", ProxyURL: &url.URL{Scheme: "http", Host: "172.30.0.1:3128"}, envDir: t.TempDir(),
+		}
+		runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) { return CommandResult{}, nil }}
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime,
+			WithOCICommandRunner(runner), WithOCIEgress(egress))
+
+		args, err := executor.runArgs("codebox-exec-1", LanguagePython, Limits{MemoryMB: 128, Network: true})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"codebox-egress"}, networkArgs(args))
+
+		// The credentials are passed in an env file that only the server can read, not in the command line
+		assert.False(t, slices.ContainsFunc(args, func(arg string) bool { return strings.Contains(arg, "_PROXY=") }))
+		envFileAt := slices.Index(args, "--env-file")
+		require.Positive(t, envFileAt)
+		envFile := args[envFileAt+1]
+		info, err := os.Stat(envFile)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+		content, err := os.ReadFile(envFile)
+		require.NoError(t, err)
+
+		var proxyURL string
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if value, ok := strings.CutPrefix(line, "HTTPS_PROXY="); ok {
+				proxyURL = value
+			}
+		}
+		require.NotEmpty(t, proxyURL)
+		assert.Contains(t, string(content), "HTTP_PROXY="+proxyURL+"\nHTTPS_PROXY="+proxyURL+"\nhttp_proxy="+proxyURL+"\nhttps_proxy="+proxyURL+"\n")
+		parsed, err := url.Parse(proxyURL)
+		require.NoError(t, err)
+		assert.Equal(t, "172.30.0.1:3128", parsed.Host)
+		token, _ := parsed.User.Password()
+		assert.Equal(t, egressProxyUser, parsed.User.Username())
+
+		client, ok := proxy.clients[token]
+		require.True(t, ok, "the container is registered with the proxy")
+		assert.Equal(t, "codebox-exec-1", client.container)
+
+		// Removing the container revokes its credentials and removes its env file
+		executor.containers.track("codebox-exec-1")
+		executor.containers.release(context.Background(), "codebox-exec-1", false)
+		assert.NotContains(t, proxy.clients, token)
+		assert.NoFileExists(t, envFile)
+
+		// Containers without network access stay isolated
+		args, err = executor.runArgs("codebox-exec-2", LanguagePython, Limits{MemoryMB: 128})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"none"}, networkArgs(args))
+		assert.NotContains(t, args, "--env-file")
+	})
+}
+
+func TestEnsureEgressNetwork(t *testing.T) {
+	dockerInspect := `[{"Name":"codebox-egress","Internal":true,"IPAM":{"Config":[{"Subnet":"172.30.0.0/16","Gateway":"172.30.0.1"}]}}]`
+	podmanInspect := `[{"name":"codebox-egress","internal":true,"subnets":[{"subnet":"10.89.0.0/24","gateway":"10.89.0.1"}]}]`
+
+	t.Run("Existing", func(t *testing.T) {
+		for output, gateway := range map[string]string{dockerInspect: "172.30.0.1", podmanInspect: "10.89.0.1"} {
+			runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) {
+				return CommandResult{Stdout: output}, nil
+			}}
+			got, err := ensureEgressNetwork(context.Background(), runner, []string{"docker"}, "codebox-egress")
+			require.NoError(t, err)
+			assert.Equal(t, gateway, got)
+			assert.Nil(t, findCall(runner.Calls(), "network", "create"))
+		}
+	})
+
+	t.Run("Created", func(t *testing.T) {
+		created := false
+		runner := &FuncCommandRunner{run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			if cmd.Args[2] == "create" {
+				created = true
+				return CommandResult{}, nil
+			}
+			if !created {
+				return CommandResult{Stderr: "network codebox-egress not found", ExitCode: 1}, nil
+			}
+			return CommandResult{Stdout: dockerInspect}, nil
+		}}
+		gateway, err := ensureEgressNetwork(context.Background(), runner, []string{"docker"}, "codebox-egress")
+		require.NoError(t, err)
+		assert.Equal(t, "172.30.0.1", gateway)
+		assert.Equal(t, []string{"docker", "network", "create", "--internal", "codebox-egress"}, findCall(runner.Calls(), "network", "create"))
+	})
+
+	t.Run("NotInternal", func(t *testing.T) {
+		runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) {
+			return CommandResult{Stdout: `[{"Name":"bridge","Internal":false,"IPAM":{"Config":[{"Gateway":"172.17.0.1"}]}}]`}, nil
+		}}
+		_, err := ensureEgressNetwork(context.Background(), runner, []string{"docker"}, "bridge")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not internal")
+	})
+}
+
+func TestStartOCIEgress(t *testing.T) {
+	runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) {
+		return CommandResult{Stdout: `[{"name":"codebox-egress","internal":true,"subnets":[{"subnet":"10.89.0.0/24"}]}]`}, nil
+	}}
+
+	t.Run("NoGateway", func(t *testing.T) {
+		cfg := &config.Config{Sandbox: config.SandboxConfig{Egress: config.EgressConfig{Enabled: true}}}
+		_, err := StartOCIEgress(context.Background(), zaptest.NewLogger(t), cfg, podmanRuntime, runner)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "has no gateway, set sandbox.egress.listen and sandbox.egress.proxy_url")
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		cfg := &config.Config{Sandbox: config.SandboxConfig{Egress: config.EgressConfig{
+			Enabled: true, Listen: "127.0.0.1:0", ProxyURL: "http://host.containers.internal:3128",
+		}}}
+		egress, err := StartOCIEgress(context.Background(), zaptest.NewLogger(t), cfg, podmanRuntime, runner)
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1", egress.Proxy.Addr().(*net.TCPAddr).IP.String())
+		assert.Equal(t, "host.containers.internal:3128", egress.ProxyURL.Host)
+		assert.DirExists(t, egress.envDir)
+
+		require.NoError(t, egress.shutdown(context.Background()))
+		assert.NoDirExists(t, egress.envDir)
+	})
+}
@@ -0,0 +1,172 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. With a warm pool, the OCIExecutor runs an
+// execution in an idle container started ahead of time with the same security
+// restrictions: the workdir is copied in with cp, every phase runs with exec,
+// and the workdir is copied back out for the artifacts.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// WithOCIPool keeps idle containers for the languages of the pool configuration,
+// if the runtime supports the warm pool
+func WithOCIPool(poolConfig PoolConfig) OCIExecutorOption {
+	return func(o *OCIExecutor) {
+		o.poolConfig = poolConfig
+	}
+}
+
+// PoolStats returns the statistics of the warm container pool, or false when the pool is disabled
+func (o *OCIExecutor) PoolStats() (PoolStats, bool) {
+	if o.pool == nil {
+		return PoolStats{}, false
+	}
+	return o.pool.stats(), true
+}
+
+// startPoolContainer starts an idle container that waits for the phases of a single execution.
+// The workdir is an anonymous volume, since cp cannot write to a read-only root filesystem.
+func (o *OCIExecutor) startPoolContainer(ctx context.Context, language string) (string, error) {
+	lang, err := o.resolveLanguage(language)
+	if err != nil {
+		return "", err
+	}
+
+	containerName := fmt.Sprintf("codebox-pool-%d", time.Now().UnixNano())
+	runArgs, err := o.runArgs(containerName, language, o.config.limits(0, 0, false))
+	if err != nil {
+		return "", err
+	}
+	cmdArgs := append(runArgs,
+		"--detach",
+		"-v", WorkDirPath,
+		lang.Image,
+	)
+	cmdArgs = append(cmdArgs, containerIdleCommand...)
+
+	o.containers.track(containerName)
+	if err := o.cli(ctx, cmdArgs...); err != nil {
+		o.containers.release(ctx, containerName, true)
+		return "", fmt.Errorf("failed to start pool container: %w", err)
+	}
+	return containerName, nil
+}
+
+// poolContainerRunning inspects an idle container, treating containers that cannot be inspected as dead
+func (o *OCIExecutor) poolContainerRunning(ctx context.Context, name string) bool {
+	output, err := o.cmdRunner.RunCommand(ctx, Command{Args: o.command("inspect", "--format", "{{.State.Running}}", name)})
+	return err == nil && output.ExitCode == 0 && strings.TrimSpace(output.Stdout) == "true"
+}
+
+// pooledContainer takes an idle container for an execution that fits the pool containers:
+// a one-off workdir, the default memory limit and network access and no setup phase, which needs the network
+func (o *OCIExecutor) pooledContainer(req *ExecuteRequest, limits Limits) (string, bool) {
+	if o.pool == nil || req.Workdir != "" || req.Setup != "" || !o.pool.pools(req.Language) {
+		return "", false
+	}
+	if limits.MemoryMB != o.config.MemoryMB || limits.Network != o.config.NetworkEnabled {
+		return "", false
+	}
+	return o.pool.take(req.Language)
+}
+
+// runPooled runs the phases of an execution in a pool container, which is removed afterwards.
+// The workdir is only copied back when the artifacts are needed.
+func (o *OCIExecutor) runPooled(
+	ctx context.Context,
+	containerName string,
+	req *ExecuteRequest,
+	lang Language,
+	workdirPath string,
+	limits Limits,
+) (ExecuteResult, error) {
+	defer o.containers.release(ctx, containerName, true)
+
+	if err := o.copyToContainer(ctx, containerName, workdirPath); err != nil {
+		return ExecuteResult{}, err
+	}
+
+	// The phases are measured against the counters of the container before the first of them,
+	// which include the idle time and the copy
+	counters, err := o.readCounters(ctx, containerName)
+	if err != nil {
+		o.logger.Warn("failed to collect container resource usage", zap.String("container", containerName), zap.Error(err))
+	}
+
+	phase := o.execPhase(ctx, containerName, &counters, o.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, o.workdirs.buildTimeout(), limits.RunTimeout(), req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+
+	if !req.SkipArtifacts && result.hasArtifacts() {
+		if err := o.copyFromContainer(ctx, containerName, workdirPath); err != nil {
+			return ExecuteResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// execPhase returns a phase function that runs every phase in the same pool container
+func (o *OCIExecutor) execPhase(ctx context.Context, containerName string, counters *containerCounters, capture phaseCapture) phaseFunc {
+	return func(phaseCtx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return o.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			return o.execInContainer(phaseCtx, ctx, containerName, command, base, counters)
+		})
+	}
+}
+
+// copyToContainer copies the workdir into a pool container. Copied files belong to root,
+// so they are made writable for the unprivileged user the phases run as.
+func (o *OCIExecutor) copyToContainer(ctx context.Context, containerName, workdirPath string) error {
+	if err := o.cli(ctx, "cp", workdirPath+"/.", containerName+":"+WorkDirPath); err != nil {
+		return fmt.Errorf("failed to copy workdir into container: %w", err)
+	}
+	if err := o.cli(ctx, "exec", "--user", containerRootUser, containerName, "chmod", "-R", "a+rwX", WorkDirPath); err != nil {
+		return fmt.Errorf("failed to prepare workdir in container: %w", err)
+	}
+	return nil
+}
+
+// copyFromContainer replaces the workdir with the workdir of a pool container.
+// Spilled output is written on the host during the phases and is kept.
+func (o *OCIExecutor) copyFromContainer(ctx context.Context, containerName, workdirPath string) error {
+	entries, err := o.fs.ReadDir(workdirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read workdir: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == SpillDirName {
+			continue
+		}
+		if err := o.fs.RemoveAll(filepath.Join(workdirPath, entry.Name())); err != nil {
+			return fmt.Errorf("failed to clear workdir: %w", err)
+		}
+	}
+
+	if err := o.cli(ctx, "cp", containerName+":"+WorkDirPath+"/.", workdirPath); err != nil {
+		return fmt.Errorf("failed to copy workdir from container: %w", err)
+	}
+	return nil
+}
+
+// cli runs a subcommand of the container CLI that has to succeed
+func (o *OCIExecutor) cli(ctx context.Context, args ...string) error {
+	output, err := o.cmdRunner.RunCommand(ctx, Command{Args: o.command(args...)})
+	if err != nil {
+		return err
+	}
+	if output.ExitCode != 0 {
+		return fmt.Errorf("%s %s: %s", o.runtime.Binary, args[0], strings.TrimSpace(output.Stderr))
+	}
+	return nil
+}
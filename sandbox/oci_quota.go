@@ -0,0 +1,105 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. With a workdir quota, the OCIExecutor keeps
+// the workdir of an execution in a size-capped tmpfs volume instead of
+// mounting the host directory: an idle holder container mounts the volume so
+// that the workdir can be copied in and out with cp, while every phase runs in
+// a container of its own that mounts the same volume.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// workdirQuota is the size-capped workdir of an execution
+type workdirQuota struct {
+	volume string // tmpfs volume mounted at WorkDirPath
+	holder string // idle container that keeps the volume mounted for cp
+}
+
+// runWithQuota runs the phases of an execution on a size-capped copy of the workdir. The workdir is
+// copied back for sessions, whose workdir lives on, and when the artifacts are needed.
+func (o *OCIExecutor) runWithQuota(
+	ctx context.Context,
+	req *ExecuteRequest,
+	lang Language,
+	workdirPath string,
+	limits Limits,
+) (ExecuteResult, error) {
+	quota, err := o.startWorkdirQuota(ctx, req.Language, lang, workdirPath)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	defer o.releaseWorkdirQuota(ctx, quota)
+
+	phase := o.containerPhase(ctx, req.Language, lang, quota.volume, limits, o.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, o.workdirs.buildTimeout(), limits.RunTimeout(), req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+
+	if req.Workdir != "" || (!req.SkipArtifacts && result.hasArtifacts()) {
+		if err := o.copyFromContainer(ctx, quota.holder, workdirPath); err != nil {
+			return ExecuteResult{}, err
+		}
+	}
+	return result, nil
+}
+
+// startWorkdirQuota creates the tmpfs volume of an execution, starts its holder container and copies the
+// workdir into it. The caller releases the quota with releaseWorkdirQuota.
+func (o *OCIExecutor) startWorkdirQuota(ctx context.Context, language string, lang Language, workdirPath string) (*workdirQuota, error) {
+	name := fmt.Sprintf("codebox-workdir-%d", time.Now().UnixNano())
+	sizeMB := o.cfg.Sandbox.Filesystem.WorkdirSizeMB
+	runArgs, err := o.runArgs(name, language, o.config.limits(0, 0, false))
+	if err != nil {
+		return nil, err
+	}
+	err = o.cli(ctx, "volume", "create", "--label", instanceLabel(o.instance),
+		"--opt", "type=tmpfs", "--opt", "device=tmpfs", "--opt", fmt.Sprintf("o=size=%dm,mode=1777", sizeMB),
+		name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create workdir volume: %w", err)
+	}
+	quota := &workdirQuota{volume: name, holder: name}
+
+	cmdArgs := append(runArgs,
+		"--detach",
+		"-v", quota.volume+":"+WorkDirPath,
+		lang.Image,
+	)
+	cmdArgs = append(cmdArgs, containerIdleCommand...)
+	o.containers.track(quota.holder)
+	if err := o.cli(ctx, cmdArgs...); err != nil {
+		o.releaseWorkdirQuota(ctx, quota)
+		return nil, fmt.Errorf("failed to start workdir container: %w", err)
+	}
+
+	if err := o.copyToContainer(ctx, quota.holder, workdirPath); err != nil {
+		o.releaseWorkdirQuota(ctx, quota)
+		if strings.Contains(err.Error(), "no space left on device") {
+			return nil, fmt.Errorf("workdir exceeds the quota of %d MB: %w", sizeMB, err)
+		}
+		return nil, err
+	}
+	return quota, nil
+}
+
+// releaseWorkdirQuota removes the holder container and the volume of a workdir quota.
+// Cleanup outlives the cancellation of ctx.
+func (o *OCIExecutor) releaseWorkdirQuota(ctx context.Context, quota *workdirQuota) {
+	o.containers.release(ctx, quota.holder, true)
+
+	cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), containerCleanupTimeout)
+	defer cancel()
+	if err := o.cli(cleanupCtx, "volume", "rm", "-f", quota.volume); err != nil {
+		o.logger.Warn("failed to remove workdir volume", zap.String("volume", quota.volume), zap.Error(err))
+	}
+}
@@ -0,0 +1,118 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// findCall returns the first recorded command line that starts with prefix after the binary
+func findCall(calls [][]string, prefix ...string) []string {
+	for _, call := range calls {
+		if len(call) > len(prefix) && slices.Equal(call[1:len(prefix)+1], prefix) {
+			return call
+		}
+	}
+	return nil
+}
+
+func TestOCIExecutorWorkdirQuota(t *testing.T) {
+	cfg := &config.Config{
+		Sandbox:   config.SandboxConfig{Filesystem: config.FilesystemConfig{WorkdirSizeMB: 64}},
+		Languages: map[string]config.Language{LanguagePython: {}},
+	}
+
+	// Phases fill the workdir and fail to write, and df reports the workdir full afterwards
+	newRunner := func(t *testing.T) *FuncCommandRunner {
+		t.Helper()
+		return &FuncCommandRunner{run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			switch {
+			case isPhaseCommand(cmd.Args):
+				return CommandResult{Stderr: "OSError: [Errno 28] No space left on device\n", ExitCode: 1}, nil
+			case isUsageCommand(cmd.Args):
+				df := "Filesystem 1024-blocks Used Available Capacity Mounted on\ntmpfs 65536 65536 0 100% /workdir\n"
+				return CommandResult{Stdout: containerUsageOutput("", "", 0, df)}, nil
+			default:
+				return CommandResult{}, nil
+			}
+		}}
+	}
+
+	t.Run("Execute", func(t *testing.T) {
+		runner := newRunner(t)
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}, cfg,
+			dockerRuntime, WithOCICommandRunner(runner))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "open('big', 'w').write('x' * 10**9)"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusDiskQuotaExceeded, result.Status)
+		assert.True(t, result.Usage.DiskQuotaExceeded)
+
+		// The workdir is a tmpfs volume that a holder container keeps mounted for cp
+		calls := runner.Calls()
+		create := findCall(calls, "volume", "create")
+		require.NotNil(t, create)
+		assert.Subset(t, create, []string{"type=tmpfs", "device=tmpfs", "o=size=64m,mode=1777"})
+		volume := create[len(create)-1]
+
+		holder := findCall(calls, "run", "--name", volume)
+		require.NotNil(t, holder)
+		assert.Subset(t, holder, []string{"--detach", "--read-only", volume + ":" + WorkDirPath})
+		assert.NotNil(t, findCall(calls, "cp"), "the workdir is copied into the volume")
+
+		// The phase runs in a container of its own on the volume instead of the host workdir
+		var phase []string
+		for _, call := range calls {
+			if call[1] == "run" && !slices.Contains(call, volume) {
+				phase = call
+			}
+		}
+		require.NotNil(t, phase)
+		assert.Contains(t, phase, volume+":"+WorkDirPath)
+
+		// The holder and the volume are removed afterwards
+		assert.NotNil(t, findCall(calls, "rm", "-f", "-v", volume))
+		assert.Equal(t, []string{"docker", "volume", "rm", "-f", volume}, findCall(calls, "volume", "rm"))
+	})
+
+	t.Run("SessionWorkdirIsCopiedBack", func(t *testing.T) {
+		runner := newRunner(t)
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}, cfg,
+			dockerRuntime, WithOCICommandRunner(runner))
+		workdir := filepath.Join(t.TempDir(), "workdir")
+		require.NoError(t, os.MkdirAll(workdir, DirPermission))
+
+		_, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguagePython, Code: "pass", Workdir: workdir, SkipArtifacts: true,
+		})
+		require.NoError(t, err)
+
+		volume := findCall(runner.Calls(), "volume", "create")
+		require.NotNil(t, volume)
+		assert.NotNil(t, findCall(runner.Calls(), "cp", volume[len(volume)-1]+":"+WorkDirPath+"/.", workdir))
+	})
+
+	t.Run("NoQuota", func(t *testing.T) {
+		// Without a quota, the workdir is a bind mount of the host, whose disk may be nearly full
+		cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}, LanguageGo: {}}}
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}, cfg,
+			dockerRuntime, WithOCICommandRunner(newRunner(t)))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "raise SystemExit(1)"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusNonzeroExit, result.Status)
+		assert.False(t, result.Usage.DiskQuotaExceeded)
+
+		result, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguageGo, Code: "package main"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusCompileError, result.Status)
+	})
+}
@@ -0,0 +1,87 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Containers can run with another OCI runtime
+// than the default of the engine, like gVisor's runsc or kata, which has to be
+// registered with the engine before the server starts using it.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// runtimeCheckTimeout bounds the startup check of the OCI runtimes
+const runtimeCheckTimeout = 30 * time.Second
+
+// checkRuntimes makes sure that every OCI runtime selected by the configuration is known to the container engine
+func (o *OCIExecutor) checkRuntimes(ctx context.Context) error {
+	for _, name := range o.cfg.GetRuntimes() {
+		var err error
+		switch o.runtime.RuntimeCheck {
+		case config.RuntimeCheckFlag:
+			err = o.checkRuntimeFlag(ctx, name)
+		case config.RuntimeCheckShim:
+			err = checkRuntimeShim(name)
+		default:
+			err = o.checkRuntimeInfo(ctx, name)
+		}
+		if err != nil {
+			return fmt.Errorf("OCI runtime %s is not available: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// checkRuntimeInfo looks the runtime up in the runtimes registered with the daemon, like docker lists them
+func (o *OCIExecutor) checkRuntimeInfo(ctx context.Context, name string) error {
+	output, err := o.cmdRunner.RunCommand(ctx, Command{Args: o.command("info", "--format", "{{json .Runtimes}}")})
+	if err != nil {
+		return err
+	}
+	if output.ExitCode != 0 {
+		return fmt.Errorf("%s info: %s", o.runtime.Binary, strings.TrimSpace(output.Stderr))
+	}
+
+	var runtimes map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(output.Stdout), &runtimes); err != nil {
+		return fmt.Errorf("failed to parse the runtimes of %s: %w", o.runtime.Binary, err)
+	}
+	if _, ok := runtimes[name]; !ok {
+		registered := slices.Sorted(maps.Keys(runtimes))
+		return fmt.Errorf("not registered with %s, registered runtimes: %s", o.runtime.Binary, strings.Join(registered, ", "))
+	}
+	return nil
+}
+
+// checkRuntimeFlag passes the runtime as the global --runtime flag of a CLI like podman,
+// which refuses to run any command with a runtime that it cannot find
+func (o *OCIExecutor) checkRuntimeFlag(ctx context.Context, name string) error {
+	output, err := o.cmdRunner.RunCommand(ctx, Command{Args: o.command("--runtime", name, "info", "--format", "{{.Host.OCIRuntime.Name}}")})
+	if err != nil {
+		return err
+	}
+	if output.ExitCode != 0 {
+		return fmt.Errorf("%s info: %s", o.runtime.Binary, strings.TrimSpace(output.Stderr))
+	}
+	return nil
+}
+
+// checkRuntimeShim looks for the executable that containerd runs for the runtime: the shim of a runtime type like
+// io.containerd.runsc.v1, which is containerd-shim-runsc-v1, or otherwise the runc compatible binary itself
+func checkRuntimeShim(name string) error {
+	binary := name
+	if parts := strings.Split(name, "."); len(parts) == 4 && !strings.Contains(name, "/") { //nolint:mnd // io.containerd.<name>.<version>
+		binary = fmt.Sprintf("containerd-shim-%s-%s", parts[2], parts[3])
+	}
+	_, err := exec.LookPath(binary)
+	return err
+}
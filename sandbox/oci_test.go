@@ -0,0 +1,205 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestOCIExecutorRuntime(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}}}
+	runtime := OCIRuntime{
+		Name:       "nerdctl",
+		Binary:     "/usr/local/bin/nerdctl",
+		GlobalArgs: []string{"--namespace", "codebox"},
+		RunArgs:    []string{"--pull=never"},
+		Network:    "codebox-net",
+	}
+
+	runner := &FuncCommandRunner{
+		run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			switch {
+			case isPhaseCommand(cmd.Args):
+				time.Sleep(10 * time.Millisecond)
+				return CommandResult{Stdout: "ok\n"}, nil
+			case isUsageCommand(cmd.Args):
+				return CommandResult{Stdout: containerUsageOutput("", "2048\n", 0, "")}, nil
+			default:
+				return CommandResult{}, nil
+			}
+		},
+	}
+	executor := NewOCIExecutor(logger, executorConfig, cfg, runtime, WithOCICommandRunner(runner))
+
+	result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print('ok')", Network: true})
+	require.NoError(t, err)
+	assert.Equal(t, "ok\n", result.Stdout)
+	assert.Equal(t, int64(2048), result.Usage.PeakMemoryBytes)
+	assert.GreaterOrEqual(t, result.Usage.WallTime, 10*time.Millisecond, "the run time of the exec is measured")
+
+	calls := runner.Calls()
+	require.Len(t, calls, 4)
+	run := calls[0]
+	assert.Equal(t, []string{"/usr/local/bin/nerdctl", "--namespace", "codebox", "run"}, run[:4])
+	assert.Contains(t, run, "--pull=never")
+	assert.Less(t, slices.Index(run, "--pull=never"), slices.Index(run, "python:3.11-slim"), "run arguments go before the image")
+	networkAt := slices.Index(run, "codebox-net")
+	require.Positive(t, networkAt)
+	assert.Equal(t, "--network", run[networkAt-1])
+	assert.Equal(t, []string{"/usr/local/bin/nerdctl", "--namespace", "codebox", "exec"}, calls[1][:4])
+	assert.Equal(t, []string{"/usr/local/bin/nerdctl", "--namespace", "codebox", "exec", "--user", containerRootUser}, calls[2][:6])
+	assert.Equal(t, []string{"/usr/local/bin/nerdctl", "--namespace", "codebox", "rm", "-f"}, calls[3][:5])
+}
+
+func TestOCIRuntimeResolution(t *testing.T) {
+	newConfig := func(backend string, runtimes map[string]config.RuntimeConfig) *config.Config {
+		return &config.Config{Sandbox: config.SandboxConfig{Backend: backend, Runtimes: runtimes}}
+	}
+
+	t.Run("BuiltIn", func(t *testing.T) {
+		runtime, ok := ociRuntime(newConfig(config.BackendNerdctl, nil))
+		require.True(t, ok)
+		assert.Equal(t, nerdctlRuntime, runtime)
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		pool := false
+		runtime, ok := ociRuntime(newConfig(config.BackendPodman, map[string]config.RuntimeConfig{
+			config.BackendPodman: {
+				Binary: "/opt/podman/bin/podman", RunArgs: []string{"--userns=keep-id"}, Pool: &pool, RuntimeCheck: config.RuntimeCheckShim,
+			},
+		}))
+		require.True(t, ok)
+		assert.Equal(t, "/opt/podman/bin/podman", runtime.Binary)
+		assert.Equal(t, []string{"--userns=keep-id"}, runtime.RunArgs)
+		assert.Equal(t, "bridge", runtime.Network)
+		assert.False(t, runtime.Pool)
+		assert.Equal(t, config.RuntimeCheckShim, runtime.RuntimeCheck)
+	})
+
+	t.Run("Added", func(t *testing.T) {
+		runtime, ok := ociRuntime(newConfig("finch", map[string]config.RuntimeConfig{"finch": {Binary: "finch"}}))
+		require.True(t, ok)
+		assert.Equal(t, OCIRuntime{
+			Name: "finch", Binary: "finch", Network: "bridge", Pool: true, RuntimeCheck: config.RuntimeCheckInfo,
+		}, runtime)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, ok := ociRuntime(newConfig("finch", nil))
+		assert.False(t, ok)
+	})
+}
+
+func TestNewExecutorPoolCapability(t *testing.T) {
+	cfg := &config.Config{
+		Sandbox: config.SandboxConfig{
+			Backend: config.BackendNerdctl,
+			Pool:    config.PoolConfig{Enabled: true, Size: 1},
+		},
+		Languages: map[string]config.Language{LanguagePython: {}},
+	}
+
+	_, err := NewExecutor(zaptest.NewLogger(t), cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sandbox.pool is not supported by the nerdctl runtime")
+
+	cfg.Sandbox.Pool = config.PoolConfig{}
+	cfg.Sandbox.Filesystem.WorkdirSizeMB = 64
+	_, err = NewExecutor(zaptest.NewLogger(t), cfg)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sandbox.filesystem.workdir_size_mb is not supported by the nerdctl runtime")
+}
+
+func TestOCIExecutorOCIRuntime(t *testing.T) {
+	cfg := &config.Config{
+		Sandbox: config.SandboxConfig{Runtime: "runsc"},
+		Languages: map[string]config.Language{
+			LanguagePython: {},
+			LanguageNodeJS: {Runtime: "runc"},
+		},
+	}
+	newExecutor := func(runtime OCIRuntime, runner CommandRunner) *OCIExecutor {
+		executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+		return NewOCIExecutor(zaptest.NewLogger(t), executorConfig, cfg, runtime, WithOCICommandRunner(runner))
+	}
+
+	t.Run("PassedToRun", func(t *testing.T) {
+		runner := &FuncCommandRunner{}
+		executor := newExecutor(dockerRuntime, runner)
+
+		for language, runtime := range map[string]string{LanguagePython: "runsc", LanguageNodeJS: "runc"} {
+			result, err := executor.Execute(context.Background(), ExecuteRequest{Language: language, Code: "1"})
+			require.NoError(t, err)
+			assert.Equal(t, runtime, result.Runtime)
+
+			runs := runner.RunCalls()
+			run := runs[len(runs)-1]
+			runtimeAt := slices.Index(run, "--runtime")
+			require.Positive(t, runtimeAt, language)
+			assert.Equal(t, runtime, run[runtimeAt+1])
+		}
+	})
+
+	t.Run("Info", func(t *testing.T) {
+		registered := `{"io.containerd.runc.v2":{"path":"runc"},"runc":{"path":"runc"}}`
+		runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) {
+			return CommandResult{Stdout: registered}, nil
+		}}
+		executor := newExecutor(dockerRuntime, runner)
+
+		err := executor.checkRuntimes(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "OCI runtime runsc is not available: not registered with docker")
+		assert.Contains(t, err.Error(), "registered runtimes: io.containerd.runc.v2, runc")
+		assert.Equal(t, []string{"docker", "info", "--format", "{{json .Runtimes}}"}, runner.Calls()[0])
+
+		registered = `{"runc":{"path":"runc"},"runsc":{"path":"/usr/local/bin/runsc"}}`
+		require.NoError(t, executor.checkRuntimes(context.Background()))
+	})
+
+	t.Run("Flag", func(t *testing.T) {
+		runner := &FuncCommandRunner{run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			if cmd.Args[2] == "runsc" {
+				return CommandResult{ExitCode: 125, Stderr: `Error: default OCI runtime "runsc" not found`}, nil
+			}
+			return CommandResult{Stdout: "runc\n"}, nil
+		}}
+		executor := newExecutor(podmanRuntime, runner)
+
+		err := executor.checkRuntimes(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `OCI runtime runsc is not available: podman info: Error: default OCI runtime "runsc" not found`)
+		assert.Equal(t, []string{"podman", "--runtime", "runc", "info", "--format", "{{.Host.OCIRuntime.Name}}"}, runner.Calls()[0])
+	})
+
+	t.Run("Shim", func(t *testing.T) {
+		binDir := t.TempDir()
+		t.Setenv("PATH", binDir)
+		cfg := &config.Config{Languages: map[string]config.Language{
+			LanguagePython: {Runtime: "io.containerd.runsc.v1"},
+			LanguageNodeJS: {Runtime: "crun"},
+		}}
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{}, cfg, nerdctlRuntime)
+
+		err := executor.checkRuntimes(context.Background())
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "OCI runtime crun is not available")
+
+		for _, binary := range []string{"crun", "containerd-shim-runsc-v1"} {
+			require.NoError(t, os.WriteFile(filepath.Join(binDir, binary), []byte("#!/bin/sh\n"), 0o755)) //nolint:gosec // Test executable
+		}
+		require.NoError(t, executor.checkRuntimes(context.Background()))
+	})
+}
@@ -0,0 +1,257 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Output streams are captured up to a
+// configurable size so that a runaway program cannot exhaust server memory;
+// the complete streams can optionally be spilled into the workdir and
+// streamed to the caller while the program runs.
+package sandbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// OutputLimits bounds how much of each output stream of a command is captured
+type OutputLimits struct {
+	StdoutBytes  int  // 0 captures stdout without limit
+	StderrBytes  int  // 0 captures stderr without limit
+	KillOnExceed bool // kill the command as soon as a stream exceeds its limit
+}
+
+// OutputStats describes the output streams a command produced
+type OutputStats struct {
+	StdoutBytes     int64 // total bytes written to stdout, including the part that was not captured
+	StderrBytes     int64 // total bytes written to stderr, including the part that was not captured
+	StdoutTruncated bool
+	StderrTruncated bool
+}
+
+// Output stream names
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// OutputChunk is a piece of output produced by a phase while it runs
+type OutputChunk struct {
+	Phase  string // PhaseSetup, PhaseBuild or PhaseRun
+	Stream string // StreamStdout or StreamStderr
+	Data   []byte
+}
+
+// OutputHandler receives the output of an execution while it is produced, bounded by the
+// same limits as the captured output. It is called concurrently for stdout and stderr and
+// must not retain Data after returning.
+type OutputHandler func(chunk OutputChunk)
+
+// streamWriter passes everything written to it to an OutputHandler
+type streamWriter struct {
+	phase   string
+	stream  string
+	handler OutputHandler
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	s.handler(OutputChunk{Phase: s.phase, Stream: s.stream, Data: p})
+	return len(p), nil
+}
+
+// newStreamSink returns a writer streaming at most limit bytes to handler, or everything when limit is 0
+func newStreamSink(handler OutputHandler, phase, stream string, limit int) io.Writer {
+	var sink io.Writer = &streamWriter{phase: phase, stream: stream, handler: handler}
+	if limit > 0 {
+		sink = &limitedWriter{w: sink, remaining: int64(limit)}
+	}
+	return sink
+}
+
+// cappedBuffer keeps the first limit bytes written to it and counts the rest.
+// Everything written is also passed to the optional sink.
+type cappedBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	total    int64
+	sink     io.Writer
+	onExceed func()
+}
+
+// newCappedBuffer creates a buffer that captures at most limit bytes, or everything when limit is 0
+func newCappedBuffer(limit int, sink io.Writer, onExceed func()) *cappedBuffer {
+	return &cappedBuffer{limit: limit, sink: sink, onExceed: onExceed}
+}
+
+// Write captures p up to the limit and never fails, so the writing process is not disturbed
+func (c *cappedBuffer) Write(p []byte) (int, error) {
+	wasTruncated := c.truncated()
+	c.total += int64(len(p))
+
+	if c.sink != nil {
+		_, _ = c.sink.Write(p)
+	}
+
+	if c.limit <= 0 {
+		c.buf.Write(p)
+		return len(p), nil
+	}
+
+	if room := c.limit - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(room, len(p))])
+	}
+	if !wasTruncated && c.truncated() && c.onExceed != nil {
+		c.onExceed()
+	}
+	return len(p), nil
+}
+
+// truncated reports whether more was written than the buffer kept
+func (c *cappedBuffer) truncated() bool {
+	return c.total > int64(c.buf.Len())
+}
+
+// String returns the captured output
+func (c *cappedBuffer) String() string {
+	return c.buf.String()
+}
+
+// limitedWriter passes at most remaining bytes to w and silently drops the rest
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.remaining > 0 {
+		n := min(int64(len(p)), l.remaining)
+		written, err := l.w.Write(p[:n])
+		l.remaining -= int64(written)
+		if err != nil {
+			l.remaining = 0
+		}
+	}
+	return len(p), nil
+}
+
+// SpillDirName is the workdir directory that receives the complete output of truncated streams
+const SpillDirName = ".codebox-output"
+
+// outputSpill saves the complete output streams of a phase into files
+type outputSpill struct {
+	dir    string
+	stdout *os.File
+	stderr *os.File
+}
+
+// openOutputSpill creates the spill files <phase>.stdout and <phase>.stderr in dir
+func openOutputSpill(dir, phase string) (*outputSpill, error) {
+	if err := os.MkdirAll(dir, DirPermission); err != nil {
+		return nil, fmt.Errorf("failed to create output spill dir: %w", err)
+	}
+
+	stdout, err := os.Create(filepath.Join(dir, phase+".stdout")) //nolint:gosec // Path is built from the workdir and a phase name
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout spill file: %w", err)
+	}
+	stderr, err := os.Create(filepath.Join(dir, phase+".stderr")) //nolint:gosec // Path is built from the workdir and a phase name
+	if err != nil {
+		_ = stdout.Close()
+		return nil, fmt.Errorf("failed to create stderr spill file: %w", err)
+	}
+
+	return &outputSpill{dir: dir, stdout: stdout, stderr: stderr}, nil
+}
+
+// sinks returns writers for both streams, each bounded by maxBytes
+func (s *outputSpill) sinks(maxBytes int64) (stdout, stderr io.Writer) {
+	return &limitedWriter{w: s.stdout, remaining: maxBytes}, &limitedWriter{w: s.stderr, remaining: maxBytes}
+}
+
+// finish closes the spill files and removes those of streams that were captured completely
+func (s *outputSpill) finish(stats OutputStats) {
+	_ = s.stdout.Close()
+	_ = s.stderr.Close()
+
+	if !stats.StdoutTruncated {
+		_ = os.Remove(s.stdout.Name())
+	}
+	if !stats.StderrTruncated {
+		_ = os.Remove(s.stderr.Name())
+	}
+
+	// Only succeeds when no spill file is left
+	_ = os.Remove(s.dir)
+}
+
+// phaseCapture describes where the output of the phases of an execution goes besides the result
+type phaseCapture struct {
+	spillDir string        // receives the complete output of truncated streams, empty for none
+	stream   OutputHandler // receives the output while it is produced, nil for none
+}
+
+// appendSink adds sink to the writers receiving a stream
+func appendSink(current, sink io.Writer) io.Writer {
+	if current == nil {
+		return sink
+	}
+	return io.MultiWriter(current, sink)
+}
+
+// outputLimits returns the output limits of the executor
+func (c *Config) outputLimits() OutputLimits {
+	return OutputLimits{
+		StdoutBytes:  c.MaxStdoutBytes,
+		StderrBytes:  c.MaxStderrBytes,
+		KillOnExceed: c.KillOnOutputLimit,
+	}
+}
+
+// capturePhase runs a phase with the output limits of the executor. run receives a command carrying
+// the stdin, output limits and sinks of the phase and only has to add what is specific to the backend.
+// When a spill dir is set, the complete streams are written to files there, kept only for truncated streams.
+// When a stream handler is set, the output is passed to it as it is produced.
+func (c *Config) capturePhase(
+	phase string,
+	capture phaseCapture,
+	stdin []byte,
+	run func(Command) (PhaseResult, error),
+) (PhaseResult, error) {
+	cmd := Command{Stdin: stdin, Output: c.outputLimits()}
+	if capture.stream != nil {
+		cmd.StdoutSink = newStreamSink(capture.stream, phase, StreamStdout, cmd.Output.StdoutBytes)
+		cmd.StderrSink = newStreamSink(capture.stream, phase, StreamStderr, cmd.Output.StderrBytes)
+	}
+	if capture.spillDir == "" {
+		return run(cmd)
+	}
+
+	spill, err := openOutputSpill(capture.spillDir, phase)
+	if err != nil {
+		return PhaseResult{}, err
+	}
+	stdoutSpill, stderrSpill := spill.sinks(int64(c.MaxArtifactSizeMB) * MaxArtifactSizeMul)
+	cmd.StdoutSink = appendSink(cmd.StdoutSink, stdoutSpill)
+	cmd.StderrSink = appendSink(cmd.StderrSink, stderrSpill)
+
+	result, err := run(cmd)
+	spill.finish(result.Output)
+	return result, err
+}
+
+// phaseOutput converts the result of a command into a phase result
+func phaseOutput(output *CommandResult) PhaseResult {
+	result := PhaseResult{
+		Stdout:   output.Stdout,
+		Stderr:   output.Stderr,
+		ExitCode: output.ExitCode,
+		Signal:   output.Signal,
+		Usage:    output.Usage,
+		Output:   output.Output,
+	}
+	if output.OutputLimitExceeded {
+		result.Status = StatusOutputLimitExceeded
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	t.Run("Unlimited", func(t *testing.T) {
+		buf := newCappedBuffer(0, nil, nil)
+		_, _ = buf.Write([]byte("hello "))
+		_, _ = buf.Write([]byte("world"))
+		assert.Equal(t, "hello world", buf.String())
+		assert.Equal(t, int64(11), buf.total)
+		assert.False(t, buf.truncated())
+	})
+
+	t.Run("TruncatesAndCounts", func(t *testing.T) {
+		var sink bytes.Buffer
+		exceeded := 0
+		buf := newCappedBuffer(8, &sink, func() { exceeded++ })
+
+		n, err := buf.Write([]byte("12345"))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		assert.Zero(t, exceeded)
+
+		n, err = buf.Write([]byte("67890"))
+		require.NoError(t, err)
+		assert.Equal(t, 5, n)
+		_, _ = buf.Write([]byte("abc"))
+
+		assert.Equal(t, "12345678", buf.String())
+		assert.Equal(t, int64(13), buf.total)
+		assert.True(t, buf.truncated())
+		assert.Equal(t, 1, exceeded, "the limit callback fires once")
+		assert.Equal(t, "1234567890abc", sink.String(), "the sink receives the complete stream")
+	})
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &limitedWriter{w: &out, remaining: 4}
+	n, err := w.Write([]byte("abcdef"))
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+	_, _ = w.Write([]byte("gh"))
+	assert.Equal(t, "abcd", out.String())
+}
+
+func TestRealCommandRunnerOutputLimits(t *testing.T) {
+	runner := RealCommandRunner{}
+	flood := []string{"sh", "-c", "yes | head -c 100000; echo oops >&2"}
+
+	t.Run("Truncates", func(t *testing.T) {
+		result, err := runner.RunCommand(context.Background(), Command{
+			Args:   flood,
+			Output: OutputLimits{StdoutBytes: 1000, StderrBytes: 1000},
+		})
+		require.NoError(t, err)
+		assert.Len(t, result.Stdout, 1000)
+		assert.Equal(t, "oops\n", result.Stderr)
+		assert.Equal(t, OutputStats{StdoutBytes: 100000, StderrBytes: 5, StdoutTruncated: true}, result.Output)
+		assert.False(t, result.OutputLimitExceeded)
+		assert.Equal(t, 0, result.ExitCode)
+	})
+
+	t.Run("KillsOnExceed", func(t *testing.T) {
+		result, err := runner.RunCommand(context.Background(), Command{
+			Args:   []string{"sh", "-c", "while true; do echo flood; done"},
+			Output: OutputLimits{StdoutBytes: 1000, StderrBytes: 1000, KillOnExceed: true},
+		})
+		require.NoError(t, err)
+		assert.True(t, result.OutputLimitExceeded)
+		assert.True(t, result.Output.StdoutTruncated)
+		assert.Len(t, result.Stdout, 1000)
+	})
+}
+
+func TestLocalExecutorOutputLimits(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	code := "import sys\nprint('x' * 5000)\nprint('err', file=sys.stderr)\n"
+
+	t.Run("KillOnOutputLimit", func(t *testing.T) {
+		executorConfig := &Config{
+			TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5,
+			MaxStdoutBytes: 1024, MaxStderrBytes: 1024, KillOnOutputLimit: true,
+		}
+		executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguagePython,
+			Code:     "while True:\n    print('flood')\n",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOutputLimitExceeded, result.Status)
+		assert.True(t, result.Output.StdoutTruncated)
+		assert.Len(t, result.Stdout, 1024)
+	})
+
+	t.Run("SpillsTruncatedStreams", func(t *testing.T) {
+		executorConfig := &Config{
+			TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5,
+			MaxStdoutBytes: 1024, MaxStderrBytes: 1024, SpillOutput: true,
+		}
+		executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: code})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOK, result.Status)
+		assert.Equal(t, OutputStats{StdoutBytes: 5001, StderrBytes: 4, StdoutTruncated: true}, result.Output)
+		assert.Len(t, result.Stdout, 1024)
+
+		artifactsDir := t.TempDir()
+		require.NoError(t, ExtractTarToDir(RealFileSystem{}, result.ArtifactsTar, artifactsDir))
+
+		spilled, err := os.ReadFile(filepath.Join(artifactsDir, SpillDirName, "run.stdout"))
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", 5000)+"\n", string(spilled))
+
+		// Streams that fit are not spilled
+		assert.NoFileExists(t, filepath.Join(artifactsDir, SpillDirName, "run.stderr"))
+	})
+
+	t.Run("NoSpillWithoutTruncation", func(t *testing.T) {
+		executorConfig := &Config{TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5, SpillOutput: true}
+		executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: code})
+		require.NoError(t, err)
+		assert.False(t, result.Output.StdoutTruncated)
+
+		artifactsDir := t.TempDir()
+		require.NoError(t, ExtractTarToDir(RealFileSystem{}, result.ArtifactsTar, artifactsDir))
+		assert.NoDirExists(t, filepath.Join(artifactsDir, SpillDirName))
+	})
+}
+
+func TestLocalExecutorStreamsOutput(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5, MaxStdoutBytes: 1024, MaxStderrBytes: 1024}
+	executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+	var mu sync.Mutex
+	streamed := map[string]*strings.Builder{StreamStdout: {}, StreamStderr: {}}
+	result, err := executor.Execute(context.Background(), ExecuteRequest{
+		Language: LanguagePython,
+		Code:     "import sys\nprint('x' * 5000, flush=True)\nprint('err', file=sys.stderr)\n",
+		Stream: func(chunk OutputChunk) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, PhaseRun, chunk.Phase)
+			streamed[chunk.Stream].Write(chunk.Data)
+		},
+	})
+	require.NoError(t, err)
+
+	// The stream is bounded by the same limits as the captured output
+	assert.Equal(t, result.Stdout, streamed[StreamStdout].String())
+	assert.Len(t, result.Stdout, 1024)
+	assert.Equal(t, "err\n", streamed[StreamStderr].String())
+}
@@ -0,0 +1,145 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Execution is split into an optional setup
+// phase with network access, a build phase for compiled languages and a run
+// phase, each with its own output, exit code, duration and time limit.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Execution phase names
+const (
+	PhaseSetup = "setup"
+	PhaseBuild = "build"
+	PhaseRun   = "run"
+)
+
+// PhaseResult represents the outcome of a single execution phase
+type PhaseResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	TimedOut bool
+	Status   Status
+	Signal   string // name of the signal that killed the phase, e.g. SIGSEGV
+	Usage    ResourceUsage
+	Output   OutputStats
+}
+
+// Succeeded reports whether the phase finished in time with a zero exit code
+func (p *PhaseResult) Succeeded() bool {
+	return p != nil && !p.TimedOut && p.ExitCode == 0
+}
+
+// phaseFunc runs a single shell command in the prepared workdir of an executor.
+// A non-nil stdin is fed to the command's standard input.
+type phaseFunc func(ctx context.Context, phase, command string, stdin []byte) (PhaseResult, error)
+
+// runPhases runs the setup command of the request (if any), the build phase (for compiled languages)
+// and then the run phase, each bounded by its own timeout. The setup phase is bounded by the setup
+// timeout of the request, or buildTimeout when unset. Every phase is skipped when the one before fails.
+// Only the run phase receives the stdin of the request. The top-level output of the result mirrors
+// the last phase that ran.
+func runPhases(
+	ctx context.Context,
+	lang Language,
+	buildTimeout, runTimeout time.Duration,
+	req *ExecuteRequest,
+	run phaseFunc,
+) (ExecuteResult, error) {
+	var result ExecuteResult
+
+	if req.Setup != "" {
+		setupTimeout := buildTimeout
+		if req.SetupTimeoutSec > 0 {
+			setupTimeout = time.Duration(req.SetupTimeoutSec) * time.Second
+		}
+		setup, err := runTimedPhase(ctx, setupTimeout, PhaseSetup, req.Setup, nil, run)
+		if err != nil {
+			return ExecuteResult{}, fmt.Errorf("setup phase failed: %w", err)
+		}
+		result.Setup = &setup
+		result.setOutput(&setup)
+		result.Usage.add(&setup.Usage)
+		if !setup.Succeeded() {
+			result.Status = setupStatus(&setup)
+			return result, nil
+		}
+	}
+
+	build, err := runBuildPhase(ctx, lang, buildTimeout, run)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	if build != nil {
+		result.Build = build
+		result.setOutput(build)
+		result.Usage.add(&build.Usage)
+		if !build.Succeeded() {
+			result.Status = buildStatus(build)
+			return result, nil
+		}
+	}
+
+	runResult, err := runTimedPhase(ctx, runTimeout, PhaseRun, lang.RunCmd, req.Stdin, run)
+	if err != nil {
+		return ExecuteResult{}, fmt.Errorf("run phase failed: %w", err)
+	}
+	result.Run = &runResult
+	result.setOutput(&runResult)
+	result.Usage.add(&runResult.Usage)
+
+	return result, nil
+}
+
+// runBuildPhase runs the build phase of a compiled language, returning nil for interpreted ones
+func runBuildPhase(ctx context.Context, lang Language, buildTimeout time.Duration, run phaseFunc) (*PhaseResult, error) {
+	if !lang.Compiled() {
+		return nil, nil
+	}
+
+	build, err := runTimedPhase(ctx, buildTimeout, PhaseBuild, lang.BuildCmd, nil, run)
+	if err != nil {
+		return nil, fmt.Errorf("build phase failed: %w", err)
+	}
+	return &build, nil
+}
+
+// runTimedPhase runs a phase under its own timeout and measures its duration
+func runTimedPhase(ctx context.Context, timeout time.Duration, phase, command string, stdin []byte, run phaseFunc) (PhaseResult, error) {
+	phaseCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	result, err := run(phaseCtx, phase, command, stdin)
+	result.Duration = time.Since(start)
+	if result.Usage.WallTime == 0 {
+		result.Usage.WallTime = result.Duration
+	}
+
+	// If the phase timed out, report it as a failed phase rather than an error
+	if errors.Is(phaseCtx.Err(), context.DeadlineExceeded) {
+		result.TimedOut = true
+		result.ExitCode = timeoutExitCode
+	} else if err != nil {
+		return PhaseResult{}, err
+	}
+
+	result.Status, result.Signal = classifyPhase(&result)
+	return result, nil
+}
+
+// phaseTimeout converts a timeout in seconds to a duration, using the fallback when unset
+func phaseTimeout(timeoutSec, fallbackSec int) time.Duration {
+	if timeoutSec <= 0 {
+		timeoutSec = fallbackSec
+	}
+	return time.Duration(timeoutSec) * time.Second
+}
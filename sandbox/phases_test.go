@@ -0,0 +1,352 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// FuncCommandRunner implements CommandRunner with a function and records every call
+type FuncCommandRunner struct {
+	mu       sync.Mutex
+	commands []Command
+	run      func(ctx context.Context, cmd Command) (CommandResult, error)
+}
+
+func (f *FuncCommandRunner) RunCommand(ctx context.Context, cmd Command) (CommandResult, error) {
+	f.mu.Lock()
+	f.commands = append(f.commands, cmd)
+	f.mu.Unlock()
+
+	if f.run == nil {
+		return CommandResult{}, nil
+	}
+	return f.run(ctx, cmd)
+}
+
+// Commands returns the recorded commands
+func (f *FuncCommandRunner) Commands() []Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Command(nil), f.commands...)
+}
+
+// Calls returns the recorded command lines
+func (f *FuncCommandRunner) Calls() [][]string {
+	var calls [][]string
+	for _, cmd := range f.Commands() {
+		calls = append(calls, cmd.Args)
+	}
+	return calls
+}
+
+// Runs returns the recorded container runs, leaving out inspect and cleanup commands
+func (f *FuncCommandRunner) Runs() []Command {
+	var runs []Command
+	for _, cmd := range f.Commands() {
+		if len(cmd.Args) > 1 && cmd.Args[1] == "run" {
+			runs = append(runs, cmd)
+		}
+	}
+	return runs
+}
+
+// RunCalls returns the command lines of the recorded container runs
+func (f *FuncCommandRunner) RunCalls() [][]string {
+	var calls [][]string
+	for _, cmd := range f.Runs() {
+		calls = append(calls, cmd.Args)
+	}
+	return calls
+}
+
+// Phases returns the recorded phase commands run with exec
+func (f *FuncCommandRunner) Phases() []Command {
+	var phases []Command
+	for _, cmd := range f.Commands() {
+		if isPhaseCommand(cmd.Args) {
+			phases = append(phases, cmd)
+		}
+	}
+	return phases
+}
+
+// PhaseCalls returns the command lines of the recorded phases
+func (f *FuncCommandRunner) PhaseCalls() [][]string {
+	var calls [][]string
+	for _, cmd := range f.Phases() {
+		calls = append(calls, cmd.Args)
+	}
+	return calls
+}
+
+func TestRunPhases(t *testing.T) {
+	interpreted := Language{Name: LanguagePython, RunCmd: "python main.py"}
+	compiled := Language{Name: LanguageGo, BuildCmd: "go build -o app main.go", RunCmd: "./app"}
+
+	t.Run("InterpretedRunsOnlyRunPhase", func(t *testing.T) {
+		var phases []string
+		result, err := runPhases(context.Background(), interpreted, time.Second, time.Second, &ExecuteRequest{},
+			func(_ context.Context, phase, command string, _ []byte) (PhaseResult, error) {
+				phases = append(phases, phase+":"+command)
+				return PhaseResult{Stdout: "hello\n"}, nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"run:python main.py"}, phases)
+		assert.Nil(t, result.Build)
+		require.NotNil(t, result.Run)
+		assert.Equal(t, "hello\n", result.Stdout)
+		assert.True(t, result.hasArtifacts())
+	})
+
+	t.Run("CompiledRunsBothPhases", func(t *testing.T) {
+		var phases []string
+		result, err := runPhases(context.Background(), compiled, time.Second, time.Second, &ExecuteRequest{},
+			func(_ context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				phases = append(phases, phase)
+				return PhaseResult{Stdout: phase + " output"}, nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, []string{PhaseBuild, PhaseRun}, phases)
+		require.NotNil(t, result.Build)
+		require.NotNil(t, result.Run)
+		assert.Equal(t, "build output", result.Build.Stdout)
+		assert.Equal(t, "run output", result.Stdout)
+	})
+
+	t.Run("BuildFailureSkipsRun", func(t *testing.T) {
+		result, err := runPhases(context.Background(), compiled, time.Second, time.Second, &ExecuteRequest{},
+			func(_ context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				if phase == PhaseRun {
+					t.Fatal("run phase must not be executed after a failed build")
+				}
+				return PhaseResult{Stderr: "main.go:1: syntax error", ExitCode: 2}, nil
+			})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.Nil(t, result.Run)
+		assert.False(t, result.Build.Succeeded())
+		assert.Equal(t, 2, result.ExitCode)
+		assert.Equal(t, "main.go:1: syntax error", result.Stderr)
+		assert.Equal(t, StatusCompileError, result.Status)
+		assert.False(t, result.hasArtifacts())
+	})
+
+	t.Run("SeparateTimeouts", func(t *testing.T) {
+		result, err := runPhases(context.Background(), compiled, time.Second, 20*time.Millisecond, &ExecuteRequest{},
+			func(ctx context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				if phase == PhaseRun {
+					<-ctx.Done()
+					return PhaseResult{Stdout: "partial"}, ctx.Err()
+				}
+				return PhaseResult{}, nil
+			})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.False(t, result.Build.TimedOut)
+		require.NotNil(t, result.Run)
+		assert.True(t, result.Run.TimedOut)
+		assert.Equal(t, "partial", result.Run.Stdout)
+		assert.Equal(t, StatusTimeout, result.Status)
+		assert.Equal(t, -1, result.ExitCode)
+		// A timeout is only reported through the status, never through the program output
+		assert.Empty(t, result.Stderr)
+		assert.GreaterOrEqual(t, result.Run.Duration, 20*time.Millisecond)
+		assert.False(t, result.hasArtifacts())
+	})
+
+	t.Run("SetupRunsFirst", func(t *testing.T) {
+		var phases []string
+		req := &ExecuteRequest{Setup: "pip install -r requirements.txt", Stdin: []byte("input")}
+		result, err := runPhases(context.Background(), compiled, time.Second, time.Second, req,
+			func(_ context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+				phases = append(phases, phase+":"+command)
+				if phase != PhaseRun {
+					assert.Nil(t, stdin, "only the run phase gets the stdin")
+				}
+				return PhaseResult{Stdout: phase + " output"}, nil
+			})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"setup:pip install -r requirements.txt", "build:go build -o app main.go", "run:./app"}, phases)
+		require.NotNil(t, result.Setup)
+		assert.Equal(t, "setup output", result.Setup.Stdout)
+		assert.Equal(t, "run output", result.Stdout)
+		assert.Equal(t, StatusOK, result.Status)
+	})
+
+	t.Run("SetupFailureSkipsBuildAndRun", func(t *testing.T) {
+		result, err := runPhases(context.Background(), compiled, time.Second, time.Second, &ExecuteRequest{Setup: "npm ci"},
+			func(_ context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				if phase != PhaseSetup {
+					t.Fatalf("%s phase must not be executed after a failed setup", phase)
+				}
+				return PhaseResult{Stderr: "npm ERR! network", ExitCode: 1}, nil
+			})
+		require.NoError(t, err)
+		require.NotNil(t, result.Setup)
+		assert.Nil(t, result.Build)
+		assert.Nil(t, result.Run)
+		assert.Equal(t, StatusSetupError, result.Status)
+		assert.Equal(t, "npm ERR! network", result.Stderr)
+		assert.False(t, result.hasArtifacts())
+	})
+
+	t.Run("SetupTimeout", func(t *testing.T) {
+		req := &ExecuteRequest{Setup: "sleep 60", SetupTimeoutSec: 1}
+		result, err := runPhases(context.Background(), interpreted, time.Hour, time.Second, req,
+			func(ctx context.Context, phase, _ string, _ []byte) (PhaseResult, error) {
+				deadline, ok := ctx.Deadline()
+				require.True(t, ok)
+				assert.WithinDuration(t, time.Now().Add(time.Second), deadline, 100*time.Millisecond,
+					"the setup phase has its own time limit")
+				<-ctx.Done()
+				return PhaseResult{}, ctx.Err()
+			})
+		require.NoError(t, err)
+		require.NotNil(t, result.Setup)
+		assert.True(t, result.Setup.TimedOut)
+		assert.Equal(t, StatusTimeout, result.Status)
+		assert.Nil(t, result.Run)
+	})
+
+	t.Run("PhaseError", func(t *testing.T) {
+		_, err := runPhases(context.Background(), interpreted, time.Second, time.Second, &ExecuteRequest{},
+			func(_ context.Context, _, _ string, _ []byte) (PhaseResult, error) {
+				return PhaseResult{}, errors.New("runtime unavailable")
+			})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "run phase failed")
+	})
+}
+
+func TestDockerExecutorPhases(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 5, BuildTimeoutSec: 30, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	cfg := &config.Config{Languages: map[string]config.Language{LanguageGo: {}}}
+
+	t.Run("CompileErrorReportedAsBuildPhase", func(t *testing.T) {
+		runner := &FuncCommandRunner{
+			run: func(_ context.Context, cmd Command) (CommandResult, error) {
+				if strings.HasPrefix(cmd.Args[len(cmd.Args)-1], "go build") {
+					return CommandResult{Stderr: "./main.go:3:1: syntax error", ExitCode: 1}, nil
+				}
+				return CommandResult{}, nil
+			},
+		}
+		executor := NewDockerExecutor(logger, executorConfig, cfg,
+			WithDockerCommandRunner(runner), WithDockerFileSystem(&TarTestMockFileSystem{}))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguageGo, Code: "package main"})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.Nil(t, result.Run)
+		assert.Equal(t, 1, result.Build.ExitCode)
+		assert.Contains(t, result.Build.Stderr, "syntax error")
+		assert.Len(t, runner.RunCalls(), 1)
+	})
+
+	t.Run("BuildAndRunInSeparateContainers", func(t *testing.T) {
+		runner := &FuncCommandRunner{
+			run: func(_ context.Context, cmd Command) (CommandResult, error) {
+				if cmd.Args[len(cmd.Args)-1] == "./app" {
+					return CommandResult{Stdout: "hello\n"}, nil
+				}
+				return CommandResult{}, nil
+			},
+		}
+		executor := NewDockerExecutor(logger, executorConfig, cfg, WithDockerCommandRunner(runner))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguageGo, Code: "package main"})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		require.NotNil(t, result.Run)
+		assert.Equal(t, "hello\n", result.Stdout)
+
+		assert.Len(t, runner.RunCalls(), 2)
+		calls := runner.PhaseCalls()
+		require.Len(t, calls, 2)
+		assert.Equal(t, "go build -o app main.go", calls[0][len(calls[0])-1])
+		assert.Equal(t, "./app", calls[1][len(calls[1])-1])
+	})
+
+	t.Run("SetupWithNetworkThenOfflineRun", func(t *testing.T) {
+		runner := &FuncCommandRunner{run: func(context.Context, Command) (CommandResult, error) { return CommandResult{}, nil }}
+		executor := NewDockerExecutor(logger, executorConfig, cfg, WithDockerCommandRunner(runner))
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguageGo, Code: "package main", Setup: "go mod download", SetupTimeoutSec: 300,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, result.Setup)
+		require.NotNil(t, result.Run)
+		assert.False(t, result.Limits.Network)
+
+		phases := runner.PhaseCalls()
+		require.Len(t, phases, 3)
+		assert.Equal(t, "go mod download", phases[0][len(phases[0])-1])
+		calls := runner.RunCalls()
+		require.Len(t, calls, 3)
+		assert.Equal(t, []string{"bridge"}, networkArgs(calls[0]), "the setup container has network access")
+		for _, call := range calls[1:] {
+			assert.Equal(t, []string{"none"}, networkArgs(call), "the build and run containers are offline")
+		}
+
+		// Every phase works on the same workdir
+		workdirMount := func(call []string) string {
+			for _, arg := range call {
+				if strings.HasSuffix(arg, ":"+WorkDirPath) {
+					return arg
+				}
+			}
+			return ""
+		}
+		assert.NotEmpty(t, workdirMount(calls[0]))
+		assert.Equal(t, workdirMount(calls[0]), workdirMount(calls[2]))
+	})
+}
+
+func TestLocalExecutorPhases(t *testing.T) {
+	if _, err := exec.LookPath("g++"); err != nil {
+		t.Skip("g++ is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 10, BuildTimeoutSec: 60, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+	t.Run("CompileError", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguageCPP,
+			Code:     "int main() { return undefined_symbol; }",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.Nil(t, result.Run)
+		assert.NotEqual(t, 0, result.Build.ExitCode)
+		assert.Contains(t, result.Build.Stderr, "undefined_symbol")
+	})
+
+	t.Run("RuntimeFailure", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguageCPP,
+			Code:     "#include <cstdio>\nint main() { std::puts(\"hi\"); return 3; }",
+		})
+		require.NoError(t, err)
+		require.NotNil(t, result.Build)
+		assert.True(t, result.Build.Succeeded())
+		require.NotNil(t, result.Run)
+		assert.Equal(t, 3, result.Run.ExitCode)
+		assert.Equal(t, "hi\n", result.Run.Stdout)
+		assert.Equal(t, 3, result.ExitCode)
+	})
+}
@@ -0,0 +1,245 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The Podman API backend runs containers through
+// the libpod REST API of the podman service, which also serves rootless podman
+// on a per-user socket, and supports podman specific options like user
+// namespace modes.
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// libpodAPIPrefix is the path prefix of the libpod endpoints, which are only served under a version
+const libpodAPIPrefix = "/v4.0.0/libpod"
+
+// DefaultPodmanHost returns the socket of the podman service used when neither sandbox.engine_host
+// nor CONTAINER_HOST is set. Rootless podman serves every user on a socket in its runtime directory.
+func DefaultPodmanHost() string {
+	uid := os.Geteuid()
+	if uid == 0 {
+		return "unix:///run/podman/podman.sock"
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", uid)
+	}
+	return "unix://" + filepath.Join(runtimeDir, "podman", "podman.sock")
+}
+
+// NewPodmanAPIExecutor creates an executor for the podman service listening on host,
+// e.g. unix:///run/podman/podman.sock. The podman options come from sandbox.podman.
+func NewPodmanAPIExecutor(
+	logger *zap.Logger,
+	executorConfig *Config,
+	cfg *config.Config,
+	host string,
+	opts ...EngineAPIExecutorOption,
+) (*EngineAPIExecutor, error) {
+	client, err := newEngineClient(host)
+	if err != nil {
+		return nil, err
+	}
+	engine := &libpodEngine{logger: logger, client: client, userns: cfg.Sandbox.Podman.Userns}
+	return newEngineAPIExecutor(logger, executorConfig, cfg, client, engine, opts...), nil
+}
+
+// libpodSpec is the body of a libpod container create request
+type libpodSpec struct {
+	Name            string            `json:"name"`
+	Image           string            `json:"image"`
+	Command         []string          `json:"command"`
+	Env             map[string]string `json:"env,omitempty"`
+	WorkDir         string            `json:"work_dir"`
+	User            string            `json:"user"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	OCIRuntime      string            `json:"oci_runtime,omitempty"` // default: the service's
+	ResourceLimits  libpodResources   `json:"resource_limits"`
+	NetNS           *libpodNamespace  `json:"netns,omitempty"`
+	UserNS          *libpodNamespace  `json:"userns,omitempty"`
+	NoNewPrivileges bool              `json:"no_new_privileges"`
+	CapDrop         []string          `json:"cap_drop"`
+	Rlimits         []libpodRlimit    `json:"r_limits"`
+	ShmSize         int64             `json:"shm_size,omitempty"`             // size of /dev/shm in bytes, default: the service's
+	SeccompProfile  string            `json:"seccomp_profile_path,omitempty"` // on the host of the service, or unconfined
+	AppArmorProfile string            `json:"apparmor_profile,omitempty"`
+	SELinuxOpts     []string          `json:"selinux_opts,omitempty"`
+	ReadOnly        bool              `json:"read_only_filesystem"`
+	Mounts          []libpodMount     `json:"mounts,omitempty"`
+	Volumes         []libpodVolume    `json:"volumes,omitempty"`
+}
+
+// libpodMount is a mount of a container, e.g. a tmpfs
+type libpodMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options"`
+}
+
+// libpodVolume is a named volume mounted into a container
+type libpodVolume struct {
+	Name        string   `json:"Name,omitempty"`
+	Dest        string   `json:"Dest"`
+	Options     []string `json:"Options"`
+	IsAnonymous bool     `json:"IsAnonymous"` // removed together with the container
+}
+
+// libpodResources holds the resource limits of a container
+type libpodResources struct {
+	Memory libpodMemory `json:"memory"`
+	CPU    *libpodCPU   `json:"cpu,omitempty"`
+	Pids   libpodPids   `json:"pids"`
+}
+
+// libpodMemory holds the memory limit of a container in bytes
+type libpodMemory struct {
+	Limit int64 `json:"limit"`
+	Swap  int64 `json:"swap"` // memory and swap together, -1 for unlimited swap
+}
+
+// libpodCPU holds the CPU quota of a container: quota microseconds of CPU time every period
+type libpodCPU struct {
+	Quota  int64 `json:"quota"`
+	Period int64 `json:"period"`
+}
+
+// libpodPids holds the limit of processes and threads of a container
+type libpodPids struct {
+	Limit int64 `json:"limit"`
+}
+
+// libpodNamespace selects how a namespace of a container is created, e.g. keep-id with uid=1000 for user namespaces
+type libpodNamespace struct {
+	NSMode string `json:"nsmode"`
+	Value  string `json:"value,omitempty"`
+}
+
+// libpodRlimit is a resource limit of the processes in a container
+type libpodRlimit struct {
+	Type string `json:"type"`
+	Hard int64  `json:"hard"`
+	Soft int64  `json:"soft"`
+}
+
+// libpodEngine implements the endpoints of the libpod API that differ from the Docker Engine API
+type libpodEngine struct {
+	logger *zap.Logger
+	client *engineClient
+	userns string // user namespace mode, e.g. keep-id or auto, empty for the default of the service
+}
+
+func (p *libpodEngine) containerPath(name, endpoint string) string {
+	if endpoint == "" {
+		return libpodAPIPrefix + "/containers/" + name
+	}
+	return libpodAPIPrefix + "/containers/" + name + "/" + endpoint
+}
+
+func (p *libpodEngine) execPath(id, endpoint string) string {
+	return libpodAPIPrefix + "/exec/" + id + "/" + endpoint
+}
+
+func (p *libpodEngine) listPath() string {
+	return libpodAPIPrefix + "/containers/json"
+}
+
+// checkRuntime accepts every runtime: the libpod API lists only the default runtime of the service,
+// which refuses to create a container with a runtime that it cannot find instead
+func (*libpodEngine) checkRuntime(context.Context, string) error {
+	return nil
+}
+
+// containerSpec translates a container into a create request
+func (p *libpodEngine) containerSpec(name string, spec *containerSpec) libpodSpec {
+	rlimits := make([]libpodRlimit, 0, len(spec.Resources.Ulimits))
+	for _, ulimit := range spec.Resources.Ulimits {
+		rlimits = append(rlimits, libpodRlimit{Type: "RLIMIT_" + strings.ToUpper(ulimit.Name), Soft: ulimit.Value, Hard: ulimit.Value})
+	}
+
+	body := libpodSpec{
+		Name:       name,
+		Image:      spec.Image,
+		Command:    spec.Cmd,
+		Env:        spec.Env,
+		WorkDir:    WorkDirPath,
+		User:       spec.User,
+		Labels:     spec.Labels,
+		OCIRuntime: spec.Runtime,
+		ResourceLimits: libpodResources{
+			Memory: libpodMemory{Limit: spec.MemoryBytes, Swap: spec.Resources.memorySwapBytes(spec.MemoryBytes)},
+			Pids:   libpodPids{Limit: spec.Resources.PidsLimit},
+		},
+		NoNewPrivileges: true,
+		CapDrop:         []string{"ALL"}, // Drop all capabilities
+		Rlimits:         rlimits,
+		ShmSize:         int64(spec.Resources.ShmSizeMB) * BytesPerKB * BytesPerKB,
+		SeccompProfile:  spec.Security.SeccompFile,
+		AppArmorProfile: spec.Security.AppArmor,
+		SELinuxOpts:     spec.Security.SELinux,
+		ReadOnly:        spec.Filesystem.ReadOnly,
+	}
+
+	tmpfsMounts := spec.Filesystem.tmpfsMounts()
+	for _, destination := range slices.Sorted(maps.Keys(tmpfsMounts)) {
+		body.Mounts = append(body.Mounts, libpodMount{
+			Destination: destination, Type: "tmpfs", Source: "tmpfs", Options: strings.Split(tmpfsMounts[destination], ","),
+		})
+	}
+	// The U option hands the volumes to the user the container runs as
+	for _, destination := range engineVolumes {
+		body.Volumes = append(body.Volumes, libpodVolume{Dest: destination, Options: []string{"U"}, IsAnonymous: true})
+	}
+
+	if quota := spec.Resources.cpuQuota(); quota > 0 {
+		body.ResourceLimits.CPU = &libpodCPU{Quota: quota, Period: cpuPeriod}
+	}
+
+	// Disable network by default. With network access the service picks its default network,
+	// which is a bridge for rootful and a user mode network for rootless podman.
+	if !spec.Network {
+		body.NetNS = &libpodNamespace{NSMode: "none"}
+	}
+	if p.userns != "" {
+		mode, value, _ := strings.Cut(p.userns, ":")
+		body.UserNS = &libpodNamespace{NSMode: mode, Value: value}
+	}
+	return body
+}
+
+func (p *libpodEngine) createContainer(ctx context.Context, name string, spec *containerSpec) error {
+	// The libpod API does not pull missing images on create
+	if err := p.ensureImage(ctx, spec.Image); err != nil {
+		return err
+	}
+	return p.client.call(ctx, http.MethodPost, libpodAPIPrefix+"/containers/create", nil, p.containerSpec(name, spec), nil)
+}
+
+// ensureImage pulls an image unless the service already has it
+func (p *libpodEngine) ensureImage(ctx context.Context, image string) error {
+	err := p.client.call(ctx, http.MethodGet, libpodAPIPrefix+"/images/"+image+"/exists", nil, nil, nil)
+	if err == nil || !isEngineNotFound(err) {
+		return err
+	}
+
+	p.logger.Info("pulling image", zap.String("image", image))
+	resp, err := p.client.send(ctx, http.MethodPost, libpodAPIPrefix+"/images/pull", url.Values{"reference": {image}}, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer resp.Body.Close()
+	return readPullProgress(resp.Body, image)
+}
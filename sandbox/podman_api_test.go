@@ -0,0 +1,278 @@
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// newFakeLibpodEngine serves the libpod API of a podman service
+func newFakeLibpodEngine(t *testing.T, program fakeProgram) *fakeEngine {
+	t.Helper()
+	e, mux := newFakeEngine(t, program, libpodAPIPrefix)
+	mux.HandleFunc("POST "+libpodAPIPrefix+"/containers/create", e.createLibpod)
+	mux.HandleFunc("GET "+libpodAPIPrefix+"/images/", e.imageExists)
+	mux.HandleFunc("POST "+libpodAPIPrefix+"/images/pull", e.pullLibpod)
+	return e
+}
+
+func (e *fakeEngine) createLibpod(w http.ResponseWriter, r *http.Request) {
+	var spec libpodSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		writeEngineError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if !e.images[spec.Image] {
+		writeEngineError(w, http.StatusInternalServerError, spec.Image+": image not known")
+		return
+	}
+	c := e.addContainer(spec.Name, spec.Command)
+	c.spec = spec
+	w.WriteHeader(http.StatusCreated)
+	_, _ = fmt.Fprintf(w, `{"Id":%q,"Warnings":[]}`, c.name)
+}
+
+// imageExists answers the exists endpoint of image names, which may contain slashes
+func (e *fakeEngine) imageExists(w http.ResponseWriter, r *http.Request) {
+	image, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, libpodAPIPrefix+"/images/"), "/exists")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	e.mu.Lock()
+	exists := e.images[image]
+	e.mu.Unlock()
+	if !exists {
+		writeEngineError(w, http.StatusNotFound, "failed to find image "+image)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *fakeEngine) pullLibpod(w http.ResponseWriter, r *http.Request) {
+	image := r.URL.Query().Get("reference")
+	e.mu.Lock()
+	e.pulls = append(e.pulls, image)
+	e.images[image] = true
+	e.mu.Unlock()
+	_, _ = fmt.Fprintf(w, "{\"stream\":\"Trying to pull %s...\\n\"}\n{\"images\":[\"0123\"],\"id\":\"0123\"}\n", image)
+}
+
+func newPodmanAPIExecutor(t *testing.T, engine *fakeEngine, userns string) *EngineAPIExecutor {
+	t.Helper()
+	cfg := &config.Config{
+		Sandbox: config.SandboxConfig{Podman: config.PodmanConfig{Userns: userns}},
+		Languages: map[string]config.Language{
+			LanguagePython: {Environment: map[string]string{"PYTHONUNBUFFERED": "1"}},
+		},
+	}
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor, err := NewPodmanAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host)
+	require.NoError(t, err)
+	return executor
+}
+
+func TestPodmanAPIExecutor(t *testing.T) {
+	t.Run("Execute", func(t *testing.T) {
+		engine := newFakeLibpodEngine(t, func(c *fakeContainer) (string, string, int) {
+			c.files["workdir/out.txt"] = fakeFile{content: "result", mode: 0o644}
+			recordUsage(c)
+			return string(c.stdin), "", 3
+		})
+		executor := newPodmanAPIExecutor(t, engine, "keep-id:uid=1000,gid=1000")
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguagePython,
+			Code:     "import sys; print(input()); sys.exit(3)",
+			Stdin:    []byte("hello\n"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, StatusNonzeroExit, result.Status)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "hello\n", result.Stdout)
+		assert.Equal(t, int64(1048576), result.Usage.PeakMemoryBytes)
+		assert.NotEmpty(t, result.ArtifactsTar)
+
+		created := engine.createdContainers()
+		require.Len(t, created, 1)
+		spec := created[0].spec
+		assert.Equal(t, created[0].name, spec.Name)
+		assert.Equal(t, "python:3.11-slim", spec.Image)
+		assert.Equal(t, containerIdleCommand, spec.Command)
+		assert.Equal(t, phaseCommand("python3 main.py"), created[0].execCmd)
+		assert.Equal(t, map[string]string{"PYTHONUNBUFFERED": "1", "HOME": containerHomeDir}, spec.Env)
+		assert.Equal(t, WorkDirPath, spec.WorkDir)
+		assert.Equal(t, "nobody", spec.User)
+		assert.Equal(t, int64(128*1024*1024), spec.ResourceLimits.Memory.Limit)
+		assert.Equal(t, &libpodNamespace{NSMode: "none"}, spec.NetNS)
+		assert.Equal(t, &libpodNamespace{NSMode: "keep-id", Value: "uid=1000,gid=1000"}, spec.UserNS)
+		assert.True(t, spec.NoNewPrivileges)
+		assert.Equal(t, []string{"ALL"}, spec.CapDrop)
+		assert.Contains(t, spec.Rlimits, libpodRlimit{Type: "RLIMIT_FSIZE", Soft: 100000000, Hard: 100000000})
+		assert.Equal(t, map[string]string{containerInstanceLabel: executor.instance}, spec.Labels)
+
+		pulls, _, removed, leftovers := engine.state()
+		assert.Empty(t, pulls)
+		assert.Equal(t, []string{created[0].name}, removed)
+		assert.Empty(t, leftovers)
+	})
+
+	t.Run("RemovesStaleContainers", func(t *testing.T) {
+		engine := newFakeLibpodEngine(t, nil)
+		executor := newPodmanAPIExecutor(t, engine, "")
+		engine.mu.Lock()
+		engine.addContainer("codebox-stale", containerIdleCommand).spec.Labels = map[string]string{
+			containerInstanceLabel: executor.instance,
+		}
+		engine.addContainer("unrelated", nil)
+		engine.mu.Unlock()
+
+		executor.removeStaleContainers(context.Background())
+
+		_, _, removed, leftovers := engine.state()
+		assert.Equal(t, []string{"codebox-stale"}, removed)
+		assert.Equal(t, []string{"unrelated"}, leftovers)
+	})
+
+	t.Run("PullsMissingImage", func(t *testing.T) {
+		engine := newFakeLibpodEngine(t, func(*fakeContainer) (string, string, int) { return "ok\n", "", 0 })
+		executor := newPodmanAPIExecutor(t, engine, "")
+		executor.cfg.Languages[LanguagePython] = config.Language{Image: "quay.io/codebox/python"}
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print('ok')"})
+		require.NoError(t, err)
+		assert.Equal(t, "ok\n", result.Stdout)
+
+		spec := engine.createdContainers()[0].spec
+		assert.Nil(t, spec.UserNS, "the user namespace mode of the service is kept by default")
+		pulls, _, _, _ := engine.state()
+		assert.Equal(t, []string{"quay.io/codebox/python"}, pulls)
+	})
+
+	t.Run("Runtime", func(t *testing.T) {
+		engine := newFakeLibpodEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+		executor := newPodmanAPIExecutor(t, engine, "")
+		executor.cfg.Languages[LanguagePython] = config.Language{Runtime: "runsc"}
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+		require.NoError(t, err)
+		assert.Equal(t, "runsc", result.Runtime)
+		assert.Equal(t, "runsc", engine.createdContainers()[0].spec.OCIRuntime)
+	})
+
+	t.Run("NetworkRequested", func(t *testing.T) {
+		engine := newFakeLibpodEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+		executor := newPodmanAPIExecutor(t, engine, "")
+
+		_, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass", Network: true})
+		require.NoError(t, err)
+		assert.Nil(t, engine.createdContainers()[0].spec.NetNS, "the default network of the service is used")
+	})
+
+	t.Run("TimedOut", func(t *testing.T) {
+		engine := newFakeLibpodEngine(t, runUntilKilled)
+		executor := newPodmanAPIExecutor(t, engine, "")
+		executor.config.TimeoutSec = 1
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "while True: pass"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusTimeout, result.Status)
+
+		_, killed, _, leftovers := engine.state()
+		assert.Len(t, killed, 1)
+		assert.Empty(t, leftovers)
+	})
+}
+
+func TestPodmanAPIExecutorSecurity(t *testing.T) {
+	engine := newFakeLibpodEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	require.NoError(t, os.WriteFile(profile, []byte(`{"defaultAction": "SCMP_ACT_ALLOW"}`), FilePermission))
+	cfg := &config.Config{Languages: map[string]config.Language{
+		LanguagePython: {Security: config.SecurityConfig{Seccomp: profile, AppArmor: config.SecurityUnconfined, SELinux: []string{"disable"}}},
+	}}
+	security, err := NewSecurityProfiles(cfg)
+	require.NoError(t, err)
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor, err := NewPodmanAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host, WithEngineAPISecurity(security))
+	require.NoError(t, err)
+
+	_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+	require.NoError(t, err)
+
+	spec := engine.createdContainers()[0].spec
+	assert.Equal(t, profile, spec.SeccompProfile, "libpod reads the profile from its file")
+	assert.Equal(t, config.SecurityUnconfined, spec.AppArmorProfile)
+	assert.Equal(t, []string{"disable"}, spec.SELinuxOpts)
+}
+
+func TestDefaultPodmanHost(t *testing.T) {
+	if os.Geteuid() == 0 {
+		assert.Equal(t, "unix:///run/podman/podman.sock", DefaultPodmanHost())
+		return
+	}
+
+	t.Setenv("XDG_RUNTIME_DIR", "/run/user/1000")
+	assert.Equal(t, "unix:///run/user/1000/podman/podman.sock", DefaultPodmanHost())
+}
+
+func TestPodmanAPIExecutorResources(t *testing.T) {
+	engine := newFakeLibpodEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+	cfg := &config.Config{Languages: map[string]config.Language{
+		LanguagePython: {Resources: config.ResourcesConfig{CPUs: 0.5, MemorySwapMB: 64, ShmSizeMB: 16, Ulimits: map[string]int64{"nproc": 32}}},
+	}}
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor, err := NewPodmanAPIExecutor(zaptest.NewLogger(t), executorConfig, cfg, engine.host)
+	require.NoError(t, err)
+
+	_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+	require.NoError(t, err)
+
+	spec := engine.createdContainers()[0].spec
+	assert.Equal(t, libpodMemory{Limit: 128 * 1024 * 1024, Swap: 192 * 1024 * 1024}, spec.ResourceLimits.Memory)
+	assert.Equal(t, &libpodCPU{Quota: 50000, Period: 100000}, spec.ResourceLimits.CPU)
+	assert.Equal(t, libpodPids{Limit: 256}, spec.ResourceLimits.Pids)
+	assert.Equal(t, int64(16*1024*1024), spec.ShmSize)
+	assert.Equal(t, []libpodRlimit{
+		{Type: "RLIMIT_FSIZE", Soft: 100000000, Hard: 100000000},
+		{Type: "RLIMIT_NPROC", Soft: 32, Hard: 32},
+	}, spec.Rlimits)
+}
+
+func TestPodmanAPIExecutorFilesystem(t *testing.T) {
+	engine := newFakeLibpodEngine(t, func(*fakeContainer) (string, string, int) { return "", "", 0 })
+	cfg := &config.Config{Sandbox: config.SandboxConfig{Filesystem: config.FilesystemConfig{TmpfsSizeMB: 64}}}
+	executor, err := NewPodmanAPIExecutor(zaptest.NewLogger(t), &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}, cfg, engine.host)
+	require.NoError(t, err)
+
+	_, err = executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "pass"})
+	require.NoError(t, err)
+
+	created := engine.createdContainers()
+	require.Len(t, created, 1)
+	spec := created[0].spec
+	assert.True(t, spec.ReadOnly)
+	options := []string{"rw", "exec", "nosuid", "nodev", "size=64m", "mode=1777"}
+	assert.Equal(t, []libpodMount{
+		{Destination: containerHomeDir, Type: "tmpfs", Source: "tmpfs", Options: options},
+		{Destination: "/tmp", Type: "tmpfs", Source: "tmpfs", Options: options},
+	}, spec.Mounts)
+	assert.Equal(t, []libpodVolume{
+		{Dest: WorkDirPath, Options: []string{"U"}, IsAnonymous: true},
+	}, spec.Volumes)
+	assert.Equal(t, int64(0o666), created[0].files["workdir/main.py"].mode, "the workdir is copied into its volume")
+}
@@ -0,0 +1,281 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The container pool keeps idle containers
+// started ahead of time for each language, so that an execution does not wait
+// for a container to start. Every pooled container serves a single execution
+// and is removed afterwards, so no state is shared between executions.
+package sandbox
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// poolStartTimeout bounds how long starting a single pool container may take
+const poolStartTimeout = 2 * time.Minute
+
+// PoolConfig configures the warm container pool of an executor
+type PoolConfig struct {
+	Size                int      // idle containers kept per language
+	MaxContainers       int      // idle containers across all languages, 0 for no limit
+	Languages           []string // languages that get idle containers
+	HealthCheckInterval time.Duration
+}
+
+// PoolStats reports how often executions found an idle container
+type PoolStats struct {
+	Hits   int64          // executions served by an idle container
+	Misses int64          // executions that had to start their own container
+	Idle   map[string]int // idle containers per language
+}
+
+// startPoolContainer starts an idle container for a language and returns its name
+type startPoolContainer func(ctx context.Context, language string) (string, error)
+
+// poolContainerRunning reports whether an idle container is still running
+type poolContainerRunning func(ctx context.Context, name string) bool
+
+// containerPool keeps idle containers for the configured languages and refills itself in the background.
+// Pool containers are tracked in the registry of the executor from the moment they are started.
+type containerPool struct {
+	logger     *zap.Logger
+	containers *containerRegistry
+	config     PoolConfig
+	start      startPoolContainer
+	running    poolContainerRunning
+
+	mu       sync.Mutex
+	idle     map[string][]string
+	stopping bool
+
+	hits   atomic.Int64
+	misses atomic.Int64
+
+	refill chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newContainerPool creates a pool and starts filling it in the background until stop is called
+func newContainerPool(
+	logger *zap.Logger,
+	containers *containerRegistry,
+	config PoolConfig,
+	start startPoolContainer,
+	running poolContainerRunning,
+) *containerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &containerPool{
+		logger:     logger,
+		containers: containers,
+		config:     config,
+		start:      start,
+		running:    running,
+		idle:       make(map[string][]string),
+		refill:     make(chan struct{}, 1),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p
+}
+
+// pools reports whether the pool keeps idle containers for a language
+func (p *containerPool) pools(language string) bool {
+	return slices.Contains(p.config.Languages, language)
+}
+
+// take hands out an idle container for a language. The caller owns the container and must release it.
+// The pool is refilled in the background either way.
+func (p *containerPool) take(language string) (string, bool) {
+	p.mu.Lock()
+	idle := p.idle[language]
+	var name string
+	if len(idle) > 0 {
+		name = idle[0]
+		p.idle[language] = idle[1:]
+	}
+	p.mu.Unlock()
+
+	p.requestRefill()
+	if name == "" {
+		p.misses.Add(1)
+		p.logger.Debug("container pool miss", zap.String("language", language))
+		return "", false
+	}
+
+	p.hits.Add(1)
+	p.logger.Debug("container pool hit", zap.String("language", language), zap.String("container", name))
+	return name, true
+}
+
+// stats returns the hit and miss counters and the number of idle containers
+func (p *containerPool) stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idle := make(map[string]int, len(p.idle))
+	for language, names := range p.idle {
+		idle[language] = len(names)
+	}
+	return PoolStats{Hits: p.hits.Load(), Misses: p.misses.Load(), Idle: idle}
+}
+
+// stop ends the refill loop and removes every idle container
+func (p *containerPool) stop(ctx context.Context) {
+	p.mu.Lock()
+	p.stopping = true
+	p.mu.Unlock()
+
+	p.cancel()
+	<-p.done
+
+	p.mu.Lock()
+	var names []string
+	for language, idle := range p.idle {
+		names = append(names, idle...)
+		delete(p.idle, language)
+	}
+	p.mu.Unlock()
+
+	for _, name := range names {
+		p.containers.release(ctx, name, true)
+	}
+
+	stats := p.stats()
+	p.logger.Info("container pool stopped", zap.Int64("hits", stats.Hits), zap.Int64("misses", stats.Misses))
+}
+
+// requestRefill wakes the refill loop without blocking
+func (p *containerPool) requestRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
+
+// run fills the pool whenever a container was taken, checks the idle containers periodically and
+// logs the pool stats along with the health checks
+func (p *containerPool) run(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+
+	var reported PoolStats
+	p.fill(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.refill:
+		case <-ticker.C:
+			p.checkHealth(ctx)
+			reported = p.logStats(reported)
+		}
+		p.fill(ctx)
+	}
+}
+
+// logStats logs the hit and miss counters and the idle containers when the counters changed since
+// the previous report, and returns the stats as the new previous report
+func (p *containerPool) logStats(previous PoolStats) PoolStats {
+	stats := p.stats()
+	if stats.Hits != previous.Hits || stats.Misses != previous.Misses {
+		p.logger.Info("container pool stats",
+			zap.Int64("hits", stats.Hits), zap.Int64("misses", stats.Misses), zap.Any("idle", stats.Idle))
+	}
+	return stats
+}
+
+// fill starts containers until every language has its idle containers or the total limit is reached
+func (p *containerPool) fill(ctx context.Context) {
+	for _, language := range p.config.Languages {
+		for ctx.Err() == nil && p.needs(language) {
+			startCtx, cancel := context.WithTimeout(ctx, poolStartTimeout)
+			name, err := p.start(startCtx, language)
+			cancel()
+			if err != nil {
+				// Try again at the next refill or health check rather than hammering a failing engine
+				p.logger.Warn("failed to start pool container", zap.String("language", language), zap.Error(err))
+				break
+			}
+			p.add(ctx, language, name)
+		}
+	}
+}
+
+// needs reports whether a language is below its pool size and the pool below its total limit
+func (p *containerPool) needs(language string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	total := 0
+	for _, idle := range p.idle {
+		total += len(idle)
+	}
+	if p.config.MaxContainers > 0 && total >= p.config.MaxContainers {
+		return false
+	}
+	return len(p.idle[language]) < p.config.Size
+}
+
+// add makes a started container available, removing it instead when the pool is stopping
+func (p *containerPool) add(ctx context.Context, language, name string) {
+	p.mu.Lock()
+	stopping := p.stopping
+	if !stopping {
+		p.idle[language] = append(p.idle[language], name)
+	}
+	p.mu.Unlock()
+
+	if stopping {
+		p.containers.release(ctx, name, true)
+	}
+}
+
+// checkHealth removes idle containers that are no longer running, so that the next fill replaces them
+func (p *containerPool) checkHealth(ctx context.Context) {
+	p.mu.Lock()
+	var names []string
+	for _, idle := range p.idle {
+		names = append(names, idle...)
+	}
+	p.mu.Unlock()
+
+	for _, name := range names {
+		running := p.running(ctx, name)
+		if ctx.Err() != nil {
+			return
+		}
+		if running {
+			continue
+		}
+
+		if !p.discard(name) {
+			continue // taken in the meantime
+		}
+		p.logger.Warn("removing unhealthy pool container", zap.String("container", name))
+		p.containers.release(ctx, name, true)
+	}
+}
+
+// discard takes an idle container out of the pool, reporting whether it was still idle
+func (p *containerPool) discard(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for language, idle := range p.idle {
+		if i := slices.Index(idle, name); i >= 0 {
+			p.idle[language] = slices.Delete(idle, i, i+1)
+			return true
+		}
+	}
+	return false
+}
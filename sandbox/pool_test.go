@@ -0,0 +1,309 @@
+package sandbox
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// fakePoolEngine emulates the docker commands used by the warm pool. Containers stay running until
+// they are removed or marked dead; phases run with exec print "pooled" in pool containers and "cold" otherwise.
+type fakePoolEngine struct {
+	mu      sync.Mutex
+	running map[string]bool // false for containers that died
+	removed []string
+	killed  []string
+	execs   [][]string
+	block   bool // exec blocks until its context ends
+	oom     bool // phases are OOM-killed, which the cgroup counters of the container record
+
+	oomKills map[string]int
+}
+
+func newFakePoolEngine() *fakePoolEngine {
+	return &fakePoolEngine{running: make(map[string]bool), oomKills: make(map[string]int)}
+}
+
+func (e *fakePoolEngine) RunCommand(ctx context.Context, cmd Command) (CommandResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	name := cmd.Args[len(cmd.Args)-1]
+	switch cmd.Args[1] {
+	case "run":
+		e.running[cmd.Args[3]] = true
+		return CommandResult{}, nil
+	case "inspect":
+		if e.running[name] {
+			return CommandResult{Stdout: "true\n"}, nil
+		}
+		return CommandResult{Stdout: "false\n"}, nil
+	case "exec":
+		e.execs = append(e.execs, cmd.Args)
+		if isUsageCommand(cmd.Args) {
+			container := cmd.Args[len(cmd.Args)-len(containerUsageCommand)-1]
+			return CommandResult{Stdout: containerUsageOutput("", "", e.oomKills[container], "")}, nil
+		}
+		if !isPhaseCommand(cmd.Args) {
+			return CommandResult{}, nil
+		}
+		if e.block {
+			e.mu.Unlock()
+			<-ctx.Done()
+			e.mu.Lock()
+			return CommandResult{ExitCode: -1}, nil
+		}
+		container := cmd.Args[len(cmd.Args)-len(phaseCommand(""))-1]
+		if e.oom {
+			e.oomKills[container]++
+			return CommandResult{ExitCode: 137}, nil
+		}
+		if strings.HasPrefix(container, "codebox-pool-") {
+			return CommandResult{Stdout: "pooled\n"}, nil
+		}
+		return CommandResult{Stdout: "cold\n"}, nil
+	case "kill":
+		e.killed = append(e.killed, name)
+		return CommandResult{}, nil
+	case "rm":
+		e.removed = append(e.removed, name)
+		delete(e.running, name)
+		return CommandResult{}, nil
+	default:
+		return CommandResult{}, nil
+	}
+}
+
+// containers returns the containers that were started and not removed
+func (e *fakePoolEngine) containers() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var names []string
+	for name := range e.running {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (e *fakePoolEngine) wasRemoved(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Contains(e.removed, name)
+}
+
+func (e *fakePoolEngine) wasKilled(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return slices.Contains(e.killed, name)
+}
+
+func (e *fakePoolEngine) kill(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.running[name] = false
+}
+
+func newPooledDockerExecutor(t *testing.T, engine *fakePoolEngine, poolConfig PoolConfig) *DockerExecutor {
+	t.Helper()
+	cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}, LanguageNodeJS: {}}}
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	if poolConfig.HealthCheckInterval == 0 {
+		poolConfig.HealthCheckInterval = time.Hour
+	}
+	executor := NewDockerExecutor(zaptest.NewLogger(t), executorConfig, cfg,
+		WithDockerCommandRunner(engine), WithDockerPool(poolConfig))
+	t.Cleanup(func() { require.NoError(t, executor.Shutdown(context.Background())) })
+	return executor
+}
+
+// waitForIdle waits until the pool holds the given number of idle containers for a language
+func waitForIdle(t *testing.T, executor *DockerExecutor, language string, count int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		stats, _ := executor.PoolStats()
+		return stats.Idle[language] == count
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// idleContainers returns the idle pool containers of a language
+func idleContainers(executor *DockerExecutor, language string) []string {
+	executor.pool.mu.Lock()
+	defer executor.pool.mu.Unlock()
+	return append([]string(nil), executor.pool.idle[language]...)
+}
+
+func TestDockerExecutorPool(t *testing.T) {
+	t.Run("Disabled", func(t *testing.T) {
+		executor := NewDockerExecutor(zaptest.NewLogger(t), &Config{}, &config.Config{})
+		_, ok := executor.PoolStats()
+		assert.False(t, ok)
+	})
+
+	t.Run("HitUsesContainerOnce", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{Size: 2, Languages: []string{LanguagePython}})
+		waitForIdle(t, executor, LanguagePython, 2)
+		name := idleContainers(executor, LanguagePython)[0]
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: LanguagePython,
+			Code:     "print('hi')",
+			Stdin:    []byte("input"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "pooled\n", result.Stdout)
+		assert.Equal(t, StatusOK, result.Status)
+		assert.NotEmpty(t, result.ArtifactsTar)
+
+		// The workdir is copied in, the program runs with exec and the workdir is copied back
+		engine.mu.Lock()
+		execs := append([][]string(nil), engine.execs...)
+		engine.mu.Unlock()
+		require.Len(t, execs, 4)
+		assert.Equal(t, []string{"docker", "exec", "--user", "0", name, "chmod", "-R", "a+rwX", WorkDirPath}, execs[0])
+		assert.True(t, isUsageCommand(execs[1]), "the counters are read before the first phase")
+		assert.Equal(t, append([]string{"docker", "exec", "-i", name}, phaseCommand("python3 main.py")...), execs[2])
+		assert.True(t, isUsageCommand(execs[3]))
+
+		// The container is removed after a single use and the pool is refilled
+		assert.True(t, engine.wasRemoved(name))
+		waitForIdle(t, executor, LanguagePython, 2)
+		stats, _ := executor.PoolStats()
+		assert.Equal(t, int64(1), stats.Hits)
+		assert.Zero(t, stats.Misses)
+	})
+
+	t.Run("RequestsThatDoNotFitRunCold", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{Size: 1, Languages: []string{LanguagePython}})
+		waitForIdle(t, executor, LanguagePython, 1)
+
+		for _, req := range []ExecuteRequest{
+			{Language: LanguagePython, Code: "print(1)", MemoryMB: 256},
+			{Language: LanguagePython, Code: "print(1)", Network: true},
+			{Language: LanguagePython, Code: "print(1)", Workdir: t.TempDir()},
+			{Language: LanguageNodeJS, Code: "console.log(1)"},
+		} {
+			result, err := executor.Execute(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "cold\n", result.Stdout)
+		}
+
+		stats, _ := executor.PoolStats()
+		assert.Zero(t, stats.Hits)
+		assert.Zero(t, stats.Misses, "only requests the pool could serve count")
+	})
+
+	t.Run("MissWhenEmpty", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{Size: 1, Languages: []string{LanguagePython}})
+		waitForIdle(t, executor, LanguagePython, 1)
+		executor.pool.discard(idleContainers(executor, LanguagePython)[0])
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "print(1)"})
+		require.NoError(t, err)
+		assert.Equal(t, "cold\n", result.Stdout)
+		stats, _ := executor.PoolStats()
+		assert.Equal(t, int64(1), stats.Misses)
+	})
+
+	t.Run("TimeoutKillsContainer", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		engine.block = true
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{Size: 1, Languages: []string{LanguagePython}})
+		waitForIdle(t, executor, LanguagePython, 1)
+		name := idleContainers(executor, LanguagePython)[0]
+
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "while True: pass", TimeoutSec: 1})
+		require.NoError(t, err)
+		assert.Equal(t, StatusTimeout, result.Status)
+		assert.True(t, engine.wasKilled(name))
+		assert.True(t, engine.wasRemoved(name))
+	})
+
+	t.Run("OOMKilled", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		engine.oom = true
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{Size: 1, Languages: []string{LanguagePython}})
+		waitForIdle(t, executor, LanguagePython, 1)
+
+		// The kill is told apart from other SIGKILLs by the oom_kill counter of the pool container
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "x = ' ' * 10**10"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusOOMKilled, result.Status)
+		assert.True(t, result.Usage.OOMKilled)
+		stats, _ := executor.PoolStats()
+		assert.Equal(t, int64(1), stats.Hits)
+	})
+
+	t.Run("SizeLimits", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{
+			Size:          3,
+			MaxContainers: 4,
+			Languages:     []string{LanguagePython, LanguageNodeJS},
+		})
+		waitForIdle(t, executor, LanguagePython, 3)
+		waitForIdle(t, executor, LanguageNodeJS, 1)
+		assert.Len(t, engine.containers(), 4)
+	})
+
+	t.Run("HealthCheckReplacesDeadContainers", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		executor := newPooledDockerExecutor(t, engine, PoolConfig{
+			Size:                2,
+			Languages:           []string{LanguagePython},
+			HealthCheckInterval: 20 * time.Millisecond,
+		})
+		waitForIdle(t, executor, LanguagePython, 2)
+		dead := idleContainers(executor, LanguagePython)[0]
+		engine.kill(dead)
+
+		require.Eventually(t, func() bool { return engine.wasRemoved(dead) }, 5*time.Second, 10*time.Millisecond)
+		waitForIdle(t, executor, LanguagePython, 2)
+	})
+
+	t.Run("ShutdownRemovesIdleContainers", func(t *testing.T) {
+		engine := newFakePoolEngine()
+		cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}}}
+		executor := NewDockerExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg,
+			WithDockerCommandRunner(engine),
+			WithDockerPool(PoolConfig{Size: 2, Languages: []string{LanguagePython}, HealthCheckInterval: time.Hour}))
+		waitForIdle(t, executor, LanguagePython, 2)
+
+		require.NoError(t, executor.Shutdown(context.Background()))
+		assert.Empty(t, engine.containers())
+	})
+}
+
+func TestContainerPoolStatsLog(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	pool := &containerPool{logger: zap.New(core), idle: map[string][]string{LanguagePython: {"codebox-pool-1"}}}
+
+	// Counters that did not change since the previous report are not logged again
+	reported := pool.logStats(PoolStats{})
+	assert.Zero(t, logs.Len())
+
+	pool.hits.Add(2)
+	pool.misses.Add(1)
+	reported = pool.logStats(reported)
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, int64(2), fields["hits"])
+	assert.Equal(t, int64(1), fields["misses"])
+
+	pool.logStats(reported)
+	assert.Equal(t, 1, logs.Len())
+}
@@ -0,0 +1,27 @@
+//go:build !unix
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+// processWaitDelay bounds how long Wait blocks on output pipes held open by orphaned children
+const processWaitDelay = 2 * time.Second
+
+// configureProcessGroup only bounds the wait on platforms without process groups
+func configureProcessGroup(cmd *exec.Cmd) {
+	cmd.WaitDelay = processWaitDelay
+}
+
+// peakMemoryBytes is not available on platforms without rusage
+func peakMemoryBytes(*os.ProcessState) int64 {
+	return 0
+}
+
+// exitSignal is not available on platforms without POSIX signals
+func exitSignal(*os.ProcessState) string {
+	return ""
+}
@@ -0,0 +1,54 @@
+//go:build unix
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// processWaitDelay bounds how long Wait blocks on output pipes held open by orphaned children
+const processWaitDelay = 2 * time.Second
+
+// configureProcessGroup runs the command in its own process group so that
+// cancelling the context kills the shell together with everything it spawned
+func configureProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = processWaitDelay
+}
+
+// peakMemoryBytes returns the maximum resident set size of a finished process and its waited-for children
+func peakMemoryBytes(state *os.ProcessState) int64 {
+	rusage, ok := state.SysUsage().(*syscall.Rusage)
+	if !ok {
+		return 0
+	}
+	// ru_maxrss is reported in bytes on macOS and in kilobytes elsewhere
+	if runtime.GOOS == "darwin" {
+		return int64(rusage.Maxrss)
+	}
+	return int64(rusage.Maxrss) * BytesPerKB
+}
+
+// exitSignal returns the name of the signal that terminated a finished process, or "" when it exited normally
+func exitSignal(state *os.ProcessState) string {
+	if state == nil {
+		return ""
+	}
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	return unix.SignalName(status.Signal())
+}
@@ -0,0 +1,474 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. A REPL keeps a Python or Node.js interpreter
+// running between snippets, so variables, imports and loaded data survive the
+// way they do in a notebook. The interpreter runs a small driver that reads
+// one JSON request per line from stdin and answers with one JSON frame per
+// line, carrying the output of the snippet, the value of its last expression
+// and the exception it raised, if any.
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrReplExited is returned when the interpreter of a REPL is no longer running
+var ErrReplExited = errors.New("REPL process exited")
+
+// REPL statuses, in addition to the termination statuses of executions
+const (
+	StatusException   Status = "exception"
+	StatusInterrupted Status = "interrupted"
+)
+
+const (
+	// replInterruptGrace is how long an interrupted snippet may take to stop before the interpreter is killed
+	replInterruptGrace = 5 * time.Second
+	// replStderrLimit bounds the interpreter output kept to explain why a REPL failed to start or exited
+	replStderrLimit = 64 * 1024
+)
+
+// replKilledMessage is the exception of a snippet whose interpreter was killed because it ignored the interrupt
+const replKilledMessage = "the snippet did not stop after an interrupt, so the interpreter was killed and its state is lost; " +
+	"restart the session to start a new one"
+
+// replFrameReady is the type of the frame a REPL driver writes once it accepts snippets
+const replFrameReady = "ready"
+
+// pythonReplDriver evaluates snippets in a persistent namespace. The protocol uses a private copy of
+// the original stdout; fd 1 is pointed at stderr so that nothing else can write into the protocol.
+const pythonReplDriver = `
+import ast, io, json, os, sys, traceback
+
+_proto = os.fdopen(os.dup(1), "w", encoding="utf-8")
+os.dup2(2, 1)
+_requests = sys.stdin
+_namespace = {"__name__": "__main__", "__builtins__": __builtins__}
+
+
+class _Capture(io.TextIOBase):
+    def __init__(self, limit):
+        self.parts, self.size, self.total, self.limit = [], 0, 0, limit
+
+    def writable(self):
+        return True
+
+    def write(self, text):
+        data = str(text).encode("utf-8", "replace")
+        self.total += len(data)
+        if self.limit > 0:
+            data = data[: max(self.limit - self.size, 0)]
+        self.parts.append(data)
+        self.size += len(data)
+        return len(text)
+
+    def value(self):
+        return b"".join(self.parts).decode("utf-8", "replace")
+
+
+def _truncate(text, limit):
+    return text if limit <= 0 else text[:limit]
+
+
+def _send(frame):
+    _proto.write(json.dumps(frame) + "\n")
+    _proto.flush()
+
+
+def _run(code):
+    tree = ast.parse(code, "<session>", "exec")
+    last = None
+    if tree.body and isinstance(tree.body[-1], ast.Expr):
+        last = ast.Expression(tree.body.pop().value)
+    exec(compile(tree, "<session>", "exec"), _namespace)
+    if last is not None:
+        value = eval(compile(last, "<session>", "eval"), _namespace)
+        if value is not None:
+            return repr(value)
+    return None
+
+
+def _eval(request):
+    limit = request.get("limit", 0)
+    out, err = _Capture(limit), _Capture(limit)
+    frame = {"type": "result"}
+    sys.stdout, sys.stderr, sys.stdin = out, err, io.StringIO()
+    try:
+        result = _run(request["code"])
+        if result is not None:
+            frame["result"] = _truncate(result, limit)
+    except KeyboardInterrupt:
+        frame["interrupted"] = True
+        frame["error"] = "KeyboardInterrupt"
+    except BaseException:
+        kind, value, tb = sys.exc_info()
+        frame["error"] = _truncate("".join(traceback.format_exception(kind, value, tb.tb_next)), limit)
+    finally:
+        sys.stdout, sys.stderr, sys.stdin = sys.__stdout__, sys.__stderr__, sys.__stdin__
+    frame.update(
+        stdout=out.value(), stderr=err.value(), stdout_bytes=out.total, stderr_bytes=err.total,
+        stdout_truncated=out.total > out.size, stderr_truncated=err.total > err.size,
+    )
+    return frame
+
+
+_send({"type": "ready"})
+while True:
+    try:
+        line = _requests.readline()
+        if not line:
+            break
+        _send(_eval(json.loads(line)))
+    except KeyboardInterrupt:
+        continue
+`
+
+// nodeReplDriver evaluates snippets in the global context, awaiting promises they return.
+// Frames are written straight to fd 1, while process.stdout and process.stderr are captured.
+const nodeReplDriver = `
+const fs = require("fs");
+const readline = require("readline");
+const util = require("util");
+const vm = require("vm");
+
+globalThis.require = require;
+const interruptedCode = "ERR_SCRIPT_EXECUTION_INTERRUPTED";
+let cancel = null;
+process.on("SIGINT", () => { if (cancel) cancel(); });
+
+class Capture {
+  constructor(limit) { this.parts = []; this.size = 0; this.total = 0; this.limit = limit; }
+  write(chunk) {
+    let data = Buffer.isBuffer(chunk) ? chunk : Buffer.from(String(chunk));
+    this.total += data.length;
+    if (this.limit > 0) data = data.subarray(0, Math.max(this.limit - this.size, 0));
+    this.parts.push(data);
+    this.size += data.length;
+    return true;
+  }
+  value() { return Buffer.concat(this.parts).toString(); }
+}
+
+const truncate = (text, limit) => (limit > 0 ? text.slice(0, limit) : text);
+const send = (frame) => fs.writeSync(1, JSON.stringify(frame) + "\n");
+
+async function evaluate(request) {
+  const limit = request.limit || 0;
+  const out = new Capture(limit);
+  const err = new Capture(limit);
+  const writes = [process.stdout.write, process.stderr.write];
+  process.stdout.write = (chunk) => out.write(chunk);
+  process.stderr.write = (chunk) => err.write(chunk);
+  const frame = { type: "result" };
+  try {
+    let value = vm.runInThisContext(request.code, { filename: "<session>", breakOnSigint: true });
+    if (value && typeof value.then === "function") {
+      value = await Promise.race([value, new Promise((resolve, reject) => {
+        cancel = () => reject(Object.assign(new Error("Script execution was interrupted by SIGINT"), { code: interruptedCode }));
+      })]);
+    }
+    if (value !== undefined) frame.result = truncate(util.inspect(value), limit);
+  } catch (e) {
+    frame.error = truncate(e && e.stack ? String(e.stack) : String(e), limit);
+    if (e && e.code === interruptedCode) frame.interrupted = true;
+  } finally {
+    cancel = null;
+    [process.stdout.write, process.stderr.write] = writes;
+  }
+  return Object.assign(frame, {
+    stdout: out.value(), stderr: err.value(), stdout_bytes: out.total, stderr_bytes: err.total,
+    stdout_truncated: out.total > out.size, stderr_truncated: err.total > err.size,
+  });
+}
+
+(async () => {
+  const requests = readline.createInterface({ input: process.stdin, terminal: false });
+  send({ type: "ready" });
+  for await (const line of requests) send(await evaluate(JSON.parse(line)));
+  process.exit(0);
+})();
+`
+
+// replCommands holds the interpreter command line of every language with REPL support
+var replCommands = map[string][]string{
+	LanguagePython: {"python", "-u", "-c", pythonReplDriver},
+	LanguageNodeJS: {"node", "-e", nodeReplDriver},
+}
+
+// replCommand returns the interpreter command line that runs the REPL driver of a language
+func replCommand(language string) ([]string, error) {
+	command, ok := replCommands[language]
+	if !ok {
+		return nil, fmt.Errorf("REPL sessions are not supported for language: %s", language)
+	}
+	return command, nil
+}
+
+// ReplRequest describes the interpreter to start for a REPL session
+type ReplRequest struct {
+	Language string
+	Workdir  string // session workdir the interpreter runs in
+	MemoryMB int    // 0 uses the executor default
+	Network  bool
+}
+
+// ReplExecutor is implemented by executors that can keep an interpreter running between snippets
+type ReplExecutor interface {
+	StartRepl(ctx context.Context, req ReplRequest) (*Repl, error)
+}
+
+// EvalResult is the outcome of a snippet evaluated in a REPL
+type EvalResult struct {
+	Stdout    string
+	Stderr    string
+	Output    OutputStats
+	Result    string // representation of the value of the last expression, if any
+	Exception string // traceback or stack of the exception the snippet raised, if any
+	Status    Status // ok, exception, interrupted or timeout
+	Duration  time.Duration
+}
+
+// replFrame is a single line written by a REPL driver
+type replFrame struct {
+	Type            string `json:"type"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	StdoutBytes     int64  `json:"stdout_bytes"`
+	StderrBytes     int64  `json:"stderr_bytes"`
+	StdoutTruncated bool   `json:"stdout_truncated"`
+	StderrTruncated bool   `json:"stderr_truncated"`
+	Result          string `json:"result"`
+	Error           string `json:"error"`
+	Interrupted     bool   `json:"interrupted"`
+}
+
+// replEvalRequest is a single line read by a REPL driver
+type replEvalRequest struct {
+	Code  string `json:"code"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+// Repl is a running interpreter that evaluates snippets one at a time
+type Repl struct {
+	request     ReplRequest
+	outputLimit int
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+	stderr      *cappedBuffer
+	frames      chan replFrame // closed once the interpreter exited
+	closed      chan struct{}  // closed by Close, after which frames are dropped
+	interrupt   func() error
+	cleanup     func()
+
+	eval      sync.Mutex // serializes evaluations
+	closeOnce sync.Once
+}
+
+// startRepl starts the interpreter command, which must run one of the REPL drivers, and waits until it is ready.
+// interrupt delivers SIGINT to the interpreter and cleanup releases whatever the backend allocated for it.
+// ctx only bounds the startup, the interpreter keeps running until the REPL is closed.
+func startRepl(
+	ctx context.Context,
+	req ReplRequest,
+	cmd *exec.Cmd,
+	outputLimit int,
+	interrupt func() error,
+	cleanup func(),
+) (*Repl, error) {
+	r := &Repl{
+		request:     req,
+		outputLimit: outputLimit,
+		cmd:         cmd,
+		stderr:      newCappedBuffer(replStderrLimit, nil, nil),
+		frames:      make(chan replFrame),
+		closed:      make(chan struct{}),
+		interrupt:   interrupt,
+		cleanup:     cleanup,
+	}
+	cmd.Stderr = r.stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to open REPL stdin: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to open REPL stdout: %w", err)
+	}
+	r.stdin = stdin
+
+	if err := cmd.Start(); err != nil {
+		cleanup()
+		return nil, fmt.Errorf("failed to start REPL: %w", err)
+	}
+	go r.readFrames(stdout)
+
+	select {
+	case frame, ok := <-r.frames:
+		if ok && frame.Type == replFrameReady {
+			return r, nil
+		}
+		r.Close()
+		return nil, r.exitError()
+	case <-ctx.Done():
+		r.Close()
+		return nil, fmt.Errorf("failed to start REPL: %w", ctx.Err())
+	}
+}
+
+// readFrames passes the frames of the driver on until the interpreter exits. Lines that are not frames are skipped.
+func (r *Repl) readFrames(stdout io.Reader) {
+	defer close(r.frames)
+
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadBytes('\n')
+		var frame replFrame
+		if len(bytes.TrimSpace(line)) > 0 && json.Unmarshal(line, &frame) == nil && frame.Type != "" {
+			select {
+			case r.frames <- frame:
+			case <-r.closed:
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	_ = r.cmd.Wait()
+}
+
+// Language returns the language of the interpreter
+func (r *Repl) Language() string {
+	return r.request.Language
+}
+
+// Request returns the request the interpreter was started with, so that a restart keeps its limits
+func (r *Repl) Request() ReplRequest {
+	return r.request
+}
+
+// Eval evaluates a snippet. A snippet that runs longer than timeout is interrupted and reported with
+// StatusTimeout; the interpreter is only killed, losing its state, when it does not stop in time, after
+// which the REPL stays dead and every Eval fails with ErrReplExited until it is replaced. Cancelling ctx interrupts the snippet the same way and returns the context error.
+func (r *Repl) Eval(ctx context.Context, code string, timeout time.Duration) (EvalResult, error) {
+	r.eval.Lock()
+	defer r.eval.Unlock()
+
+	request, err := json.Marshal(replEvalRequest{Code: code, Limit: r.outputLimit})
+	if err != nil {
+		return EvalResult{}, fmt.Errorf("failed to encode REPL request: %w", err)
+	}
+
+	start := time.Now()
+	if _, err := r.stdin.Write(append(request, '\n')); err != nil {
+		r.Close()
+		return EvalResult{}, r.exitError()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case frame, ok := <-r.frames:
+		if !ok {
+			r.Close()
+			return EvalResult{}, r.exitError()
+		}
+		return frame.evalResult(time.Since(start)), nil
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	// Interrupt the snippet, which keeps the interpreter state when it stops in time
+	_ = r.Interrupt()
+	grace := time.NewTimer(replInterruptGrace)
+	defer grace.Stop()
+
+	var result EvalResult
+	select {
+	case frame, ok := <-r.frames:
+		if !ok {
+			r.Close()
+			return EvalResult{}, r.exitError()
+		}
+		result = frame.evalResult(time.Since(start))
+	case <-grace.C:
+		r.Close()
+		result = EvalResult{Exception: replKilledMessage}
+	}
+
+	if ctx.Err() != nil {
+		return EvalResult{}, fmt.Errorf("execution cancelled: %w", ctx.Err())
+	}
+	result.Status = StatusTimeout
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// Interrupt sends SIGINT to the interpreter, stopping the snippet that is running
+func (r *Repl) Interrupt() error {
+	if err := r.interrupt(); err != nil {
+		return fmt.Errorf("failed to interrupt REPL: %w", err)
+	}
+	return nil
+}
+
+// Close stops the interpreter and releases its resources
+func (r *Repl) Close() {
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		_ = r.stdin.Close()
+		if r.cmd.Process != nil {
+			_ = r.cmd.Process.Kill()
+		}
+		r.cleanup()
+	})
+}
+
+// exitError waits for the closed interpreter to exit and describes why it did, using what it wrote to stderr
+func (r *Repl) exitError() error {
+	for range r.frames {
+	}
+	if stderr := strings.TrimSpace(r.stderr.String()); stderr != "" {
+		return fmt.Errorf("%w: %s", ErrReplExited, stderr)
+	}
+	return ErrReplExited
+}
+
+// evalResult converts a result frame
+func (f *replFrame) evalResult(duration time.Duration) EvalResult {
+	result := EvalResult{
+		Stdout: f.Stdout,
+		Stderr: f.Stderr,
+		Output: OutputStats{
+			StdoutBytes:     f.StdoutBytes,
+			StderrBytes:     f.StderrBytes,
+			StdoutTruncated: f.StdoutTruncated,
+			StderrTruncated: f.StderrTruncated,
+		},
+		Result:    f.Result,
+		Exception: f.Error,
+		Status:    StatusOK,
+		Duration:  duration,
+	}
+	switch {
+	case f.Interrupted:
+		result.Status = StatusInterrupted
+	case f.Error != "":
+		result.Status = StatusException
+	}
+	return result
+}
@@ -0,0 +1,160 @@
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestLocalExecutorRepl(t *testing.T) {
+	tests := []struct {
+		language    string
+		interpreter string
+		define      string
+		use         string
+		print       string
+		raise       string
+		exception   string
+		loop        string
+	}{
+		{
+			language:    LanguagePython,
+			interpreter: "python",
+			define:      "import math\nx = 21",
+			use:         "math.floor(x * 2.5)",
+			print:       "import sys\nprint('out')\nprint('err', file=sys.stderr)",
+			raise:       "1 / 0",
+			exception:   "ZeroDivisionError",
+			loop:        "while True:\n    pass",
+		},
+		{
+			language:    LanguageNodeJS,
+			interpreter: "node",
+			define:      "const path = require('path'); var x = 21;",
+			use:         "Math.floor(x * 2.5)",
+			print:       "console.log('out'); console.error('err');",
+			raise:       "null.field",
+			exception:   "TypeError",
+			loop:        "while (true) {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.language, func(t *testing.T) {
+			if _, err := exec.LookPath(tt.interpreter); err != nil {
+				t.Skipf("%s is not available", tt.interpreter)
+			}
+
+			executor := NewLocalExecutor(zaptest.NewLogger(t), &Config{MaxStdoutBytes: 1024}, &config.Config{})
+			repl, err := executor.StartRepl(context.Background(), ReplRequest{Language: tt.language, Workdir: t.TempDir()})
+			require.NoError(t, err)
+			defer repl.Close()
+
+			eval := func(code string, timeout time.Duration) EvalResult {
+				result, err := repl.Eval(context.Background(), code, timeout)
+				require.NoError(t, err)
+				return result
+			}
+
+			// State survives between snippets
+			result := eval(tt.define, time.Minute)
+			assert.Equal(t, StatusOK, result.Status, result.Exception)
+			result = eval(tt.use, time.Minute)
+			assert.Equal(t, StatusOK, result.Status, result.Exception)
+			assert.Equal(t, "52", result.Result)
+
+			result = eval(tt.print, time.Minute)
+			assert.Equal(t, "out\n", result.Stdout)
+			assert.Equal(t, "err\n", result.Stderr)
+			assert.Equal(t, int64(4), result.Output.StdoutBytes)
+
+			result = eval(tt.raise, time.Minute)
+			assert.Equal(t, StatusException, result.Status)
+			assert.Contains(t, result.Exception, tt.exception)
+
+			// A snippet that runs too long is interrupted and the state is kept
+			result = eval(tt.loop, 200*time.Millisecond)
+			assert.Equal(t, StatusTimeout, result.Status)
+			assert.Equal(t, "52", eval(tt.use, time.Minute).Result)
+
+			// An interrupt stops the running snippet
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				assert.NoError(t, repl.Interrupt())
+			}()
+			result = eval(tt.loop, time.Minute)
+			assert.Equal(t, StatusInterrupted, result.Status)
+			assert.Equal(t, "52", eval(tt.use, time.Minute).Result)
+		})
+	}
+}
+
+func TestReplOutputLimit(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	executor := NewLocalExecutor(zaptest.NewLogger(t), &Config{MaxStdoutBytes: 10}, &config.Config{})
+	repl, err := executor.StartRepl(context.Background(), ReplRequest{Language: LanguagePython, Workdir: t.TempDir()})
+	require.NoError(t, err)
+	defer repl.Close()
+
+	result, err := repl.Eval(context.Background(), "print('x' * 100)", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, "xxxxxxxxxx", result.Stdout)
+	assert.Equal(t, int64(101), result.Output.StdoutBytes)
+	assert.True(t, result.Output.StdoutTruncated)
+}
+
+func TestReplExit(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	executor := NewLocalExecutor(zaptest.NewLogger(t), &Config{}, &config.Config{})
+	repl, err := executor.StartRepl(context.Background(), ReplRequest{Language: LanguagePython, Workdir: t.TempDir()})
+	require.NoError(t, err)
+	defer repl.Close()
+
+	// exit() only raises SystemExit, which is reported like any other exception
+	result, err := repl.Eval(context.Background(), "exit(3)", time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, StatusException, result.Status)
+
+	_, err = repl.Eval(context.Background(), "import os\nos._exit(3)", time.Minute)
+	require.ErrorIs(t, err, ErrReplExited)
+}
+
+func TestReplKilledAfterInterrupt(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	executor := NewLocalExecutor(zaptest.NewLogger(t), &Config{}, &config.Config{})
+	repl, err := executor.StartRepl(context.Background(), ReplRequest{Language: LanguagePython, Workdir: t.TempDir()})
+	require.NoError(t, err)
+	defer repl.Close()
+
+	// A snippet that ignores SIGINT gets the interpreter killed, which is not restarted behind the caller's back
+	result, err := repl.Eval(context.Background(), "import signal\nsignal.signal(signal.SIGINT, signal.SIG_IGN)\nwhile True:\n    pass", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, StatusTimeout, result.Status)
+	assert.Contains(t, result.Exception, "restart the session")
+
+	_, err = repl.Eval(context.Background(), "1", time.Minute)
+	require.ErrorIs(t, err, ErrReplExited)
+}
+
+func TestReplUnsupportedLanguage(t *testing.T) {
+	executor := NewLocalExecutor(zaptest.NewLogger(t), &Config{}, &config.Config{})
+	_, err := executor.StartRepl(context.Background(), ReplRequest{Language: LanguageGo, Workdir: t.TempDir()})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not supported")
+}
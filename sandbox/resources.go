@@ -0,0 +1,91 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Container resources translate the CPU, PIDs,
+// swap, shared memory and ulimit settings of a language into the flags of the
+// container CLIs and the fields of the engine APIs.
+package sandbox
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// Units of the CPU quotas of the engine APIs
+const (
+	cpuPeriod       = 100000 // CFS period in microseconds that libpod quotas are expressed in
+	nanoCPUsPerCore = 1e9    // Docker Engine API quotas are in billionths of a core
+)
+
+// containerResources holds the resource limits of the containers of a language beyond the memory limit
+type containerResources struct {
+	CPUs         float64           // CPU quota in cores, 0 for no quota
+	PidsLimit    int64             // processes and threads, -1 for unlimited
+	MemorySwapMB int               // swap on top of the memory limit, -1 for unlimited
+	ShmSizeMB    int               // size of /dev/shm, 0 for the engine default
+	Ulimits      []containerUlimit // sorted by name
+}
+
+// containerUlimit is a resource limit of the processes in a container, with equal soft and hard values
+type containerUlimit struct {
+	Name  string
+	Value int64
+}
+
+// newContainerResources returns the resource limits of a language's containers
+func newContainerResources(cfg *config.Config, language string) containerResources {
+	resources := cfg.GetResources(language)
+	ulimits := make([]containerUlimit, 0, len(resources.Ulimits))
+	for name, value := range resources.Ulimits {
+		ulimits = append(ulimits, containerUlimit{Name: name, Value: value})
+	}
+	slices.SortFunc(ulimits, func(a, b containerUlimit) int { return cmp.Compare(a.Name, b.Name) })
+
+	return containerResources{
+		CPUs:         resources.CPUs,
+		PidsLimit:    resources.PidsLimit,
+		MemorySwapMB: resources.MemorySwapMB,
+		ShmSizeMB:    resources.ShmSizeMB,
+		Ulimits:      ulimits,
+	}
+}
+
+// memorySwapBytes returns the limit of memory and swap together of a container with memoryBytes of memory,
+// or -1 for unlimited swap. Without swap it equals the memory limit.
+func (r containerResources) memorySwapBytes(memoryBytes int64) int64 {
+	if r.MemorySwapMB < 0 {
+		return -1
+	}
+	return memoryBytes + int64(r.MemorySwapMB)*BytesPerKB*BytesPerKB
+}
+
+// cpuQuota returns the CPU time in microseconds that a container may use per cpuPeriod, 0 for no quota
+func (r containerResources) cpuQuota() int64 {
+	return int64(r.CPUs * cpuPeriod)
+}
+
+// runArgs returns the flags of a container run with memoryMB of memory that apply the limits
+func (r containerResources) runArgs(memoryMB int) []string {
+	memorySwap := "-1"
+	if r.MemorySwapMB >= 0 {
+		memorySwap = fmt.Sprintf("%dm", memoryMB+r.MemorySwapMB)
+	}
+	args := []string{
+		"--memory-swap", memorySwap,
+		"--pids-limit", strconv.FormatInt(r.PidsLimit, 10),
+	}
+	if r.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+	}
+	if r.ShmSizeMB > 0 {
+		args = append(args, "--shm-size", fmt.Sprintf("%dm", r.ShmSizeMB))
+	}
+	for _, ulimit := range r.Ulimits {
+		args = append(args, FlagUlimit, fmt.Sprintf("%s=%d", ulimit.Name, ulimit.Value))
+	}
+	return args
+}
@@ -0,0 +1,46 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestOCIExecutorResources(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, &config.Config{}, dockerRuntime)
+		args, err := executor.runArgs("codebox-exec-1", LanguagePython, Limits{MemoryMB: 128})
+		require.NoError(t, err)
+		assert.Subset(t, args, []string{"--memory-swap", "128m", "--pids-limit", "256", "fsize=100000000"})
+		assert.NotContains(t, args, "--cpus", "no CPU quota without cpus")
+		assert.NotContains(t, args, "cpu=10", "CPU time is bounded by the phase timeout")
+	})
+
+	t.Run("LanguageOverrides", func(t *testing.T) {
+		cfg := &config.Config{
+			Sandbox: config.SandboxConfig{Resources: config.ResourcesConfig{
+				CPUs: 0.5, MemorySwapMB: 64, Ulimits: map[string]int64{"nproc": 64, "nofile": 1024},
+			}},
+			Languages: map[string]config.Language{
+				LanguageGo: {Resources: config.ResourcesConfig{CPUs: 2, PidsLimit: -1, ShmSizeMB: 128, Ulimits: map[string]int64{"nofile": 4096}}},
+			},
+		}
+		executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime)
+
+		// Swap comes on top of the memory limit of the execution
+		resources := newContainerResources(cfg, LanguagePython)
+		assert.Equal(t, []string{
+			"--memory-swap", "320m", "--pids-limit", "256", "--cpus", "0.5",
+			FlagUlimit, "fsize=100000000", FlagUlimit, "nofile=1024", FlagUlimit, "nproc=64",
+		}, resources.runArgs(256))
+
+		args, err := executor.runArgs("codebox-exec-1", LanguageGo, Limits{MemoryMB: 128})
+		require.NoError(t, err)
+		assert.Subset(t, args, []string{"--memory-swap", "192m", "--pids-limit", "-1", "--cpus", "2", "--shm-size", "128m", "nofile=4096"})
+		assert.NotContains(t, args, "nofile=1024")
+	})
+}
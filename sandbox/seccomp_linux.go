@@ -0,0 +1,130 @@
+//go:build linux
+
+package sandbox
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets into struct seccomp_data
+const (
+	seccompNrOffset   = 0
+	seccompArchOffset = 4
+	seccompArg0Offset = 16 // low half of the first argument on little-endian architectures
+)
+
+// seccompX32SyscallBit marks the x32 ABI syscalls, which reach the kernel with the x86_64 audit arch
+const seccompX32SyscallBit = 0x40000000
+
+// seccompNamespaceFlags are the clone flags that create namespaces
+const seccompNamespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS | unix.CLONE_NEWIPC |
+	unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+
+// seccompPersonalities are the personality arguments that are allowed, like in the default profile of docker:
+// PER_LINUX, PER_LINUX32, both with UNAME26, and 0xffffffff to query the personality. Flags like
+// ADDR_NO_RANDOMIZE or READ_IMPLIES_EXEC would weaken the protections of the processes of the sandbox.
+var seccompPersonalities = []uint32{0x0, 0x8, 0x20000, 0x20008, 0xffffffff}
+
+// seccompDenied are the syscalls that sandboxed code gets EPERM for: they administer the system, change
+// mounts and namespaces, inspect other processes or expose large parts of the kernel to attacks
+var seccompDenied = []uintptr{
+	unix.SYS_MOUNT, unix.SYS_UMOUNT2, unix.SYS_PIVOT_ROOT, unix.SYS_UNSHARE, unix.SYS_SETNS,
+	unix.SYS_FSOPEN, unix.SYS_FSMOUNT, unix.SYS_FSCONFIG, unix.SYS_FSPICK, unix.SYS_MOVE_MOUNT, unix.SYS_OPEN_TREE,
+	unix.SYS_MOUNT_SETATTR, unix.SYS_OPEN_BY_HANDLE_AT, unix.SYS_NAME_TO_HANDLE_AT,
+	unix.SYS_PTRACE, unix.SYS_PROCESS_VM_READV, unix.SYS_PROCESS_VM_WRITEV,
+	unix.SYS_KEXEC_LOAD, unix.SYS_KEXEC_FILE_LOAD, unix.SYS_INIT_MODULE, unix.SYS_FINIT_MODULE, unix.SYS_DELETE_MODULE,
+	unix.SYS_BPF, unix.SYS_PERF_EVENT_OPEN, unix.SYS_USERFAULTFD, unix.SYS_LOOKUP_DCOOKIE,
+	unix.SYS_KEYCTL, unix.SYS_ADD_KEY, unix.SYS_REQUEST_KEY,
+	unix.SYS_SWAPON, unix.SYS_SWAPOFF, unix.SYS_REBOOT, unix.SYS_ACCT, unix.SYS_QUOTACTL, unix.SYS_SYSLOG,
+	unix.SYS_SETTIMEOFDAY, unix.SYS_CLOCK_SETTIME, unix.SYS_CLOCK_ADJTIME, unix.SYS_ADJTIMEX,
+	unix.SYS_SETHOSTNAME, unix.SYS_SETDOMAINNAME,
+	unix.SYS_IO_URING_SETUP, unix.SYS_IO_URING_ENTER, unix.SYS_IO_URING_REGISTER, unix.SYS_KCMP,
+	unix.SYS_MOVE_PAGES, unix.SYS_MIGRATE_PAGES, unix.SYS_MBIND, unix.SYS_SET_MEMPOLICY,
+}
+
+// seccompAuditArch returns the audit architecture of the syscalls that the filter allows
+func seccompAuditArch() (uint32, error) {
+	switch runtime.GOARCH {
+	case "amd64":
+		return unix.AUDIT_ARCH_X86_64, nil
+	case "arm64":
+		return unix.AUDIT_ARCH_AARCH64, nil
+	default:
+		return 0, errors.New("seccomp filtering is not supported on " + runtime.GOARCH)
+	}
+}
+
+// seccompFilter builds the BPF program of the sandbox: syscalls of other architectures kill the process,
+// the denied syscalls, personality with other arguments than seccompPersonalities and clone with namespace
+// flags fail with EPERM, clone3 fails with ENOSYS so that the C library falls back to clone, and everything
+// else is allowed
+func seccompFilter() ([]unix.SockFilter, error) {
+	arch, err := seccompAuditArch()
+	if err != nil {
+		return nil, err
+	}
+
+	load := func(offset uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offset}
+	}
+	ret := func(value uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: value}
+	}
+	// jumpIfNot skips the next instruction unless the accumulator equals value
+	jumpIfNot := func(value uint32) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: value, Jt: 0, Jf: 1}
+	}
+	deny := ret(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+
+	filter := []unix.SockFilter{
+		load(seccompArchOffset),
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: arch, Jt: 1, Jf: 0},
+		ret(unix.SECCOMP_RET_KILL_PROCESS),
+		load(seccompNrOffset),
+		{Code: unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K, K: seccompX32SyscallBit, Jt: 0, Jf: 1},
+		deny,
+	}
+	for _, nr := range seccompDenied {
+		filter = append(filter, jumpIfNot(uint32(nr)), deny)
+	}
+	// personality: allowed with the arguments of seccompPersonalities only, which jump over the deny to the allow
+	personalities := uint8(len(seccompPersonalities)) //nolint:gosec // A handful of values
+	jumpIf := func(value uint32, jt, jf uint8) unix.SockFilter {
+		return unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: value, Jt: jt, Jf: jf}
+	}
+	filter = append(filter, jumpIf(unix.SYS_PERSONALITY, 0, personalities+3), load(seccompArg0Offset)) //nolint:mnd // Skips the check
+	for i, persona := range seccompPersonalities {
+		filter = append(filter, jumpIf(persona, personalities-uint8(i), 0)) //nolint:gosec // A handful of values
+	}
+	filter = append(filter, deny, ret(unix.SECCOMP_RET_ALLOW))
+	filter = append(filter,
+		jumpIfNot(unix.SYS_CLONE3), ret(unix.SECCOMP_RET_ERRNO|uint32(unix.ENOSYS)),
+		// clone: allowed unless it creates namespaces
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: unix.SYS_CLONE, Jt: 0, Jf: 3}, //nolint:mnd // Skips the flags check
+		load(seccompArg0Offset),
+		unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, K: seccompNamespaceFlags, Jt: 0, Jf: 1},
+		deny,
+		ret(unix.SECCOMP_RET_ALLOW),
+	)
+	return filter, nil
+}
+
+// installSeccompFilter installs the filter of the sandbox on every thread of the process.
+// The process must not be able to gain privileges anymore.
+func installSeccompFilter() error {
+	filter, err := seccompFilter()
+	if err != nil {
+		return err
+	}
+	program := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	_, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC,
+		uintptr(unsafe.Pointer(&program)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
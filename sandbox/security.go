@@ -0,0 +1,152 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Security profiles resolve the seccomp,
+// AppArmor and SELinux settings of every language into the form that the
+// container CLIs and engine APIs take them in.
+package sandbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// containerSecurity holds the security profiles of the containers of a language
+type containerSecurity struct {
+	SeccompFile string   // seccomp profile file for the CLIs and libpod, unconfined, or empty for the engine default
+	Seccomp     string   // compact JSON of the profile for the Docker Engine API, unconfined, or empty
+	AppArmor    string   // AppArmor profile, unconfined, or empty for the engine default
+	SELinux     []string // SELinux label options, e.g. type:container_t
+}
+
+// securityOpts returns the --security-opt values of the profiles. The Docker Engine API takes the seccomp
+// profile itself, while the CLIs read it from its file.
+func (s containerSecurity) securityOpts(inlineSeccomp bool) []string {
+	var opts []string
+	switch {
+	case inlineSeccomp && s.Seccomp != "":
+		opts = append(opts, "seccomp="+s.Seccomp)
+	case !inlineSeccomp && s.SeccompFile != "":
+		opts = append(opts, "seccomp="+s.SeccompFile)
+	}
+	if s.AppArmor != "" {
+		opts = append(opts, "apparmor="+s.AppArmor)
+	}
+	for _, option := range s.SELinux {
+		opts = append(opts, "label="+option)
+	}
+	return opts
+}
+
+// SecurityProfiles holds the resolved security profiles of every language
+type SecurityProfiles struct {
+	languages map[string]containerSecurity
+	fallback  containerSecurity // profiles of languages without a configuration of their own
+}
+
+// NewSecurityProfiles resolves sandbox.security and the security of every language. The builtin seccomp
+// profile is written to a file in the temporary directory, so that the CLIs can read it like any other.
+func NewSecurityProfiles(cfg *config.Config) (*SecurityProfiles, error) {
+	profiles := &SecurityProfiles{languages: make(map[string]containerSecurity, len(cfg.Languages))}
+
+	fallback, err := resolveSecurity(cfg.Sandbox.Security)
+	if err != nil {
+		return nil, err
+	}
+	profiles.fallback = fallback
+
+	for language := range cfg.Languages {
+		security, err := resolveSecurity(cfg.GetSecurity(language))
+		if err != nil {
+			return nil, fmt.Errorf("language %s: %w", language, err)
+		}
+		profiles.languages[language] = security
+	}
+	return profiles, nil
+}
+
+// forLanguage returns the profiles of a language's containers. A nil SecurityProfiles leaves every
+// profile to the engine.
+func (p *SecurityProfiles) forLanguage(language string) containerSecurity {
+	if p == nil {
+		return containerSecurity{}
+	}
+	if security, ok := p.languages[language]; ok {
+		return security
+	}
+	return p.fallback
+}
+
+// resolveSecurity loads the seccomp profile of a security configuration
+func resolveSecurity(security config.SecurityConfig) (containerSecurity, error) {
+	resolved := containerSecurity{AppArmor: security.AppArmor, SELinux: security.SELinux}
+
+	var profile []byte
+	switch security.Seccomp {
+	case "":
+		return resolved, nil
+	case config.SecurityUnconfined:
+		resolved.SeccompFile = config.SecurityUnconfined
+		resolved.Seccomp = config.SecurityUnconfined
+		return resolved, nil
+	case config.SeccompBuiltin:
+		file, err := writeBuiltinSeccompProfile()
+		if err != nil {
+			return containerSecurity{}, err
+		}
+		resolved.SeccompFile = file
+		profile = config.BuiltinSeccompProfile
+	default:
+		data, err := os.ReadFile(security.Seccomp)
+		if err != nil {
+			return containerSecurity{}, fmt.Errorf("failed to read seccomp profile: %w", err)
+		}
+		resolved.SeccompFile = security.Seccomp
+		profile = data
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, profile); err != nil {
+		return containerSecurity{}, fmt.Errorf("invalid seccomp profile %s: %w", resolved.SeccompFile, err)
+	}
+	resolved.Seccomp = compact.String()
+	return resolved, nil
+}
+
+// writeBuiltinSeccompProfile writes the builtin seccomp profile to a file named after its content,
+// unless a previous start already did, and returns the path of the file
+func writeBuiltinSeccompProfile() (string, error) {
+	sum := sha256.Sum256(config.BuiltinSeccompProfile)
+	path := filepath.Join(os.TempDir(), "codebox-seccomp-"+hex.EncodeToString(sum[:8])+".json")
+	if existing, err := os.ReadFile(path); err == nil && bytes.Equal(existing, config.BuiltinSeccompProfile) {
+		return path, nil
+	}
+
+	// Write to a temporary file first, so that concurrent starts never see a partial profile
+	file, err := os.CreateTemp(os.TempDir(), "codebox-seccomp-*.json")
+	if err != nil {
+		return "", fmt.Errorf("failed to write builtin seccomp profile: %w", err)
+	}
+	_, err = file.Write(config.BuiltinSeccompProfile)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(file.Name(), FilePermission)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write builtin seccomp profile: %w", err)
+	}
+	return path, nil
+}
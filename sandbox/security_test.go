@@ -0,0 +1,88 @@
+package sandbox
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestSecurityProfiles(t *testing.T) {
+	t.Run("LanguageOverrides", func(t *testing.T) {
+		profile := filepath.Join(t.TempDir(), "seccomp.json")
+		require.NoError(t, os.WriteFile(profile, []byte("{\n  \"defaultAction\": \"SCMP_ACT_ERRNO\"\n}\n"), FilePermission))
+		cfg := &config.Config{
+			Sandbox: config.SandboxConfig{Security: config.SecurityConfig{Seccomp: config.SeccompBuiltin, AppArmor: "codebox"}},
+			Languages: map[string]config.Language{
+				LanguagePython: {Security: config.SecurityConfig{Seccomp: profile}},
+				LanguageGo:     {Security: config.SecurityConfig{Seccomp: config.SecurityUnconfined, SELinux: []string{"level:s0:c1,c2"}}},
+			},
+		}
+		profiles, err := NewSecurityProfiles(cfg)
+		require.NoError(t, err)
+
+		python := profiles.forLanguage(LanguagePython)
+		assert.Equal(t, profile, python.SeccompFile)
+		assert.Equal(t, `{"defaultAction":"SCMP_ACT_ERRNO"}`, python.Seccomp)
+		assert.Equal(t, "codebox", python.AppArmor, "the language keeps the AppArmor profile of the sandbox")
+		assert.Equal(t, []string{"seccomp=" + profile, "apparmor=codebox"}, python.securityOpts(false))
+
+		golang := profiles.forLanguage(LanguageGo)
+		assert.Equal(t, []string{"seccomp=unconfined", "apparmor=codebox", "label=level:s0:c1,c2"}, golang.securityOpts(true))
+
+		// Languages without a configuration get the profiles of the sandbox, with the builtin profile written to a file
+		nodejs := profiles.forLanguage(LanguageNodeJS)
+		content, err := os.ReadFile(nodejs.SeccompFile)
+		require.NoError(t, err)
+		assert.Equal(t, config.BuiltinSeccompProfile, content)
+
+		again, err := NewSecurityProfiles(cfg)
+		require.NoError(t, err)
+		assert.Equal(t, nodejs.SeccompFile, again.forLanguage(LanguageNodeJS).SeccompFile, "the builtin profile file is reused")
+	})
+
+	t.Run("EngineDefaults", func(t *testing.T) {
+		profiles, err := NewSecurityProfiles(&config.Config{})
+		require.NoError(t, err)
+		python := profiles.forLanguage(LanguagePython)
+		assert.Empty(t, python.securityOpts(false))
+
+		var none *SecurityProfiles
+		assert.Empty(t, none.forLanguage(LanguagePython).securityOpts(true))
+	})
+
+	t.Run("MissingProfile", func(t *testing.T) {
+		cfg := &config.Config{Languages: map[string]config.Language{
+			LanguagePython: {Security: config.SecurityConfig{Seccomp: filepath.Join(t.TempDir(), "missing.json")}},
+		}}
+		_, err := NewSecurityProfiles(cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "language python: failed to read seccomp profile")
+	})
+}
+
+func TestOCIExecutorSecurity(t *testing.T) {
+	cfg := &config.Config{Sandbox: config.SandboxConfig{
+		Security: config.SecurityConfig{Seccomp: config.SecurityUnconfined, SELinux: []string{"type:container_t"}},
+	}}
+	security, err := NewSecurityProfiles(cfg)
+	require.NoError(t, err)
+	executor := NewOCIExecutor(zaptest.NewLogger(t), &Config{MemoryMB: 128}, cfg, dockerRuntime, WithOCISecurity(security))
+
+	args, err := executor.runArgs("codebox-exec-1", LanguagePython, Limits{MemoryMB: 128})
+	require.NoError(t, err)
+	var opts []string
+	for i, arg := range args {
+		if arg == "--security-opt" {
+			opts = append(opts, args[i+1])
+		}
+	}
+	assert.Equal(t, []string{"no-new-privileges:true", "seccomp=unconfined", "label=type:container_t"}, opts)
+	assert.True(t, slices.Contains(args, "--cap-drop"), "the profiles come on top of the restrictions")
+}
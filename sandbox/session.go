@@ -0,0 +1,293 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Sessions keep a workdir on the server between
+// executions, so multi-step work does not have to send the workdir back and
+// forth. Session identifiers are random and anyone holding one may use the
+// session; the client that created it counts against its session limit.
+package sandbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Session errors
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionLimit    = errors.New("session limit reached")
+)
+
+const (
+	sessionIDBytes      = 16
+	sessionReapInterval = 30 * time.Second
+)
+
+// Session is a persistent workdir that survives between executions
+type Session struct {
+	ID    string
+	Owner string // client that created the session
+	dir   string // holds the workdir and the usage dir of container backends
+
+	// exec serializes the executions of the session; closed is guarded by it
+	exec   sync.Mutex
+	closed bool
+
+	// Guarded by the mutex of the manager
+	lastUsed time.Time
+	active   int
+
+	// repl is the interpreter of a REPL session, read without holding exec so that it can be interrupted
+	repl atomic.Pointer[Repl]
+}
+
+// Workdir returns the path of the session workdir
+func (s *Session) Workdir() string {
+	return filepath.Join(s.dir, "workdir")
+}
+
+// Repl returns the interpreter of a REPL session, nil for sessions that only keep a workdir
+func (s *Session) Repl() *Repl {
+	return s.repl.Load()
+}
+
+// SetRepl replaces the interpreter of the session, closing the previous one
+func (s *Session) SetRepl(repl *Repl) {
+	if previous := s.repl.Swap(repl); previous != nil {
+		previous.Close()
+	}
+}
+
+// SessionManager keeps the sessions of all clients, enforcing a per-client limit
+// and closing sessions that have been idle for longer than the idle TTL
+type SessionManager struct {
+	logger       *zap.Logger
+	idleTTL      time.Duration
+	maxPerClient int
+	now          func() time.Time
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+
+	reaper sync.Once
+	done   chan struct{}
+	stop   sync.Once
+}
+
+// NewSessionManager creates a session manager. Idle sessions are only reaped once the first session exists.
+func NewSessionManager(logger *zap.Logger, idleTTL time.Duration, maxPerClient int) *SessionManager {
+	return &SessionManager{
+		logger:       logger,
+		idleTTL:      idleTTL,
+		maxPerClient: maxPerClient,
+		now:          time.Now,
+		sessions:     make(map[string]*Session),
+		done:         make(chan struct{}),
+	}
+}
+
+// IdleTTL returns how long a session may stay unused before it is closed
+func (m *SessionManager) IdleTTL() time.Duration {
+	return m.idleTTL
+}
+
+// Create creates a session for owner with a workdir holding the extracted workdir tar, if any
+func (m *SessionManager) Create(owner string, workdirTar []byte) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if count := m.countLocked(owner); count >= m.maxPerClient {
+		return nil, fmt.Errorf("%w: %d open sessions, at most %d per client", ErrSessionLimit, count, m.maxPerClient)
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "codebox-session-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session dir: %w", err)
+	}
+	session := &Session{ID: id, Owner: owner, dir: dir, lastUsed: m.now()}
+
+	if err := os.MkdirAll(session.Workdir(), DirPermission); err != nil {
+		removeSessionDir(m.logger, session)
+		return nil, fmt.Errorf("failed to create session workdir: %w", err)
+	}
+	if len(workdirTar) > 0 {
+		if err := ExtractTarToDir(RealFileSystem{}, workdirTar, session.Workdir()); err != nil {
+			removeSessionDir(m.logger, session)
+			return nil, fmt.Errorf("failed to extract workdir_tar: %w", err)
+		}
+	}
+
+	m.sessions[id] = session
+	m.reaper.Do(func() { go m.reap() })
+
+	m.logger.Info("session created", zap.String("session_id", id), zap.String("owner", owner))
+	return session, nil
+}
+
+// Acquire gives exclusive use of a session until the returned release function is called.
+// Executions in the same session run one after another.
+func (m *SessionManager) Acquire(id string) (*Session, func(), error) {
+	m.mu.Lock()
+	session, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return nil, nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	session.active++
+	m.mu.Unlock()
+
+	release := func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		session.active--
+		session.lastUsed = m.now()
+	}
+
+	session.exec.Lock()
+	if session.closed {
+		session.exec.Unlock()
+		release()
+		return nil, nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	return session, func() {
+		session.exec.Unlock()
+		release()
+	}, nil
+}
+
+// Get returns a session without waiting for its running execution, e.g. to interrupt it
+func (m *SessionManager) Get(id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return session, nil
+}
+
+// Close closes a session and removes its workdir, waiting for a running execution to finish
+func (m *SessionManager) Close(id string) error {
+	m.mu.Lock()
+	session, ok := m.sessions[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	m.closeSession(session, "closed")
+	return nil
+}
+
+// CloseAll stops reaping and closes every session, e.g. when the server shuts down
+func (m *SessionManager) CloseAll() {
+	m.stop.Do(func() { close(m.done) })
+
+	m.mu.Lock()
+	sessions := make([]*Session, 0, len(m.sessions))
+	for id, session := range m.sessions {
+		sessions = append(sessions, session)
+		delete(m.sessions, id)
+	}
+	m.mu.Unlock()
+
+	for _, session := range sessions {
+		m.closeSession(session, "closed on shutdown")
+	}
+}
+
+// Count returns the number of open sessions of owner
+func (m *SessionManager) Count(owner string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.countLocked(owner)
+}
+
+func (m *SessionManager) countLocked(owner string) int {
+	count := 0
+	for _, session := range m.sessions {
+		if session.Owner == owner {
+			count++
+		}
+	}
+	return count
+}
+
+// reap periodically closes idle sessions until CloseAll is called
+func (m *SessionManager) reap() {
+	ticker := time.NewTicker(min(m.idleTTL, sessionReapInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.closeIdle()
+		}
+	}
+}
+
+// closeIdle closes the sessions that have not been used for longer than the idle TTL
+func (m *SessionManager) closeIdle() {
+	now := m.now()
+
+	m.mu.Lock()
+	var idle []*Session
+	for id, session := range m.sessions {
+		if session.active == 0 && now.Sub(session.lastUsed) > m.idleTTL {
+			idle = append(idle, session)
+			delete(m.sessions, id)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, session := range idle {
+		m.closeSession(session, "expired")
+	}
+}
+
+// closeSession stops the interpreter and removes the workdir of a session that was already taken out of the manager
+func (m *SessionManager) closeSession(session *Session, reason string) {
+	session.exec.Lock()
+	defer session.exec.Unlock()
+
+	session.closed = true
+	session.SetRepl(nil)
+	removeSessionDir(m.logger, session)
+	m.logger.Info("session "+reason, zap.String("session_id", session.ID), zap.String("owner", session.Owner))
+}
+
+// removeSessionDir removes the directory of a session
+func removeSessionDir(logger *zap.Logger, session *Session) {
+	if err := os.RemoveAll(session.dir); err != nil {
+		logger.Error("failed to remove session directory", zap.String("path", session.dir), zap.Error(err))
+	}
+}
+
+// newSessionID returns a random session identifier
+func newSessionID() (string, error) {
+	id := make([]byte, sessionIDBytes)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate session id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
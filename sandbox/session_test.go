@@ -0,0 +1,166 @@
+package sandbox
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestSessionManager(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+
+	t.Run("CreateWithWorkdirTar", func(t *testing.T) {
+		manager := NewSessionManager(logger, time.Minute, 2)
+		defer manager.CloseAll()
+
+		session, err := manager.Create("client", createTestTar(t, map[string]string{"data.txt": "hello"}))
+		require.NoError(t, err)
+		assert.Len(t, session.ID, 2*sessionIDBytes)
+
+		data, err := os.ReadFile(filepath.Join(session.Workdir(), "data.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("PerClientLimit", func(t *testing.T) {
+		manager := NewSessionManager(logger, time.Minute, 2)
+		defer manager.CloseAll()
+
+		for range 2 {
+			_, err := manager.Create("client", nil)
+			require.NoError(t, err)
+		}
+		_, err := manager.Create("client", nil)
+		require.ErrorIs(t, err, ErrSessionLimit)
+
+		// Other clients have their own limit
+		_, err = manager.Create("other", nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, manager.Count("client"))
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		manager := NewSessionManager(logger, time.Minute, 2)
+		defer manager.CloseAll()
+
+		session, err := manager.Create("client", nil)
+		require.NoError(t, err)
+		require.NoError(t, manager.Close(session.ID))
+
+		assert.NoDirExists(t, session.Workdir())
+		_, _, err = manager.Acquire(session.ID)
+		require.ErrorIs(t, err, ErrSessionNotFound)
+		assert.Zero(t, manager.Count("client"))
+	})
+
+	t.Run("CloseWaitsForExecution", func(t *testing.T) {
+		manager := NewSessionManager(logger, time.Minute, 2)
+		defer manager.CloseAll()
+
+		session, err := manager.Create("client", nil)
+		require.NoError(t, err)
+		_, release, err := manager.Acquire(session.ID)
+		require.NoError(t, err)
+
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			assert.NoError(t, manager.Close(session.ID))
+		}()
+
+		select {
+		case <-closed:
+			t.Fatal("the session was closed during an execution")
+		case <-time.After(50 * time.Millisecond):
+		}
+		assert.DirExists(t, session.Workdir())
+
+		release()
+		<-closed
+		assert.NoDirExists(t, session.Workdir())
+	})
+
+	t.Run("IdleSessionsExpire", func(t *testing.T) {
+		manager := NewSessionManager(logger, time.Minute, 2)
+		defer manager.CloseAll()
+		now := time.Now()
+		manager.now = func() time.Time { return now }
+
+		idle, err := manager.Create("client", nil)
+		require.NoError(t, err)
+		busy, err := manager.Create("client", nil)
+		require.NoError(t, err)
+		_, release, err := manager.Acquire(busy.ID)
+		require.NoError(t, err)
+
+		now = now.Add(2 * time.Minute)
+		manager.closeIdle()
+		assert.NoDirExists(t, idle.Workdir())
+		assert.DirExists(t, busy.Workdir(), "sessions in use never expire")
+
+		// The idle time counts from the end of the last execution
+		release()
+		manager.closeIdle()
+		assert.DirExists(t, busy.Workdir())
+		assert.Equal(t, 1, manager.Count("client"))
+	})
+
+	t.Run("CloseAll", func(t *testing.T) {
+		manager := NewSessionManager(logger, time.Minute, 2)
+
+		first, err := manager.Create("client", nil)
+		require.NoError(t, err)
+		second, err := manager.Create("other", nil)
+		require.NoError(t, err)
+
+		manager.CloseAll()
+		assert.NoDirExists(t, first.Workdir())
+		assert.NoDirExists(t, second.Workdir())
+		assert.Zero(t, manager.Count("client")+manager.Count("other"))
+	})
+}
+
+func TestLocalExecutorSessionWorkdir(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+	manager := NewSessionManager(logger, time.Minute, 1)
+	defer manager.CloseAll()
+	session, err := manager.Create("client", nil)
+	require.NoError(t, err)
+
+	result, err := executor.Execute(context.Background(), ExecuteRequest{
+		Language:      LanguagePython,
+		Code:          "open('state.txt', 'w').write('42')",
+		Workdir:       session.Workdir(),
+		SkipArtifacts: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, StatusOK, result.Status)
+	assert.Empty(t, result.ArtifactsTar)
+
+	// The next execution sees the files of the previous one
+	result, err = executor.Execute(context.Background(), ExecuteRequest{
+		Language: LanguagePython,
+		Code:     "print(open('state.txt').read())",
+		Workdir:  session.Workdir(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "42\n", result.Stdout)
+	assert.NotEmpty(t, result.ArtifactsTar)
+	assert.FileExists(t, filepath.Join(session.Workdir(), "state.txt"), "the session workdir is kept")
+}
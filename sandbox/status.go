@@ -0,0 +1,83 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Every phase and execution ends with a typed
+// status, so clients can tell timeouts, OOM kills, full workdirs and signals
+// apart from programs that simply exited with a non-zero code.
+package sandbox
+
+// Status is the termination status of a phase or an execution
+type Status string
+
+// Termination statuses
+const (
+	StatusOK                  Status = "ok"
+	StatusNonzeroExit         Status = "nonzero_exit"
+	StatusTimeout             Status = "timeout"
+	StatusOOMKilled           Status = "oom_killed"
+	StatusKilledBySignal      Status = "killed_by_signal"
+	StatusCompileError        Status = "compile_error"
+	StatusSetupError          Status = "setup_error"
+	StatusOutputLimitExceeded Status = "output_limit_exceeded"
+	StatusDiskQuotaExceeded   Status = "disk_quota_exceeded"
+	StatusInternalError       Status = "internal_error"
+)
+
+// timeoutExitCode is reported for phases that were killed at their time limit and have no exit code
+const timeoutExitCode = -1
+
+// signalExitBase is added to the signal number in the exit code of a shell or container killed by a signal
+const signalExitBase = 128
+
+// linuxSignalNames maps Linux signal numbers to their names. Container exit codes always use Linux numbering.
+var linuxSignalNames = map[int]string{
+	1: "SIGHUP", 2: "SIGINT", 3: "SIGQUIT", 4: "SIGILL", 5: "SIGTRAP", 6: "SIGABRT", 7: "SIGBUS", 8: "SIGFPE",
+	9: "SIGKILL", 10: "SIGUSR1", 11: "SIGSEGV", 12: "SIGUSR2", 13: "SIGPIPE", 14: "SIGALRM", 15: "SIGTERM",
+	16: "SIGSTKFLT", 17: "SIGCHLD", 18: "SIGCONT", 19: "SIGSTOP", 20: "SIGTSTP", 21: "SIGTTIN", 22: "SIGTTOU",
+	23: "SIGURG", 24: "SIGXCPU", 25: "SIGXFSZ", 26: "SIGVTALRM", 27: "SIGPROF", 28: "SIGWINCH", 29: "SIGIO",
+	30: "SIGPWR", 31: "SIGSYS",
+}
+
+// classifyPhase derives the status of a finished phase and, for signals, the signal name.
+// A signal is taken from the process when known and otherwise from an exit code of 128+N.
+func classifyPhase(phase *PhaseResult) (Status, string) {
+	switch {
+	case phase.Status == StatusOutputLimitExceeded:
+		return StatusOutputLimitExceeded, ""
+	case phase.TimedOut:
+		return StatusTimeout, ""
+	case phase.Usage.OOMKilled:
+		return StatusOOMKilled, ""
+	case phase.Usage.DiskQuotaExceeded && phase.ExitCode != 0:
+		return StatusDiskQuotaExceeded, ""
+	case phase.Signal != "":
+		return StatusKilledBySignal, phase.Signal
+	case phase.ExitCode == 0:
+		return StatusOK, ""
+	}
+
+	if name, ok := linuxSignalNames[phase.ExitCode-signalExitBase]; ok {
+		return StatusKilledBySignal, name
+	}
+	return StatusNonzeroExit, ""
+}
+
+// buildStatus returns the status of an execution whose build phase failed.
+// Timeouts, OOM kills and a full workdir keep their own status, every other failure is a compile error.
+func buildStatus(build *PhaseResult) Status {
+	return failedPhaseStatus(build, StatusCompileError)
+}
+
+// setupStatus returns the status of an execution whose setup phase failed, like buildStatus does for the build phase
+func setupStatus(setup *PhaseResult) Status {
+	return failedPhaseStatus(setup, StatusSetupError)
+}
+
+// failedPhaseStatus keeps the status of a failed phase that timed out, was OOM killed or filled the workdir
+// and reports every other failure as failure
+func failedPhaseStatus(phase *PhaseResult, failure Status) Status {
+	if phase.Status == StatusTimeout || phase.Status == StatusOOMKilled || phase.Status == StatusDiskQuotaExceeded {
+		return phase.Status
+	}
+	return failure
+}
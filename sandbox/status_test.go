@@ -0,0 +1,79 @@
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestClassifyPhase(t *testing.T) {
+	tests := []struct {
+		name   string
+		phase  PhaseResult
+		status Status
+		signal string
+	}{
+		{"OK", PhaseResult{}, StatusOK, ""},
+		{"NonzeroExit", PhaseResult{ExitCode: 1}, StatusNonzeroExit, ""},
+		{"ExitCodeAboveSignalRange", PhaseResult{ExitCode: 200}, StatusNonzeroExit, ""},
+		{"Timeout", PhaseResult{TimedOut: true, ExitCode: -1}, StatusTimeout, ""},
+		{"OOMKilled", PhaseResult{ExitCode: 137, Usage: ResourceUsage{OOMKilled: true}}, StatusOOMKilled, ""},
+		{"ProcessSignal", PhaseResult{ExitCode: -1, Signal: "SIGSEGV"}, StatusKilledBySignal, "SIGSEGV"},
+		{"ContainerSignalExitCode", PhaseResult{ExitCode: 139}, StatusKilledBySignal, "SIGSEGV"},
+		{"CPULimitSignal", PhaseResult{ExitCode: 152}, StatusKilledBySignal, "SIGXCPU"},
+		{"OutputLimit", PhaseResult{ExitCode: 137, Status: StatusOutputLimitExceeded}, StatusOutputLimitExceeded, ""},
+		{"DiskQuotaExceeded", PhaseResult{ExitCode: 1, Usage: ResourceUsage{DiskQuotaExceeded: true}}, StatusDiskQuotaExceeded, ""},
+		{"FullWorkdirSucceeded", PhaseResult{Usage: ResourceUsage{DiskQuotaExceeded: true}}, StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, signal := classifyPhase(&tt.phase)
+			assert.Equal(t, tt.status, status)
+			assert.Equal(t, tt.signal, signal)
+		})
+	}
+}
+
+func TestBuildStatus(t *testing.T) {
+	assert.Equal(t, StatusCompileError, buildStatus(&PhaseResult{ExitCode: 1, Status: StatusNonzeroExit}))
+	assert.Equal(t, StatusTimeout, buildStatus(&PhaseResult{TimedOut: true, Status: StatusTimeout}))
+	assert.Equal(t, StatusOOMKilled, buildStatus(&PhaseResult{Status: StatusOOMKilled}))
+	assert.Equal(t, StatusDiskQuotaExceeded, buildStatus(&PhaseResult{Status: StatusDiskQuotaExceeded}))
+}
+
+func TestLocalExecutorStatus(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executor := NewLocalExecutor(logger, &Config{TimeoutSec: 1, MemoryMB: 128, MaxArtifactSizeMB: 5}, &config.Config{})
+
+	tests := []struct {
+		name   string
+		code   string
+		status Status
+		signal string
+	}{
+		{"OK", "print('ok')", StatusOK, ""},
+		{"NonzeroExit", "import sys\nsys.exit(3)", StatusNonzeroExit, ""},
+		{"Signal", "import os, signal\nos.kill(os.getpid(), signal.SIGSEGV)", StatusKilledBySignal, "SIGSEGV"},
+		{"Timeout", "while True:\n    pass", StatusTimeout, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: tt.code})
+			require.NoError(t, err)
+			assert.Equal(t, tt.status, result.Status)
+			assert.Equal(t, tt.signal, result.Signal)
+		})
+	}
+}
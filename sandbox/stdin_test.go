@@ -0,0 +1,81 @@
+package sandbox
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestRealCommandRunnerStdin(t *testing.T) {
+	runner := RealCommandRunner{}
+
+	result, err := runner.RunCommand(context.Background(), Command{
+		Args:  []string{"cat"},
+		Stdin: []byte("line one\nline two\n"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "line one\nline two\n", result.Stdout)
+	assert.Equal(t, 0, result.ExitCode)
+}
+
+func TestContainerExecutorsStdin(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 5, BuildTimeoutSec: 30, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	cfg := &config.Config{Languages: map[string]config.Language{LanguageGo: {}}}
+	stdin := []byte("3 4\n")
+
+	executors := map[string]func(runner CommandRunner) SandboxExecutor{
+		"Docker": func(runner CommandRunner) SandboxExecutor {
+			return NewDockerExecutor(logger, executorConfig, cfg, WithDockerCommandRunner(runner))
+		},
+		"Podman": func(runner CommandRunner) SandboxExecutor {
+			return NewPodmanExecutor(logger, executorConfig, cfg, WithPodmanCommandRunner(runner))
+		},
+	}
+
+	for name, newExecutor := range executors {
+		t.Run(name, func(t *testing.T) {
+			runner := &FuncCommandRunner{}
+			executor := newExecutor(runner)
+
+			_, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguageGo, Code: "package main", Stdin: stdin})
+			require.NoError(t, err)
+
+			commands := runner.Phases()
+			require.Len(t, commands, 2)
+
+			// The build phase never sees the program input
+			assert.Nil(t, commands[0].Stdin)
+			assert.NotContains(t, commands[0].Args, "-i")
+
+			// The run phase gets the input with an open stdin
+			assert.Equal(t, stdin, commands[1].Stdin)
+			assert.Equal(t, []string{"exec", "-i"}, commands[1].Args[1:3])
+		})
+	}
+}
+
+func TestLocalExecutorStdin(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+	result, err := executor.Execute(context.Background(), ExecuteRequest{
+		Language: LanguagePython,
+		Code:     "import sys\na, b = map(int, sys.stdin.read().split())\nprint(a + b)",
+		Stdin:    []byte("3 4\n"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.ExitCode, result.Stderr)
+	assert.Equal(t, "7\n", result.Stdout)
+}
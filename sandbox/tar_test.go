@@ -36,6 +36,10 @@ func (m *TarTestMockFileSystem) MkdirAll(path string, _ os.FileMode) error {
 	return nil
 }
 
+func (TarTestMockFileSystem) Chmod(_ string, _ os.FileMode) error {
+	return nil
+}
+
 func (m *TarTestMockFileSystem) WriteFile(filename string, data []byte, _ os.FileMode) error {
 	if m.writeFileCalls == nil {
 		m.writeFileCalls = make(map[string][]byte)
@@ -49,6 +53,10 @@ func (TarTestMockFileSystem) ReadFile(_ string) ([]byte, error) {
 	return nil, nil
 }
 
+func (TarTestMockFileSystem) ReadDir(_ string) ([]os.DirEntry, error) {
+	return nil, nil
+}
+
 func (TarTestMockFileSystem) RemoveAll(_ string) error {
 	return nil
 }
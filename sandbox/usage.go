@@ -0,0 +1,227 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. Every phase reports the resources it used:
+// local processes are measured with rusage, while the cgroup accounting of
+// containers is read by the server with an exec of its own after each phase.
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ResourceUsage holds the resources consumed by an execution.
+// Zero values mean that the backend could not measure the resource.
+type ResourceUsage struct {
+	WallTime        time.Duration
+	UserTime        time.Duration
+	SystemTime      time.Duration
+	PeakMemoryBytes int64
+	OOMKilled       bool
+	// DiskQuotaExceeded reports that the filesystem of the workdir was full when the phase ended
+	DiskQuotaExceeded bool
+}
+
+// add accumulates the usage of another phase, summing times and keeping the highest memory peak
+func (u *ResourceUsage) add(other *ResourceUsage) {
+	u.WallTime += other.WallTime
+	u.UserTime += other.UserTime
+	u.SystemTime += other.SystemTime
+	u.PeakMemoryBytes = max(u.PeakMemoryBytes, other.PeakMemoryBytes)
+	u.OOMKilled = u.OOMKilled || other.OOMKilled
+	u.DiskQuotaExceeded = u.DiskQuotaExceeded || other.DiskQuotaExceeded
+}
+
+// processUsage returns the usage of a finished local process, including its waited-for children
+func processUsage(state *os.ProcessState, wallTime time.Duration) ResourceUsage {
+	usage := ResourceUsage{WallTime: wallTime}
+	if state == nil {
+		return usage
+	}
+
+	usage.UserTime = state.UserTime()
+	usage.SystemTime = state.SystemTime()
+	usage.PeakMemoryBytes = peakMemoryBytes(state)
+	return usage
+}
+
+// Files of the cgroup v2 accounting
+const (
+	cpuStatFile      = "cpu.stat"
+	memoryPeakFile   = "memory.peak"
+	memoryEventsFile = "memory.events"
+)
+
+// Host directory next to the workdir that the namespace init records the exit status of a sandbox in
+const (
+	usageDirName = "usage"
+
+	// usageDirPermission lets the init write the status when it runs as nobody
+	usageDirPermission = 0o777
+)
+
+// prepareUsageDir creates the directory that the namespace init records the exit status in
+func prepareUsageDir(fs FileSystem, workdirPath string) error {
+	usageDir := usageDirFor(workdirPath)
+	if err := fs.MkdirAll(usageDir, DirPermission); err != nil {
+		return fmt.Errorf("failed to create usage dir: %w", err)
+	}
+	if err := fs.Chmod(usageDir, usageDirPermission); err != nil {
+		return fmt.Errorf("failed to set usage dir permissions: %w", err)
+	}
+	return nil
+}
+
+// usageDirFor returns the usage directory that belongs to a workdir
+func usageDirFor(workdirPath string) string {
+	return filepath.Join(filepath.Dir(workdirPath), usageDirName)
+}
+
+// containerIdleCommand keeps a container running, so that its phases run with exec and its cgroup outlives them
+var containerIdleCommand = []string{"tail", "-f", "/dev/null"}
+
+// phaseWrapperScript runs the command of a phase passed as $1 in a container and then kills whatever it left
+// running, as a container that exits with its command would, exiting with the status of the command.
+const phaseWrapperScript = `sh -c "$1"
+status=$?
+kill -9 -1 2>/dev/null
+exit $status`
+
+// phaseCommand returns the exec arguments that run command as a phase of a container
+func phaseCommand(command string) []string {
+	return []string{"sh", "-c", phaseWrapperScript, "sh", command}
+}
+
+// containerUsageScript prints the cgroup v2 accounting of a container and the df output of its workdir, each
+// after a line with its name. It runs as root in an exec of its own after a phase ended: the counters are kept
+// by the kernel, so the program, which runs as nobody, can neither fake them nor write into the output.
+const containerUsageScript = `for file in ` + cpuStatFile + ` ` + memoryPeakFile + ` ` + memoryEventsFile + `; do
+echo "== $file"; cat /sys/fs/cgroup/$file 2>/dev/null
+done
+echo "== df"; df -Pk ` + WorkDirPath + ` 2>/dev/null
+exit 0`
+
+// containerUsageCommand runs containerUsageScript
+var containerUsageCommand = []string{"sh", "-c", containerUsageScript}
+
+const (
+	// containerRootUser is the user that containerUsageCommand runs as
+	containerRootUser = "0"
+	// containerUsageOutputLimit bounds how much of the output of containerUsageCommand is read
+	containerUsageOutputLimit = 64 * 1024
+)
+
+// containerCounters are the cumulative cgroup counters of a container and the state of its workdir
+type containerCounters struct {
+	usage    ResourceUsage // CPU time, memory peak and whether the workdir is full
+	oomKills int64
+}
+
+// parseContainerCounters parses the output of containerUsageScript
+func parseContainerCounters(output string) containerCounters {
+	sections := make(map[string][]byte)
+	var name string
+	for line := range strings.SplitSeq(output, "\n") {
+		if header, ok := strings.CutPrefix(line, "== "); ok {
+			name = header
+			continue
+		}
+		sections[name] = append(sections[name], line+"\n"...)
+	}
+
+	counters := containerCounters{
+		usage:    parseCgroupUsage(sections[cpuStatFile], sections[memoryPeakFile]),
+		oomKills: parseOOMKills(sections[memoryEventsFile]),
+	}
+	counters.usage.DiskQuotaExceeded = workdirFull(sections["df"])
+	return counters
+}
+
+// phaseUsage returns the usage of a phase that ended with exitCode after wallTime, from the counters
+// before and after it. The memory peak is the peak of the container so far. A phase was OOM-killed when
+// it was killed with SIGKILL and the kernel OOM-killed a process of the container meanwhile. A full workdir
+// only counts when workdirQuota tells that it is the size-capped volume of a quota: otherwise it is on the
+// disk of the host, which may be nearly full and still have plenty of space left.
+func (c containerCounters) phaseUsage(before containerCounters, exitCode int, wallTime time.Duration, workdirQuota bool) ResourceUsage {
+	return ResourceUsage{
+		WallTime:          wallTime,
+		UserTime:          c.usage.UserTime - before.usage.UserTime,
+		SystemTime:        c.usage.SystemTime - before.usage.SystemTime,
+		PeakMemoryBytes:   c.usage.PeakMemoryBytes,
+		OOMKilled:         exitCode == signalExitBase+int(syscall.SIGKILL) && c.oomKills > before.oomKills,
+		DiskQuotaExceeded: workdirQuota && c.usage.DiskQuotaExceeded,
+	}
+}
+
+// parseOOMKills returns the oom_kill count of a cgroup v2 memory.events file
+func parseOOMKills(events []byte) int64 {
+	for line := range strings.SplitSeq(string(events), "\n") {
+		if count, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			kills, _ := strconv.ParseInt(strings.TrimSpace(count), 10, 64)
+			return kills
+		}
+	}
+	return 0
+}
+
+// parseCgroupUsage parses the cgroup v2 cpu.stat and memory.peak files
+func parseCgroupUsage(cpuStat, memoryPeak []byte) ResourceUsage {
+	var usage ResourceUsage
+
+	scanner := bufio.NewScanner(bytes.NewReader(cpuStat))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		usec, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "user_usec":
+			usage.UserTime = time.Duration(usec) * time.Microsecond
+		case "system_usec":
+			usage.SystemTime = time.Duration(usec) * time.Microsecond
+		}
+	}
+
+	if peak, err := strconv.ParseInt(strings.TrimSpace(string(memoryPeak)), 10, 64); err == nil {
+		usage.PeakMemoryBytes = peak
+	}
+
+	return usage
+}
+
+// Fields of the POSIX df output: Filesystem 1024-blocks Used Available Capacity Mounted-on
+const (
+	dfTotalField     = 1
+	dfAvailableField = 3
+
+	// dfFreeFraction counts a workdir with less than 1/100 of its space free as full. A write that
+	// runs out of space fills the filesystem before failing, but the last blocks may stay free.
+	dfFreeFraction = 100
+)
+
+// workdirFull parses the df output of the workdir filesystem and reports whether it is full
+func workdirFull(df []byte) bool {
+	lines := strings.Split(strings.TrimSpace(string(df)), "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(lines) < 2 || len(fields) <= dfAvailableField {
+		return false
+	}
+	total, errTotal := strconv.ParseInt(fields[dfTotalField], 10, 64)
+	available, errAvailable := strconv.ParseInt(fields[dfAvailableField], 10, 64)
+	if errTotal != nil || errAvailable != nil || total <= 0 {
+		return false
+	}
+	return available <= total/dfFreeFraction
+}
@@ -0,0 +1,160 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestParseCgroupUsage(t *testing.T) {
+	cpuStat := []byte("usage_usec 1500000\nuser_usec 1200000\nsystem_usec 300000\nnr_periods 0\n")
+	usage := parseCgroupUsage(cpuStat, []byte("73400320\n"))
+	assert.Equal(t, 1200*time.Millisecond, usage.UserTime)
+	assert.Equal(t, 300*time.Millisecond, usage.SystemTime)
+	assert.Equal(t, int64(73400320), usage.PeakMemoryBytes)
+
+	assert.Equal(t, ResourceUsage{}, parseCgroupUsage(nil, nil))
+}
+
+func TestWorkdirFull(t *testing.T) {
+	header := "Filesystem     1024-blocks  Used Available Capacity Mounted on\n"
+	assert.True(t, workdirFull([]byte(header+"tmpfs 65536 65536 0 100% /workdir\n")))
+	assert.True(t, workdirFull([]byte(header+"tmpfs 65536 65100 436 100% /workdir\n")), "the last blocks may stay free")
+	assert.False(t, workdirFull([]byte(header+"tmpfs 65536 1024 64512 2% /workdir\n")))
+	assert.False(t, workdirFull(nil), "df is missing from the image")
+	assert.False(t, workdirFull([]byte("df: /workdir: No such file or directory\n")))
+}
+
+// containerUsageOutput returns what containerUsageCommand prints for a container with the given cgroup files and df output
+func containerUsageOutput(cpuStat, memoryPeak string, oomKills int, df string) string {
+	return fmt.Sprintf("== %s\n%s== %s\n%s== %s\noom_kill %d\n== df\n%s",
+		cpuStatFile, cpuStat, memoryPeakFile, memoryPeak, memoryEventsFile, oomKills, df)
+}
+
+// isUsageCommand reports whether a command line runs containerUsageCommand
+func isUsageCommand(args []string) bool {
+	return len(args) >= len(containerUsageCommand) && slices.Equal(args[len(args)-len(containerUsageCommand):], containerUsageCommand)
+}
+
+// isPhaseCommand reports whether a command line runs the command of a phase with exec
+func isPhaseCommand(args []string) bool {
+	wrapper := phaseCommand("")
+	return len(args) >= len(wrapper) && slices.Equal(args[len(args)-len(wrapper):len(args)-1], wrapper[:len(wrapper)-1])
+}
+
+func TestContainerCounters(t *testing.T) {
+	df := "Filesystem 1024-blocks Used Available Capacity Mounted on\ntmpfs 65536 65536 0 100% /workdir\n"
+	before := parseContainerCounters(containerUsageOutput("user_usec 1000\nsystem_usec 500\n", "4096\n", 1, ""))
+	assert.Equal(t, containerCounters{
+		usage:    ResourceUsage{UserTime: time.Millisecond, SystemTime: 500 * time.Microsecond, PeakMemoryBytes: 4096},
+		oomKills: 1,
+	}, before)
+
+	after := parseContainerCounters(containerUsageOutput("user_usec 41000\nsystem_usec 10500\n", "134217728\n", 2, df))
+	assert.Equal(t, ResourceUsage{
+		WallTime:          time.Second,
+		UserTime:          40 * time.Millisecond,
+		SystemTime:        10 * time.Millisecond,
+		PeakMemoryBytes:   134217728,
+		OOMKilled:         true,
+		DiskQuotaExceeded: true,
+	}, after.phaseUsage(before, 137, time.Second, true))
+
+	// Only a phase that was killed counts as OOM-killed, another process may have been the victim
+	assert.False(t, after.phaseUsage(before, 1, time.Second, true).OOMKilled)
+	assert.False(t, after.phaseUsage(after, 137, time.Second, true).OOMKilled)
+
+	// Without a quota, the workdir is on the disk of the host, which is not the limit of the program
+	assert.False(t, after.phaseUsage(before, 1, time.Second, false).DiskQuotaExceeded)
+	assert.Equal(t, containerCounters{}, parseContainerCounters(""))
+}
+
+func TestResourceUsageAdd(t *testing.T) {
+	total := ResourceUsage{WallTime: time.Second, UserTime: time.Second, PeakMemoryBytes: 100}
+	total.add(&ResourceUsage{WallTime: time.Second, SystemTime: time.Second, PeakMemoryBytes: 50, OOMKilled: true})
+	assert.Equal(t, ResourceUsage{
+		WallTime:        2 * time.Second,
+		UserTime:        time.Second,
+		SystemTime:      time.Second,
+		PeakMemoryBytes: 100,
+		OOMKilled:       true,
+	}, total)
+}
+
+func TestDockerExecutorUsage(t *testing.T) {
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 5, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {}}}
+
+	runner := &FuncCommandRunner{
+		run: func(_ context.Context, cmd Command) (CommandResult, error) {
+			switch {
+			case isPhaseCommand(cmd.Args):
+				time.Sleep(10 * time.Millisecond)
+				return CommandResult{Stderr: "Killed", ExitCode: 137}, nil
+			case isUsageCommand(cmd.Args):
+				return CommandResult{Stdout: containerUsageOutput("user_usec 40000\nsystem_usec 10000\n", "134217728\n", 1, "")}, nil
+			default:
+				return CommandResult{}, nil
+			}
+		},
+	}
+	executor := NewDockerExecutor(logger, executorConfig, cfg, WithDockerCommandRunner(runner))
+
+	result, err := executor.Execute(context.Background(), ExecuteRequest{Language: LanguagePython, Code: "x = ' ' * 10**10"})
+	require.NoError(t, err)
+	require.NotNil(t, result.Run)
+	assert.GreaterOrEqual(t, result.Run.Usage.WallTime, 10*time.Millisecond)
+	result.Run.Usage.WallTime = 0
+	assert.Equal(t, ResourceUsage{
+		UserTime:        40 * time.Millisecond,
+		SystemTime:      10 * time.Millisecond,
+		PeakMemoryBytes: 134217728,
+		OOMKilled:       true,
+	}, result.Run.Usage)
+	assert.Equal(t, StatusOOMKilled, result.Status)
+
+	// The container idles while the phase runs with exec, the usage is read with a root exec
+	// and the container is removed afterwards
+	calls := runner.Calls()
+	require.Len(t, calls, 4)
+	assert.Contains(t, calls[0], "--detach")
+	assert.Equal(t, containerIdleCommand, calls[0][len(calls[0])-len(containerIdleCommand):])
+	assert.Equal(t, 1, strings.Count(strings.Join(calls[0], " "), " -v "), "only the workdir is mounted")
+	assert.Equal(t, "exec", calls[1][1])
+	assert.True(t, isPhaseCommand(calls[1]))
+	assert.Equal(t, []string{"docker", "exec", "--user", containerRootUser}, calls[2][:4])
+	assert.True(t, isUsageCommand(calls[2]))
+	assert.True(t, slices.Equal([]string{"docker", "rm", "-f"}, calls[3][:3]))
+}
+
+func TestLocalExecutorUsage(t *testing.T) {
+	if _, err := exec.LookPath("python"); err != nil {
+		t.Skip("python is not available")
+	}
+
+	logger := zaptest.NewLogger(t)
+	executorConfig := &Config{TimeoutSec: 10, MemoryMB: 128, MaxArtifactSizeMB: 5}
+	executor := NewLocalExecutor(logger, executorConfig, &config.Config{})
+
+	result, err := executor.Execute(context.Background(), ExecuteRequest{
+		Language: LanguagePython,
+		Code:     "data = bytearray(64 * 1024 * 1024)\nprint(len(data))\n",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, result.Run)
+	assert.Positive(t, result.Usage.WallTime)
+	assert.Positive(t, result.Usage.UserTime+result.Usage.SystemTime)
+	assert.GreaterOrEqual(t, result.Usage.PeakMemoryBytes, int64(64*1024*1024))
+	assert.False(t, result.Usage.OOMKilled)
+}
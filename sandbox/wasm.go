@@ -0,0 +1,350 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The WasmExecutor runs every phase in-process
+// as a WASI module on wazero: a language is a WebAssembly interpreter, e.g. a
+// WASI build of CPython, that only sees the workdir and read-only mounts, with
+// its memory capped by memory_mb and its function calls by max_calls.
+package sandbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/experimental"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+const (
+	// wasmPagesPerMB is the number of 64 KiB WebAssembly memory pages in a megabyte
+	wasmPagesPerMB = 16
+	// wasmMaxPages is the largest memory a 32-bit WebAssembly module can address
+	wasmMaxPages = 65536
+	// wasmStartFunction is the entry point of a WASI command
+	wasmStartFunction = "_start"
+)
+
+// errCallLimit cancels a module that made the maximum number of function calls
+var errCallLimit = errors.New("function call limit reached")
+
+// errWasmOutputLimit cancels a module whose output exceeded the limits
+var errWasmOutputLimit = errors.New("output limit exceeded")
+
+// wasmTrapSignals maps the traps of a module to the signal a native program would get for the same fault
+var wasmTrapSignals = map[string]string{
+	"unreachable":                 "SIGABRT", // abort() of wasi-libc
+	"out of bounds memory access": "SIGSEGV",
+	"stack overflow":              "SIGSEGV",
+	"integer divide by zero":      "SIGFPE",
+	"integer overflow":            "SIGFPE",
+}
+
+// WasmExecutor implements SandboxExecutor with WASI modules run in-process by wazero
+type WasmExecutor struct {
+	logger   *zap.Logger
+	config   *Config
+	cfg      *config.Config // Reference to the full configuration
+	fs       FileSystem
+	workdirs hostWorkdirs
+	cache    wazero.CompilationCache
+	modules  map[string][]byte // WASI module of every configured language
+}
+
+// WasmExecutorOption defines a functional option for WasmExecutor
+type WasmExecutorOption func(*WasmExecutor)
+
+// WithWasmFileSystem sets the FileSystem for WasmExecutor
+func WithWasmFileSystem(fs FileSystem) WasmExecutorOption {
+	return func(w *WasmExecutor) {
+		w.fs = fs
+	}
+}
+
+// NewWasmExecutor creates a new WasmExecutor. It compiles the module of every configured language up front,
+// so that a missing or invalid module fails at startup and executions only instantiate compiled code.
+func NewWasmExecutor(logger *zap.Logger, executorConfig *Config, cfg *config.Config, opts ...WasmExecutorOption) (*WasmExecutor, error) {
+	executor := &WasmExecutor{
+		logger:  logger,
+		config:  executorConfig,
+		cfg:     cfg,
+		fs:      &RealFileSystem{}, // Default implementation
+		modules: make(map[string][]byte, len(cfg.Languages)),
+	}
+
+	// Apply options
+	for _, opt := range opts {
+		opt(executor)
+	}
+	executor.workdirs = hostWorkdirs{logger: logger, config: executorConfig, cfg: cfg, fs: executor.fs}
+
+	if cfg.Sandbox.NetworkEnabled || cfg.Sandbox.AllowRequestNetwork {
+		logger.Warn("the wasm backend has no network access, network settings are ignored")
+	}
+
+	if dir := cfg.Sandbox.Wasm.CacheDir; dir != "" {
+		cache, err := wazero.NewCompilationCacheWithDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sandbox.wasm.cache_dir: %w", err)
+		}
+		executor.cache = cache
+	} else {
+		executor.cache = wazero.NewCompilationCache()
+	}
+
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCompilationCache(executor.cache))
+	defer runtime.Close(ctx)
+
+	for language, langConfig := range cfg.Languages {
+		lang, err := ResolveLanguage(cfg.Languages, language)
+		if err != nil {
+			return nil, err
+		}
+		if lang.Compiled() {
+			return nil, fmt.Errorf("language %s: a build phase is not supported by the wasm backend", language)
+		}
+
+		module, err := executor.fs.ReadFile(langConfig.Wasm)
+		if err != nil {
+			return nil, fmt.Errorf("language %s: failed to read wasm module: %w", language, err)
+		}
+		compiled, err := runtime.CompileModule(executor.compileContext(ctx), module)
+		if err != nil {
+			return nil, fmt.Errorf("language %s: invalid wasm module %s: %w", language, langConfig.Wasm, err)
+		}
+		if _, ok := compiled.ExportedFunctions()[wasmStartFunction]; !ok {
+			return nil, fmt.Errorf("language %s: wasm module %s is not a WASI command without a %s export",
+				language, langConfig.Wasm, wasmStartFunction)
+		}
+		executor.modules[language] = module
+	}
+
+	return executor, nil
+}
+
+// compileContext returns the context that modules are compiled with. With max_calls, every function call of a
+// module is counted, which wazero only allows for listeners that are compiled in.
+func (w *WasmExecutor) compileContext(ctx context.Context) context.Context {
+	if w.cfg.Sandbox.Wasm.MaxCalls == 0 {
+		return ctx
+	}
+	return experimental.WithFunctionListenerFactory(ctx, experimental.FunctionListenerFactoryFunc(
+		func(api.FunctionDefinition) experimental.FunctionListener { return wasmCallListener },
+	))
+}
+
+// wasmCalls counts down the function calls left to a single phase, carried by the context of the module's calls
+type wasmCalls struct {
+	remaining atomic.Int64
+	reached   func()
+}
+
+type wasmCallsKey struct{}
+
+// wasmCallListener counts every function call against the calls left to the phase
+var wasmCallListener = experimental.FunctionListenerFunc(
+	func(ctx context.Context, _ api.Module, _ api.FunctionDefinition, _ []uint64, _ experimental.StackIterator) {
+		if calls, ok := ctx.Value(wasmCallsKey{}).(*wasmCalls); ok && calls.remaining.Add(-1) == 0 {
+			calls.reached()
+		}
+	},
+)
+
+// Execute runs the code in the WASI module of its language
+//
+//nolint:gocritic // Large request struct is passed by value to satisfy the SandboxExecutor interface
+func (w *WasmExecutor) Execute(ctx context.Context, req ExecuteRequest) (ExecuteResult, error) {
+	// A setup command is a shell command with network access, neither of which a module has
+	if req.Setup != "" {
+		return ExecuteResult{}, errors.New("a setup phase is not supported by the wasm backend")
+	}
+
+	workdirPath, lang, cleanup, err := w.prepareWorkdir(req.Language, req.Code, req.Workdir, req.WorkdirTar)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	defer cleanup()
+
+	// WASI has no sockets, a module never gets network access
+	limits := w.config.limits(req.TimeoutSec, req.MemoryMB, false)
+	phase := w.modulePhase(req.Language, workdirPath, limits, w.workdirs.outputCapture(workdirPath, req.Stream))
+	result, err := runPhases(ctx, lang, w.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+	if err != nil {
+		return ExecuteResult{}, err
+	}
+	result.Limits = limits
+
+	// Only return artifacts when the run phase actually finished and the caller wants them
+	if err := w.workdirs.collectArtifacts(&result, &req, workdirPath); err != nil {
+		return ExecuteResult{}, err
+	}
+	return result, nil
+}
+
+// ExecuteBatch runs the code against every test case in the same workdir
+//
+//nolint:gocritic // Large request struct is passed by value to mirror Execute
+func (w *WasmExecutor) ExecuteBatch(ctx context.Context, req BatchRequest) (BatchResult, error) {
+	workdirPath, lang, cleanup, err := w.prepareWorkdir(req.Language, req.Code, "", req.WorkdirTar)
+	if err != nil {
+		return BatchResult{}, err
+	}
+	defer cleanup()
+
+	limits := w.config.limits(0, 0, false)
+	phase := w.modulePhase(req.Language, workdirPath, limits, phaseCapture{})
+	return runTestCases(ctx, lang, w.workdirs.buildTimeout(), limits.RunTimeout(), &req, phase)
+}
+
+// prepareWorkdir prepares the workdir of an execution in a language that has a wasm module
+func (w *WasmExecutor) prepareWorkdir(language, code, sessionWorkdir string, workdirTar []byte) (string, Language, func(), error) {
+	if _, ok := w.modules[language]; !ok {
+		if _, err := ResolveLanguage(w.cfg.Languages, language); err != nil {
+			return "", Language{}, nil, fmt.Errorf("invalid language: %w", err)
+		}
+		return "", Language{}, nil, fmt.Errorf("invalid language: %s has no wasm module", language)
+	}
+	return w.workdirs.prepare(language, code, sessionWorkdir, workdirTar)
+}
+
+// modulePhase returns a phaseFunc that runs every phase in a fresh instance of the language's module.
+// capture decides where the output goes besides the phase result.
+func (w *WasmExecutor) modulePhase(language, workdirPath string, limits Limits, capture phaseCapture) phaseFunc {
+	return func(ctx context.Context, phase, command string, stdin []byte) (PhaseResult, error) {
+		return w.config.capturePhase(phase, capture, stdin, func(base Command) (PhaseResult, error) {
+			base.Args = strings.Fields(command)
+			output, err := w.runModule(ctx, language, workdirPath, limits.MemoryMB, base)
+
+			// A timeout is reported by the caller, keep whatever output was produced
+			if ctx.Err() != nil {
+				return PhaseResult{Stdout: output.Stdout, Stderr: output.Stderr, Usage: output.Usage, Output: output.Output}, ctx.Err()
+			}
+			if err != nil {
+				return PhaseResult{}, err
+			}
+			return phaseOutput(&output), nil
+		})
+	}
+}
+
+// runModule instantiates the module of a language with the workdir as its root directory and runs it
+// with base.Args as its arguments. A module that traps ends like a native program killed by a signal.
+func (w *WasmExecutor) runModule(ctx context.Context, language, workdirPath string, memoryMB int, base Command) (CommandResult, error) {
+	runCtx, stop := context.WithCancelCause(ctx)
+	defer stop(nil)
+
+	// Stop the module as soon as its output exceeds the limits, if configured
+	var limitExceeded atomic.Bool
+	onExceed := func() {
+		if base.Output.KillOnExceed && !limitExceeded.Swap(true) {
+			stop(errWasmOutputLimit)
+		}
+	}
+	stdout := newCappedBuffer(base.Output.StdoutBytes, base.StdoutSink, onExceed)
+	stderr := newCappedBuffer(base.Output.StderrBytes, base.StderrSink, onExceed)
+
+	// Closing the module when the context is done also stops loops that never call a function
+	runtimeConfig := wazero.NewRuntimeConfig().
+		WithCompilationCache(w.cache).
+		WithMemoryLimitPages(uint32(min(memoryMB*wasmPagesPerMB, wasmMaxPages))). //nolint:gosec // Bounded by wasmMaxPages
+		WithCloseOnContextDone(true)
+	runtime := wazero.NewRuntimeWithConfig(runCtx, runtimeConfig)
+	defer runtime.Close(context.Background())
+
+	if _, err := wasi_snapshot_preview1.Instantiate(runCtx, runtime); err != nil {
+		return CommandResult{}, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+	compiled, err := runtime.CompileModule(w.compileContext(runCtx), w.modules[language])
+	if err != nil {
+		return CommandResult{}, fmt.Errorf("failed to compile wasm module: %w", err)
+	}
+
+	// The workdir is the root of the module, so that relative paths resolve against it
+	fsConfig := wazero.NewFSConfig().WithDirMount(workdirPath, "/")
+	for _, bind := range w.cfg.Languages[language].Binds {
+		fsConfig = fsConfig.WithReadOnlyDirMount(bind, bind)
+	}
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("").
+		WithArgs(base.Args...).
+		WithStdin(bytes.NewReader(base.Stdin)).
+		WithStdout(stdout).
+		WithStderr(stderr).
+		WithFSConfig(fsConfig).
+		WithSysWalltime().
+		WithSysNanotime().
+		WithSysNanosleep().
+		WithRandSource(rand.Reader).
+		WithStartFunctions() // _start is called below, with the call limit of the phase
+	for key, value := range w.cfg.Languages[language].Environment {
+		moduleConfig = moduleConfig.WithEnv(key, value)
+	}
+
+	callCtx := runCtx
+	if maxCalls := w.cfg.Sandbox.Wasm.MaxCalls; maxCalls > 0 {
+		calls := &wasmCalls{reached: func() { stop(errCallLimit) }}
+		calls.remaining.Store(maxCalls)
+		callCtx = context.WithValue(runCtx, wasmCallsKey{}, calls)
+	}
+
+	start := time.Now()
+	module, err := runtime.InstantiateModule(callCtx, compiled, moduleConfig)
+	if err != nil {
+		return CommandResult{}, fmt.Errorf("failed to instantiate wasm module: %w", err)
+	}
+	_, err = module.ExportedFunction(wasmStartFunction).Call(callCtx)
+
+	output := CommandResult{Usage: ResourceUsage{WallTime: time.Since(start)}}
+	if memory := module.Memory(); memory != nil {
+		output.Usage.PeakMemoryBytes = int64(memory.Size())
+	}
+
+	var exitErr *sys.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr) && exitErr.ExitCode() != sys.ExitCodeContextCanceled && exitErr.ExitCode() != sys.ExitCodeDeadlineExceeded:
+		output.ExitCode = int(exitErr.ExitCode())
+	case errors.Is(context.Cause(runCtx), errCallLimit):
+		// Like a process over its CPU time limit
+		output.ExitCode = -1
+		output.Signal = "SIGXCPU"
+	case ctx.Err() != nil, limitExceeded.Load():
+	default:
+		output.ExitCode = -1
+		output.Signal = wasmTrapSignal(err)
+		_, _ = fmt.Fprintf(stderr, "%v\n", err)
+	}
+
+	output.OutputLimitExceeded = limitExceeded.Load()
+	output.Output = OutputStats{
+		StdoutBytes:     stdout.total,
+		StderrBytes:     stderr.total,
+		StdoutTruncated: stdout.truncated(),
+		StderrTruncated: stderr.truncated(),
+	}
+	output.Stdout = stdout.String()
+	output.Stderr = stderr.String()
+	return output, nil
+}
+
+// wasmTrapSignal returns the signal of the trap that ended a module, SIGILL for any other failure
+func wasmTrapSignal(err error) string {
+	for trap, signal := range wasmTrapSignals {
+		if strings.Contains(err.Error(), "wasm error: "+trap) {
+			return signal
+		}
+	}
+	return "SIGILL"
+}
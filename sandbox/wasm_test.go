@@ -0,0 +1,211 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// wasmTestInterpreter is a tiny interpreter built as a WASI command: every line of the code file is a
+// statement that prints, reads stdin, writes a file, allocates memory, recurses, spins or exits
+const wasmTestInterpreter = `package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var sink [][]byte
+
+//go:noinline
+func recurse(n int) int { return recurse(n+1) + 1 }
+
+func main() {
+	code, err := os.ReadFile(os.Args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(code)), "\n") {
+		statement, arg, _ := strings.Cut(line, " ")
+		switch statement {
+		case "print":
+			fmt.Println(arg)
+		case "env":
+			fmt.Println(os.Getenv(arg))
+		case "stdin":
+			input, _ := io.ReadAll(os.Stdin)
+			fmt.Print(strings.ToUpper(string(input)))
+		case "write":
+			name, content, _ := strings.Cut(arg, " ")
+			if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		case "cat":
+			content, err := os.ReadFile(arg)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Println(string(content))
+		case "alloc":
+			mb, _ := strconv.Atoi(arg)
+			for i := 0; i < mb; i++ {
+				sink = append(sink, make([]byte, 1<<20))
+			}
+		case "recurse":
+			recurse(0)
+		case "spin":
+			for {
+			}
+		case "exit":
+			code, _ := strconv.Atoi(arg)
+			os.Exit(code)
+		}
+	}
+}
+`
+
+// buildWasmTestInterpreter compiles wasmTestInterpreter with the Go toolchain for wasip1
+func buildWasmTestInterpreter(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("building a wasm module is slow")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte(wasmTestInterpreter), FilePermission))
+
+	module := filepath.Join(dir, "interpreter.wasm")
+	cmd := exec.Command("go", "build", "-o", module, "main.go")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm", "GOFLAGS=", "GO111MODULE=off")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("cannot build a wasip1 module: %v: %s", err, output)
+	}
+	return module
+}
+
+func TestWasmExecutor(t *testing.T) {
+	module := buildWasmTestInterpreter(t)
+	shared := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(shared, "data.txt"), []byte("shared data"), FilePermission))
+
+	newExecutor := func(t *testing.T, maxCalls int64) *WasmExecutor {
+		t.Helper()
+		cfg := &config.Config{
+			Sandbox: config.SandboxConfig{Backend: config.BackendWasm, Wasm: config.WasmConfig{MaxCalls: maxCalls}},
+			Languages: map[string]config.Language{
+				"toy": {
+					Wasm:        module,
+					SourceFile:  "main.toy",
+					RunCmd:      "toy main.toy",
+					Binds:       []string{shared},
+					Environment: map[string]string{"GREETING": "hi"},
+				},
+			},
+		}
+		executorConfig := &Config{TimeoutSec: 5, MemoryMB: 64, MaxArtifactSizeMB: 5}
+		executor, err := NewWasmExecutor(zaptest.NewLogger(t), executorConfig, cfg)
+		require.NoError(t, err)
+		return executor
+	}
+	executor := newExecutor(t, 0)
+
+	t.Run("Execute", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: "toy",
+			Code:     "print hello\nenv GREETING\nstdin\nwrite out.txt result\ncat " + filepath.Join(shared, "data.txt") + "\nexit 3",
+			Stdin:    []byte("input\n"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, StatusNonzeroExit, result.Status)
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "hello\nhi\nINPUT\nshared data\n", result.Stdout)
+		assert.Positive(t, result.Usage.PeakMemoryBytes)
+		assert.False(t, result.Limits.Network, "a module has no network")
+
+		artifactsDir := t.TempDir()
+		require.NoError(t, ExtractTarToDir(RealFileSystem{}, result.ArtifactsTar, artifactsDir))
+		content, err := os.ReadFile(filepath.Join(artifactsDir, "out.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "result", string(content))
+	})
+
+	t.Run("ReadOnlyBind", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{
+			Language: "toy",
+			Code:     "write " + filepath.Join(shared, "data.txt") + " changed",
+		})
+		require.NoError(t, err)
+		assert.NotEmpty(t, result.Stderr)
+
+		content, err := os.ReadFile(filepath.Join(shared, "data.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "shared data", string(content))
+	})
+
+	t.Run("MemoryLimit", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: "toy", Code: "alloc 256\nprint done"})
+		require.NoError(t, err)
+		assert.NotEqual(t, StatusOK, result.Status)
+		assert.NotContains(t, result.Stdout, "done")
+		assert.LessOrEqual(t, result.Usage.PeakMemoryBytes, int64(64*BytesPerKB*BytesPerKB))
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: "toy", Code: "print started\nspin", TimeoutSec: 1})
+		require.NoError(t, err)
+		assert.Equal(t, StatusTimeout, result.Status)
+		assert.Equal(t, "started\n", result.Stdout)
+	})
+
+	t.Run("CallLimit", func(t *testing.T) {
+		executor := newExecutor(t, 1_000_000)
+		result, err := executor.Execute(context.Background(), ExecuteRequest{Language: "toy", Code: "print started\nrecurse"})
+		require.NoError(t, err)
+		assert.Equal(t, StatusKilledBySignal, result.Status)
+		assert.Equal(t, "SIGXCPU", result.Signal)
+		assert.Equal(t, "started\n", result.Stdout)
+	})
+
+	t.Run("SetupPhase", func(t *testing.T) {
+		_, err := executor.Execute(context.Background(), ExecuteRequest{Language: "toy", Code: "print hello", Setup: "pip install toy"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "a setup phase is not supported by the wasm backend")
+	})
+
+	t.Run("InvalidModule", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "invalid.wasm")
+		require.NoError(t, os.WriteFile(invalid, []byte("not wasm"), FilePermission))
+		cfg := &config.Config{Languages: map[string]config.Language{LanguagePython: {Wasm: invalid}}}
+		_, err := NewWasmExecutor(zaptest.NewLogger(t), &Config{}, cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "language python: invalid wasm module")
+	})
+
+	t.Run("BuildPhase", func(t *testing.T) {
+		cfg := &config.Config{Languages: map[string]config.Language{LanguageGo: {Wasm: module}}}
+		_, err := NewWasmExecutor(zaptest.NewLogger(t), &Config{}, cfg)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "a build phase is not supported by the wasm backend")
+	})
+}
+
+func TestWasmTrapSignal(t *testing.T) {
+	assert.Equal(t, "SIGABRT", wasmTrapSignal(errors.New("wasm error: unreachable\nwasm stack trace: ...")))
+	assert.Equal(t, "SIGSEGV", wasmTrapSignal(errors.New("wasm error: out of bounds memory access")))
+	assert.Equal(t, "SIGFPE", wasmTrapSignal(errors.New("wasm error: integer divide by zero")))
+	assert.Equal(t, "SIGILL", wasmTrapSignal(errors.New("module closed")))
+}
@@ -0,0 +1,127 @@
+// Package sandbox provides secure code execution capabilities.
+//
+// The sandbox package implements the execution engine for running untrusted
+// code in isolated environments. The executors share how the workdir of an
+// execution is prepared on the host and how its artifacts are collected
+// afterwards, whether the phases run on the workdir itself, on a mount of it
+// or on a copy of it in a container.
+package sandbox
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/isdmx/codebox/config"
+)
+
+// hostWorkdirs prepares the workdirs of an executor on the host and collects the artifacts of its executions
+type hostWorkdirs struct {
+	logger *zap.Logger
+	config *Config
+	cfg    *config.Config // Reference to the full configuration
+	fs     FileSystem
+}
+
+// prepare fills a workdir with the extracted workdir tar and the user code. A non-empty
+// sessionWorkdir is reused, otherwise a temporary workdir is created. The returned cleanup
+// function removes a temporary workdir and must always be called on success.
+func (h *hostWorkdirs) prepare(language, code, sessionWorkdir string, workdirTar []byte) (string, Language, func(), error) {
+	// Resolve how the language is written, built and run
+	lang, err := ResolveLanguage(h.cfg.Languages, language)
+	if err != nil {
+		return "", Language{}, nil, fmt.Errorf("invalid language: %w", err)
+	}
+
+	// A session keeps its workdir between executions, otherwise a temporary one is created
+	cleanup := func() {}
+	workdirPath := sessionWorkdir
+	if workdirPath == "" {
+		tempDir, err := h.fs.MkdirTemp("", "codebox-exec-*")
+		if err != nil {
+			return "", Language{}, nil, fmt.Errorf("failed to create temp dir: %w", err)
+		}
+		cleanup = func() {
+			if rmErr := h.fs.RemoveAll(tempDir); rmErr != nil {
+				h.logger.Error("failed to remove temp directory", zap.String("path", tempDir), zap.Error(rmErr))
+			}
+		}
+
+		workdirPath = filepath.Join(tempDir, "workdir")
+		if mkdirErr := h.fs.MkdirAll(workdirPath, DirPermission); mkdirErr != nil {
+			cleanup()
+			return "", Language{}, nil, fmt.Errorf("failed to create workdir: %w", mkdirErr)
+		}
+	}
+
+	// If workdir_tar is provided, extract it
+	if len(workdirTar) > 0 {
+		if extractErr := ExtractTarToDir(h.fs, workdirTar, workdirPath); extractErr != nil {
+			cleanup()
+			return "", Language{}, nil, fmt.Errorf("failed to extract workdir_tar: %w", extractErr)
+		}
+	}
+
+	// Apply hooks for interpreted languages using config
+	var prefixCode, postfixCode string
+	if langConfig, exists := h.cfg.Languages[language]; exists {
+		prefixCode = langConfig.PrefixCode
+		postfixCode = langConfig.PostfixCode
+	}
+
+	codeFilePath := filepath.Join(workdirPath, lang.SourceFile)
+	if writeErr := h.fs.WriteFile(codeFilePath, []byte(prefixCode+code+postfixCode), FilePermission); writeErr != nil {
+		cleanup()
+		return "", Language{}, nil, fmt.Errorf("failed to write user code: %w", writeErr)
+	}
+
+	return workdirPath, lang, cleanup, nil
+}
+
+// buildTimeout returns the time limit of the build phase
+func (h *hostWorkdirs) buildTimeout() time.Duration {
+	return phaseTimeout(h.config.BuildTimeoutSec, h.config.TimeoutSec)
+}
+
+// outputCapture sends the output of an execution to the stream handler and, when spilling
+// is enabled, the complete output of truncated streams into the workdir
+func (h *hostWorkdirs) outputCapture(workdirPath string, stream OutputHandler) phaseCapture {
+	capture := phaseCapture{stream: stream}
+	if h.config.SpillOutput {
+		capture.spillDir = filepath.Join(workdirPath, SpillDirName)
+	}
+	return capture
+}
+
+// collectArtifacts sets the artifacts of a finished execution to the workdir as a tar without the
+// exclude patterns of the language. They are empty when the run phase did not finish or the caller
+// skips them.
+func (h *hostWorkdirs) collectArtifacts(result *ExecuteResult, req *ExecuteRequest, workdirPath string) error {
+	if req.SkipArtifacts || !result.hasArtifacts() {
+		result.ArtifactsTar = []byte{}
+		return nil
+	}
+
+	// Determine exclude patterns based on language from config
+	var excludePatterns []string
+	if langConfig, exists := h.cfg.Languages[req.Language]; exists {
+		excludePatterns = langConfig.ExcludePatterns
+	}
+
+	// Create artifacts tar from the workdir with exclude patterns
+	artifactsTar, err := CreateTarFromDirWithExcludes(workdirPath, excludePatterns)
+	if err != nil {
+		return fmt.Errorf("failed to create artifacts tar: %w", err)
+	}
+
+	// Check artifact size
+	if len(artifactsTar) > h.config.MaxArtifactSizeMB*MaxArtifactSizeMul {
+		return fmt.Errorf("artifacts size exceeds limit: %d bytes > %d bytes",
+			len(artifactsTar), h.config.MaxArtifactSizeMB*MaxArtifactSizeMul)
+	}
+
+	result.ArtifactsTar = artifactsTar
+	return nil
+}
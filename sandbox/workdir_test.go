@@ -0,0 +1,90 @@
+package sandbox
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zaptest"
+
+	"github.com/isdmx/codebox/config"
+)
+
+func TestHostWorkdirs(t *testing.T) {
+	cfg := &config.Config{Languages: map[string]config.Language{
+		LanguagePython: {PrefixCode: "# prefix\n", ExcludePatterns: []string{"*.log"}},
+	}}
+	workdirs := hostWorkdirs{
+		logger: zaptest.NewLogger(t),
+		config: &Config{MaxArtifactSizeMB: 1},
+		cfg:    cfg,
+		fs:     &RealFileSystem{},
+	}
+
+	t.Run("Temporary", func(t *testing.T) {
+		workdirPath, lang, cleanup, err := workdirs.prepare(LanguagePython, "print(1)", "", nil)
+		require.NoError(t, err)
+
+		code, err := os.ReadFile(filepath.Join(workdirPath, lang.SourceFile))
+		require.NoError(t, err)
+		assert.Equal(t, "# prefix\nprint(1)", string(code))
+
+		cleanup()
+		assert.NoDirExists(t, filepath.Dir(workdirPath), "the temporary workdir is removed")
+	})
+
+	t.Run("Session", func(t *testing.T) {
+		session := t.TempDir()
+		workdirPath, _, cleanup, err := workdirs.prepare(LanguagePython, "print(1)", session, nil)
+		require.NoError(t, err)
+		assert.Equal(t, session, workdirPath)
+
+		cleanup()
+		assert.DirExists(t, session, "a session workdir is kept")
+	})
+
+	t.Run("UnknownLanguage", func(t *testing.T) {
+		_, _, _, err := workdirs.prepare("cobol", "", "", nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid language")
+	})
+
+	t.Run("Artifacts", func(t *testing.T) {
+		workdirPath := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(workdirPath, "out.txt"), []byte("out"), FilePermission))
+		require.NoError(t, os.WriteFile(filepath.Join(workdirPath, "debug.log"), []byte("log"), FilePermission))
+		req := &ExecuteRequest{Language: LanguagePython}
+
+		result := ExecuteResult{Run: &PhaseResult{}}
+		require.NoError(t, workdirs.collectArtifacts(&result, req, workdirPath))
+		extracted := t.TempDir()
+		require.NoError(t, ExtractTarToDir(&RealFileSystem{}, result.ArtifactsTar, extracted))
+		assert.FileExists(t, filepath.Join(extracted, "out.txt"))
+		assert.NoFileExists(t, filepath.Join(extracted, "debug.log"), "exclude patterns apply")
+
+		// A timed-out run and skipped artifacts leave an empty tar
+		result = ExecuteResult{Run: &PhaseResult{TimedOut: true}}
+		require.NoError(t, workdirs.collectArtifacts(&result, req, workdirPath))
+		assert.Empty(t, result.ArtifactsTar)
+		result = ExecuteResult{Run: &PhaseResult{}}
+		require.NoError(t, workdirs.collectArtifacts(&result, &ExecuteRequest{Language: LanguagePython, SkipArtifacts: true}, workdirPath))
+		assert.Empty(t, result.ArtifactsTar)
+	})
+
+	t.Run("ArtifactsTooLarge", func(t *testing.T) {
+		// Random data does not compress, so the tar exceeds the limit of 1 MB
+		workdirPath := t.TempDir()
+		data := make([]byte, 2*MaxArtifactSizeMul)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(workdirPath, "data.bin"), data, FilePermission))
+
+		result := ExecuteResult{Run: &PhaseResult{}}
+		err = workdirs.collectArtifacts(&result, &ExecuteRequest{Language: LanguagePython}, workdirPath)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "artifacts size exceeds limit")
+	})
+}